@@ -0,0 +1,95 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"errors"
+	"io"
+)
+
+// truncater is implemented by files that can be truncated.
+type truncater interface {
+	Truncate(size int64) error
+}
+
+// WriterAppend opens the xz file for appending using the default
+// parameters; see WriterConfig.WriterAppend.
+func WriterAppend(xz io.ReadWriteSeeker) (w *Writer, err error) {
+	return WriterConfig{}.WriterAppend(xz)
+}
+
+// WriterAppend opens the complete xz file for appending data without
+// recompressing the existing data, which supports append-only log
+// archives. The data written is added as new blocks to the last stream
+// of the file. The index and the footer of the stream are overwritten
+// and Close writes the rebuilt index with the records of the old and
+// the new blocks. The check method of the stream is used; the CheckSum
+// of the configuration is ignored. An empty file gets a new stream.
+//
+// The file is incomplete until Close has been called. Stream padding at
+// the end of the file is removed; this requires a Truncate method like
+// the one of os.File. Appending a separate stream doesn't require
+// WriterAppend: seek to the end of the file and use NewWriter, since xz
+// files may consist of multiple streams.
+func (c WriterConfig) WriterAppend(xz io.ReadWriteSeeker) (w *Writer,
+	err error) {
+
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	end, err := xz.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if end == 0 {
+		return c.NewWriter(xz)
+	}
+	if end%4 != 0 {
+		return nil, errStat
+	}
+	p := make([]byte, HeaderLen)
+	pos := end
+	for pos >= 4 {
+		if err = readAt(xz, pos-4, p[:4]); err != nil {
+			return nil, err
+		}
+		if !allZeros(p[:4]) {
+			break
+		}
+		pos -= 4
+	}
+	s, err := statStream(xz, pos, p)
+	if err != nil {
+		return nil, err
+	}
+	if pos < end {
+		t, ok := xz.(truncater)
+		if !ok {
+			return nil, errors.New(
+				"xz: stream padding can't be removed")
+		}
+		if err = t.Truncate(pos); err != nil {
+			return nil, err
+		}
+	}
+	indexStart := s.Offset + HeaderLen
+	for _, rec := range s.Index.records {
+		indexStart += rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+	}
+	if _, err = xz.Seek(indexStart, io.SeekStart); err != nil {
+		return nil, err
+	}
+	c.CheckSum = s.Check
+	if w, err = c.newWriter(xz); err != nil {
+		return nil, err
+	}
+	w.streamOffset = s.Offset
+	w.index = append(w.index, s.Index.records...)
+	w.uncompressed = s.Index.UncompressedSize()
+	if err = w.newBlockWriter(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
@@ -0,0 +1,80 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestWriterAppend(t *testing.T) {
+	f, err := ioutil.TempFile("", "append")
+	if err != nil {
+		t.Fatalf("TempFile error %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	records := []string{
+		"first record\n",
+		"second record\n",
+		"third record\n",
+	}
+	for i, rec := range records {
+		w, err := WriterConfig{CheckSum: CRC32}.WriterAppend(f)
+		if err != nil {
+			t.Fatalf("WriterAppend error %s", err)
+		}
+		if _, err = io.WriteString(w, rec); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		if i == 1 {
+			// stream padding
+			if _, err = f.Write(make([]byte, 8)); err != nil {
+				t.Fatalf("Write error %s", err)
+			}
+		}
+	}
+	// The check of the first stream is kept.
+	w, err := WriterConfig{CheckSum: CRC64}.WriterAppend(f)
+	if err != nil {
+		t.Fatalf("WriterAppend error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+
+	info, err := Stat(f)
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	if len(info.Streams) != 1 {
+		t.Fatalf("got %d streams; want 1", len(info.Streams))
+	}
+	if s := info.Streams[0]; s.Check != CRC32 || s.Padding != 0 {
+		t.Fatalf("stream check %#02x padding %d; want %#02x and 0",
+			s.Check, s.Padding, CRC32)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek error %s", err)
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if want := strings.Join(records, ""); string(data) != want {
+		t.Fatalf("got %q; want %q", data, want)
+	}
+}
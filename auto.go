@@ -0,0 +1,45 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bufio"
+	"errors"
+	"io"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// ErrUnknownFormat is returned by NewAutoReader if the data starts
+// neither with an xz header nor with the header of the classic LZMA
+// format.
+var ErrUnknownFormat = errors.New("xz: unknown compression format")
+
+// NewAutoReader detects the format of the compressed data by its
+// header and returns a reader for it. It supports xz streams and the
+// classic LZMA format and uses the default reader parameters. Since the
+// LZMA header has no magic bytes, the header values are checked for
+// plausibility as lzma.ValidHeader does. ErrUnknownFormat is returned
+// for all other data.
+//
+// The reader buffers the input, so data following the compressed
+// stream may be consumed.
+func NewAutoReader(r io.Reader) (io.Reader, error) {
+	br, ok := r.(*bufio.Reader)
+	if !ok {
+		br = bufio.NewReader(r)
+	}
+	h, err := br.Peek(lzma.HeaderLen)
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	switch {
+	case len(h) >= HeaderLen && ValidHeader(h[:HeaderLen]):
+		return NewReader(br)
+	case len(h) == lzma.HeaderLen && lzma.ValidHeader(h):
+		return lzma.NewReader(br)
+	}
+	return nil, ErrUnknownFormat
+}
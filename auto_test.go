@@ -0,0 +1,55 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestNewAutoReader(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog.\n"
+	var xzBuf, lzmaBuf bytes.Buffer
+	xw, err := NewWriter(&xzBuf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	lw, err := lzma.NewWriter(&lzmaBuf)
+	if err != nil {
+		t.Fatalf("lzma.NewWriter error %s", err)
+	}
+	for _, w := range []io.WriteCloser{xw, lw} {
+		if _, err = io.WriteString(w, text); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+	}
+	for _, data := range [][]byte{xzBuf.Bytes(), lzmaBuf.Bytes()} {
+		r, err := NewAutoReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("NewAutoReader error %s", err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if string(p) != text {
+			t.Fatalf("read %q; want %q", p, text)
+		}
+	}
+	for _, s := range []string{"", "xz", text} {
+		_, err := NewAutoReader(bytes.NewReader([]byte(s)))
+		if err != ErrUnknownFormat {
+			t.Fatalf("NewAutoReader(%q) returned error %v; want %s",
+				s, err, ErrUnknownFormat)
+		}
+	}
+}
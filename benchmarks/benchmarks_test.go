@@ -0,0 +1,120 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package benchmarks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+// presets are the compression levels benchmarked.
+var presets = []int{0, 3, 6, 9}
+
+func compress(tb testing.TB, level int, data []byte) []byte {
+	cfg, err := xz.Preset(level, false)
+	if err != nil {
+		tb.Fatalf("xz.Preset(%d, false) error %s", level, err)
+	}
+	var buf bytes.Buffer
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		tb.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		tb.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		tb.Fatalf("w.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func samples(b *testing.B) []Sample {
+	s, err := Samples()
+	if err != nil {
+		b.Fatalf("Samples error %s", err)
+	}
+	return s
+}
+
+func BenchmarkEncode(b *testing.B) {
+	for _, s := range samples(b) {
+		for _, level := range presets {
+			name := fmt.Sprintf("%s/preset-%d", s.Name, level)
+			b.Run(name, func(b *testing.B) {
+				var n int
+				b.SetBytes(int64(len(s.Data)))
+				for i := 0; i < b.N; i++ {
+					n = len(compress(b, level, s.Data))
+				}
+				b.ReportMetric(float64(n)/float64(len(s.Data)),
+					"ratio")
+			})
+		}
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	for _, s := range samples(b) {
+		for _, level := range presets {
+			name := fmt.Sprintf("%s/preset-%d", s.Name, level)
+			b.Run(name, func(b *testing.B) {
+				data := compress(b, level, s.Data)
+				b.SetBytes(int64(len(s.Data)))
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					r, err := xz.NewReader(
+						bytes.NewReader(data))
+					if err != nil {
+						b.Fatalf("NewReader error %s",
+							err)
+					}
+					if _, err = io.Copy(ioutil.Discard,
+						r); err != nil {
+						b.Fatalf("io.Copy error %s", err)
+					}
+				}
+				b.ReportMetric(float64(len(data))/
+					float64(len(s.Data)), "ratio")
+			})
+		}
+	}
+}
+
+func TestReadDir(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{"b": "bbb", "a": "aa"}
+	for name, content := range files {
+		err := ioutil.WriteFile(filepath.Join(dir, name),
+			[]byte(content), 0644)
+		if err != nil {
+			t.Fatalf("WriteFile error %s", err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, "c"), 0755); err != nil {
+		t.Fatalf("Mkdir error %s", err)
+	}
+	samples, err := ReadDir(dir)
+	if err != nil {
+		t.Fatalf("ReadDir error %s", err)
+	}
+	if len(samples) != 2 {
+		t.Fatalf("got %d samples; want %d", len(samples), 2)
+	}
+	for i, name := range []string{"a", "b"} {
+		s := samples[i]
+		if s.Name != name || string(s.Data) != files[name] {
+			t.Errorf("sample %d is %s:%q; want %s:%q", i,
+				s.Name, s.Data, name, files[name])
+		}
+	}
+}
@@ -0,0 +1,109 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package benchmarks provides the benchmarks for the compression and
+// decompression of the xz package over a set of samples.
+//
+// The package generates a few small samples itself, so the benchmarks
+// can be run without any preparation. Standard corpora like enwik8 or
+// the Silesia corpus are too large to be included in the repository.
+// Download and unpack them into a directory and set the environment
+// variable XZ_BENCH_CORPUS to it. Every regular file in the directory
+// is then used as additional sample.
+//
+//	$ export XZ_BENCH_CORPUS=$HOME/corpus
+//	$ go test -run NONE -bench . -count 10 > new.txt
+//
+// The benchmarks report the throughput in MB/s of uncompressed data
+// and the compression ratio as compressed bytes per uncompressed byte.
+// Regressions can be tracked by comparing the results of two runs with
+// the benchstat tool from golang.org/x/perf.
+//
+//	$ benchstat old.txt new.txt
+package benchmarks
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// CorpusEnv is the environment variable that names the directory
+// containing additional samples.
+const CorpusEnv = "XZ_BENCH_CORPUS"
+
+// sampleSize is the size of the generated samples.
+const sampleSize = 1 << 20
+
+// Sample is a named input for the benchmarks.
+type Sample struct {
+	Name string
+	Data []byte
+}
+
+// Generated returns the samples generated by the package. The samples
+// are english-like text, incompressible random bytes and highly
+// repetitive data. They are deterministic, so results of different
+// runs can be compared.
+func Generated() []Sample {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(1)), sampleSize))
+	if err != nil {
+		panic(err)
+	}
+	rnd := make([]byte, sampleSize)
+	rand.New(rand.NewSource(2)).Read(rnd)
+	rep := bytes.Repeat([]byte("The quick brown fox jumps over"+
+		" the lazy dog.\n"), sampleSize/45)
+	return []Sample{
+		{Name: "text", Data: txt},
+		{Name: "random", Data: rnd},
+		{Name: "repeat", Data: rep},
+	}
+}
+
+// ReadDir reads all regular files of the directory as samples. The
+// samples are sorted by name.
+func ReadDir(dir string) (samples []Sample, err error) {
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, fi := range entries {
+		if !fi.Mode().IsRegular() {
+			continue
+		}
+		data, err := ioutil.ReadFile(filepath.Join(dir, fi.Name()))
+		if err != nil {
+			return nil, err
+		}
+		samples = append(samples, Sample{Name: fi.Name(), Data: data})
+	}
+	sort.Slice(samples, func(i, j int) bool {
+		return samples[i].Name < samples[j].Name
+	})
+	return samples, nil
+}
+
+// Samples returns the generated samples and the samples of the
+// directory given by the environment variable XZ_BENCH_CORPUS, if it
+// is set.
+func Samples() ([]Sample, error) {
+	samples := Generated()
+	dir := os.Getenv(CorpusEnv)
+	if dir == "" {
+		return samples, nil
+	}
+	s, err := ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	return append(samples, s...), nil
+}
@@ -5,6 +5,7 @@
 package xz
 
 import (
+	"bytes"
 	"errors"
 	"io"
 )
@@ -52,7 +53,12 @@ func putUvarint(p []byte, x uint64) int {
 // errOverflow indicates an overflow of the 64-bit unsigned integer.
 var errOverflowU64 = errors.New("xz: uvarint overflows 64-bit unsigned integer")
 
-// readUvarint reads a uvarint from the given byte reader.
+// errNonCanonical indicates a multibyte integer with superfluous zero
+// bytes.
+var errNonCanonical = errors.New("xz: uvarint not in canonical form")
+
+// readUvarint reads a uvarint from the given byte reader. Encodings
+// ending with a superfluous zero byte are rejected.
 func readUvarint(r io.ByteReader) (x uint64, n int, err error) {
 	var s uint
 	i := 0
@@ -66,9 +72,57 @@ func readUvarint(r io.ByteReader) (x uint64, n int, err error) {
 			if i > 10 || i == 10 && b > 1 {
 				return x, i, errOverflowU64
 			}
+			if b == 0 && i > 1 {
+				return x, i, errNonCanonical
+			}
 			return x | uint64(b)<<s, i, nil
 		}
 		x |= uint64(b&0x7f) << s
 		s += 7
 	}
 }
+
+// MaxUvarint is the largest integer that can be stored in the multibyte
+// integer encoding of the xz format. MaxUvarintLen is the maximum
+// length of the encoding.
+const (
+	MaxUvarint    = 1<<63 - 1
+	MaxUvarintLen = 9
+)
+
+// errUvarint indicates a multibyte integer exceeding MaxUvarint.
+var errUvarint = errors.New("xz: uvarint exceeds maximum value")
+
+// PutUvarint encodes x in the multibyte integer encoding used by the
+// block headers, the index and the filter flags of the xz format and
+// returns the number of bytes written. The slice p must have room for
+// MaxUvarintLen bytes. The function panics if x exceeds MaxUvarint.
+func PutUvarint(p []byte, x uint64) int {
+	if x > MaxUvarint {
+		panic(errUvarint)
+	}
+	return putUvarint(p, x)
+}
+
+// ReadUvarint reads a multibyte integer from r and returns it together
+// with the number of bytes read. Values exceeding MaxUvarint and
+// encodings that are not in the canonical form are rejected.
+func ReadUvarint(r io.ByteReader) (x uint64, n int, err error) {
+	x, n, err = readUvarint(r)
+	if err == nil && x > MaxUvarint {
+		err = errUvarint
+	}
+	return x, n, err
+}
+
+// Uvarint decodes the multibyte integer at the start of p and returns
+// it together with the length of its encoding. It validates the
+// encoding as ReadUvarint does. If p ends before the integer,
+// io.ErrUnexpectedEOF is returned.
+func Uvarint(p []byte) (x uint64, n int, err error) {
+	x, n, err = ReadUvarint(bytes.NewReader(p))
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return x, n, err
+}
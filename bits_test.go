@@ -6,6 +6,7 @@ package xz
 
 import (
 	"bytes"
+	"io"
 	"testing"
 )
 
@@ -31,3 +32,34 @@ func TestUvarint(t *testing.T) {
 		}
 	}
 }
+
+func TestUvarintStrict(t *testing.T) {
+	p := make([]byte, MaxUvarintLen)
+	for _, u := range []uint64{0, 0x7f, 0x80, 0x3fff, MaxUvarint} {
+		n := PutUvarint(p, u)
+		x, m, err := Uvarint(p[:n])
+		if err != nil {
+			t.Fatalf("Uvarint(%#x) error %s", u, err)
+		}
+		if x != u || m != n {
+			t.Fatalf("Uvarint returned %#x, %d; want %#x, %d",
+				x, m, u, n)
+		}
+	}
+	tests := []struct {
+		p   []byte
+		err error
+	}{
+		{[]byte{0x80, 0x00}, errNonCanonical},
+		{[]byte{0xff, 0x80, 0x00}, errNonCanonical},
+		{[]byte{0x80}, io.ErrUnexpectedEOF},
+		{[]byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80,
+			0x80, 0x01}, errUvarint},
+	}
+	for _, c := range tests {
+		if _, _, err := Uvarint(c.p); err != c.err {
+			t.Errorf("Uvarint(% x) returned error %v; want %v",
+				c.p, err, c.err)
+		}
+	}
+}
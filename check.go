@@ -0,0 +1,142 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"sync"
+)
+
+// maxCheckID is the largest check ID defined by the xz format. The IDs
+// that are not used by CRC32, CRC64 and SHA256 are reserved.
+const maxCheckID = 0xf
+
+// checkSize returns the size of the check value for the given ID. The
+// xz format defines the sizes for all IDs, so that readers can skip
+// checks they don't support.
+func checkSize(id byte) int {
+	if id == 0 {
+		return 0
+	}
+	return 4 << ((id - 1) / 3)
+}
+
+// registeredCheck is an entry of the check registry.
+type registeredCheck struct {
+	name    string
+	newHash func() hash.Hash
+}
+
+// checkRegistry stores the checks registered by RegisterCheck.
+var checkRegistry = struct {
+	sync.RWMutex
+	byID map[byte]registeredCheck
+}{
+	byID: make(map[byte]registeredCheck),
+}
+
+// RegisterCheck registers the check method with the given ID, so that
+// it can be selected by WriterConfig.CheckSum and is verified by the
+// reader. The ID must be one of the reserved check IDs of the xz
+// format and the size of the hashes created by newHash must be the
+// size the format defines for the ID: 4 bytes for the IDs 0x2 and 0x3,
+// 8 bytes for 0x5 and 0x6, 16 bytes for 0x7 to 0x9, 32 bytes for 0xb
+// and 0xc and 64 bytes for 0xd to 0xf. Readers skip the values of
+// checks that have not been registered. RegisterCheck may be called
+// concurrently from multiple goroutines.
+func RegisterCheck(id byte, name string, newHash func() hash.Hash) error {
+	if !(1 <= id && id <= maxCheckID) {
+		return fmt.Errorf("xz: check ID %#x out of range", id)
+	}
+	if _, ok := flagstrings[id]; ok {
+		return fmt.Errorf("xz: check ID %#x is predefined", id)
+	}
+	if newHash == nil {
+		return errors.New("xz: check hash function is nil")
+	}
+	if n := newHash().Size(); n != checkSize(id) {
+		return fmt.Errorf("xz: check ID %#x requires %d-byte "+
+			"hashes; got %d", id, checkSize(id), n)
+	}
+	checkRegistry.Lock()
+	defer checkRegistry.Unlock()
+	if _, ok := checkRegistry.byID[id]; ok {
+		return fmt.Errorf("xz: check ID %#x already registered", id)
+	}
+	checkRegistry.byID[id] = registeredCheck{name: name, newHash: newHash}
+	return nil
+}
+
+// flagstrings maps flag values to strings.
+var flagstrings = map[byte]string{
+	CRC32:  "CRC-32",
+	CRC64:  "CRC-64",
+	SHA256: "SHA-256",
+}
+
+// flagString returns the string representation for the given flags.
+// Checks without name are named like xz-utils does.
+func flagString(flags byte) string {
+	if s, ok := flagstrings[flags]; ok {
+		return s
+	}
+	if flags > maxCheckID {
+		return "invalid"
+	}
+	checkRegistry.RLock()
+	rc, ok := checkRegistry.byID[flags]
+	checkRegistry.RUnlock()
+	if ok && rc.name != "" {
+		return rc.name
+	}
+	if flags == 0 {
+		return "None"
+	}
+	return fmt.Sprintf("Unknown-%d", flags)
+}
+
+// errUnsupportedCheck indicates a check ID that is valid but neither
+// predefined nor registered.
+var errUnsupportedCheck = errors.New("xz: unsupported check")
+
+// newHashFunc returns a function that creates hash instances for the
+// hash method encoded in flags.
+func newHashFunc(flags byte) (newHash func() hash.Hash, err error) {
+	switch flags {
+	case 0:
+		// The check None has no value to verify.
+		return func() hash.Hash { return skipCheck(0) }, nil
+	case CRC32:
+		return newCRC32, nil
+	case CRC64:
+		return newCRC64, nil
+	case SHA256:
+		return sha256.New, nil
+	}
+	if err = verifyFlags(flags); err != nil {
+		return nil, err
+	}
+	checkRegistry.RLock()
+	defer checkRegistry.RUnlock()
+	rc, ok := checkRegistry.byID[flags]
+	if !ok {
+		return nil, errUnsupportedCheck
+	}
+	return rc.newHash, nil
+}
+
+// skipCheck is used by the readers for the check None, for checks that
+// are not supported and for all checks if IgnoreChecks is set. It has
+// the size of the check value, which is skipped and not verified.
+type skipCheck int
+
+func (c skipCheck) Write(p []byte) (n int, err error) { return len(p), nil }
+func (c skipCheck) Sum(b []byte) []byte               { return b }
+func (c skipCheck) Reset()                            {}
+func (c skipCheck) Size() int                         { return int(c) }
+func (c skipCheck) BlockSize() int                    { return 1 }
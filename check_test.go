@@ -0,0 +1,153 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// md5Check is registered for the tests. The registry is global, so the
+// registration must happen only once.
+const md5Check = 0x7
+
+func init() {
+	if err := RegisterCheck(md5Check, "MD5", md5.New); err != nil {
+		panic(err)
+	}
+}
+
+func TestRegisterCheckErrors(t *testing.T) {
+	tests := []struct {
+		id   byte
+		size int
+	}{
+		{0, 0},
+		{0x10, 64},
+		{CRC64, 8},
+		{md5Check, 16},
+		{0x5, 16},
+	}
+	for _, c := range tests {
+		if err := RegisterCheck(c.id, "", md5.New); err == nil {
+			t.Errorf("RegisterCheck(%#x) returned no error", c.id)
+		}
+	}
+}
+
+// setCheck replaces the check ID in the header and the footer of a
+// single stream.
+func setCheck(xz []byte, id byte) {
+	xz[7] = id
+	putUint32LE(xz[8:], crc32.ChecksumIEEE(xz[6:8]))
+	f := xz[len(xz)-footerLen:]
+	f[9] = id
+	putUint32LE(f, crc32.ChecksumIEEE(f[4:10]))
+}
+
+func TestRegisteredCheck(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf bytes.Buffer
+	w, err := WriterConfig{CheckSum: md5Check}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	xz := buf.Bytes()
+	readAll := func(p []byte) (string, error) {
+		r, err := NewReader(bytes.NewReader(p))
+		if err != nil {
+			return "", err
+		}
+		out, err := ioutil.ReadAll(r)
+		return string(out), err
+	}
+	out, err := readAll(xz)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if out != text {
+		t.Fatalf("got %q; want %q", out, text)
+	}
+	info, err := Stat(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	if name := info.Streams[0].CheckName(); name != "MD5" {
+		t.Fatalf("CheckName returned %q; want %q", name, "MD5")
+	}
+
+	// The check value of the block precedes the index.
+	i := len(xz) - footerLen - int(info.Streams[0].Index.Len()) - 12
+	bad := append([]byte{}, xz...)
+	bad[i] ^= 1
+	if _, err = readAll(bad); err == nil {
+		t.Fatalf("corrupted check not detected")
+	}
+
+	// 0x8 has the same check size but is not registered.
+	unknown := append([]byte{}, xz...)
+	setCheck(unknown, 0x8)
+	if out, err = readAll(unknown); err != nil {
+		t.Fatalf("unknown check: ReadAll error %s", err)
+	}
+	if out != text {
+		t.Fatalf("unknown check: got %q; want %q", out, text)
+	}
+	if s := flagString(0x8); s != "Unknown-8" {
+		t.Fatalf("flagString(0x8) returned %q", s)
+	}
+	if _, err = (WriterConfig{CheckSum: 0x8}).NewWriter(&buf); err == nil {
+		t.Fatalf("NewWriter accepted unsupported check")
+	}
+}
+
+// warnLogger records the warnings logged.
+type warnLogger struct{ warnings []string }
+
+func (l *warnLogger) Debugf(format string, v ...interface{}) {}
+func (l *warnLogger) Infof(format string, v ...interface{})  {}
+func (l *warnLogger) Warnf(format string, v ...interface{}) {
+	l.warnings = append(l.warnings, fmt.Sprintf(format, v...))
+}
+
+// noneStream has been created by xz -C none.
+var noneStream = []byte{
+	0xfd, 0x37, 0x7a, 0x58, 0x5a, 0x00, 0x00, 0x00, 0xff, 0x12, 0xd9,
+	0x41, 0x04, 0xc0, 0x10, 0x0c, 0x21, 0x01, 0x16, 0x00, 0x00, 0x00,
+	0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x7b, 0xb0, 0x54, 0x28, 0x01,
+	0x00, 0x0b, 0x4e, 0x6f, 0x6e, 0x65, 0x20, 0x63, 0x68, 0x65, 0x63,
+	0x6b, 0x2e, 0x0a, 0x00, 0x00, 0x01, 0x24, 0x0c, 0xa6, 0x18, 0xd8,
+	0xd8, 0x06, 0x72, 0x9e, 0x7a, 0x01, 0x00, 0x00, 0x00, 0x00, 0x00,
+	0x59, 0x5a,
+}
+
+func TestCheckNone(t *testing.T) {
+	l := new(warnLogger)
+	r, err := ReaderConfig{Logger: l}.NewReader(bytes.NewReader(noneStream))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(p) != "None check.\n" {
+		t.Fatalf("ReadAll returned %q; want %q", p, "None check.\n")
+	}
+	if len(l.warnings) > 0 {
+		t.Fatalf("unexpected warnings %q", l.warnings)
+	}
+}
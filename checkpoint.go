@@ -0,0 +1,198 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"hash/crc32"
+	"io"
+)
+
+// Every block of an xz stream starts with a new LZMA2 dictionary, new
+// probabilities and a new range coder, and the match finder is reset as
+// well. At a block boundary the complete state of the encoder is
+// therefore given by the check method and the index records of the
+// blocks written; the offset in the stream and the number of
+// uncompressed bytes follow from the records. A checkpoint is encoded
+// as stream header followed by the index, so both parts are protected
+// by their CRC32 checksums. If the stream doesn't start at the
+// beginning of the output, its offset follows as variable-length
+// integer and its CRC32.
+
+// Checkpoint terminates the current block and returns a snapshot of the
+// writer state at the block boundary. All data up to the checkpoint has
+// been written to the underlying writer. If it has been synced to
+// stable storage, the compression can be continued after a restart
+// with ResumeWriter. The writer can be used as before; the next data
+// written starts a new block.
+//
+// The returned snapshot is small but grows with the number of blocks.
+// Frequent checkpoints degrade the compression ratio, because the
+// dictionary is not preserved across blocks.
+func (w *Writer) Checkpoint() (snapshot []byte, err error) {
+	if err = w.lock(); err != nil {
+		w.unlock()
+		return nil, err
+	}
+	defer w.unlock()
+	if w.closed {
+		return nil, errClosed
+	}
+	if w.bw.uncompressedSize() > 0 {
+		if err = w.closeBlockWriter(); err != nil {
+			return nil, err
+		}
+		if err = w.newBlockWriter(); err != nil {
+			return nil, err
+		}
+		w.pending = false
+	}
+	data, err := w.h.MarshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewBuffer(data)
+	if _, err = writeIndex(buf, w.index); err != nil {
+		return nil, err
+	}
+	if w.streamOffset > 0 {
+		p := make([]byte, 14)
+		n := putUvarint(p, uint64(w.streamOffset))
+		putUint32LE(p[n:], crc32.ChecksumIEEE(p[:n]))
+		buf.Write(p[:n+4])
+	}
+	return buf.Bytes(), nil
+}
+
+// checkpoint is the decoded snapshot of a writer.
+type checkpoint struct {
+	h     header
+	index []record
+	// offset of the stream header in the output
+	streamOffset int64
+	// offset of the next block relative to the stream header
+	offset int64
+	// uncompressed size of the blocks
+	uncompressed int64
+}
+
+// errCheckpoint indicates a corrupted checkpoint.
+var errCheckpoint = errors.New("xz: invalid checkpoint")
+
+// UnmarshalBinary decodes the snapshot created by Writer.Checkpoint.
+func (cp *checkpoint) UnmarshalBinary(data []byte) error {
+	if len(data) < HeaderLen+1 {
+		return errCheckpoint
+	}
+	if err := cp.h.UnmarshalBinary(data[:HeaderLen]); err != nil {
+		return err
+	}
+	if data[HeaderLen] != 0 {
+		return errCheckpoint
+	}
+	r := bytes.NewReader(data[HeaderLen+1:])
+	records, _, err := readIndexBody(r)
+	if err != nil {
+		return err
+	}
+	cp.streamOffset = 0
+	if r.Len() > 0 {
+		p := data[len(data)-r.Len():]
+		x, n, err := Uvarint(p)
+		if err != nil || len(p) != n+4 ||
+			uint32LE(p[n:]) != crc32.ChecksumIEEE(p[:n]) {
+			return errCheckpoint
+		}
+		cp.streamOffset = int64(x)
+		if cp.streamOffset < 0 {
+			return errCheckpoint
+		}
+	}
+	cp.index = records
+	cp.offset = HeaderLen
+	cp.uncompressed = 0
+	for _, rec := range records {
+		cp.offset += rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+		cp.uncompressed += rec.uncompressedSize
+		if cp.offset < 0 || cp.uncompressed < 0 {
+			return errCheckpoint
+		}
+	}
+	if cp.end() < 0 {
+		return errCheckpoint
+	}
+	return nil
+}
+
+// end returns the size of the output at the checkpoint.
+func (cp *checkpoint) end() int64 {
+	return cp.streamOffset + cp.offset
+}
+
+// CheckpointSizes returns the size of the output and the number of
+// uncompressed bytes at the checkpoint. After a restart the output must
+// be truncated to the compressed size and the input must be continued
+// after the uncompressed bytes. The compressed size includes the data
+// preceding the stream, like the streams of a file written by
+// WriterAppend, if the writer could determine the offset of the stream
+// in its seekable output. The uncompressed size is counted from the
+// start of the stream.
+func CheckpointSizes(snapshot []byte) (compressed, uncompressed int64,
+	err error) {
+
+	var cp checkpoint
+	if err = cp.UnmarshalBinary(snapshot); err != nil {
+		return 0, 0, err
+	}
+	return cp.end(), cp.uncompressed, nil
+}
+
+// ResumeWriter resumes the compression at the checkpoint given by the
+// snapshot returned by Writer.Checkpoint. The Writer continues the
+// stream with a new block, and its Close method writes the index
+// including the blocks written before the checkpoint. The check method
+// of the snapshot is used; the CheckSum of the configuration is
+// ignored.
+//
+// The output must contain the data written up to the checkpoint. Data
+// written afterwards is removed if xz supports Seek and Truncate like
+// os.File; otherwise xz must be positioned at the compressed size
+// returned by CheckpointSizes. Streams preceding the stream of the
+// checkpoint in the output are preserved. The caller must skip the
+// uncompressed bytes of the input already compressed.
+func (c WriterConfig) ResumeWriter(xz io.Writer, snapshot []byte) (w *Writer,
+	err error) {
+
+	var cp checkpoint
+	if err = cp.UnmarshalBinary(snapshot); err != nil {
+		return nil, err
+	}
+	c.CheckSum = cp.h.flags
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	if s, ok := xz.(io.Seeker); ok {
+		if t, ok := xz.(truncater); ok {
+			if err = t.Truncate(cp.end()); err != nil {
+				return nil, err
+			}
+		}
+		if _, err = s.Seek(cp.end(), io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	if w, err = c.newWriter(xz); err != nil {
+		return nil, err
+	}
+	w.streamOffset = cp.streamOffset
+	w.index = append(w.index, cp.index...)
+	w.uncompressed = cp.uncompressed
+	w.cxz.n = cp.offset
+	if err = w.newBlockWriter(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
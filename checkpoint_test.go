@@ -0,0 +1,266 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestWriterCheckpoint(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<18)
+	data := txt.Bytes()
+
+	f, err := ioutil.TempFile("", "checkpoint")
+	if err != nil {
+		t.Fatalf("TempFile error %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	cfg := WriterConfig{CheckSum: SHA256, BlockSize: 1 << 16}
+	w, err := cfg.NewWriter(f)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data[:100000]); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	snapshot, err := w.Checkpoint()
+	if err != nil {
+		t.Fatalf("Checkpoint error %s", err)
+	}
+	// A checkpoint without new data doesn't change the state.
+	s2, err := w.Checkpoint()
+	if err != nil {
+		t.Fatalf("Checkpoint error %s", err)
+	}
+	if !bytes.Equal(s2, snapshot) {
+		t.Fatalf("second checkpoint differs")
+	}
+	// data written after the checkpoint is lost by the crash
+	if _, err = w.Write(data[100000:150000]); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+
+	compressed, uncompressed, err := CheckpointSizes(snapshot)
+	if err != nil {
+		t.Fatalf("CheckpointSizes error %s", err)
+	}
+	if uncompressed != 100000 {
+		t.Fatalf("uncompressed size %d; want %d", uncompressed, 100000)
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	if fi.Size() <= compressed {
+		t.Fatalf("file size %d; want more than %d", fi.Size(),
+			compressed)
+	}
+
+	// CheckSum of the configuration is ignored
+	w, err = WriterConfig{BlockSize: 1 << 16}.ResumeWriter(f, snapshot)
+	if err != nil {
+		t.Fatalf("ResumeWriter error %s", err)
+	}
+	if _, err = w.Write(data[uncompressed:]); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+
+	info, err := Stat(f)
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	if len(info.Streams) != 1 || info.Streams[0].Check != SHA256 {
+		t.Fatalf("unexpected streams %+v", info.Streams)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek error %s", err)
+	}
+	r, err := NewReader(f)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestResumeWriterBuffer(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	snapshot, err := w.Checkpoint()
+	if err != nil {
+		t.Fatalf("Checkpoint error %s", err)
+	}
+	compressed, uncompressed, err := CheckpointSizes(snapshot)
+	if err != nil {
+		t.Fatalf("CheckpointSizes error %s", err)
+	}
+	if compressed != HeaderLen || uncompressed != 0 {
+		t.Fatalf("CheckpointSizes returned %d, %d; want %d, 0",
+			compressed, uncompressed, HeaderLen)
+	}
+	// the header of the empty block must be removed
+	buf.Truncate(int(compressed))
+	if w, err = (WriterConfig{}).ResumeWriter(&buf, snapshot); err != nil {
+		t.Fatalf("ResumeWriter error %s", err)
+	}
+	const text = "The quick brown fox jumps over the lazy dog.\n"
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	got, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(got) != text {
+		t.Fatalf("got %q; want %q", got, text)
+	}
+}
+
+func TestResumeWriterCorrupted(t *testing.T) {
+	w, err := NewWriter(ioutil.Discard)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, "data"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	snapshot, err := w.Checkpoint()
+	if err != nil {
+		t.Fatalf("Checkpoint error %s", err)
+	}
+	for i := range snapshot {
+		p := append([]byte(nil), snapshot...)
+		p[i] ^= 0x10
+		if _, err = (WriterConfig{}).ResumeWriter(ioutil.Discard,
+			p); err == nil {
+			t.Fatalf("no error for corrupted byte %d", i)
+		}
+	}
+	if _, err = (WriterConfig{}).ResumeWriter(ioutil.Discard,
+		snapshot[:len(snapshot)-1]); err == nil {
+		t.Fatalf("no error for truncated snapshot")
+	}
+}
+
+func TestResumeWriterAfterStream(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<18)
+	data := txt.Bytes()
+	for _, appending := range []bool{false, true} {
+		f, err := ioutil.TempFile("", "checkpoint")
+		if err != nil {
+			t.Fatalf("TempFile error %s", err)
+		}
+		defer os.Remove(f.Name())
+		defer f.Close()
+
+		// first stream
+		w, err := NewWriter(f)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(data[:50000]); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		start, err := f.Seek(0, io.SeekCurrent)
+		if err != nil {
+			t.Fatalf("Seek error %s", err)
+		}
+
+		// The second stream or the appended blocks are
+		// interrupted after a checkpoint.
+		cfg := WriterConfig{BlockSize: 1 << 16}
+		if appending {
+			w, err = cfg.WriterAppend(f)
+		} else {
+			w, err = cfg.NewWriter(f)
+		}
+		if err != nil {
+			t.Fatalf("appending %t: writer error %s", appending, err)
+		}
+		if _, err = w.Write(data[50000:150000]); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		snapshot, err := w.Checkpoint()
+		if err != nil {
+			t.Fatalf("Checkpoint error %s", err)
+		}
+		if _, err = w.Write(data[150000:200000]); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		compressed, uncompressed, err := CheckpointSizes(snapshot)
+		if err != nil {
+			t.Fatalf("CheckpointSizes error %s", err)
+		}
+		if compressed <= start {
+			t.Fatalf("appending %t: compressed size %d; want "+
+				"more than %d", appending, compressed, start)
+		}
+
+		w, err = cfg.ResumeWriter(f, snapshot)
+		if err != nil {
+			t.Fatalf("ResumeWriter error %s", err)
+		}
+		skip := int64(50000)
+		if appending {
+			// the appended blocks continue the first stream
+			skip = 0
+		}
+		if _, err = w.Write(data[skip+uncompressed:]); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek error %s", err)
+		}
+		r, err := NewReader(f)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("appending %t: ReadAll error %s", appending,
+				err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("appending %t: decompressed data differs",
+				appending)
+		}
+	}
+}
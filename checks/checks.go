@@ -0,0 +1,153 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package checks provides the CRC-32 and CRC-64 checks of the xz
+// format. The CRC-64 uses the ECMA-182 polynomial in the bit-reversed
+// form and the initial and final inversion of the xz format. Unlike the
+// hashes of the packages hash/crc32 and hash/crc64, the Sum methods
+// append the check values in little-endian byte order, which is the
+// order used in xz files.
+//
+// Writer and Reader compute a check over the data flowing through them,
+// which is how the xz writer and reader compute the checks of blocks.
+package checks
+
+import (
+	"hash"
+	"hash/crc32"
+	"hash/crc64"
+	"io"
+)
+
+// Sizes of the check values in bytes.
+const (
+	Size32 = 4
+	Size64 = 8
+)
+
+// crc64Table is the table for the ECMA-182 polynomial used by xz.
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+// CRC32 returns the CRC-32 check of the data.
+func CRC32(p []byte) uint32 {
+	return crc32.ChecksumIEEE(p)
+}
+
+// UpdateCRC32 returns the CRC-32 check after adding the data in p to
+// the check crc.
+func UpdateCRC32(crc uint32, p []byte) uint32 {
+	return crc32.Update(crc, crc32.IEEETable, p)
+}
+
+// CRC64 returns the CRC-64 check of the data.
+func CRC64(p []byte) uint64 {
+	return crc64.Checksum(p, crc64Table)
+}
+
+// UpdateCRC64 returns the CRC-64 check after adding the data in p to
+// the check crc.
+func UpdateCRC64(crc uint64, p []byte) uint64 {
+	return crc64.Update(crc, crc64Table, p)
+}
+
+// crc32Digest computes the CRC-32 check.
+type crc32Digest struct {
+	crc uint32
+}
+
+// NewCRC32 returns a hash computing the CRC-32 check. Sum appends the
+// value in little-endian byte order.
+func NewCRC32() hash.Hash32 {
+	return new(crc32Digest)
+}
+
+func (d *crc32Digest) Size() int      { return Size32 }
+func (d *crc32Digest) BlockSize() int { return 1 }
+func (d *crc32Digest) Reset()         { d.crc = 0 }
+func (d *crc32Digest) Sum32() uint32  { return d.crc }
+
+// Write adds the data to the check. It never returns an error.
+func (d *crc32Digest) Write(p []byte) (n int, err error) {
+	d.crc = UpdateCRC32(d.crc, p)
+	return len(p), nil
+}
+
+// Sum appends the check value in little-endian byte order to b.
+func (d *crc32Digest) Sum(b []byte) []byte {
+	s := d.crc
+	return append(b, byte(s), byte(s>>8), byte(s>>16), byte(s>>24))
+}
+
+// crc64Digest computes the CRC-64 check.
+type crc64Digest struct {
+	crc uint64
+}
+
+// NewCRC64 returns a hash computing the CRC-64 check of xz. Sum
+// appends the value in little-endian byte order.
+func NewCRC64() hash.Hash64 {
+	return new(crc64Digest)
+}
+
+func (d *crc64Digest) Size() int      { return Size64 }
+func (d *crc64Digest) BlockSize() int { return 1 }
+func (d *crc64Digest) Reset()         { d.crc = 0 }
+func (d *crc64Digest) Sum64() uint64  { return d.crc }
+
+// Write adds the data to the check. It never returns an error.
+func (d *crc64Digest) Write(p []byte) (n int, err error) {
+	d.crc = UpdateCRC64(d.crc, p)
+	return len(p), nil
+}
+
+// Sum appends the check value in little-endian byte order to b.
+func (d *crc64Digest) Sum(b []byte) []byte {
+	s := d.crc
+	for i := 0; i < Size64; i++ {
+		b = append(b, byte(s))
+		s >>= 8
+	}
+	return b
+}
+
+// Writer writes data to an underlying writer and adds the data written
+// to a hash.
+type Writer struct {
+	w io.Writer
+	h hash.Hash
+}
+
+// NewWriter creates a writer that writes to w and adds the data
+// written to h.
+func NewWriter(w io.Writer, h hash.Hash) *Writer {
+	return &Writer{w: w, h: h}
+}
+
+// Write writes the data to the underlying writer. Only the bytes
+// actually written are added to the hash.
+func (cw *Writer) Write(p []byte) (n int, err error) {
+	n, err = cw.w.Write(p)
+	cw.h.Write(p[:n])
+	return n, err
+}
+
+// Reader reads data from an underlying reader and adds the data read to
+// a hash.
+type Reader struct {
+	r io.Reader
+	h hash.Hash
+}
+
+// NewReader creates a reader that reads from r and adds the data read
+// to h.
+func NewReader(r io.Reader, h hash.Hash) *Reader {
+	return &Reader{r: r, h: h}
+}
+
+// Read reads data from the underlying reader and adds it to the hash.
+func (cr *Reader) Read(p []byte) (n int, err error) {
+	n, err = cr.r.Read(p)
+	cr.h.Write(p[:n])
+	return n, err
+}
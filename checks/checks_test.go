@@ -0,0 +1,109 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package checks
+
+import (
+	"bytes"
+	"hash"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// check is the standard input for CRC test vectors.
+const check = "123456789"
+
+func TestCRC(t *testing.T) {
+	if c := CRC32([]byte(check)); c != 0xcbf43926 {
+		t.Fatalf("CRC32 returned %#08x; want %#08x", c, uint32(0xcbf43926))
+	}
+	if c := CRC64([]byte(check)); c != 0x995dc9bbdf1939fa {
+		t.Fatalf("CRC64 returned %#016x; want %#016x", c,
+			uint64(0x995dc9bbdf1939fa))
+	}
+	if c := UpdateCRC64(CRC64([]byte(check[:4])),
+		[]byte(check[4:])); c != CRC64([]byte(check)) {
+		t.Fatalf("UpdateCRC64 returned %#016x", c)
+	}
+	if c := UpdateCRC32(CRC32([]byte(check[:4])),
+		[]byte(check[4:])); c != CRC32([]byte(check)) {
+		t.Fatalf("UpdateCRC32 returned %#08x", c)
+	}
+}
+
+func TestSum(t *testing.T) {
+	tests := []struct {
+		h    hash.Hash
+		want []byte
+	}{
+		{NewCRC32(), []byte{0x26, 0x39, 0xf4, 0xcb}},
+		{NewCRC64(), []byte{0xfa, 0x39, 0x19, 0xdf, 0xbb, 0xc9, 0x5d,
+			0x99}},
+	}
+	for _, tc := range tests {
+		if _, err := io.WriteString(tc.h, check); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if tc.h.Size() != len(tc.want) {
+			t.Fatalf("Size returned %d; want %d", tc.h.Size(),
+				len(tc.want))
+		}
+		prefix := []byte("x")
+		got := tc.h.Sum(prefix)
+		if !bytes.Equal(got[1:], tc.want) || got[0] != 'x' {
+			t.Fatalf("Sum returned % x; want % x", got[1:], tc.want)
+		}
+		tc.h.Reset()
+		if got = tc.h.Sum(nil); !bytes.Equal(got, make([]byte,
+			len(tc.want))) {
+			t.Fatalf("Sum after Reset returned % x", got)
+		}
+	}
+}
+
+// shortWriter accepts at most n bytes per call.
+type shortWriter struct {
+	n   int
+	buf bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (n int, err error) {
+	if len(p) > w.n {
+		p = p[:w.n]
+		err = io.ErrShortWrite
+	}
+	n, _ = w.buf.Write(p)
+	return n, err
+}
+
+func TestWriter(t *testing.T) {
+	h := NewCRC64()
+	sw := &shortWriter{n: 4}
+	w := NewWriter(sw, h)
+	n, err := io.WriteString(w, check)
+	if err != io.ErrShortWrite || n != 4 {
+		t.Fatalf("WriteString returned %d, %v; want 4, %v", n, err,
+			io.ErrShortWrite)
+	}
+	if h.Sum64() != CRC64([]byte(check[:4])) {
+		t.Fatalf("hash covers data not written")
+	}
+}
+
+func TestReader(t *testing.T) {
+	h := NewCRC32()
+	r := NewReader(bytes.NewReader([]byte(check)), h)
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(p) != check {
+		t.Fatalf("read %q; want %q", p, check)
+	}
+	if h.Sum32() != 0xcbf43926 {
+		t.Fatalf("Sum32 returned %#08x; want %#08x", h.Sum32(),
+			uint32(0xcbf43926))
+	}
+}
@@ -9,6 +9,7 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"io/ioutil"
 	"os"
 	"os/signal"
 	"path/filepath"
@@ -54,15 +55,30 @@ type format struct {
 // sizes.
 var lzmaDictCapExps = []uint{18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
 
+// logger returns the standard logger if debug messages are requested by
+// the verbose option. Otherwise it returns nil to disable logging in
+// the readers and writers.
+func (opts *options) logger() lzma.Logger {
+	if opts.verbose >= 2 && opts.quiet == 0 {
+		return xlog.Default()
+	}
+	return nil
+}
+
 // formats contains the formats supported by gxz.
 var formats = map[string]*format{
 	"lzma": &format{
 		newCompressor: func(w io.Writer, opts *options,
 		) (c io.WriteCloser, err error) {
-			lc := lzma.WriterConfig{
-				Properties: &lzma.Properties{LC: 3, LP: 0,
-					PB: 2},
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+			lc, err := lzma.Preset(opts.preset, opts.extreme)
+			if err != nil {
+				return nil, err
+			}
+			if opts.niceLen > 0 {
+				lc.NiceLen = opts.niceLen
+			}
+			if opts.depth > 0 {
+				lc.Depth = opts.depth
 			}
 			return lc.NewWriter(w)
 		},
@@ -84,17 +100,22 @@ var formats = map[string]*format{
 	"xz": &format{
 		newCompressor: func(w io.Writer, opts *options,
 		) (c io.WriteCloser, err error) {
-			cfg := xz.WriterConfig{
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
+			cfg, err := xz.Preset(opts.preset, opts.extreme)
+			if err != nil {
+				return nil, err
+			}
+			if opts.niceLen > 0 {
+				cfg.NiceLen = opts.niceLen
 			}
+			if opts.depth > 0 {
+				cfg.Depth = opts.depth
+			}
+			cfg.Logger = opts.logger()
 			return cfg.NewWriter(w)
 		},
 		newDecompressor: func(r io.Reader, opts *options,
 		) (d io.Reader, err error) {
-			cfg := xz.ReaderConfig{
-				DictCap: 1 << lzmaDictCapExps[opts.preset],
-			}
-			return cfg.NewReader(r)
+			return xzReaderConfig(opts).NewReader(r)
 		},
 		validHeader: func(br *bufio.Reader) bool {
 			h, err := br.Peek(xz.HeaderLen)
@@ -106,6 +127,15 @@ var formats = map[string]*format{
 	},
 }
 
+// xzReaderConfig returns the configuration for the xz readers.
+func xzReaderConfig(opts *options) xz.ReaderConfig {
+	return xz.ReaderConfig{
+		DictCap: 1 << lzmaDictCapExps[opts.preset],
+		Logger:  opts.logger(),
+		Workers: opts.threads,
+	}
+}
+
 var errBase = errors.New("name has no base part")
 
 // targetName finds the correct target name taking the options into
@@ -386,6 +416,35 @@ func newDecompressor(br *bufio.Reader, opts *options) (dec io.Reader,
 	return dec, nil
 }
 
+// newParallelDecompressor creates a parallel reader for regular xz
+// files if more than one thread has been requested. The file is read
+// using ReadAt, so the buffered reader is only used to identify the
+// format. The function returns a nil reader if the file cannot be
+// decompressed in parallel.
+func newParallelDecompressor(f *os.File, br *bufio.Reader, opts *options,
+) (dec io.Reader, err error) {
+	if !opts.decompress {
+		panic("no decompressor needed")
+	}
+	if opts.threads == 1 || isStdin(f) {
+		return nil, nil
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil, nil
+	}
+	if _, err = readerFormat(br, opts); err != nil {
+		return nil, err
+	}
+	if opts.format != "xz" {
+		return nil, nil
+	}
+	return xzReaderConfig(opts).NewParallelReader(f, fi.Size())
+}
+
 // newReader creates a new reader for files.
 func newReader(path string, opts *options) (r *reader, err error) {
 	f, err := openFile(path, opts)
@@ -397,7 +456,10 @@ func newReader(path string, opts *options) (r *reader, err error) {
 		r = &reader{f: f, Reader: br, keep: opts.keep || opts.stdout}
 		return r, nil
 	}
-	dec, err := newDecompressor(br, opts)
+	dec, err := newParallelDecompressor(f, br, opts)
+	if err == nil && dec == nil {
+		dec, err = newDecompressor(br, opts)
+	}
 	if err != nil {
 		return nil, &userPathError{path, err}
 	}
@@ -417,6 +479,11 @@ func (r *reader) Close() error {
 		return errInval
 	}
 	defer func() { r.f = nil }()
+	if c, ok := r.Reader.(io.Closer); ok {
+		if err := c.Close(); err != nil {
+			return err
+		}
+	}
 	if isStdin(r.f) {
 		return nil
 	}
@@ -478,6 +545,23 @@ func printErr(err error) {
 	}
 }
 
+// copyFile copies the data from the reader to the writer. Zero runs
+// are compressed without the match finder and are written as holes
+// when decompressing to a file, unless the no-sparse option is set.
+func copyFile(w *writer, r *reader, opts *options) (n int64, err error) {
+	if opts.noSparse {
+		return io.Copy(w, r)
+	}
+	if xw, ok := w.cmp.(*xz.Writer); ok {
+		// nothing has been read from the file yet
+		return xz.CopySparse(xw, r.f)
+	}
+	if opts.decompress && !isStdout(w.f) {
+		return xz.WriteSparse(w.f, r)
+	}
+	return io.Copy(w, r)
+}
+
 // processFile process the file with the given path applying the
 // provided options.
 func processFile(path string, opts *options) (err error) {
@@ -487,6 +571,17 @@ func processFile(path string, opts *options) (err error) {
 		return
 	}
 	defer r.Close()
+	if opts.test {
+		// decompress the file without writing the data; the
+		// decompressor is copied directly to use its WriteTo
+		// method
+		if _, err = io.Copy(ioutil.Discard, r.Reader); err != nil {
+			printErr(&userPathError{path, err})
+			return err
+		}
+		r.SetSuccess()
+		return r.Close()
+	}
 	w, err := newWriter(path, r.Perm(), opts)
 	if err != nil {
 		printErr(err)
@@ -494,7 +589,7 @@ func processFile(path string, opts *options) (err error) {
 	}
 	defer w.Close()
 	quitSignalHandler := signalHandler(w)
-	if _, err = io.Copy(w, r); err != nil {
+	if _, err = copyFile(w, r, opts); err != nil {
 		close(quitSignalHandler)
 		printErr(err)
 		return err
@@ -0,0 +1,127 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// listHeader provides the header line for the list output.
+const listHeader = "Strms  Blocks   Compressed Uncompressed  Ratio  Check" +
+	"    DictCap  Filename"
+
+// sizeString formats a byte size using binary prefixes.
+func sizeString(n int64) string {
+	const units = "KMGTPE"
+	if n < 1024 {
+		return fmt.Sprintf("%d B", n)
+	}
+	f := float64(n)
+	i := -1
+	for f >= 1024 && i < len(units)-1 {
+		f /= 1024
+		i++
+	}
+	return fmt.Sprintf("%.1f %ciB", f, units[i])
+}
+
+// ratioString formats the compression ratio.
+func ratioString(compressed, uncompressed int64) string {
+	if uncompressed <= 0 {
+		return "---"
+	}
+	return fmt.Sprintf("%.3f", float64(compressed)/float64(uncompressed))
+}
+
+// listFile prints the information for a single file. The file content
+// is not decompressed; only the headers and the index are read.
+func listFile(path string, opts *options) error {
+	if path == "-" {
+		return errors.New("--list doesn't support standard input")
+	}
+	f, err := openFile(path, opts)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	// opts.format is changed by readerFormat
+	o := *opts
+	if _, err = readerFormat(bufio.NewReader(f), &o); err != nil {
+		return &userPathError{path, err}
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if o.format == "lzma" {
+		return listLZMA(f, path)
+	}
+	return listXZ(f, path, opts.verbose > 0)
+}
+
+// listLZMA prints the information provided by the header of an LZMA
+// file.
+func listLZMA(f *os.File, path string) error {
+	h, err := lzma.ReadHeaderInfo(f)
+	if err != nil {
+		return &userPathError{path, err}
+	}
+	fi, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	u := "Unknown"
+	if h.Size >= 0 {
+		u = sizeString(h.Size)
+	}
+	fmt.Printf("%5s %7s %12s %12s %6s  %-6s %10s  %s\n", "-", "-",
+		sizeString(fi.Size()), u, ratioString(fi.Size(), h.Size),
+		"None", sizeString(int64(h.DictCap)), path)
+	return nil
+}
+
+// listXZ prints the information for an xz file. If verbose is set, the
+// streams and blocks are listed as well.
+func listXZ(f *os.File, path string, verbose bool) error {
+	info, err := xz.Stat(f)
+	if err != nil {
+		return &userPathError{path, err}
+	}
+	check := "-"
+	for i, s := range info.Streams {
+		if i == 0 {
+			check = s.CheckName()
+		} else if check != s.CheckName() {
+			check = "Mixed"
+		}
+	}
+	fmt.Printf("%5d %7d %12s %12s %6s  %-6s %10s  %s\n",
+		len(info.Streams), info.Blocks(),
+		sizeString(info.CompressedSize),
+		sizeString(info.UncompressedSize),
+		ratioString(info.CompressedSize, info.UncompressedSize),
+		check, sizeString(info.DictCap()), path)
+	if !verbose {
+		return nil
+	}
+	for i, s := range info.Streams {
+		fmt.Printf("  stream %d: offset %d compressed %d"+
+			" uncompressed %d padding %d check %s\n",
+			i+1, s.Offset, s.CompressedSize,
+			s.Index.UncompressedSize(), s.Padding, s.CheckName())
+		for j, b := range s.Index.BlockRecords() {
+			fmt.Printf("    block %d: unpadded %d uncompressed %d\n",
+				j+1, b.UnpaddedSize, b.UncompressedSize)
+		}
+	}
+	return nil
+}
@@ -8,7 +8,6 @@
 package main
 
 //go:generate xb cat -o licenses.go xzLicense:github.com/ulikunitz/xz/LICENSE goLicense:~/go/LICENSE
-//go:generate xb version-file -o version.go
 
 import (
 	"fmt"
@@ -16,9 +15,11 @@ import (
 	"os"
 	"path/filepath"
 	"runtime/pprof"
+	"strconv"
 	"strings"
 	"text/template"
 
+	"github.com/ulikunitz/xz"
 	"github.com/ulikunitz/xz/internal/gflag"
 	"github.com/ulikunitz/xz/internal/term"
 	"github.com/ulikunitz/xz/internal/xlog"
@@ -31,6 +32,7 @@ in place).
 
   -c, --stdout      write to standard output and don't delete input files
   -d, --decompress  force decompression
+  -e, --extreme     use the slower match finder for better compression
   -f, --force       force overwrite of output file and compress links
   -F, --format <format>
                     Specify the file format to compress or decompress.
@@ -40,21 +42,48 @@ in place).
     lzma, alone     Compress to the .lzma file format.
   -h, --help        give this help
   -k, --keep        keep (don't delete) input files
+  -l, --list        list information about compressed files
   -L, --license     display software license
   -q, --quiet       suppress all warnings
+  -t, --test        test the integrity of compressed files
+  -T, --threads <n> number of threads for the decompression of regular xz
+                    files; 0 uses one thread per CPU; compression uses a
+                    single thread
   -v, --verbose     verbose mode
   -V, --version     display version string
   -z, --compress    force compression
   -0 ... -9         compression preset; default is 6
+  --no-sparse       don't create sparse files when decompressing and
+                    compress zero runs like other data
+  --lzma2 <options> override match finder options of the preset; the
+                    comma-separated options are nice=<n>, the match length
+                    that stops the search, and depth=<n>, the maximum
+                    search depth
   --cpuprofile <file>
                     create a cpuprofile that can be used with go tool pprof
 
-With no file, or when FILE is -, read standard input.
+With no file, or when FILE is -, read standard input. If the command is
+invoked as xzcat it decompresses to standard output, as unxz it
+decompresses in place.
 
 Report bugs using <https://github.com/ulikunitz/xz/issues>.
 `
 )
 
+// capabilities describes the capabilities of the xz package.
+func capabilities(c xz.Capabilities) string {
+	var filters, checks []string
+	for _, f := range c.Filters {
+		filters = append(filters, f.Name)
+	}
+	for _, ch := range c.Checks {
+		checks = append(checks, ch.Name)
+	}
+	return fmt.Sprintf("formats %s; filters %s; checks %s",
+		strings.Join(c.Formats, ", "), strings.Join(filters, ", "),
+		strings.Join(checks, ", "))
+}
+
 func usage(w io.Writer) {
 	fmt.Fprint(w, usageStr)
 }
@@ -92,16 +121,26 @@ contain code from those packages.
 type options struct {
 	help       bool
 	stdout     bool
+	compress   bool
 	decompress bool
+	extreme    bool
 	force      bool
 	format     string
 	keep       bool
 	license    bool
+	list       bool
 	version    bool
 	quiet      int
+	test       bool
+	threads    int
 	verbose    int
 	preset     int
+	lzma2      string
 	cpuprofile string
+	noSparse   bool
+	// match finder options parsed from lzma2
+	niceLen int
+	depth   int
 }
 
 func (o *options) Init() {
@@ -110,18 +149,74 @@ func (o *options) Init() {
 	}
 	gflag.BoolVarP(&o.help, "help", "h", false, "")
 	gflag.BoolVarP(&o.stdout, "stdout", "c", false, "")
+	gflag.BoolVarP(&o.compress, "compress", "z", false, "")
 	gflag.BoolVarP(&o.decompress, "decompress", "d", false, "")
+	gflag.BoolVarP(&o.extreme, "extreme", "e", false, "")
 	gflag.BoolVarP(&o.force, "force", "f", false, "")
 	gflag.StringVarP(&o.format, "format", "F", "auto", "")
 	gflag.BoolVarP(&o.keep, "keep", "k", false, "")
 	gflag.BoolVarP(&o.license, "license", "L", false, "")
+	gflag.BoolVarP(&o.list, "list", "l", false, "")
 	gflag.BoolVarP(&o.version, "version", "V", false, "")
 	gflag.CounterVarP(&o.quiet, "quiet", "q", 0, "")
+	gflag.BoolVarP(&o.test, "test", "t", false, "")
+	gflag.IntVarP(&o.threads, "threads", "T", 1, "")
 	gflag.CounterVarP(&o.verbose, "verbose", "v", 0, "")
 	gflag.PresetVar(&o.preset, 0, 9, 6, "")
+	gflag.StringVarP(&o.lzma2, "lzma2", "", "", "")
+	gflag.BoolVarP(&o.noSparse, "no-sparse", "", false, "")
 	gflag.StringVarP(&o.cpuprofile, "cpuprofile", "", "", "")
 }
 
+// parseLZMA2 parses the options given by the lzma2 flag.
+func parseLZMA2(o *options) error {
+	if o.lzma2 == "" {
+		return nil
+	}
+	for _, opt := range strings.Split(o.lzma2, ",") {
+		kv := strings.SplitN(opt, "=", 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("lzma2 option %q has no value", opt)
+		}
+		n, err := strconv.Atoi(kv[1])
+		if err != nil || n <= 0 {
+			return fmt.Errorf("lzma2 option %q has invalid value", opt)
+		}
+		switch kv[0] {
+		case "nice":
+			o.niceLen = n
+		case "depth":
+			o.depth = n
+		default:
+			return fmt.Errorf("lzma2 option %q unsupported", kv[0])
+		}
+	}
+	return nil
+}
+
+// setCmdDefaults sets the default options for the name under which the
+// command has been invoked. The names follow the links provided by
+// xz-utils: xzcat decompresses to standard output and unxz decompresses
+// in place. A ".exe" extension is ignored.
+func setCmdDefaults(o *options, cmdName string) {
+	cmdName = strings.TrimSuffix(strings.ToLower(cmdName), ".exe")
+	switch cmdName {
+	case "lzma", "glzma":
+		o.format = "lzma"
+	case "lzcat", "glzcat":
+		o.format = "lzma"
+		fallthrough
+	case "xzcat", "gxzcat":
+		o.stdout = true
+		o.decompress = true
+	case "unlzma", "unglzma":
+		o.format = "lzma"
+		fallthrough
+	case "unxz", "ungxz":
+		o.decompress = true
+	}
+}
+
 // normalizeFormat normalizes the format field of options. If the
 // function completes without error the format field will be "xz",
 // "lzma" or "auto". The latter only if the option decompress is true.
@@ -152,22 +247,11 @@ func main() {
 	opts := options{}
 	opts.Init()
 
-	switch cmdName {
-	case "lzma", "glzma":
-		opts.format = "lzma"
-	case "lzcat", "glzcat":
-		opts.format = "lzma"
-		fallthrough
-	case "xzcat", "gxzcat":
-		opts.stdout = true
-		opts.decompress = true
-	case "unlzma", "unglzma":
-		opts.format = "lzma"
-		fallthrough
-	case "unxz", "ungxz":
-		opts.decompress = true
-	}
+	setCmdDefaults(&opts, cmdName)
 	gflag.Parse()
+	if opts.compress {
+		opts.decompress = false
+	}
 
 	if opts.help {
 		usage(os.Stdout)
@@ -178,7 +262,8 @@ func main() {
 		os.Exit(0)
 	}
 	if opts.version {
-		xlog.Printf("version %s\n", version)
+		xlog.Printf("version %s\n", xz.Version())
+		xlog.Printf("%s\n", capabilities(xz.SupportedCapabilities()))
 		os.Exit(0)
 	}
 
@@ -208,10 +293,27 @@ func main() {
 		}
 	}
 
+	if opts.test || opts.list {
+		opts.decompress = true
+		opts.keep = true
+	}
+	if opts.threads < 0 {
+		pprof.StopCPUProfile()
+		xlog.Fatal("number of threads must not be negative")
+	}
+	if opts.threads > 1 && !opts.decompress {
+		pprof.StopCPUProfile()
+		xlog.Fatal("compression with multiple threads not supported")
+	}
+
 	if err := normalizeFormat(&opts); err != nil {
 		pprof.StopCPUProfile()
 		xlog.Fatal(err)
 	}
+	if err := parseLZMA2(&opts); err != nil {
+		pprof.StopCPUProfile()
+		xlog.Fatal(err)
+	}
 
 	var args []string
 	if gflag.NArg() == 0 {
@@ -221,7 +323,20 @@ func main() {
 		args = gflag.Args()
 	}
 
-	if opts.stdout && !opts.decompress && !opts.force &&
+	if opts.list {
+		exit := 0
+		fmt.Println(listHeader)
+		for _, arg := range args {
+			if err := listFile(arg, &opts); err != nil {
+				printErr(err)
+				exit = 1
+			}
+		}
+		pprof.StopCPUProfile()
+		os.Exit(exit)
+	}
+
+	if opts.stdout && !opts.decompress && !opts.force && !opts.test &&
 		term.IsTerminal(os.Stdout.Fd()) {
 		pprof.StopCPUProfile()
 		xlog.Fatal(`Compressed data will not be written to a terminal
@@ -1,3 +0,0 @@
-package main
-
-const version = "0.5.4"
@@ -0,0 +1,111 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"sync"
+	"testing"
+
+	"github.com/ulikunitz/xz/benchmarks"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// compressSteps compresses data with writes of at most step bytes.
+func compressSteps(t *testing.T, w *Writer, data []byte, step int) {
+	for len(data) > 0 {
+		n := step
+		if n > len(data) {
+			n = len(data)
+		}
+		if _, err := w.Write(data[:n]); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		data = data[n:]
+	}
+	if err := w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+}
+
+func TestDeterministicOutput(t *testing.T) {
+	size := 1 << 17
+	if testing.Short() {
+		size = 1 << 14
+	}
+	configs := []WriterConfig{
+		{},
+		{BlockSize: 1 << 16},
+		{Matcher: lzma.HashTable4, HashMixer: lzma.CRCMixer, WordLen: 3},
+		{HeaderSizes: true, BlockSize: 1 << 15},
+		{Store: true},
+	}
+	for _, level := range []int{0, 6} {
+		c, err := Preset(level, level == 6)
+		if err != nil {
+			t.Fatalf("Preset error %s", err)
+		}
+		configs = append(configs, c)
+	}
+	for _, s := range benchmarks.Generated() {
+		data := s.Data[:size]
+		for i, cfg := range configs {
+			var want bytes.Buffer
+			w, err := cfg.NewWriter(&want)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			compressSteps(t, w, data, len(data))
+
+			// different write sizes and a writer reused by
+			// Reset
+			for _, step := range []int{1000, 4096 + 1} {
+				var buf bytes.Buffer
+				if err = w.Reset(&buf); err != nil {
+					t.Fatalf("Reset error %s", err)
+				}
+				compressSteps(t, w, data, step)
+				if !bytes.Equal(buf.Bytes(), want.Bytes()) {
+					t.Fatalf("%s config %d: output for "+
+						"step %d differs", s.Name, i, step)
+				}
+			}
+
+			// independent writers running concurrently in
+			// different numbers of goroutines
+			for _, workers := range []int{1, 4} {
+				outs := make([]bytes.Buffer, workers)
+				var wg sync.WaitGroup
+				for k := range outs {
+					wg.Add(1)
+					go func(buf *bytes.Buffer) {
+						defer wg.Done()
+						w, err := cfg.NewWriter(buf)
+						if err != nil {
+							t.Errorf("NewWriter error %s", err)
+							return
+						}
+						if _, err = w.Write(data); err != nil {
+							t.Errorf("Write error %s", err)
+							return
+						}
+						if err = w.Close(); err != nil {
+							t.Errorf("Close error %s", err)
+						}
+					}(&outs[k])
+				}
+				wg.Wait()
+				for k := range outs {
+					if !bytes.Equal(outs[k].Bytes(),
+						want.Bytes()) {
+						t.Fatalf("%s config %d: output of "+
+							"worker %d of %d differs",
+							s.Name, i, k, workers)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/ulikunitz/xz"
+)
+
+// The example compresses a file into a file with the extension .xz.
+func Example_compressFile() {
+	dir, err := ioutil.TempDir("", "xz-example")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	name := filepath.Join(dir, "fox.txt")
+	text := "The quick brown fox jumps over the lazy dog.\n"
+	if err = ioutil.WriteFile(name, []byte(text), 0644); err != nil {
+		log.Fatal(err)
+	}
+
+	in, err := os.Open(name)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer in.Close()
+	out, err := os.Create(name + ".xz")
+	if err != nil {
+		log.Fatal(err)
+	}
+	w, err := xz.NewWriter(out)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = io.Copy(w, in); err != nil {
+		log.Fatal(err)
+	}
+	// Close writes the index and the footer of the xz stream, but
+	// doesn't close the file.
+	if err = w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	if err = out.Close(); err != nil {
+		log.Fatal(err)
+	}
+
+	data, err := ioutil.ReadFile(name + ".xz")
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println(bytes.HasPrefix(data, []byte("\xfd7zXZ\x00")))
+	// Output:
+	// true
+}
+
+// The example decompresses a stream while it is written by another
+// goroutine. Flush makes the data written so far available to the
+// reader.
+func Example_decompressStream() {
+	pr, pw := io.Pipe()
+	go func() {
+		w, err := xz.NewWriter(pw)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, line := range []string{"first line\n", "second line\n"} {
+			if _, err = io.WriteString(w, line); err != nil {
+				log.Fatal(err)
+			}
+			if err = w.Flush(); err != nil {
+				log.Fatal(err)
+			}
+		}
+		if err = w.Close(); err != nil {
+			log.Fatal(err)
+		}
+		pw.Close()
+	}()
+
+	r, err := xz.NewReader(pr)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = io.Copy(os.Stdout, r); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// first line
+	// second line
+}
+
+// compressParts compresses each part into its own xz stream using one
+// goroutine per part.
+func compressParts(parts []string) [][]byte {
+	streams := make([][]byte, len(parts))
+	var wg sync.WaitGroup
+	for i := range parts {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			var buf bytes.Buffer
+			w, err := xz.NewWriter(&buf)
+			if err != nil {
+				log.Fatal(err)
+			}
+			if _, err = io.WriteString(w, parts[i]); err != nil {
+				log.Fatal(err)
+			}
+			if err = w.Close(); err != nil {
+				log.Fatal(err)
+			}
+			streams[i] = buf.Bytes()
+		}(i)
+	}
+	wg.Wait()
+	return streams
+}
+
+// The example compresses independent parts of the data in parallel. The
+// concatenation of xz streams is an xz file, so the Reader decompresses
+// all parts in sequence.
+func Example_parallelCompress() {
+	parts := []string{"The quick brown fox ", "jumps over ",
+		"the lazy dog.\n"}
+	streams := compressParts(parts)
+
+	r, err := xz.NewReader(bytes.NewReader(bytes.Join(streams, nil)))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = io.Copy(os.Stdout, r); err != nil {
+		log.Fatal(err)
+	}
+	// Output:
+	// The quick brown fox jumps over the lazy dog.
+}
+
+// The example provides random access to a file of concatenated xz
+// streams. The offsets of the streams are recorded during compression
+// and a single stream is decompressed with the SingleStream option.
+func Example_randomAccess() {
+	parts := []string{"alpha\n", "beta\n", "gamma\n", "delta\n"}
+	streams := compressParts(parts)
+	offsets := make([]int, len(streams))
+	var file []byte
+	for i, s := range streams {
+		offsets[i] = len(file)
+		file = append(file, s...)
+	}
+
+	// read the third part only
+	c := xz.ReaderConfig{SingleStream: true}
+	r, err := c.NewReader(bytes.NewReader(file[offsets[2]:offsets[3]]))
+	if err != nil {
+		log.Fatal(err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		log.Fatal(err)
+	}
+	fmt.Print(strings.ToUpper(string(p)))
+	// Output:
+	// GAMMA
+}
@@ -6,16 +6,32 @@ package xz
 
 import (
 	"bytes"
-	"crypto/sha256"
 	"errors"
 	"fmt"
-	"hash"
 	"hash/crc32"
 	"io"
 
+	"github.com/ulikunitz/xz/checks"
 	"github.com/ulikunitz/xz/lzma"
 )
 
+// ErrPadding indicates a non-zero byte in the padding of a block
+// header, a block, an index or between streams. The xz format requires
+// all padding bytes to be zero.
+var ErrPadding = errors.New("xz: non-zero padding byte")
+
+// ErrChecksum reports a checksum that doesn't match the data it
+// protects. Part names the protected structure: "stream header",
+// "stream footer", "block header", "index" or "block".
+type ErrChecksum struct {
+	Part string
+}
+
+// Error returns the error message.
+func (e *ErrChecksum) Error() string {
+	return "xz: checksum error for " + e.Part
+}
+
 // allZeros checks whether a given byte slice has only zeros.
 func allZeros(p []byte) bool {
 	for _, c := range p {
@@ -54,47 +70,14 @@ const (
 // errInvalidFlags indicates that flags are invalid.
 var errInvalidFlags = errors.New("xz: invalid flags")
 
-// verifyFlags returns the error errInvalidFlags if the value is
-// invalid.
+// verifyFlags returns the error errInvalidFlags if the value is not a
+// check ID defined by the xz format. The check doesn't need to be
+// supported.
 func verifyFlags(flags byte) error {
-	switch flags {
-	case CRC32, CRC64, SHA256:
-		return nil
-	default:
+	if flags > maxCheckID {
 		return errInvalidFlags
 	}
-}
-
-// flagstrings maps flag values to strings.
-var flagstrings = map[byte]string{
-	CRC32:  "CRC-32",
-	CRC64:  "CRC-64",
-	SHA256: "SHA-256",
-}
-
-// flagString returns the string representation for the given flags.
-func flagString(flags byte) string {
-	s, ok := flagstrings[flags]
-	if !ok {
-		return "invalid"
-	}
-	return s
-}
-
-// newHashFunc returns a function that creates hash instances for the
-// hash method encoded in flags.
-func newHashFunc(flags byte) (newHash func() hash.Hash, err error) {
-	switch flags {
-	case CRC32:
-		newHash = newCRC32
-	case CRC64:
-		newHash = newCRC64
-	case SHA256:
-		newHash = sha256.New
-	default:
-		err = errInvalidFlags
-	}
-	return
+	return nil
 }
 
 // header provides the actual content of the xz file header: the flags.
@@ -134,7 +117,7 @@ func (h *header) UnmarshalBinary(data []byte) error {
 	crc := crc32.NewIEEE()
 	crc.Write(data[6:8])
 	if uint32LE(data[8:]) != crc.Sum32() {
-		return errors.New("xz: invalid checksum for file header")
+		return &ErrChecksum{Part: "stream header"}
 	}
 
 	// stream flags
@@ -240,7 +223,7 @@ func (f *footer) UnmarshalBinary(data []byte) error {
 	crc := crc32.NewIEEE()
 	crc.Write(data[4:10])
 	if uint32LE(data) != crc.Sum32() {
-		return errors.New("xz: footer checksum error")
+		return &ErrChecksum{Part: "stream footer"}
 	}
 
 	var g footer
@@ -347,13 +330,13 @@ func readSizeInBlockHeader(r io.ByteReader, present bool) (n int64, err error) {
 	if !present {
 		return -1, nil
 	}
-	x, _, err := readUvarint(r)
+	x, _, err := ReadUvarint(r)
 	if err != nil {
+		if err == errUvarint {
+			err = errors.New("xz: size overflow in block header")
+		}
 		return 0, err
 	}
-	if x >= 1<<63 {
-		return 0, errors.New("xz: size overflow in block header")
-	}
 	return int64(x), nil
 }
 
@@ -375,7 +358,7 @@ func (h *blockHeader) UnmarshalBinary(data []byte) error {
 	crc := crc32.NewIEEE()
 	crc.Write(data[:n])
 	if crc.Sum32() != uint32LE(data[n:]) {
-		return errors.New("xz: checksum error for block header")
+		return &ErrChecksum{Part: "block header"}
 	}
 
 	// Block header flags
@@ -418,11 +401,23 @@ func (h *blockHeader) UnmarshalBinary(data []byte) error {
 	// The only reasonable approach seems to be to ignore the
 	// padding size. We still check that all padding bytes are zero.
 	if !allZeros(data[n-k : n]) {
-		return errPadding
+		return ErrPadding
 	}
 	return nil
 }
 
+// flags returns the block flags describing the header.
+func (h *blockHeader) flags() byte {
+	flags := byte(len(h.filters) - 1)
+	if h.compressedSize >= 0 {
+		flags |= compressedSizePresent
+	}
+	if h.uncompressedSize >= 0 {
+		flags |= uncompressedSizePresent
+	}
+	return flags
+}
+
 // MarshalBinary marshals the binary header.
 func (h *blockHeader) MarshalBinary() (data []byte, err error) {
 	if !(minFilters <= len(h.filters) && len(h.filters) <= maxFilters) {
@@ -448,22 +443,15 @@ func (h *blockHeader) MarshalBinary() (data []byte, err error) {
 	buf.WriteByte(0)
 
 	// flags
-	flags := byte(len(h.filters) - 1)
-	if h.compressedSize >= 0 {
-		flags |= compressedSizePresent
-	}
-	if h.uncompressedSize >= 0 {
-		flags |= uncompressedSizePresent
-	}
-	buf.WriteByte(flags)
+	buf.WriteByte(h.flags())
 
 	p := make([]byte, 10)
 	if h.compressedSize >= 0 {
-		k := putUvarint(p, uint64(h.compressedSize))
+		k := PutUvarint(p, uint64(h.compressedSize))
 		buf.Write(p[:k])
 	}
 	if h.uncompressedSize >= 0 {
-		k := putUvarint(p, uint64(h.uncompressedSize))
+		k := PutUvarint(p, uint64(h.uncompressedSize))
 		buf.Write(p[:k])
 	}
 
@@ -525,7 +513,7 @@ func readFilter(r io.Reader) (f filter, err error) {
 	br := lzma.ByteReader(r)
 
 	// index
-	id, _, err := readUvarint(br)
+	id, _, err := ReadUvarint(br)
 	if err != nil {
 		return nil, err
 	}
@@ -591,7 +579,7 @@ type record struct {
 
 // readRecord reads an index record.
 func readRecord(r io.ByteReader) (rec record, n int, err error) {
-	u, k, err := readUvarint(r)
+	u, k, err := ReadUvarint(r)
 	n += k
 	if err != nil {
 		return rec, n, err
@@ -601,7 +589,7 @@ func readRecord(r io.ByteReader) (rec record, n int, err error) {
 		return rec, n, errors.New("xz: unpadded size negative")
 	}
 
-	u, k, err = readUvarint(r)
+	u, k, err = ReadUvarint(r)
 	n += k
 	if err != nil {
 		return rec, n, err
@@ -618,15 +606,15 @@ func readRecord(r io.ByteReader) (rec record, n int, err error) {
 func (rec *record) MarshalBinary() (data []byte, err error) {
 	// maximum length of a uvarint is 10
 	p := make([]byte, 20)
-	n := putUvarint(p, uint64(rec.unpaddedSize))
-	n += putUvarint(p[n:], uint64(rec.uncompressedSize))
+	n := PutUvarint(p, uint64(rec.unpaddedSize))
+	n += PutUvarint(p[n:], uint64(rec.uncompressedSize))
 	return p[:n], nil
 }
 
 // writeIndex writes the index, a sequence of records.
 func writeIndex(w io.Writer, index []record) (n int64, err error) {
-	crc := crc32.NewIEEE()
-	mw := io.MultiWriter(w, crc)
+	crc := checks.NewCRC32()
+	mw := checks.NewWriter(w, crc)
 
 	// index indicator
 	k, err := mw.Write([]byte{0})
@@ -637,7 +625,7 @@ func writeIndex(w io.Writer, index []record) (n int64, err error) {
 
 	// number of records
 	p := make([]byte, 10)
-	k = putUvarint(p, uint64(len(index)))
+	k = PutUvarint(p, uint64(len(index)))
 	k, err = mw.Write(p[:k])
 	n += int64(k)
 	if err != nil {
@@ -672,17 +660,21 @@ func writeIndex(w io.Writer, index []record) (n int64, err error) {
 	return n, err
 }
 
+// maxPreallocRecords limits the number of index records allocated
+// before the records have been read.
+const maxPreallocRecords = 1024
+
 // readIndexBody reads the index from the reader. It assumes that the
 // index indicator has already been read.
 func readIndexBody(r io.Reader) (records []record, n int64, err error) {
-	crc := crc32.NewIEEE()
+	crc := checks.NewCRC32()
 	// index indicator
 	crc.Write([]byte{0})
 
-	br := lzma.ByteReader(io.TeeReader(r, crc))
+	br := lzma.ByteReader(checks.NewReader(r, crc))
 
 	// number of records
-	u, k, err := readUvarint(br)
+	u, k, err := ReadUvarint(br)
 	n += int64(k)
 	if err != nil {
 		return nil, n, err
@@ -692,14 +684,21 @@ func readIndexBody(r io.Reader) (records []record, n int64, err error) {
 		return nil, n, errors.New("xz: record number overflow")
 	}
 
-	// list of records
-	records = make([]record, recLen)
-	for i := range records {
-		records[i], k, err = readRecord(br)
+	// list of records; the number of records is read from the
+	// stream and cannot be trusted for the allocation
+	c := recLen
+	if c > maxPreallocRecords {
+		c = maxPreallocRecords
+	}
+	records = make([]record, 0, c)
+	for i := 0; i < recLen; i++ {
+		var rec record
+		rec, k, err = readRecord(br)
 		n += int64(k)
 		if err != nil {
 			return nil, n, err
 		}
+		records = append(records, rec)
 	}
 
 	p := make([]byte, padLen(int64(n+1)), 4)
@@ -709,7 +708,7 @@ func readIndexBody(r io.Reader) (records []record, n int64, err error) {
 		return nil, n, err
 	}
 	if !allZeros(p) {
-		return nil, n, errors.New("xz: non-zero byte in index padding")
+		return nil, n, ErrPadding
 	}
 
 	// crc32
@@ -721,7 +720,7 @@ func readIndexBody(r io.Reader) (records []record, n int64, err error) {
 		return records, n, err
 	}
 	if uint32LE(p) != s {
-		return nil, n, errors.New("xz: wrong checksum for index")
+		return nil, n, &ErrChecksum{Part: "index"}
 	}
 
 	return records, n, nil
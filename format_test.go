@@ -140,3 +140,13 @@ func TestBlockHeader(t *testing.T) {
 		t.Errorf("got dictCap %d; want %d", glf.dictCap, hlf.dictCap)
 	}
 }
+
+func TestIndexRecordCount(t *testing.T) {
+	// claims 2^62 records but provides only one
+	data := []byte{0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x80, 0x40,
+		0x10, 0x20}
+	_, _, err := readIndexBody(bytes.NewReader(data))
+	if err == nil {
+		t.Fatalf("readIndexBody returned no error")
+	}
+}
@@ -0,0 +1,53 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ulikunitz/xz/xztestdata"
+)
+
+func FuzzReader(f *testing.F) {
+	data, err := ioutil.ReadFile("fox.xz")
+	if err != nil {
+		f.Fatalf("ReadFile error %s", err)
+	}
+	f.Add(data)
+	var buf bytes.Buffer
+	w, err := WriterConfig{BlockSize: 100, CheckSum: CRC32}.NewWriter(&buf)
+	if err != nil {
+		f.Fatalf("NewWriter error %s", err)
+	}
+	const text = "The quick brown fox jumps over the lazy dog."
+	for i := 0; i < 5; i++ {
+		if _, err = io.WriteString(w, text); err != nil {
+			f.Fatalf("WriteString error %s", err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		f.Fatalf("w.Close error %s", err)
+	}
+	f.Add(buf.Bytes())
+	for _, reg := range xztestdata.Regressions() {
+		if reg.Format == "xz" {
+			f.Add(reg.Data)
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := ReaderConfig{DictCap: 1 << 12, MemoryLimit: 1 << 24}
+		r, err := c.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(r, 1<<24))
+	})
+}
@@ -0,0 +1,51 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+// BlockRecord describes a block in the index of an xz stream.
+type BlockRecord struct {
+	// size of the block without the block padding
+	UnpaddedSize int64
+	// size of the data stored in the block
+	UncompressedSize int64
+}
+
+// Index provides the block records of an xz stream.
+type Index struct {
+	records []record
+}
+
+// Len returns the number of blocks in the index.
+func (ix *Index) Len() int {
+	return len(ix.records)
+}
+
+// Block returns the record for block i.
+func (ix *Index) Block(i int) BlockRecord {
+	rec := ix.records[i]
+	return BlockRecord{
+		UnpaddedSize:     rec.unpaddedSize,
+		UncompressedSize: rec.uncompressedSize,
+	}
+}
+
+// BlockRecords returns the records of all blocks of the index.
+func (ix *Index) BlockRecords() []BlockRecord {
+	blocks := make([]BlockRecord, len(ix.records))
+	for i := range blocks {
+		blocks[i] = ix.Block(i)
+	}
+	return blocks
+}
+
+// UncompressedSize returns the size of the uncompressed data of the
+// stream.
+func (ix *Index) UncompressedSize() int64 {
+	var n int64
+	for _, rec := range ix.records {
+		n += rec.uncompressedSize
+	}
+	return n
+}
@@ -0,0 +1,233 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package index supports the encoding and decoding of the index of an
+// xz stream. The index follows the blocks of the stream and contains a
+// record with the unpadded and the uncompressed size for each block.
+// Tools building or inspecting xz files, for instance seekable
+// archives, can use the package to manipulate indexes directly.
+package index
+
+import (
+	"errors"
+	"hash/crc32"
+	"sort"
+
+	"github.com/ulikunitz/xz"
+)
+
+// Record describes a block in the index of an xz stream.
+type Record struct {
+	// size of the block without the block padding
+	UnpaddedSize int64
+	// size of the data stored in the block
+	UncompressedSize int64
+}
+
+// Limits for the unpadded size of a block. The smallest block consists
+// of the block header with a single filter and no data. The largest
+// size must allow the padding and the encoding as uvarint.
+const (
+	MinUnpaddedSize = 5
+	MaxUnpaddedSize = 1<<63 - 4
+)
+
+// verify checks the record for errors.
+func (rec Record) verify() error {
+	if !(MinUnpaddedSize <= rec.UnpaddedSize &&
+		rec.UnpaddedSize <= MaxUnpaddedSize) {
+		return errors.New("index: unpadded size out of range")
+	}
+	if rec.UncompressedSize < 0 {
+		return errors.New("index: uncompressed size negative")
+	}
+	return nil
+}
+
+// paddedSize returns the size of the block including the padding.
+func (rec Record) paddedSize() int64 {
+	return (rec.UnpaddedSize + 3) &^ 3
+}
+
+// Index stores the records of the blocks of an xz stream. The zero
+// value is an empty index.
+type Index struct {
+	records []Record
+	// offsets of the blocks; the compressed offsets are relative to
+	// the start of the first block
+	offsets []Block
+	// total sizes of all blocks
+	compressed   int64
+	uncompressed int64
+}
+
+// Block describes the position of a block in the stream.
+type Block struct {
+	Record
+	// number of the block starting with zero
+	Number int
+	// offset of the block header relative to the start of the first
+	// block
+	CompressedOffset int64
+	// offset of the first byte of the block in the uncompressed data
+	UncompressedOffset int64
+}
+
+// Append adds the record for the next block to the index.
+func (ix *Index) Append(rec Record) error {
+	if err := rec.verify(); err != nil {
+		return err
+	}
+	c := ix.compressed + rec.paddedSize()
+	u := ix.uncompressed + rec.UncompressedSize
+	if c < 0 || u < 0 {
+		return errors.New("index: size overflow")
+	}
+	ix.offsets = append(ix.offsets, Block{
+		Record:             rec,
+		Number:             len(ix.records),
+		CompressedOffset:   ix.compressed,
+		UncompressedOffset: ix.uncompressed,
+	})
+	ix.records = append(ix.records, rec)
+	ix.compressed, ix.uncompressed = c, u
+	return nil
+}
+
+// Len returns the number of records in the index.
+func (ix *Index) Len() int {
+	return len(ix.records)
+}
+
+// Record returns the record for block i.
+func (ix *Index) Record(i int) Record {
+	return ix.records[i]
+}
+
+// Records returns the records of all blocks.
+func (ix *Index) Records() []Record {
+	return append([]Record(nil), ix.records...)
+}
+
+// CompressedSize returns the size of all blocks including their
+// padding.
+func (ix *Index) CompressedSize() int64 {
+	return ix.compressed
+}
+
+// UncompressedSize returns the size of the uncompressed data of all
+// blocks.
+func (ix *Index) UncompressedSize() int64 {
+	return ix.uncompressed
+}
+
+// Lookup returns the block containing the byte at the given offset of
+// the uncompressed data. The ok result is false if the offset is
+// outside of the uncompressed data. Blocks without data are never
+// returned.
+func (ix *Index) Lookup(offset int64) (b Block, ok bool) {
+	if !(0 <= offset && offset < ix.uncompressed) {
+		return b, false
+	}
+	i := sort.Search(len(ix.offsets), func(i int) bool {
+		b := &ix.offsets[i]
+		return offset < b.UncompressedOffset+b.UncompressedSize
+	})
+	return ix.offsets[i], true
+}
+
+// BinarySize returns the length of the binary encoding of the index.
+// It is the backward size stored in the stream footer.
+func (ix *Index) BinarySize() int64 {
+	var p [xz.MaxUvarintLen]byte
+	put := func(x int64) int64 {
+		return int64(xz.PutUvarint(p[:], uint64(x)))
+	}
+	n := 1 + put(int64(len(ix.records)))
+	for _, rec := range ix.records {
+		n += put(rec.UnpaddedSize) + put(rec.UncompressedSize)
+	}
+	return (n+3)&^3 + 4
+}
+
+// MarshalBinary encodes the index as stored in the xz stream. The
+// encoding starts with the index indicator and ends with the CRC32
+// checksum.
+func (ix *Index) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, 0, ix.BinarySize())
+	var p [xz.MaxUvarintLen]byte
+	put := func(x int64) {
+		data = append(data, p[:xz.PutUvarint(p[:], uint64(x))]...)
+	}
+	data = append(data, 0)
+	put(int64(len(ix.records)))
+	for _, rec := range ix.records {
+		put(rec.UnpaddedSize)
+		put(rec.UncompressedSize)
+	}
+	for len(data)&3 != 0 {
+		data = append(data, 0)
+	}
+	s := crc32.ChecksumIEEE(data)
+	data = append(data, byte(s), byte(s>>8), byte(s>>16), byte(s>>24))
+	return data, nil
+}
+
+// errIndex indicates a malformed index encoding.
+var errIndex = errors.New("index: invalid index encoding")
+
+// UnmarshalBinary decodes the index from its encoding in the xz
+// stream. The data must contain the complete index from the index
+// indicator to the CRC32 checksum.
+func (ix *Index) UnmarshalBinary(data []byte) error {
+	if len(data) < 8 || len(data)&3 != 0 || data[0] != 0 {
+		return errIndex
+	}
+	body, sum := data[:len(data)-4], data[len(data)-4:]
+	s := uint32(sum[0]) | uint32(sum[1])<<8 | uint32(sum[2])<<16 |
+		uint32(sum[3])<<24
+	if crc32.ChecksumIEEE(body) != s {
+		return errors.New("index: wrong checksum")
+	}
+	p := body[1:]
+	count, n, err := xz.Uvarint(p)
+	if err != nil {
+		return err
+	}
+	p = p[n:]
+	// Each record requires at least two bytes.
+	if count > uint64(len(p)/2) {
+		return errIndex
+	}
+	var x Index
+	x.records = make([]Record, 0, count)
+	x.offsets = make([]Block, 0, count)
+	for i := uint64(0); i < count; i++ {
+		u, n, err := xz.Uvarint(p)
+		if err != nil {
+			return err
+		}
+		p = p[n:]
+		c, n, err := xz.Uvarint(p)
+		if err != nil {
+			return err
+		}
+		p = p[n:]
+		rec := Record{UnpaddedSize: int64(u),
+			UncompressedSize: int64(c)}
+		if err := x.Append(rec); err != nil {
+			return err
+		}
+	}
+	if len(p) > 3 {
+		return errIndex
+	}
+	for _, b := range p {
+		if b != 0 {
+			return errors.New("index: non-zero byte in padding")
+		}
+	}
+	*ix = x
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+)
+
+func TestIndexMarshal(t *testing.T) {
+	var ix Index
+	records := []Record{{1234, 1}, {2345, 200000}, {12, 0}, {5, 7}}
+	for _, rec := range records {
+		if err := ix.Append(rec); err != nil {
+			t.Fatalf("Append(%v) error %s", rec, err)
+		}
+	}
+	data, err := ix.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	if int64(len(data)) != ix.BinarySize() {
+		t.Fatalf("MarshalBinary returned %d bytes; BinarySize %d",
+			len(data), ix.BinarySize())
+	}
+	var g Index
+	if err = g.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if g.Len() != len(records) {
+		t.Fatalf("got %d records; want %d", g.Len(), len(records))
+	}
+	for i, rec := range records {
+		if g.Record(i) != rec {
+			t.Fatalf("record %d is %v; want %v", i, g.Record(i), rec)
+		}
+	}
+	data[5] ^= 1
+	if err = g.UnmarshalBinary(data); err == nil {
+		t.Fatalf("UnmarshalBinary accepted corrupted data")
+	}
+	if err = ix.Append(Record{4, 10}); err == nil {
+		t.Fatalf("Append accepted unpadded size 4")
+	}
+}
+
+func TestIndexLookup(t *testing.T) {
+	var ix Index
+	for _, rec := range []Record{{1001, 100}, {50, 0}, {70, 20}} {
+		if err := ix.Append(rec); err != nil {
+			t.Fatalf("Append error %s", err)
+		}
+	}
+	tests := []struct {
+		offset int64
+		ok     bool
+		number int
+		coff   int64
+		uoff   int64
+	}{
+		{-1, false, 0, 0, 0},
+		{0, true, 0, 0, 0},
+		{99, true, 0, 0, 0},
+		{100, true, 2, 1056, 100},
+		{119, true, 2, 1056, 100},
+		{120, false, 0, 0, 0},
+	}
+	for _, c := range tests {
+		b, ok := ix.Lookup(c.offset)
+		if ok != c.ok {
+			t.Fatalf("Lookup(%d) returned ok %t; want %t",
+				c.offset, ok, c.ok)
+		}
+		if !ok {
+			continue
+		}
+		if b.Number != c.number || b.CompressedOffset != c.coff ||
+			b.UncompressedOffset != c.uoff {
+			t.Fatalf("Lookup(%d) returned %+v; want block %d at "+
+				"%d/%d", c.offset, b, c.number, c.coff, c.uoff)
+		}
+	}
+	if n := ix.CompressedSize(); n != 1056+72 {
+		t.Fatalf("CompressedSize %d; want %d", n, 1056+72)
+	}
+}
+
+func TestIndexStream(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := xz.WriterConfig{BlockSize: 1000}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	p := bytes.Repeat([]byte("The quick brown fox. "), 200)
+	if _, err = w.Write(p); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	stream := buf.Bytes()
+	footer := stream[len(stream)-12:]
+	n := (int(binary.LittleEndian.Uint32(footer[4:])) + 1) * 4
+	data := stream[len(stream)-12-n : len(stream)-12]
+	var ix Index
+	if err = ix.UnmarshalBinary(data); err != nil {
+		t.Fatalf("UnmarshalBinary error %s", err)
+	}
+	if ix.UncompressedSize() != int64(len(p)) {
+		t.Fatalf("UncompressedSize %d; want %d", ix.UncompressedSize(),
+			len(p))
+	}
+	if ix.Len() != (len(p)+999)/1000 {
+		t.Fatalf("got %d records; want %d", ix.Len(),
+			(len(p)+999)/1000)
+	}
+	// the blocks follow the 12-byte stream header
+	if ix.CompressedSize() != int64(len(stream)-24-n) {
+		t.Fatalf("CompressedSize %d; want %d", ix.CompressedSize(),
+			len(stream)-24-n)
+	}
+	q, err := ix.MarshalBinary()
+	if err != nil {
+		t.Fatalf("MarshalBinary error %s", err)
+	}
+	if !bytes.Equal(q, data) {
+		t.Fatalf("MarshalBinary differs from the index in the stream")
+	}
+}
@@ -0,0 +1,33 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+// +build go1.23
+
+package xz
+
+import "iter"
+
+// Blocks returns an iterator over the block records of the index.
+func (ix *Index) Blocks() iter.Seq[BlockRecord] {
+	return func(yield func(BlockRecord) bool) {
+		for i := range ix.records {
+			if !yield(ix.Block(i)) {
+				return
+			}
+		}
+	}
+}
+
+// Streams returns an iterator over the indexes of the streams that have
+// been read completely. See Indexes.
+func (r *Reader) Streams() iter.Seq[*Index] {
+	return func(yield func(*Index) bool) {
+		for _, ix := range r.indexes {
+			if !yield(ix) {
+				return
+			}
+		}
+	}
+}
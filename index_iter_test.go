@@ -0,0 +1,55 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.23
+// +build go1.23
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestReaderStreams(t *testing.T) {
+	xz, data := multiStream(t, 1000)
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	i := 0
+	for ix := range r.Streams() {
+		var n int64
+		k := 0
+		for b := range ix.Blocks() {
+			if b != ix.Block(k) {
+				t.Fatalf("block %d: got %+v; want %+v", k, b,
+					ix.Block(k))
+			}
+			n += b.UncompressedSize
+			k++
+		}
+		if k != ix.Len() {
+			t.Fatalf("Blocks yielded %d records; want %d", k,
+				ix.Len())
+		}
+		if n != int64(len(data[i])) {
+			t.Fatalf("stream %d: blocks have %d bytes; want %d",
+				i, n, len(data[i]))
+		}
+		i++
+	}
+	if i != len(data) {
+		t.Fatalf("Streams yielded %d indexes; want %d", i, len(data))
+	}
+	// stop iteration early
+	for range r.Streams() {
+		break
+	}
+}
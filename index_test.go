@@ -0,0 +1,77 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// multiStream creates two concatenated xz streams with blocks of the
+// given size. It returns the stream and the data for each stream.
+func multiStream(t *testing.T, blockSize int64) (xz []byte, data [][]byte) {
+	var buf bytes.Buffer
+	for i := 0; i < 2; i++ {
+		p := bytes.Repeat([]byte("The quick brown fox. "), 100*(i+1))
+		w, err := WriterConfig{BlockSize: blockSize}.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(p); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		data = append(data, p)
+	}
+	return buf.Bytes(), data
+}
+
+func TestReaderIndexes(t *testing.T) {
+	const blockSize = 1000
+	xz, data := multiStream(t, blockSize)
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if n := len(r.Indexes()); n != 0 {
+		t.Fatalf("Indexes returned %d indexes before reading", n)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	indexes := r.Indexes()
+	if len(indexes) != len(data) {
+		t.Fatalf("got %d indexes; want %d", len(indexes), len(data))
+	}
+	for i, ix := range indexes {
+		n := int64(len(data[i]))
+		if u := ix.UncompressedSize(); u != n {
+			t.Fatalf("stream %d: uncompressed size %d; want %d",
+				i, u, n)
+		}
+		blocks := ix.BlockRecords()
+		if len(blocks) != ix.Len() {
+			t.Fatalf("BlockRecords returned %d records; want %d",
+				len(blocks), ix.Len())
+		}
+		if k := (n + blockSize - 1) / blockSize; int64(len(blocks)) != k {
+			t.Fatalf("stream %d: got %d blocks; want %d", i,
+				len(blocks), k)
+		}
+		for j, b := range blocks {
+			if b != ix.Block(j) {
+				t.Fatalf("BlockRecords()[%d] differs from Block",
+					j)
+			}
+			if b.UnpaddedSize <= 0 || b.UncompressedSize > blockSize {
+				t.Fatalf("unexpected block record %+v", b)
+			}
+		}
+	}
+}
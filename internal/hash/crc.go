@@ -0,0 +1,58 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hash
+
+import "hash/crc32"
+
+// CRC provides the CRC-based hash used by the match finders of
+// liblzma. The hash isn't rolling in the strict sense; it is recomputed
+// from the last Len bytes, which is cheap for the short words hashed by
+// the LZMA compressor.
+type CRC struct {
+	p []byte
+	i int
+}
+
+// NewCRC creates a new CRC hash for byte sequences of length n. The
+// number n must be positive; the function panics if this isn't the
+// case.
+func NewCRC(n int) *CRC {
+	if n < 1 {
+		panic("argument n must be positive")
+	}
+	return &CRC{p: make([]byte, 0, n)}
+}
+
+// Len returns the length of the byte sequence for which a hash is
+// generated.
+func (r *CRC) Len() int {
+	return cap(r.p)
+}
+
+// RollByte hashes the next byte and returns the hash value of the last
+// Len bytes.
+func (r *CRC) RollByte(x byte) uint64 {
+	if len(r.p) < cap(r.p) {
+		r.p = append(r.p, x)
+	} else {
+		r.p[r.i] = x
+		r.i = (r.i + 1) % cap(r.p)
+	}
+	// b returns the k-th byte of the word with the oldest first
+	b := func(k int) uint32 {
+		return uint32(r.p[(r.i+k)%len(r.p)])
+	}
+	h := crc32.IEEETable[b(0)]
+	if len(r.p) > 1 {
+		h ^= b(1)
+	}
+	if len(r.p) > 2 {
+		h ^= b(2) << 8
+	}
+	for k := 3; k < len(r.p); k++ {
+		h ^= crc32.IEEETable[b(k)] << uint(5*(k-2))
+	}
+	return uint64(h)
+}
@@ -0,0 +1,31 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package hash
+
+import "testing"
+
+func TestCRCSimple(t *testing.T) {
+	p := []byte("abcdefgh")
+	for n := 2; n <= 5; n++ {
+		r := NewCRC(n)
+		hs := Hashes(r, p)
+		for i, h := range hs {
+			w := Hashes(NewCRC(n), p[i:i+n])[0]
+			if h != w {
+				t.Errorf("n=%d rolling hash %d: %#016x; want %#016x",
+					n, i, h, w)
+			}
+		}
+	}
+}
+
+func BenchmarkCRC(b *testing.B) {
+	p := makeBenchmarkBytes(4096)
+	r := NewCRC(4)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		Hashes(r, p)
+	}
+}
@@ -8,7 +8,8 @@ Package hash provides rolling hashes.
 Rolling hashes have to be used for maintaining the positions of n-byte
 sequences in the dictionary buffer.
 
-The package provides currently the Rabin-Karp rolling hash and a Cyclic
-Polynomial hash. Both support the Hashes method to be used with an interface.
+The package provides currently the Rabin-Karp rolling hash, a Cyclic
+Polynomial hash and the CRC-based hash of liblzma. All support the Hashes
+method to be used with an interface.
 */
 package hash
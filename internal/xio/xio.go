@@ -0,0 +1,37 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package xio provides the I/O helpers shared by the xz and lzma
+// packages.
+package xio
+
+import "io"
+
+// MaxZeroWrites limits the number of consecutive writes without
+// progress accepted by WriteFull.
+const MaxZeroWrites = 100
+
+// WriteFull writes all bytes of p to w. Writers for non-blocking sinks
+// may write only a part of p without returning an error, which
+// violates the io.Writer contract. Such short writes are retried;
+// io.ErrShortWrite is returned after MaxZeroWrites consecutive writes
+// without progress.
+func WriteFull(w io.Writer, p []byte) (n int, err error) {
+	zeros := 0
+	for n < len(p) {
+		k, err := w.Write(p[n:])
+		n += k
+		if err != nil {
+			return n, err
+		}
+		if k > 0 {
+			zeros = 0
+			continue
+		}
+		if zeros++; zeros >= MaxZeroWrites {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xio
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+// shortWriter writes at most 3 bytes per call and nothing in every
+// second call without reporting an error.
+type shortWriter struct {
+	buf   bytes.Buffer
+	calls int
+}
+
+func (w *shortWriter) Write(p []byte) (n int, err error) {
+	w.calls++
+	if w.calls%2 == 0 {
+		return 0, nil
+	}
+	if len(p) > 3 {
+		p = p[:3]
+	}
+	return w.buf.Write(p)
+}
+
+// stuckWriter never makes progress.
+type stuckWriter struct {
+	calls int
+}
+
+func (w *stuckWriter) Write(p []byte) (n int, err error) {
+	w.calls++
+	return 0, nil
+}
+
+func TestWriteFull(t *testing.T) {
+	var w shortWriter
+	p := []byte("The quick brown fox jumps over the lazy dog.")
+	n, err := WriteFull(&w, p)
+	if err != nil {
+		t.Fatalf("WriteFull error %s", err)
+	}
+	if n != len(p) || !bytes.Equal(w.buf.Bytes(), p) {
+		t.Fatalf("WriteFull wrote %q; want %q", w.buf.Bytes(), p)
+	}
+	var sw stuckWriter
+	if _, err = WriteFull(&sw, p); err != io.ErrShortWrite {
+		t.Fatalf("WriteFull returned error %v; want %s", err,
+			io.ErrShortWrite)
+	}
+	if sw.calls != MaxZeroWrites {
+		t.Fatalf("WriteFull called Write %d times; want %d", sw.calls,
+			MaxZeroWrites)
+	}
+}
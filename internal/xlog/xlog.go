@@ -14,6 +14,10 @@
 // The Fatal functions call os.Exit(1) after the message is output
 // unless not suppressed by the flags. The Panic functions call panic
 // after the writing the log message unless suppressed.
+//
+// The interface Leveled describes the leveled logging used by the
+// readers and writers of the xz and lzma packages. A Logger satisfies
+// it; the Info level is controlled by the flag Lnoprint.
 package xlog
 
 import (
@@ -73,6 +77,21 @@ func New(out io.Writer, prefix string, flag int) *Logger {
 // std is the standard logger used by the package scope functions.
 var std = New(os.Stderr, "", Lstdflags)
 
+// Default returns the standard logger used by the package scope
+// functions.
+func Default() *Logger { return std }
+
+// Leveled is the interface for loggers supporting the levels debug,
+// info and warning.
+type Leveled interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+}
+
+// Logger supports the leveled logging.
+var _ Leveled = (*Logger)(nil)
+
 // itoa converts the integer to ASCII. A negative widths will avoid
 // zero-padding. The function supports only non-negative integers.
 func itoa(buf *[]byte, i int, wid int) {
@@ -360,6 +379,18 @@ func Println(v ...interface{}) {
 	std.Outputln(2, Lnoprint, v...)
 }
 
+// Infof prints the message like Printf. The printing might be
+// suppressed by the flag Lnoprint.
+func (l *Logger) Infof(format string, v ...interface{}) {
+	l.Outputf(2, Lnoprint, format, v...)
+}
+
+// Infof prints the message like Printf. The printing might be
+// suppressed by the flag Lnoprint.
+func Infof(format string, v ...interface{}) {
+	std.Outputf(2, Lnoprint, format, v...)
+}
+
 // Debug prints the message like Print. The printing might be suppressed
 // by the flag Lnodebug.
 func (l *Logger) Debug(v ...interface{}) {
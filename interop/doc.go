@@ -0,0 +1,14 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package interop contains the interoperability tests of the xz and
+// lzma packages with the xz command of XZ Utils. The tests verify that
+// the xz command decodes our output and that we decode the output of
+// the xz command at every preset. They require the xz command in PATH
+// and are only compiled with the build tag interop.
+//
+//	$ go test -tags interop ./interop
+//
+// With the -short flag only the small samples are used.
+package interop
@@ -0,0 +1,177 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build interop
+// +build interop
+
+package interop
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/benchmarks"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// xzPath returns the path of the xz command. The test is skipped if
+// the command cannot be found.
+func xzPath(t *testing.T) string {
+	path, err := exec.LookPath("xz")
+	if err != nil {
+		t.Skip("xz command not found")
+	}
+	return path
+}
+
+// samples returns the test corpus. The large generated samples are
+// omitted in short mode.
+func samples(t *testing.T) []benchmarks.Sample {
+	s := []benchmarks.Sample{
+		{Name: "empty", Data: []byte{}},
+		{Name: "byte", Data: []byte{'a'}},
+		{Name: "fox", Data: []byte(
+			"The quick brown fox jumps over the lazy dog.\n")},
+	}
+	if !testing.Short() {
+		s = append(s, benchmarks.Generated()...)
+	}
+	return s
+}
+
+// run executes the xz command with the given arguments on the input
+// and returns its output.
+func run(t *testing.T, input []byte, args ...string) []byte {
+	cmd := exec.Command(xzPath(t), args...)
+	cmd.Stdin = bytes.NewReader(input)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("xz %v error %s: %s", args, err, stderr.Bytes())
+	}
+	return out
+}
+
+func TestXZDecodesOurs(t *testing.T) {
+	xzPath(t)
+	for _, s := range samples(t) {
+		for level := 0; level <= 9; level++ {
+			cfg, err := xz.Preset(level, false)
+			if err != nil {
+				t.Fatalf("Preset(%d) error %s", level, err)
+			}
+			var buf bytes.Buffer
+			w, err := cfg.NewWriter(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			if _, err = w.Write(s.Data); err != nil {
+				t.Fatalf("Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("Close error %s", err)
+			}
+			out := run(t, buf.Bytes(), "-dc")
+			if !bytes.Equal(out, s.Data) {
+				t.Fatalf("%s level %d: xz output differs",
+					s.Name, level)
+			}
+		}
+	}
+}
+
+func TestXZDecodesOurLZMA(t *testing.T) {
+	xzPath(t)
+	for _, s := range samples(t) {
+		var buf bytes.Buffer
+		w, err := lzma.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(s.Data); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		out := run(t, buf.Bytes(), "--format=lzma", "-dc")
+		if !bytes.Equal(out, s.Data) {
+			t.Fatalf("%s: xz output differs", s.Name)
+		}
+	}
+}
+
+// readAll decodes data with the reader returned by newReader.
+func readAll(data []byte, newReader func(io.Reader) (io.Reader,
+	error)) ([]byte, error) {
+
+	r, err := newReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+func newXZReader(r io.Reader) (io.Reader, error) {
+	return xz.NewReader(r)
+}
+
+func newLZMAReader(r io.Reader) (io.Reader, error) {
+	return lzma.NewReader(r)
+}
+
+func TestOursDecodesXZ(t *testing.T) {
+	xzPath(t)
+	for _, s := range samples(t) {
+		for level := 0; level <= 9; level++ {
+			for _, e := range []string{"", "e"} {
+				preset := fmt.Sprintf("-%d%s", level, e)
+				for _, f := range []struct {
+					format    string
+					newReader func(io.Reader) (io.Reader, error)
+				}{
+					{"xz", newXZReader},
+					{"lzma", newLZMAReader},
+				} {
+					z := run(t, s.Data, "-c", "--format="+f.format,
+						preset)
+					out, err := readAll(z, f.newReader)
+					if err != nil {
+						t.Fatalf("%s %s %s: read error %s",
+							s.Name, f.format, preset, err)
+					}
+					if !bytes.Equal(out, s.Data) {
+						t.Fatalf("%s %s %s: output differs",
+							s.Name, f.format, preset)
+					}
+				}
+			}
+		}
+	}
+}
+
+func TestOursDecodesXZChecks(t *testing.T) {
+	xzPath(t)
+	for _, s := range samples(t) {
+		for _, check := range []string{"none", "crc32", "crc64",
+			"sha256"} {
+			z := run(t, s.Data, "-c", "--check="+check)
+			out, err := readAll(z, newXZReader)
+			if err != nil {
+				t.Fatalf("%s check %s: read error %s", s.Name,
+					check, err)
+			}
+			if !bytes.Equal(out, s.Data) {
+				t.Fatalf("%s check %s: output differs", s.Name,
+					check)
+			}
+		}
+	}
+}
@@ -37,3 +37,45 @@ func (r *breader) ReadByte() (c byte, err error) {
 	}
 	return r.p[0], nil
 }
+
+// sourceError wraps an error of the underlying reader that has been
+// returned at a point, where the reader can continue after the error.
+// The read can be retried, which supports sources returning temporary
+// errors like network timeouts.
+type sourceError struct {
+	err error
+}
+
+// Error returns the message of the wrapped error.
+func (e *sourceError) Error() string { return e.err.Error() }
+
+// sticky stores err in *p, unless it is a sourceError, and returns the
+// error for the caller. Stored errors are returned by all further
+// calls; errors of the source are unwrapped and not stored.
+func sticky(p *error, err error) error {
+	if e, ok := err.(*sourceError); ok {
+		return e.err
+	}
+	*p = err
+	return err
+}
+
+// readSource reads from r into p until it is full. The argument n
+// gives the number of bytes already read and is updated, so the read
+// can be continued after an error of r, which is returned as
+// sourceError. The end of the data before p is full is reported as
+// io.ErrUnexpectedEOF.
+func readSource(r io.Reader, p []byte, n *int) error {
+	for *n < len(p) {
+		k, err := r.Read(p[*n:])
+		*n += k
+		if err == nil || *n == len(p) {
+			continue
+		}
+		if err == io.EOF {
+			return io.ErrUnexpectedEOF
+		}
+		return &sourceError{err}
+	}
+	return nil
+}
@@ -0,0 +1,144 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+var errTimeout = errors.New("timeout")
+
+// timeoutReader returns at most 7 bytes per call and fails every
+// third call with errTimeout without returning data.
+type timeoutReader struct {
+	r     io.Reader
+	calls int
+}
+
+func (r *timeoutReader) Read(p []byte) (n int, err error) {
+	r.calls++
+	if r.calls%3 == 0 {
+		return 0, errTimeout
+	}
+	if len(p) > 7 {
+		p = p[:7]
+	}
+	return r.r.Read(p)
+}
+
+// readRetry reads r completely and retries after errTimeout.
+func readRetry(r io.Reader) (data []byte, err error) {
+	var buf bytes.Buffer
+	p := make([]byte, 100)
+	for {
+		n, err := r.Read(p)
+		buf.Write(p[:n])
+		switch err {
+		case nil, errTimeout:
+			continue
+		case io.EOF:
+			return buf.Bytes(), nil
+		}
+		return buf.Bytes(), err
+	}
+}
+
+func TestReadRetry(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<15)
+	data := txt.Bytes()
+	var lzmaBuf, lzma2Buf bytes.Buffer
+	w, err := WriterConfig{Size: int64(len(data))}.NewWriter(&lzmaBuf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	w2, err := Writer2Config{ChunkSize: 1 << 12}.NewWriter2(&lzma2Buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	for _, w := range []io.WriteCloser{w, w2} {
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+	}
+	// The stored chunks test the reading of uncompressed chunks.
+	var storeBuf bytes.Buffer
+	w2, err = Writer2Config{Store: true}.NewWriter2(&storeBuf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w2.Write(data); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w2.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+
+	// The header is read before the source is used by the reader.
+	src := bytes.NewReader(lzmaBuf.Bytes())
+	r, err := ReaderConfig{BufSize: 64}.NewReader(
+		io.MultiReader(io.LimitReader(src, HeaderLen),
+			&timeoutReader{r: src}))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	got, err := readRetry(r)
+	if err != nil {
+		t.Fatalf("Reader: read error %s", err)
+	}
+	if !bytes.Equal(got, data) {
+		t.Fatalf("Reader: data differs")
+	}
+	for _, stream := range [][]byte{lzma2Buf.Bytes(), storeBuf.Bytes()} {
+		r2, err := NewReader2(&timeoutReader{
+			r: bytes.NewReader(stream)})
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		if got, err = readRetry(r2); err != nil {
+			t.Fatalf("Reader2: read error %s", err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("Reader2: data differs")
+		}
+	}
+}
+
+func TestReadRetryCorrupt(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = io.WriteString(w, "The quick brown fox."); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	p := buf.Bytes()
+	// invalid chunk type
+	p[0] = 0x03
+	r, err := NewReader2(bytes.NewReader(p))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if _, err = r.Read(make([]byte, 10)); err == nil {
+		t.Fatalf("Read didn't return an error")
+	}
+	// The error is sticky.
+	if _, err2 := r.Read(make([]byte, 10)); err2 != err {
+		t.Fatalf("second Read returned %v; want %v", err2, err)
+	}
+}
@@ -6,6 +6,7 @@ package lzma
 
 import (
 	"errors"
+	"io"
 )
 
 // buffer provides a circular buffer of bytes. If the front index equals
@@ -23,11 +24,54 @@ func newBuffer(size int) *buffer {
 	return &buffer{data: make([]byte, size+1)}
 }
 
+// newBufferIn creates a buffer with the given size using the memory of
+// p. The buffer is allocated if p is nil. ErrDictBuf is returned if the
+// capacity of p is too small.
+func newBufferIn(p []byte, size int) (*buffer, error) {
+	if p == nil {
+		return newBuffer(size), nil
+	}
+	if err := verifyDictBuf(p, size); err != nil {
+		return nil, err
+	}
+	return &buffer{data: p[:size+1]}, nil
+}
+
+// verifyDictBuf checks that the capacity of the dictionary buffer p
+// provided by the caller suffices for a buffer of the given size. A nil
+// buffer is always valid.
+func verifyDictBuf(p []byte, size int) error {
+	if p != nil && cap(p)-1 < size {
+		return ErrDictBuf
+	}
+	return nil
+}
+
 // Cap returns the capacity of the buffer.
 func (b *buffer) Cap() int {
 	return len(b.data) - 1
 }
 
+// grow increases the capacity of the buffer to size. The bytes in
+// front of the front index keep their distances, so the buffered data
+// and the history of written data are preserved. The function does
+// nothing if size is not larger than the current capacity.
+func (b *buffer) grow(size int) {
+	if size <= b.Cap() {
+		return
+	}
+	data := make([]byte, size+1)
+	k := copy(data, b.data[b.front:])
+	copy(data[k:], b.data[:b.front])
+	r := b.rear - b.front
+	if r <= 0 {
+		r += len(b.data)
+	}
+	b.rear = r
+	b.front = len(b.data)
+	b.data = data
+}
+
 // Resets the buffer. The front and rear index are set to zero.
 func (b *buffer) Reset() {
 	b.front = 0
@@ -64,6 +108,20 @@ func (b *buffer) addIndex(i int, n int) int {
 	return i
 }
 
+// peekByte returns the next byte without removing it from the buffer.
+// The buffer must not be empty.
+func (b *buffer) peekByte() byte {
+	return b.data[b.rear]
+}
+
+// readByte removes the next byte from the buffer and returns it. The
+// buffer must not be empty.
+func (b *buffer) readByte() byte {
+	c := b.data[b.rear]
+	b.rear = b.addIndex(b.rear, 1)
+	return c
+}
+
 // Read reads bytes from the buffer into p and returns the number of
 // bytes read. The function never returns an error but might return less
 // data than requested.
@@ -90,6 +148,28 @@ func (b *buffer) Peek(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// WriteTo writes the buffered bytes directly from the buffer array to w
+// and advances the rear index over the bytes written.
+func (b *buffer) WriteTo(w io.Writer) (n int64, err error) {
+	for b.Buffered() > 0 {
+		end := b.front
+		if end < b.rear {
+			end = len(b.data)
+		}
+		p := b.data[b.rear:end]
+		k, err := w.Write(p)
+		b.rear = b.addIndex(b.rear, k)
+		n += int64(k)
+		if err != nil {
+			return n, err
+		}
+		if k < len(p) {
+			return n, io.ErrShortWrite
+		}
+	}
+	return n, nil
+}
+
 // Discard skips the n next bytes to read from the buffer, returning the
 // bytes discarded.
 //
@@ -130,6 +210,25 @@ func (b *buffer) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// readFrom reads data from r directly into the buffer array using a
+// single Read call. At most n bytes are read. If no space is available
+// ErrNoSpace is returned.
+func (b *buffer) readFrom(r io.Reader, n int) (k int, err error) {
+	if m := b.Available(); m < n {
+		n = m
+	}
+	if n <= 0 {
+		return 0, ErrNoSpace
+	}
+	end := b.front + n
+	if end > len(b.data) {
+		end = len(b.data)
+	}
+	k, err = r.Read(b.data[b.front:end])
+	b.front = b.addIndex(b.front, k)
+	return k, err
+}
+
 // WriteByte writes a single byte into the buffer. The error ErrNoSpace
 // is returned if no single byte is available in the buffer for writing.
 func (b *buffer) WriteByte(c byte) error {
@@ -141,17 +240,53 @@ func (b *buffer) WriteByte(c byte) error {
 	return nil
 }
 
-// prefixLen returns the length of the common prefix of a and b.
-func prefixLen(a, b []byte) int {
-	if len(a) > len(b) {
-		a, b = b, a
+// behind returns the n bytes preceding the rear index as slices of the
+// buffer array. The second slice is only non-empty if the bytes wrap
+// around the end of the array. The buffer is not changed. The caller
+// must ensure that n doesn't exceed the data written before rear.
+func (b *buffer) behind(n int) (p, q []byte) {
+	i := b.rear - n
+	if i >= 0 {
+		return b.data[i:b.rear], nil
+	}
+	return b.data[len(b.data)+i:], b.data[:b.rear]
+}
+
+// copyMatch appends n bytes starting dist bytes before the front index
+// to the buffer. The source may overlap the bytes appended, which
+// repeats the data as required for matches. If not enough space is
+// available, ErrNoSpace is returned and the buffer is not changed.
+func (b *buffer) copyMatch(dist, n int) error {
+	if !(0 < dist && dist < len(b.data)) {
+		return errors.New("buffer.copyMatch: distance out of range")
+	}
+	if n > b.Available() {
+		return ErrNoSpace
+	}
+	i := b.front - dist
+	if i < 0 {
+		i += len(b.data)
 	}
-	for i, c := range a {
-		if b[i] != c {
-			return i
+	for n > 0 {
+		// The source ends at the front index or at the end of the
+		// array, so it never overlaps the destination.
+		end := b.front
+		if i >= b.front {
+			end = len(b.data)
+		}
+		p := b.data[i:end]
+		if len(p) > n {
+			p = p[:n]
 		}
+		k := copy(b.data[b.front:], p)
+		if k < len(p) {
+			copy(b.data, p[k:])
+		}
+		b.front = b.addIndex(b.front, len(p))
+		i = b.addIndex(i, len(p))
+		n -= len(p)
 	}
-	return len(a)
+	return nil
 }
 
 // matchLen returns the length of the common prefix for the given
@@ -6,6 +6,7 @@ package lzma
 
 import (
 	"bytes"
+	"errors"
 	"io"
 	"testing"
 )
@@ -200,6 +201,36 @@ func TestPrefixLen(t *testing.T) {
 	}
 }
 
+func TestPrefixLenWords(t *testing.T) {
+	a := make([]byte, 40)
+	for i := range a {
+		a[i] = byte(i)
+	}
+	b := make([]byte, len(a))
+	for n := 0; n <= len(a); n++ {
+		for k := 0; k <= n; k++ {
+			copy(b, a)
+			if k < n {
+				b[k] ^= 0x80
+			}
+			if got := prefixLen(a[:n], b[:n]); got != k {
+				t.Fatalf("prefixLen with length %d returned %d;"+
+					" want %d", n, got, k)
+			}
+		}
+	}
+}
+
+func BenchmarkPrefixLen(b *testing.B) {
+	p := bytes.Repeat([]byte("abcdefgh"), 32)
+	q := append([]byte(nil), p...)
+	q[len(q)-1] = 'x'
+	b.SetBytes(int64(len(p)))
+	for i := 0; i < b.N; i++ {
+		prefixLen(p, q)
+	}
+}
+
 func TestMatchLen(t *testing.T) {
 	buf := newBuffer(13)
 	const s = "abcaba"
@@ -228,3 +259,175 @@ func TestMatchLen(t *testing.T) {
 		}
 	}
 }
+
+func TestBuffer_grow(t *testing.T) {
+	b := newBuffer(5)
+	if _, err := io.WriteString(b, "abcde"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	p := make([]byte, 3)
+	b.Read(p)
+	// wrap the buffer around
+	if _, err := io.WriteString(b, "fg"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	b.grow(8)
+	if b.Cap() != 8 {
+		t.Fatalf("Cap returned %d; want %d", b.Cap(), 8)
+	}
+	if n := b.Buffered(); n != 4 {
+		t.Fatalf("Buffered returned %d; want %d", n, 4)
+	}
+	// the write of "fg" has overwritten the a
+	if k := b.matchLen(2, []byte("bc")); k != 2 {
+		t.Fatalf("history lost; matchLen returned %d; want %d", k, 2)
+	}
+	if _, err := io.WriteString(b, "hijk"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	p = make([]byte, 8)
+	n, _ := b.Read(p)
+	if s := string(p[:n]); s != "defghijk" {
+		t.Fatalf("Read returned %q; want %q", s, "defghijk")
+	}
+}
+
+func TestBuffer_WriteTo(t *testing.T) {
+	b := newBuffer(5)
+	if _, err := io.WriteString(b, "abcd"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	p := make([]byte, 2)
+	b.Read(p)
+	// wrap the buffer around
+	if _, err := io.WriteString(b, "ef"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	var out bytes.Buffer
+	n, err := b.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("WriteTo error %s", err)
+	}
+	if n != 4 || out.String() != "cdef" {
+		t.Fatalf("WriteTo wrote %d bytes %q; want 4 bytes %q", n,
+			out.String(), "cdef")
+	}
+	if k := b.Buffered(); k != 0 {
+		t.Fatalf("Buffered returned %d; want 0", k)
+	}
+}
+
+// errWriter returns an error after n bytes have been written.
+type errWriter struct{ n int }
+
+var errWriterFull = errors.New("errWriter full")
+
+func (w *errWriter) Write(p []byte) (n int, err error) {
+	if len(p) > w.n {
+		n, err = w.n, errWriterFull
+	} else {
+		n = len(p)
+	}
+	w.n -= n
+	return n, err
+}
+
+func TestBuffer_WriteTo_error(t *testing.T) {
+	b := newBuffer(10)
+	if _, err := io.WriteString(b, "abcdef"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	n, err := b.WriteTo(&errWriter{n: 4})
+	if err != errWriterFull || n != 4 {
+		t.Fatalf("WriteTo returned %d, %v; want 4, %v", n, err,
+			errWriterFull)
+	}
+	p := make([]byte, 10)
+	k, _ := b.Read(p)
+	if s := string(p[:k]); s != "ef" {
+		t.Fatalf("Read returned %q; want %q", s, "ef")
+	}
+}
+
+func TestBuffer_behind(t *testing.T) {
+	b := newBuffer(5)
+	if _, err := io.WriteString(b, "abcd"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	p := make([]byte, 4)
+	b.Read(p)
+	// wrap the buffer around
+	if _, err := io.WriteString(b, "efg"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	b.Read(p[:3])
+	front, rear := b.front, b.rear
+	p, q := b.behind(4)
+	if s := string(p) + string(q); s != "defg" {
+		t.Fatalf("behind(4) returned %q; want %q", s, "defg")
+	}
+	if len(q) == 0 {
+		t.Fatalf("behind(4) didn't wrap around")
+	}
+	if b.front != front || b.rear != rear {
+		t.Fatalf("behind changed the buffer")
+	}
+}
+
+func TestBuffer_copyMatch(t *testing.T) {
+	b := newBuffer(8)
+	if _, err := io.WriteString(b, "xyzab"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	p := make([]byte, 8)
+	b.Read(p[:3])
+	// overlapping match wrapping around the end of the array
+	if err := b.copyMatch(2, 5); err != nil {
+		t.Fatalf("copyMatch error %s", err)
+	}
+	n, _ := b.Read(p)
+	if s := string(p[:n]); s != "abababa" {
+		t.Fatalf("Read returned %q; want %q", s, "abababa")
+	}
+	if _, err := io.WriteString(b, "1234567"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	front, rear := b.front, b.rear
+	if err := b.copyMatch(3, 2); err != ErrNoSpace {
+		t.Fatalf("copyMatch returned %v; want %v", err, ErrNoSpace)
+	}
+	if b.front != front || b.rear != rear {
+		t.Fatalf("copyMatch changed the buffer on error")
+	}
+}
+
+func TestEncoderDictCopyN(t *testing.T) {
+	m, err := HashTable4.new(MinDictCap, 0, 0, false, 0, CyclicPolyMixer)
+	if err != nil {
+		t.Fatalf("new matcher error %s", err)
+	}
+	d, err := newEncoderDict(MinDictCap, 16, m, nil)
+	if err != nil {
+		t.Fatalf("newEncoderDict error %s", err)
+	}
+	if _, err = io.WriteString(d, "abcdef"); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	d.Discard(6)
+	var out bytes.Buffer
+	if n, err := d.CopyN(&out, 7); err != ErrNoSpace || n != 0 {
+		t.Fatalf("CopyN(7) returned %d, %v; want 0, %v", n, err,
+			ErrNoSpace)
+	}
+	if n, err := d.CopyN(&out, 4); err != nil || n != 4 {
+		t.Fatalf("CopyN(4) returned %d, %v", n, err)
+	}
+	if s := out.String(); s != "cdef" {
+		t.Fatalf("CopyN(4) wrote %q; want %q", s, "cdef")
+	}
+	if n, err := d.CopyN(&errWriter{n: 2}, 4); err != errWriterFull ||
+		n != 2 {
+		t.Fatalf("CopyN returned %d, %v; want 2, %v", n, err,
+			errWriterFull)
+	}
+}
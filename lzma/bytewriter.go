@@ -7,6 +7,8 @@ package lzma
 import (
 	"errors"
 	"io"
+
+	"github.com/ulikunitz/xz/internal/xio"
 )
 
 // ErrLimit indicates that the limit of the LimitedByteWriter has been
@@ -35,3 +37,14 @@ func (l *LimitedByteWriter) WriteByte(c byte) error {
 	l.N--
 	return nil
 }
+
+// fullWriter retries the short writes of the underlying writer using
+// xio.WriteFull.
+type fullWriter struct {
+	w io.Writer
+}
+
+// Write writes all bytes of p to the underlying writer.
+func (fw fullWriter) Write(p []byte) (n int, err error) {
+	return xio.WriteFull(fw.w, p)
+}
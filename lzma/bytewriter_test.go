@@ -0,0 +1,108 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// flakyWriter writes only a random prefix of the data, which may be
+// empty, and never reports an error for it, like writers for
+// non-blocking sinks do.
+type flakyWriter struct {
+	buf bytes.Buffer
+	rnd *rand.Rand
+}
+
+func newFlakyWriter() *flakyWriter {
+	return &flakyWriter{rnd: rand.New(rand.NewSource(7))}
+}
+
+func (w *flakyWriter) Write(p []byte) (n int, err error) {
+	n = w.rnd.Intn(len(p) + 1)
+	if n > 17 {
+		n = 17
+	}
+	return w.buf.Write(p[:n])
+}
+
+// stuckWriter never makes progress.
+type stuckWriter struct{}
+
+func (stuckWriter) Write(p []byte) (n int, err error) { return 0, nil }
+
+func TestWritersShortWrites(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<16)
+	data := txt.Bytes()
+	tests := []struct {
+		name   string
+		create func(w io.Writer) (io.WriteCloser, error)
+		open   func(r io.Reader) (io.Reader, error)
+	}{
+		{"Writer",
+			func(w io.Writer) (io.WriteCloser, error) {
+				return NewWriter(w)
+			},
+			func(r io.Reader) (io.Reader, error) {
+				return NewReader(r)
+			}},
+		{"Writer2",
+			func(w io.Writer) (io.WriteCloser, error) {
+				return Writer2Config{ChunkSize: 1 << 12}.NewWriter2(w)
+			},
+			func(r io.Reader) (io.Reader, error) {
+				return NewReader2(r)
+			}},
+		{"Writer2Store",
+			func(w io.Writer) (io.WriteCloser, error) {
+				return Writer2Config{Store: true}.NewWriter2(w)
+			},
+			func(r io.Reader) (io.Reader, error) {
+				return NewReader2(r)
+			}},
+	}
+	for _, tc := range tests {
+		fw := newFlakyWriter()
+		w, err := tc.create(fw)
+		if err != nil {
+			t.Fatalf("%s: create error %s", tc.name, err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("%s: Write error %s", tc.name, err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("%s: Close error %s", tc.name, err)
+		}
+		r, err := tc.open(&fw.buf)
+		if err != nil {
+			t.Fatalf("%s: open error %s", tc.name, err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", tc.name, err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Fatalf("%s: decompressed data differs", tc.name)
+		}
+	}
+	w, err := NewWriter2(stuckWriter{})
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != io.ErrShortWrite {
+		t.Fatalf("Close returned error %v; want %s", err,
+			io.ErrShortWrite)
+	}
+}
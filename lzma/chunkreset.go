@@ -0,0 +1,121 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "fmt"
+
+// ChunkReset selects the reset requested for the chunk following a
+// flush. The resets are ordered; every reset includes the resets
+// before it.
+type ChunkReset int
+
+// Resets supported by FlushReset.
+const (
+	// NoReset continues the state and the dictionary.
+	NoReset ChunkReset = iota
+	// StateReset resets the probabilities and the last distances
+	// of the encoder state.
+	StateReset
+	// PropsReset resets the state and writes the properties in the
+	// chunk header.
+	PropsReset
+	// DictReset resets the dictionary in addition. The following
+	// data is compressed independently of the data before, which
+	// is required to produce identical chunks for identical data
+	// aligned at the reset.
+	DictReset
+)
+
+// chunkTypes maps the resets to the minimum chunk types of the next
+// compressed chunk.
+var chunkTypes = [...]chunkType{
+	NoReset:    cL,
+	StateReset: cLR,
+	PropsReset: cLRN,
+	DictReset:  cLRND,
+}
+
+// FlushReset flushes the buffered data like Flush and requests the
+// reset r for the next chunk. A state or properties reset is kept for
+// the next compressed chunk, since uncompressed chunks cannot reset
+// the state. A dictionary reset is done immediately and drops the
+// preset dictionary.
+func (w *Writer2) FlushReset(r ChunkReset) error {
+	err := w.lock()
+	defer w.unlock()
+	if err != nil {
+		return err
+	}
+	return w.flushReset(r)
+}
+
+// flushReset flushes the buffered data and applies the reset r.
+func (w *Writer2) flushReset(r ChunkReset) error {
+	if !(NoReset <= r && r <= DictReset) {
+		return fmt.Errorf("lzma: chunk reset %d out of range [%d, %d]",
+			r, NoReset, DictReset)
+	}
+	if err := w.flush(); err != nil {
+		return err
+	}
+	if r == DictReset {
+		return w.resetDict()
+	}
+	if t := chunkTypes[r]; t > w.reset {
+		w.reset = t
+	}
+	w.requestReset()
+	return nil
+}
+
+// resetDict clears the dictionary after all data has been flushed.
+// The next chunk resets the dictionary, the state and the properties.
+func (w *Writer2) resetDict() error {
+	e := w.encoder
+	e.traceBase += e.dict.Pos()
+	e.dict.Reset()
+	w.cstate = start
+	w.ctype = w.cstate.defaultChunkType()
+	w.reset = cEOS
+	w.start.Reset()
+	e.state.deepcopy(w.start)
+	return e.Reopen(&w.lbw)
+}
+
+// requestReset upgrades the type of the next chunk to the pending
+// reset. The encoder state is reset accordingly.
+func (w *Writer2) requestReset() {
+	if w.reset <= w.ctype {
+		return
+	}
+	w.ctype = w.reset
+	w.start.Reset()
+	w.encoder.state.deepcopy(w.start)
+}
+
+// room returns the number of bytes that can still be written to the
+// current chunk. A chunk never crosses the next periodic dictionary
+// reset.
+func (w *Writer2) room() int {
+	m := w.chunkLimit() - w.written()
+	if w.resetInterval > 0 {
+		r := w.nextReset - w.uncompressed - int64(w.written())
+		if r < int64(m) {
+			m = int(r)
+		}
+	}
+	return m
+}
+
+// endChunk terminates the current chunk if it is full. The dictionary
+// is reset if the end of the reset interval has been reached.
+func (w *Writer2) endChunk() error {
+	if w.resetInterval > 0 &&
+		w.uncompressed+int64(w.written()) >= w.nextReset {
+		w.nextReset += w.resetInterval
+		return w.flushReset(DictReset)
+	}
+	return w.flushChunk()
+}
@@ -0,0 +1,113 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// readAll2 decompresses the LZMA2 stream.
+func readAll2(t *testing.T, lzma2 []byte) []byte {
+	r, err := NewReader2(bytes.NewReader(lzma2))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	return p
+}
+
+func TestWriter2FlushReset(t *testing.T) {
+	var data bytes.Buffer
+	io.CopyN(&data, randtxt.NewReader(rand.NewSource(42)), 5<<14)
+	p := data.Bytes()
+	var buf bytes.Buffer
+	l := new(debugLogger)
+	w, err := Writer2Config{Logger: l}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	resets := []ChunkReset{NoReset, StateReset, PropsReset, DictReset}
+	for i, r := range resets {
+		if _, err = w.Write(p[i<<14 : (i+1)<<14]); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.FlushReset(r); err != nil {
+			t.Fatalf("FlushReset(%d) error %s", r, err)
+		}
+	}
+	if _, err = w.Write(p[4<<14:]); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.FlushReset(DictReset + 1); err == nil {
+		t.Fatalf("FlushReset with invalid reset returned no error")
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	want := []string{"LRND", "L", "LR", "LRN", "LRND"}
+	if len(l.msgs) != len(want) {
+		t.Fatalf("got %d chunks; want %d", len(l.msgs), len(want))
+	}
+	for i, m := range l.msgs {
+		if !strings.HasPrefix(m, "chunk header "+want[i]+" ") {
+			t.Fatalf("chunk %d: %q; want type %s", i, m, want[i])
+		}
+	}
+	if !bytes.Equal(readAll2(t, buf.Bytes()), p) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestWriter2DictResetInterval(t *testing.T) {
+	const interval = 1 << 16
+	text := func(seed int64, n int64) []byte {
+		var buf bytes.Buffer
+		io.CopyN(&buf, randtxt.NewReader(rand.NewSource(seed)), n)
+		return buf.Bytes()
+	}
+	shared := text(42, 3*interval+1000)
+	compress := func(p []byte) []byte {
+		var buf bytes.Buffer
+		w, err := Writer2Config{
+			DictResetInterval: interval,
+		}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.ReadFrom(bytes.NewReader(p)); err != nil {
+			t.Fatalf("ReadFrom error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		return buf.Bytes()
+	}
+	s := compress(shared)
+	for _, seed := range []int64{43, 44} {
+		p := append(text(seed, interval*(seed-42)), shared...)
+		out := compress(p)
+		if !bytes.HasSuffix(out, s) {
+			t.Fatalf("seed %d: chunks of the shared data differ",
+				seed)
+		}
+		if !bytes.Equal(readAll2(t, out), p) {
+			t.Fatalf("seed %d: decompressed data differs", seed)
+		}
+	}
+	c := Writer2Config{DictResetInterval: -1}
+	if err := c.Verify(); err == nil {
+		t.Fatalf("Verify accepted negative DictResetInterval")
+	}
+}
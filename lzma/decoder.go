@@ -10,13 +10,38 @@ import (
 	"io"
 )
 
+// Errors of the decoder for malformed streams. They are returned
+// wrapped in *ErrSizeMismatch. If the compressed data ends before the
+// LZMA stream, io.ErrUnexpectedEOF is returned.
+var (
+	// ErrUnexpectedEOS indicates that the end-of-stream marker has
+	// been found before the declared size has been reached.
+	ErrUnexpectedEOS = errors.New("lzma: unexpected end-of-stream marker")
+	// ErrStreamTooLong indicates that the stream contains more data
+	// than the declared size.
+	ErrStreamTooLong = errors.New("lzma: stream exceeds declared size")
+)
+
+// ErrCorrupt reports corrupted compressed data. Offset is the position
+// in the uncompressed data at which the corruption has been detected.
+type ErrCorrupt struct {
+	Offset int64
+	Reason string
+}
+
+// Error returns the error message.
+func (e *ErrCorrupt) Error() string {
+	return fmt.Sprintf("lzma: corrupt data at offset %d: %s", e.Offset,
+		e.Reason)
+}
+
 // decoder decodes a raw LZMA stream without any header.
 type decoder struct {
 	// dictionary; the rear pointer of the buffer will be used for
 	// reading the data.
-	Dict *decoderDict
+	Dict *DecoderDict
 	// decoder state
-	State *state
+	State *State
 	// range decoder
 	rd *rangeDecoder
 	// start stores the head value of the dictionary for the LZMA
@@ -28,49 +53,111 @@ type decoder struct {
 	eos bool
 	// EOS marker found
 	eosMarker bool
+	// sticky decoding error
+	err error
+	// the range decoder must be initialized before the first
+	// operation
+	initPending bool
+	// match returned by readOp
+	op match
+	// maximum number of bytes decoded by a single fill; the value 0
+	// fills the dictionary buffer
+	fillSize int
+	// buffer for writing a single byte to the tee writer
+	b [1]byte
+	// operation counts for the statistics
+	literals   int64
+	matches    int64
+	repMatches int64
 }
 
 // newDecoder creates a new decoder instance. The parameter size provides
 // the expected byte size of the decompressed data. If the size is
 // unknown use a negative value. In that case the decoder will look for
 // a terminating end-of-stream marker.
-func newDecoder(br io.ByteReader, state *state, dict *decoderDict, size int64) (d *decoder, err error) {
-	rd, err := newRangeDecoder(br)
-	if err != nil {
-		return nil, err
-	}
+func newDecoder(br io.ByteReader, state *State, dict *DecoderDict, size int64) (d *decoder, err error) {
 	d = &decoder{
 		State: state,
 		Dict:  dict,
-		rd:    rd,
-		size:  size,
-		start: dict.pos(),
+		rd:    new(rangeDecoder),
+	}
+	if err = d.Reopen(br, size); err != nil {
+		return nil, err
 	}
 	return d, nil
 }
 
+// corrupt returns an ErrCorrupt error for the current position.
+func (d *decoder) corrupt(reason string) error {
+	return &ErrCorrupt{Offset: d.Dict.Pos(), Reason: reason}
+}
+
+// rangeError converts the errors of the range decoder initialization.
+func (d *decoder) rangeError(err error) error {
+	switch err {
+	case io.EOF:
+		return io.ErrUnexpectedEOF
+	case errFirstByte, errInitCode:
+		return d.corrupt(err.Error())
+	}
+	return err
+}
+
 // Reopen restarts the decoder with a new byte reader and a new size. Reopen
-// resets the Decompressed counter to zero.
+// resets the Decompressed counter to zero. The range decoder is reused.
 func (d *decoder) Reopen(br io.ByteReader, size int64) error {
-	var err error
-	if d.rd, err = newRangeDecoder(br); err != nil {
-		return err
+	d.rd.br, d.rd.buf, d.rd.src = br, nil, nil
+	return d.restart(size)
+}
+
+// ReopenBytes restarts the decoder like Reopen, but the compressed data
+// is provided completely by p. Decoding from a byte slice avoids the
+// call of a byte reader for every input byte.
+func (d *decoder) ReopenBytes(p []byte, size int64) error {
+	d.rd.br, d.rd.buf, d.rd.src = nil, p, nil
+	return d.restart(size)
+}
+
+// ReopenReader restarts the decoder like Reopen, but the compressed
+// data is read from r in blocks into the window win, which must be
+// larger than maxOpInput. Errors of r don't stop the decoder; decoding
+// continues with the next call after such an error. The decoder may
+// read data following the LZMA stream into the window. Nothing is read
+// from r before the first call of Read or WriteTo.
+func (d *decoder) ReopenReader(r io.Reader, win []byte, size int64) error {
+	d.rd.br, d.rd.src, d.rd.win = nil, r, win
+	d.rd.buf = win[:0]
+	d.rd.srcN, d.rd.srcEOF = 0, false
+	return d.restart(size)
+}
+
+// restart initializes the range decoder and resets the stream state.
+// The initialization of a range decoder reading from a source is
+// deferred to the first call of fill.
+func (d *decoder) restart(size int64) error {
+	d.initPending = d.rd.src != nil
+	if !d.initPending {
+		if err := d.rd.init(); err != nil {
+			return d.rangeError(err)
+		}
 	}
-	d.start = d.Dict.pos()
+	d.start = d.Dict.Pos()
 	d.size = size
 	d.eos = false
+	d.err = nil
 	return nil
 }
 
 // decodeLiteral decodes a single literal from the LZMA stream.
-func (d *decoder) decodeLiteral() (op operation, err error) {
-	litState := d.State.litState(d.Dict.byteAt(1), d.Dict.head)
-	match := d.Dict.byteAt(int(d.State.rep[0]) + 1)
-	s, err := d.State.litCodec.Decode(d.rd, d.State.state, match, litState)
-	if err != nil {
-		return nil, err
+func (d *decoder) decodeLiteral() byte {
+	prev := d.Dict.ByteAt(1)
+	match := d.Dict.ByteAt(int(d.State.rep[0]) + 1)
+	if d.State.Properties.LC == 3 && d.State.Properties.LP == 0 {
+		return d.State.litCodec().decodeLC3LP0(d.rd, d.State.state,
+			match, prev)
 	}
-	return lit{s}, nil
+	litState := d.State.litState(prev, d.Dict.head)
+	return d.State.litCodec().Decode(d.rd, d.State.state, match, litState)
 }
 
 // errEOS indicates that an EOS marker has been found.
@@ -79,83 +166,71 @@ var errEOS = errors.New("EOS marker found")
 // readOp decodes the next operation from the compressed stream. It
 // returns the operation. If an explicit end of stream marker is
 // identified the eos error is returned.
+//
+// The range decoder doesn't report errors for single bits. They are
+// checked once after the operation has been decoded.
 func (d *decoder) readOp() (op operation, err error) {
+	op = d.decodeOp()
+	if err = d.rd.err; err != nil {
+		return nil, err
+	}
+	if op == nil {
+		d.eosMarker = true
+		return nil, errEOS
+	}
+	return op, nil
+}
+
+// decodeOp decodes the next operation. It returns nil for the EOS
+// marker. The result is only valid if the range decoder didn't record
+// an error.
+func (d *decoder) decodeOp() operation {
 	// Value of the end of stream (EOS) marker
 	const eosDist = 1<<32 - 1
 
 	state, state2, posState := d.State.states(d.Dict.head)
+	rd := d.rd
 
-	b, err := d.State.isMatch[state2].Decode(d.rd)
-	if err != nil {
-		return nil, err
-	}
-	if b == 0 {
+	if rd.DecodeBit(&d.State.isMatch[state2]) == 0 {
 		// literal
-		op, err := d.decodeLiteral()
-		if err != nil {
-			return nil, err
-		}
+		s := d.decodeLiteral()
 		d.State.updateStateLiteral()
-		return op, nil
-	}
-	b, err = d.State.isRep[state].Decode(d.rd)
-	if err != nil {
-		return nil, err
+		d.literals++
+		return lit{s}
 	}
-	if b == 0 {
+	if rd.DecodeBit(&d.State.isRep[state]) == 0 {
 		// simple match
 		d.State.rep[3], d.State.rep[2], d.State.rep[1] =
 			d.State.rep[2], d.State.rep[1], d.State.rep[0]
 
 		d.State.updateStateMatch()
 		// The length decoder returns the length offset.
-		n, err := d.State.lenCodec.Decode(d.rd, posState)
-		if err != nil {
-			return nil, err
-		}
+		n := d.State.lenCodec().Decode(rd, posState)
 		// The dist decoder returns the distance offset. The actual
 		// distance is 1 higher.
-		d.State.rep[0], err = d.State.distCodec.Decode(d.rd, n)
-		if err != nil {
-			return nil, err
-		}
+		d.State.rep[0] = d.State.distCodec().Decode(rd, n)
 		if d.State.rep[0] == eosDist {
-			d.eosMarker = true
-			return nil, errEOS
+			return nil
 		}
-		op = match{n: int(n) + minMatchLen,
+		d.matches++
+		d.op = match{n: int(n) + MinMatchLen,
 			distance: int64(d.State.rep[0]) + minDistance}
-		return op, nil
-	}
-	b, err = d.State.isRepG0[state].Decode(d.rd)
-	if err != nil {
-		return nil, err
+		return &d.op
 	}
 	dist := d.State.rep[0]
-	if b == 0 {
+	if rd.DecodeBit(&d.State.isRepG0[state]) == 0 {
 		// rep match 0
-		b, err = d.State.isRepG0Long[state2].Decode(d.rd)
-		if err != nil {
-			return nil, err
-		}
-		if b == 0 {
+		if rd.DecodeBit(&d.State.isRepG0Long[state2]) == 0 {
 			d.State.updateStateShortRep()
-			op = match{n: 1, distance: int64(dist) + minDistance}
-			return op, nil
+			d.repMatches++
+			d.op = match{n: 1, distance: int64(dist) + minDistance}
+			return &d.op
 		}
 	} else {
-		b, err = d.State.isRepG1[state].Decode(d.rd)
-		if err != nil {
-			return nil, err
-		}
-		if b == 0 {
+		if rd.DecodeBit(&d.State.isRepG1[state]) == 0 {
 			dist = d.State.rep[1]
 		} else {
-			b, err = d.State.isRepG2[state].Decode(d.rd)
-			if err != nil {
-				return nil, err
-			}
-			if b == 0 {
+			if rd.DecodeBit(&d.State.isRepG2[state]) == 0 {
 				dist = d.State.rep[2]
 			} else {
 				dist = d.State.rep[3]
@@ -166,21 +241,22 @@ func (d *decoder) readOp() (op operation, err error) {
 		d.State.rep[1] = d.State.rep[0]
 		d.State.rep[0] = dist
 	}
-	n, err := d.State.repLenCodec.Decode(d.rd, posState)
-	if err != nil {
-		return nil, err
-	}
+	n := d.State.repLenCodec().Decode(rd, posState)
 	d.State.updateStateRep()
-	op = match{n: int(n) + minMatchLen, distance: int64(dist) + minDistance}
-	return op, nil
+	d.repMatches++
+	d.op = match{n: int(n) + MinMatchLen, distance: int64(dist) + minDistance}
+	return &d.op
 }
 
 // apply takes the operation and transforms the decoder dictionary accordingly.
 func (d *decoder) apply(op operation) error {
 	var err error
 	switch x := op.(type) {
-	case match:
-		err = d.Dict.writeMatch(x.distance, x.n)
+	case *match:
+		err = d.Dict.CopyN(x.distance, x.n)
+		if err == errMatchDistance || err == errMatchLen {
+			return d.corrupt(err.Error())
+		}
 	case lit:
 		err = d.Dict.WriteByte(x.b)
 	default:
@@ -191,12 +267,49 @@ func (d *decoder) apply(op operation) error {
 
 // decompress fills the dictionary unless no space for new data is
 // available. If the end of the LZMA stream has been reached io.EOF will
-// be returned.
+// be returned. Errors are sticky, so the decoder never continues to
+// decode a corrupted stream.
 func (d *decoder) decompress() error {
+	if d.err != nil {
+		return d.err
+	}
+	err := d.fill()
+	if err == nil || err == io.EOF {
+		return err
+	}
+	return sticky(&d.err, err)
+}
+
+// fill decodes operations until the dictionary is full or the end of
+// the stream has been reached.
+func (d *decoder) fill() error {
 	if d.eos {
 		return io.EOF
 	}
-	for d.Dict.Available() >= maxMatchLen {
+	if d.initPending {
+		if err := d.rd.prefetch(); err != nil {
+			return err
+		}
+		if err := d.rd.init(); err != nil {
+			return d.rangeError(err)
+		}
+		d.initPending = false
+	}
+	if d.size >= 0 && d.Decompressed() >= d.size {
+		// an empty stream with declared size
+		return d.sizeReached()
+	}
+	end := int64(-1)
+	if d.fillSize > 0 {
+		end = d.Dict.Pos() + int64(d.fillSize)
+	}
+	for d.Dict.Available() >= MaxMatchLen {
+		if end >= 0 && d.Dict.Pos() >= end {
+			return nil
+		}
+		if err := d.rd.prefetch(); err != nil {
+			return err
+		}
 		op, err := d.readOp()
 		switch err {
 		case nil:
@@ -204,10 +317,11 @@ func (d *decoder) decompress() error {
 		case errEOS:
 			d.eos = true
 			if !d.rd.possiblyAtEnd() {
-				return errDataAfterEOS
+				return d.corrupt("data after end-of-stream marker")
 			}
 			if d.size >= 0 && d.size != d.Decompressed() {
-				return errSize
+				return d.sizeMismatch(d.Decompressed(),
+					ErrUnexpectedEOS)
 			}
 			return io.EOF
 		case io.EOF:
@@ -216,62 +330,157 @@ func (d *decoder) decompress() error {
 		default:
 			return err
 		}
+		if traceEnabled {
+			traceOp(d.Dict.Pos(), op)
+		}
 		if err = d.apply(op); err != nil {
 			return err
 		}
 		if d.size >= 0 && d.Decompressed() >= d.size {
-			d.eos = true
-			if d.Decompressed() > d.size {
-				return errSize
-			}
-			if !d.rd.possiblyAtEnd() {
-				switch _, err = d.readOp(); err {
-				case nil:
-					return errSize
-				case io.EOF:
-					return io.ErrUnexpectedEOF
-				case errEOS:
-					break
-				default:
-					return err
-				}
-			}
-			return io.EOF
+			return d.sizeReached()
 		}
 	}
 	return nil
 }
 
-// Errors that may be returned while decoding data.
-var (
-	errDataAfterEOS = errors.New("lzma: data after end of stream marker")
-	errSize         = errors.New("lzma: wrong uncompressed data size")
-)
+// sizeReached ends the stream after the declared size has been
+// reached. An optional EOS marker is consumed.
+func (d *decoder) sizeReached() error {
+	if err := d.rd.prefetch(); err != nil {
+		return err
+	}
+	d.eos = true
+	if d.Decompressed() > d.size {
+		return d.sizeMismatch(d.Decompressed(), ErrStreamTooLong)
+	}
+	if !d.rd.possiblyAtEnd() {
+		switch op, err := d.readOp(); err {
+		case nil:
+			return d.sizeMismatch(d.Decompressed()+int64(op.Len()),
+				ErrStreamTooLong)
+		case io.EOF:
+			return io.ErrUnexpectedEOF
+		case errEOS:
+			break
+		default:
+			return err
+		}
+	}
+	return io.EOF
+}
+
+// sizeMismatch returns the error for a stream whose n uncompressed
+// bytes don't match the declared size. The error unwraps to err.
+func (d *decoder) sizeMismatch(n int64, err error) error {
+	return &ErrSizeMismatch{Want: d.size, Got: n, err: err}
+}
 
 // Read reads data from the buffer. If no more data is available io.EOF is
-// returned.
+// returned. Read doesn't return io.EOF together with data. An error of
+// the source is returned after the data decoded before it has been
+// read; the next call retries the source.
 func (d *decoder) Read(p []byte) (n int, err error) {
 	var k int
+	var serr error
 	for {
-		// Read of decoder dict never returns an error.
+		// Read of decoder dict returns only tee errors.
 		k, err = d.Dict.Read(p[n:])
+		n += k
 		if err != nil {
-			panic(fmt.Errorf("dictionary read error %s", err))
+			return n, err
 		}
-		if k == 0 && d.eos {
-			return n, io.EOF
+		if d.Dict.Buffered() == 0 {
+			if d.err != nil {
+				return n, d.err
+			}
+			if d.eos {
+				// io.EOF is only returned without data
+				if n > 0 {
+					return n, nil
+				}
+				return 0, io.EOF
+			}
+			if serr != nil {
+				if n > 0 {
+					return n, nil
+				}
+				return 0, serr
+			}
 		}
-		n += k
-		if n >= len(p) {
+		if n >= len(p) || (d.fillSize > 0 && n > 0) {
 			return n, nil
 		}
-		if err = d.decompress(); err != nil && err != io.EOF {
+		// A decoding error is returned after the data decoded
+		// before it has been read.
+		serr = d.sourceErr(d.decompress())
+	}
+}
+
+// ReadByte returns the next byte of the decompressed data. It takes the
+// byte directly from the dictionary buffer and follows the error
+// handling of Read. If the tee writer fails, the byte is not consumed
+// and will be returned by the next call.
+func (d *decoder) ReadByte() (c byte, err error) {
+	var serr error
+	for d.Dict.Buffered() == 0 {
+		if d.err != nil {
+			return 0, d.err
+		}
+		if d.eos {
+			return 0, io.EOF
+		}
+		if serr != nil {
+			return 0, serr
+		}
+		serr = d.sourceErr(d.decompress())
+	}
+	c = d.Dict.buf.peekByte()
+	if d.Dict.tee != nil {
+		d.b[0] = c
+		if _, err = d.Dict.tee.Write(d.b[:]); err != nil {
+			return 0, err
+		}
+	}
+	d.Dict.buf.readByte()
+	return c, nil
+}
+
+// sourceErr returns err, if it is an error of the source returned by
+// decompress, which doesn't stop the decoder. Otherwise nil is
+// returned.
+func (d *decoder) sourceErr(err error) error {
+	if err == nil || err == io.EOF || d.err != nil {
+		return nil
+	}
+	return err
+}
+
+// WriteTo writes the decompressed data to w until the end of the stream
+// has been reached. The data is written directly from the dictionary
+// buffer.
+func (d *decoder) WriteTo(w io.Writer) (n int64, err error) {
+	var serr error
+	for {
+		var k int64
+		k, err = d.Dict.WriteTo(w)
+		n += k
+		if err != nil {
 			return n, err
 		}
+		if d.err != nil {
+			return n, d.err
+		}
+		if d.eos {
+			return n, nil
+		}
+		if serr != nil {
+			return n, serr
+		}
+		serr = d.sourceErr(d.decompress())
 	}
 }
 
 // Decompressed returns the number of bytes decompressed by the decoder.
 func (d *decoder) Decompressed() int64 {
-	return d.Dict.pos() - d.start
+	return d.Dict.Pos() - d.start
 }
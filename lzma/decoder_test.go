@@ -6,9 +6,13 @@ package lzma
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
+	"path/filepath"
 	"testing"
 )
 
@@ -29,11 +33,11 @@ func TestDecoder(t *testing.T) {
 		if err != nil {
 			t.Fatalf("p[0] error %s", err)
 		}
-		state := newState(props)
+		state := NewState(props)
 		const capacity = 0x800000
-		dict, err := newDecoderDict(capacity)
+		dict, err := NewDecoderDict(capacity)
 		if err != nil {
-			t.Fatalf("newDecoderDict: error %s", err)
+			t.Fatalf("NewDecoderDict: error %s", err)
 		}
 		size := int64(-1)
 		if i > 0 {
@@ -57,3 +61,109 @@ func TestDecoder(t *testing.T) {
 		}
 	}
 }
+
+// decoderError classifies the error as one of the errors the decoder
+// returns for malformed streams.
+func decoderError(err error) bool {
+	if _, ok := err.(*ErrCorrupt); ok {
+		return true
+	}
+	return errors.Is(err, ErrUnexpectedEOS) ||
+		errors.Is(err, ErrStreamTooLong) || err == io.ErrUnexpectedEOF
+}
+
+func TestDecoderErrors(t *testing.T) {
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"bad_corrupted.lzma", "corrupt"},
+		{"bad_eos_incorrect_size.lzma", "eos"},
+		{"bad_incorrect_size.lzma", "long"},
+	}
+	for _, c := range tests {
+		f, err := os.Open(filepath.Join("examples", c.file))
+		if err != nil {
+			t.Fatalf("os.Open error %s", err)
+		}
+		r, err := NewReader(bufio.NewReader(f))
+		if err == nil {
+			_, err = ioutil.ReadAll(r)
+		}
+		f.Close()
+		var got string
+		switch {
+		case errors.Is(err, ErrUnexpectedEOS):
+			got = "eos"
+		case errors.Is(err, ErrStreamTooLong):
+			got = "long"
+		default:
+			if _, ok := err.(*ErrCorrupt); ok {
+				got = "corrupt"
+			}
+		}
+		if got != c.want {
+			t.Errorf("%s: got error %v; want %s", c.file, err,
+				c.want)
+		}
+	}
+}
+
+func TestDecoderCorruption(t *testing.T) {
+	orig := readOrigFile(t)
+	var buf bytes.Buffer
+	w, err := WriterConfig{DictCap: 1 << 16}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(orig); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+	r := rand.New(rand.NewSource(23))
+	for i := 0; i < 200; i++ {
+		p := make([]byte, len(data))
+		copy(p, data)
+		for k := 0; k < 4; k++ {
+			j := HeaderLen + r.Intn(len(p)-HeaderLen)
+			p[j] ^= byte(1 + r.Intn(255))
+		}
+		if i%4 == 0 {
+			p = p[:HeaderLen+r.Intn(len(p)-HeaderLen)]
+		}
+		lr, err := NewReader(bytes.NewReader(p))
+		if err == nil {
+			_, err = ioutil.ReadAll(lr)
+		}
+		if err != nil && !decoderError(err) {
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+}
+
+func TestDecoderStickyError(t *testing.T) {
+	data, err := ioutil.ReadFile(filepath.Join("examples",
+		"bad_corrupted.lzma"))
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	r, err := NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	_, err = ioutil.ReadAll(r)
+	if _, ok := err.(*ErrCorrupt); !ok {
+		t.Fatalf("ReadAll returned %v; want ErrCorrupt", err)
+	}
+	p := make([]byte, 1024)
+	for i := 0; i < 3; i++ {
+		n, rerr := r.Read(p)
+		if n != 0 || rerr != err {
+			t.Fatalf("Read returned %d, %v; want 0, %v", n, rerr,
+				err)
+		}
+	}
+}
@@ -6,36 +6,120 @@ package lzma
 
 import (
 	"errors"
-	"fmt"
+	"io"
 )
 
-// decoderDict provides the dictionary for the decoder. The whole
-// dictionary is used as reader buffer.
-type decoderDict struct {
+// DecoderDict provides the dictionary for the decoder. The whole
+// dictionary is used as reader buffer. The buffer may be smaller than
+// the dictionary capacity; it grows as required.
+//
+// The dictionary supports driving the LZMA decoding at the level of
+// operations. Literals are written with WriteByte, matches with CopyN
+// and the decoded data is read with Read or WriteTo. Readers of other
+// container formats, for instance 7z, can use it together with their
+// own decoding of the operations.
+type DecoderDict struct {
 	buf  buffer
 	head int64
+	// capacity of the dictionary
+	capacity int
+	growth   Growth
+	// tee receives all data read from the dictionary if not nil
+	tee io.Writer
 }
 
-// newDecoderDict creates a new decoder dictionary. The whole dictionary
+// NewDecoderDict creates a new decoder dictionary. The whole dictionary
 // will be used as reader buffer.
-func newDecoderDict(dictCap int) (d *decoderDict, err error) {
+func NewDecoderDict(dictCap int) (d *DecoderDict, err error) {
 	// lower limit supports easy test cases
 	if !(1 <= dictCap && int64(dictCap) <= MaxDictCap) {
-		return nil, errors.New("lzma: dictCap out of range")
+		return nil, ErrDictCap
+	}
+	d = &DecoderDict{buf: *newBuffer(dictCap), capacity: dictCap}
+	return d, nil
+}
+
+// newGrowingDecoderDict creates a decoder dictionary whose buffer grows
+// according to the growth strategy g up to the dictionary capacity. If
+// dictBuf is not nil, its memory is used for the complete dictionary
+// and the buffer never grows.
+func newGrowingDecoderDict(dictCap int, g Growth, dictBuf []byte) (
+	d *DecoderDict, err error) {
+
+	if !(1 <= dictCap && int64(dictCap) <= MaxDictCap) {
+		return nil, ErrDictCap
+	}
+	if err = g.Verify(); err != nil {
+		return nil, err
+	}
+	size := g.Initial
+	if size > dictCap || dictBuf != nil {
+		size = dictCap
+	}
+	buf, err := newBufferIn(dictBuf, size)
+	if err != nil {
+		return nil, err
+	}
+	d = &DecoderDict{
+		buf:      *buf,
+		capacity: dictCap,
+		growth:   g,
 	}
-	d = &decoderDict{buf: *newBuffer(dictCap)}
 	return d, nil
 }
 
+// grow ensures that n bytes can be written into the buffer without
+// overwriting the dictionary or the buffered data. The buffer doesn't
+// grow beyond the dictionary capacity.
+func (d *DecoderDict) grow(n int) {
+	size := d.buf.Cap()
+	if size >= d.capacity {
+		return
+	}
+	need := d.buf.Buffered()
+	if k := d.DictLen(); k > need {
+		need = k
+	}
+	need += n
+	if need <= size {
+		return
+	}
+	d.buf.grow(d.growth.next(size, need, d.capacity))
+}
+
+// Preset initializes the dictionary with the preset dictionary p. The
+// data cannot be read from the dictionary. Only the last bytes fitting
+// into the dictionary are stored, but the head is moved over all of p.
+// Preset must be called before any other data is written.
+func (d *DecoderDict) Preset(p []byte) {
+	skip := len(p) - d.capacity
+	if skip > 0 {
+		p = p[skip:]
+	}
+	n, _ := d.Write(p)
+	d.buf.Discard(n)
+	if skip > 0 {
+		d.head += int64(skip)
+	}
+}
+
 // Reset clears the dictionary. The read buffer is not changed, so the
 // buffered data can still be read.
-func (d *decoderDict) Reset() {
+func (d *DecoderDict) Reset() {
+	d.head = 0
+}
+
+// clear resets the dictionary and discards all buffered data. The
+// buffer is kept for reuse.
+func (d *DecoderDict) clear() {
+	d.buf.Reset()
 	d.head = 0
 }
 
 // WriteByte writes a single byte into the dictionary. It is used to
 // write literals into the dictionary.
-func (d *decoderDict) WriteByte(c byte) error {
+func (d *DecoderDict) WriteByte(c byte) error {
+	d.grow(1)
 	if err := d.buf.WriteByte(c); err != nil {
 		return err
 	}
@@ -43,23 +127,25 @@ func (d *decoderDict) WriteByte(c byte) error {
 	return nil
 }
 
-// pos returns the position of the dictionary head.
-func (d *decoderDict) pos() int64 { return d.head }
+// Pos returns the position of the dictionary head. It counts all bytes
+// written into the dictionary including the preset dictionary.
+func (d *DecoderDict) Pos() int64 { return d.head }
 
-// dictLen returns the actual length of the dictionary.
-func (d *decoderDict) dictLen() int {
-	capacity := d.buf.Cap()
-	if d.head >= int64(capacity) {
-		return capacity
+// DictLen returns the actual length of the dictionary. It is the
+// maximum distance supported by ByteAt and CopyN.
+func (d *DecoderDict) DictLen() int {
+	if d.head >= int64(d.capacity) {
+		return d.capacity
 	}
 	return int(d.head)
 }
 
-// byteAt returns a byte stored in the dictionary. If the distance is
-// non-positive or exceeds the current length of the dictionary the zero
-// byte is returned.
-func (d *decoderDict) byteAt(dist int) byte {
-	if !(0 < dist && dist <= d.dictLen()) {
+// ByteAt returns a byte stored in the dictionary. The distance 1
+// addresses the byte written last. If the distance is non-positive or
+// exceeds the current length of the dictionary the zero byte is
+// returned.
+func (d *DecoderDict) ByteAt(dist int) byte {
+	if !(0 < dist && dist <= d.DictLen()) {
 		return 0
 	}
 	i := d.buf.front - dist
@@ -69,67 +155,95 @@ func (d *decoderDict) byteAt(dist int) byte {
 	return d.buf.data[i]
 }
 
-// writeMatch writes the match at the top of the dictionary. The given
-// distance must point in the current dictionary and the length must not
-// exceed the maximum length 273 supported in LZMA.
+// DictBytes copies the last bytes of the dictionary into p and returns
+// the number of bytes copied. The bytes are copied in the order they
+// have been written; the last byte copied is the byte at distance 1.
+// At most DictLen bytes are copied.
+func (d *DecoderDict) DictBytes(p []byte) int {
+	n := d.DictLen()
+	if n > len(p) {
+		n = len(p)
+	}
+	i := d.buf.front - n
+	if i >= 0 {
+		return copy(p, d.buf.data[i:d.buf.front])
+	}
+	k := copy(p, d.buf.data[len(d.buf.data)+i:])
+	return k + copy(p[k:n], d.buf.data[:d.buf.front])
+}
+
+// Errors returned by CopyN for invalid matches.
+var (
+	errMatchDistance = errors.New("match distance out of range")
+	errMatchLen      = errors.New("match length out of range")
+)
+
+// CopyN writes a match at the top of the dictionary by copying length
+// bytes starting at the given distance. The distance must point in the
+// current dictionary and the length must not exceed the maximum length
+// 273 supported in LZMA. The copied range may overlap the bytes written
+// by the copy itself.
 //
 // The error value ErrNoSpace indicates that no space is available in
 // the dictionary for writing. You need to read from the dictionary
 // first.
-func (d *decoderDict) writeMatch(dist int64, length int) error {
-	if !(0 < dist && dist <= int64(d.dictLen())) {
-		return errors.New("writeMatch: distance out of range")
+func (d *DecoderDict) CopyN(dist int64, length int) error {
+	if !(0 < dist && dist <= int64(d.DictLen())) {
+		return errMatchDistance
 	}
-	if !(0 < length && length <= maxMatchLen) {
-		return errors.New("writeMatch: length out of range")
+	if !(0 < length && length <= MaxMatchLen) {
+		return errMatchLen
 	}
-	if length > d.buf.Available() {
-		return ErrNoSpace
+	d.grow(length)
+	if err := d.buf.copyMatch(int(dist), length); err != nil {
+		return err
 	}
 	d.head += int64(length)
-
-	i := d.buf.front - int(dist)
-	if i < 0 {
-		i += len(d.buf.data)
-	}
-	for length > 0 {
-		var p []byte
-		if i >= d.buf.front {
-			p = d.buf.data[i:]
-			i = 0
-		} else {
-			p = d.buf.data[i:d.buf.front]
-			i = d.buf.front
-		}
-		if len(p) > length {
-			p = p[:length]
-		}
-		if _, err := d.buf.Write(p); err != nil {
-			panic(fmt.Errorf("d.buf.Write returned error %s", err))
-		}
-		length -= len(p)
-	}
 	return nil
 }
 
 // Write writes the given bytes into the dictionary and advances the
 // head.
-func (d *decoderDict) Write(p []byte) (n int, err error) {
+func (d *DecoderDict) Write(p []byte) (n int, err error) {
+	d.grow(len(p))
 	n, err = d.buf.Write(p)
 	d.head += int64(n)
 	return n, err
 }
 
 // Available returns the number of available bytes for writing into the
-// decoder dictionary.
-func (d *decoderDict) Available() int { return d.buf.Available() }
+// decoder dictionary. It includes the bytes that can be provided by
+// growing the buffer.
+func (d *DecoderDict) Available() int {
+	return d.buf.Available() + d.capacity - d.buf.Cap()
+}
 
 // Read reads data from the buffer contained in the decoder dictionary.
-func (d *decoderDict) Read(p []byte) (n int, err error) { return d.buf.Read(p) }
+// If a tee writer has been set, the data will be written to it before
+// Read returns. Only errors of the tee writer are returned.
+func (d *DecoderDict) Read(p []byte) (n int, err error) {
+	n, _ = d.buf.Read(p)
+	if d.tee != nil && n > 0 {
+		if _, err = d.tee.Write(p[:n]); err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// WriteTo writes the data buffered in the dictionary to w without
+// copying it into an intermediate buffer. If a tee writer has been set,
+// the data is written to it too.
+func (d *DecoderDict) WriteTo(w io.Writer) (n int64, err error) {
+	if d.tee != nil {
+		w = io.MultiWriter(w, d.tee)
+	}
+	return d.buf.WriteTo(w)
+}
 
 // Buffered returns the number of bytes currently buffered in the
 // decoder dictionary.
-func (d *decoderDict) buffered() int { return d.buf.Buffered() }
+func (d *DecoderDict) Buffered() int { return d.buf.Buffered() }
 
 // Peek gets data from the buffer without advancing the rear index.
-func (d *decoderDict) peek(p []byte) (n int, err error) { return d.buf.Peek(p) }
+func (d *DecoderDict) peek(p []byte) (n int, err error) { return d.buf.Peek(p) }
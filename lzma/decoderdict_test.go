@@ -9,8 +9,8 @@ import (
 	"testing"
 )
 
-func peek(d *decoderDict) []byte {
-	p := make([]byte, d.buffered())
+func peek(d *DecoderDict) []byte {
+	p := make([]byte, d.Buffered())
 	k, err := d.peek(p)
 	if err != nil {
 		panic(fmt.Errorf("peek: "+
@@ -24,10 +24,77 @@ func peek(d *decoderDict) []byte {
 }
 
 func TestNewDecoderDict(t *testing.T) {
-	if _, err := newDecoderDict(0); err == nil {
+	if _, err := NewDecoderDict(0); err == nil {
 		t.Fatalf("no error for zero dictionary capacity")
 	}
-	if _, err := newDecoderDict(8); err != nil {
+	if _, err := NewDecoderDict(8); err != nil {
 		t.Fatalf("error %s", err)
 	}
 }
+
+func TestDecoderDictOps(t *testing.T) {
+	d, err := NewDecoderDict(16)
+	if err != nil {
+		t.Fatalf("NewDecoderDict error %s", err)
+	}
+	d.Preset([]byte("xyz"))
+	if d.Buffered() != 0 {
+		t.Fatalf("preset dictionary is buffered")
+	}
+	if c := d.ByteAt(2); c != 'y' {
+		t.Fatalf("ByteAt(2) returned %q; want %q", c, 'y')
+	}
+	if err = d.WriteByte('a'); err != nil {
+		t.Fatalf("WriteByte error %s", err)
+	}
+	// overlapping copy of the last two bytes
+	if err = d.CopyN(2, 5); err != nil {
+		t.Fatalf("CopyN error %s", err)
+	}
+	if err = d.CopyN(int64(d.DictLen())+1, 1); err == nil {
+		t.Fatalf("CopyN accepted distance beyond the dictionary")
+	}
+	if d.Pos() != 9 {
+		t.Fatalf("Pos returned %d; want %d", d.Pos(), 9)
+	}
+	p := make([]byte, 16)
+	n, err := d.Read(p)
+	if err != nil {
+		t.Fatalf("Read error %s", err)
+	}
+	if s, want := string(p[:n]), "azazaz"; s != want {
+		t.Fatalf("Read returned %q; want %q", s, want)
+	}
+}
+
+func TestDecoderDictBytes(t *testing.T) {
+	d, err := NewDecoderDict(8)
+	if err != nil {
+		t.Fatalf("NewDecoderDict error %s", err)
+	}
+	p := make([]byte, 16)
+	if n := d.DictBytes(p); n != 0 {
+		t.Fatalf("DictBytes returned %d for empty dictionary", n)
+	}
+	const s = "abcdefghijklm"
+	for i := 0; i < len(s); i++ {
+		if err = d.WriteByte(s[i]); err != nil {
+			t.Fatalf("WriteByte error %s", err)
+		}
+		// read the byte to allow the buffer to wrap around
+		if _, err = d.Read(p[:1]); err != nil {
+			t.Fatalf("Read error %s", err)
+		}
+		n := d.DictBytes(p)
+		want := s[:i+1]
+		if len(want) > 8 {
+			want = want[len(want)-8:]
+		}
+		if got := string(p[:n]); got != want {
+			t.Fatalf("DictBytes returned %q; want %q", got, want)
+		}
+	}
+	if n := d.DictBytes(p[:3]); string(p[:n]) != "klm" {
+		t.Fatalf("DictBytes returned %q; want %q", p[:n], "klm")
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"hash"
+	"hash/fnv"
+)
+
+// DedupHint describes a region of the uncompressed data that repeats
+// data found earlier in the stream. Backup systems can use the hints to
+// deduplicate data across archives.
+type DedupHint struct {
+	// Offset of the region in the uncompressed data
+	Offset int64
+	// Length of the region
+	Length int64
+	// Distance to the earlier copy of the region
+	Distance int64
+	// 64-bit FNV-1a hash of the region
+	Hash uint64
+}
+
+// defaultDedupMinLen is the default minimum length of a region reported
+// as dedup hint.
+const defaultDedupMinLen = 1024
+
+// dedupTracker combines consecutive matches with the same distance into
+// dedup hints.
+type dedupTracker struct {
+	f      func(h DedupHint)
+	minLen int64
+	// position of the next operation in the uncompressed data
+	pos  int64
+	hint DedupHint
+	h    hash.Hash64
+}
+
+// newDedupTracker creates a tracker calling f for all regions with at
+// least minLen bytes.
+func newDedupTracker(f func(h DedupHint), minLen int) *dedupTracker {
+	return &dedupTracker{f: f, minLen: int64(minLen), h: fnv.New64a()}
+}
+
+// reset prepares the tracker for a new stream. Pending hints are
+// dropped. The method does nothing for a nil tracker.
+func (t *dedupTracker) reset() {
+	if t == nil {
+		return
+	}
+	t.pos = 0
+	t.hint = DedupHint{}
+	t.h.Reset()
+}
+
+// add adds the operation encoding the bytes p.
+func (t *dedupTracker) add(op operation, p []byte) {
+	m, ok := op.(*match)
+	if !ok || m.distance != t.hint.Distance {
+		t.flush()
+		if ok {
+			t.hint = DedupHint{Offset: t.pos, Distance: m.distance}
+			t.h.Reset()
+		}
+	}
+	if ok {
+		t.hint.Length += int64(m.n)
+		t.h.Write(p)
+	}
+	t.pos += int64(op.Len())
+}
+
+// skip moves the position over n bytes that have not been encoded by
+// operations. The method may be called for a nil tracker.
+func (t *dedupTracker) skip(n int) {
+	if t == nil {
+		return
+	}
+	t.flush()
+	t.pos += int64(n)
+}
+
+// flush reports the current region if it is long enough. The method
+// may be called for a nil tracker.
+func (t *dedupTracker) flush() {
+	if t == nil {
+		return
+	}
+	if t.hint.Length >= t.minLen && t.hint.Length > 0 {
+		t.hint.Hash = t.h.Sum64()
+		t.f(t.hint)
+	}
+	t.hint = DedupHint{}
+}
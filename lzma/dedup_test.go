@@ -0,0 +1,119 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"hash/fnv"
+	"math/rand"
+	"testing"
+)
+
+// dedupData returns random data that repeats a block of 8 KiB.
+func dedupData() []byte {
+	r := rand.New(rand.NewSource(11))
+	rnd := func(n int) []byte {
+		p := make([]byte, n)
+		r.Read(p)
+		return p
+	}
+	block := rnd(8192)
+	var data []byte
+	data = append(data, rnd(4096)...)
+	data = append(data, block...)
+	data = append(data, rnd(4096)...)
+	data = append(data, block...)
+	data = append(data, rnd(100)...)
+	return data
+}
+
+// checkHints verifies that the hints describe repeated regions of data
+// and that the hashes are correct.
+func checkHints(t *testing.T, data []byte, hints []DedupHint, minLen int) {
+	if len(hints) == 0 {
+		t.Fatalf("no dedup hints")
+	}
+	for _, h := range hints {
+		if h.Length < int64(minLen) {
+			t.Fatalf("hint %+v shorter than %d", h, minLen)
+		}
+		end := h.Offset + h.Length
+		if h.Distance > h.Offset || end > int64(len(data)) {
+			t.Fatalf("hint %+v out of range", h)
+		}
+		p := data[h.Offset:end]
+		q := data[h.Offset-h.Distance : end-h.Distance]
+		if !bytes.Equal(p, q) {
+			t.Fatalf("hint %+v doesn't describe a repetition", h)
+		}
+		f := fnv.New64a()
+		f.Write(p)
+		if h.Hash != f.Sum64() {
+			t.Fatalf("hint %+v has hash; want %#x", h, f.Sum64())
+		}
+	}
+}
+
+func TestWriterDedupHints(t *testing.T) {
+	data := dedupData()
+	var hints []DedupHint
+	var buf bytes.Buffer
+	w, err := WriterConfig{
+		DedupHints:  func(h DedupHint) { hints = append(hints, h) },
+		DedupMinLen: 4096,
+		Pipelined:   true,
+	}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	// The hints must be reported by the goroutine calling Write.
+	if w.e.pipe != nil {
+		t.Fatalf("Pipelined not ignored with DedupHints")
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	checkHints(t, data, hints, 4096)
+	if len(hints) != 1 {
+		t.Fatalf("got %d hints; want 1", len(hints))
+	}
+	if h := hints[0]; h.Distance != 8192+4096 || h.Length < 8000 {
+		t.Fatalf("unexpected hint %+v", h)
+	}
+}
+
+func TestWriter2DedupHints(t *testing.T) {
+	data := dedupData()
+	var hints []DedupHint
+	var buf bytes.Buffer
+	w, err := Writer2Config{
+		DedupHints: func(h DedupHint) { hints = append(hints, h) },
+		ChunkSize:  5000,
+		Pipelined:  true,
+	}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if w.encoder.pipe != nil {
+		t.Fatalf("Pipelined not ignored with DedupHints")
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	checkHints(t, data, hints, defaultDedupMinLen)
+	var n int64
+	for _, h := range hints {
+		n += h.Length
+	}
+	if n < 8000 {
+		t.Fatalf("hints cover %d bytes; want at least 8000", n)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// DictID identifies a preset dictionary in the process-wide dictionary
+// registry. Peers exchanging LZMA streams can agree on the ID instead
+// of transferring the dictionary itself.
+type DictID uint32
+
+// registeredDict is an entry of the dictionary registry.
+type registeredDict struct {
+	name string
+	data []byte
+}
+
+// dictRegistry stores the registered preset dictionaries. The mutex
+// protects both maps.
+var dictRegistry = struct {
+	sync.RWMutex
+	byID   map[DictID]registeredDict
+	byName map[string]DictID
+}{
+	byID:   make(map[DictID]registeredDict),
+	byName: make(map[string]DictID),
+}
+
+// RegisterDict registers the preset dictionary dict under the given id
+// and name. The name is optional; an empty name registers the
+// dictionary only under its ID. The dictionary data is copied, so the
+// caller may reuse the slice. An error is returned if the ID or the
+// name has already been registered or the dictionary is empty or
+// larger than MaxDictCap. RegisterDict may be called concurrently from
+// multiple goroutines.
+func RegisterDict(id DictID, name string, dict []byte) error {
+	if len(dict) == 0 {
+		return errors.New("lzma: preset dictionary is empty")
+	}
+	if int64(len(dict)) > MaxDictCap {
+		return errors.New("lzma: preset dictionary too large")
+	}
+	data := make([]byte, len(dict))
+	copy(data, dict)
+
+	dictRegistry.Lock()
+	defer dictRegistry.Unlock()
+	if _, ok := dictRegistry.byID[id]; ok {
+		return fmt.Errorf("lzma: preset dictionary ID %d already "+
+			"registered", id)
+	}
+	if name != "" {
+		if _, ok := dictRegistry.byName[name]; ok {
+			return fmt.Errorf("lzma: preset dictionary name %q "+
+				"already registered", name)
+		}
+		dictRegistry.byName[name] = id
+	}
+	dictRegistry.byID[id] = registeredDict{name: name, data: data}
+	return nil
+}
+
+// LookupDict returns the preset dictionary registered under id. The
+// returned slice is shared by all callers and must not be modified.
+func LookupDict(id DictID) (dict []byte, ok bool) {
+	dictRegistry.RLock()
+	defer dictRegistry.RUnlock()
+	rd, ok := dictRegistry.byID[id]
+	return rd.data, ok
+}
+
+// LookupDictName returns the ID and the preset dictionary registered
+// under the given name. The returned slice must not be modified.
+func LookupDictName(name string) (id DictID, dict []byte, ok bool) {
+	dictRegistry.RLock()
+	defer dictRegistry.RUnlock()
+	if id, ok = dictRegistry.byName[name]; !ok {
+		return 0, nil, false
+	}
+	return id, dictRegistry.byID[id].data, true
+}
+
+// DictName returns the name under which the dictionary with the given
+// ID has been registered. The empty string is returned for
+// dictionaries without names and for unknown IDs.
+func DictName(id DictID) string {
+	dictRegistry.RLock()
+	defer dictRegistry.RUnlock()
+	return dictRegistry.byID[id].name
+}
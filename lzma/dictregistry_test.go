@@ -0,0 +1,75 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+)
+
+func TestRegisterDict(t *testing.T) {
+	const id DictID = 0x7e57
+	dict := []byte("The quick brown fox jumps over the lazy dog.")
+	if err := RegisterDict(id, "fox", dict); err != nil {
+		t.Fatalf("RegisterDict error %s", err)
+	}
+	// the registry must hold a copy
+	dict[0] = 't'
+	p, ok := LookupDict(id)
+	if !ok {
+		t.Fatalf("LookupDict(%d) failed", id)
+	}
+	if p[0] != 'T' {
+		t.Fatalf("registry doesn't copy the dictionary")
+	}
+	k, q, ok := LookupDictName("fox")
+	if !ok {
+		t.Fatalf("LookupDictName(%q) failed", "fox")
+	}
+	if k != id || !bytes.Equal(p, q) {
+		t.Fatalf("LookupDictName returned %d %q; want %d %q",
+			k, q, id, p)
+	}
+	if s := DictName(id); s != "fox" {
+		t.Fatalf("DictName(%d) returned %q; want %q", id, s, "fox")
+	}
+	if err := RegisterDict(id, "", dict); err == nil {
+		t.Fatalf("RegisterDict accepted ID %d twice", id)
+	}
+	if err := RegisterDict(id+1, "fox", dict); err == nil {
+		t.Fatalf("RegisterDict accepted name %q twice", "fox")
+	}
+	if err := RegisterDict(id+2, "", nil); err == nil {
+		t.Fatalf("RegisterDict accepted empty dictionary")
+	}
+	if _, ok = LookupDict(id + 3); ok {
+		t.Fatalf("LookupDict(%d) found unregistered ID", id+3)
+	}
+}
+
+func TestRegisterDictConcurrent(t *testing.T) {
+	const base DictID = 0x10000
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			id := base + DictID(i)
+			name := fmt.Sprintf("dict%d", i)
+			if err := RegisterDict(id, name, []byte(name)); err != nil {
+				t.Errorf("RegisterDict error %s", err)
+				return
+			}
+			p, ok := LookupDict(id)
+			if !ok || string(p) != name {
+				t.Errorf("LookupDict(%d) returned %q, %t", id,
+					p, ok)
+			}
+		}(i)
+	}
+	wg.Wait()
+}
@@ -37,13 +37,18 @@ func (dc directCodec) Encode(e *rangeEncoder, v uint32) error {
 
 // Decode uses the range decoder to decode a value with the given number of
 // given bits. The most-significant bit is decoded first.
-func (dc directCodec) Decode(d *rangeDecoder) (v uint32, err error) {
+func (dc directCodec) Decode(d *rangeDecoder) (v uint32) {
+	nrange, code := d.nrange, d.code
 	for i := int(dc) - 1; i >= 0; i-- {
-		x, err := d.DirectDecodeBit()
-		if err != nil {
-			return 0, err
+		nrange >>= 1
+		code -= nrange
+		t := 0 - (code >> 31)
+		code += nrange & t
+		v = (v << 1) | ((t + 1) & 1)
+		if nrange < 1<<24 {
+			nrange, code = d.normalize(nrange, code)
 		}
-		v = (v << 1) | x
 	}
-	return v, nil
+	d.nrange, d.code = nrange, code
+	return v
 }
@@ -24,25 +24,43 @@ const (
 	maxPosSlot = 63
 )
 
-// distCodec provides encoding and decoding of distance values.
-type distCodec struct {
-	posSlotCodecs [lenStates]treeCodec
-	posModel      [endPosModel - startPosModel]treeReverseCodec
-	alignCodec    treeReverseCodec
+// distCodecProbs is the number of probability values of the distance
+// codec: the position slot trees for each length state, the position
+// models and the alignment tree.
+const distCodecProbs = lenStates<<posSlotBits + posModelProbs + 1<<alignBits
+
+// posModelProbs is the number of probability values of the position
+// models. The position slot s uses a model for (s>>1)-1 bits.
+const posModelProbs = 124
+
+// posModelOffsets provides the offsets of the position models for the
+// slots from startPosModel to endPosModel-1 in the probability values
+// of the distance codec.
+var posModelOffsets = [endPosModel - startPosModel]uint32{
+	0, 2, 4, 8, 12, 20, 28, 44, 60, 92}
+
+// distCodec provides encoding and decoding of distance values. It
+// provides a view on the distCodecProbs probability values of the
+// state starting with the position slot trees followed by the position
+// models and the alignment tree.
+type distCodec []prob
+
+// posSlot returns the position slot codec for the length offset l.
+func (dc distCodec) posSlot(l uint32) treeCodec {
+	return makeTreeCodec(dc[lenState(l)<<posSlotBits:], posSlotBits)
 }
 
-// deepcopy initializes dc as deep copy of the source.
-func (dc *distCodec) deepcopy(src *distCodec) {
-	if dc == src {
-		return
-	}
-	for i := range dc.posSlotCodecs {
-		dc.posSlotCodecs[i].deepcopy(&src.posSlotCodecs[i])
-	}
-	for i := range dc.posModel {
-		dc.posModel[i].deepcopy(&src.posModel[i])
-	}
-	dc.alignCodec.deepcopy(&src.alignCodec)
+// posModel returns the codec for the bits of a distance in the given
+// position slot, which must be less than endPosModel.
+func (dc distCodec) posModel(posSlot uint32) treeReverseCodec {
+	k := lenStates<<posSlotBits + posModelOffsets[posSlot-startPosModel]
+	return makeTreeReverseCodec(dc[k:], int(posSlot>>1)-1)
+}
+
+// align returns the codec for the alignment bits.
+func (dc distCodec) align() treeReverseCodec {
+	return makeTreeReverseCodec(dc[lenStates<<posSlotBits+posModelProbs:],
+		alignBits)
 }
 
 // distBits returns the number of bits required to encode dist.
@@ -59,19 +77,6 @@ func distBits(dist uint32) int {
 	return 36 - nlz32(dist)
 }
 
-// newDistCodec creates a new distance codec.
-func (dc *distCodec) init() {
-	for i := range dc.posSlotCodecs {
-		dc.posSlotCodecs[i] = makeTreeCodec(posSlotBits)
-	}
-	for i := range dc.posModel {
-		posSlot := startPosModel + i
-		bits := (posSlot >> 1) - 1
-		dc.posModel[i] = makeTreeReverseCodec(bits)
-	}
-	dc.alignCodec = makeTreeReverseCodec(alignBits)
-}
-
 // lenState converts the value l to a supported lenState value.
 func lenState(l uint32) uint32 {
 	if l >= lenStates {
@@ -84,7 +89,7 @@ func lenState(l uint32) uint32 {
 // the full range of uint32 values. To get the distance offset the actual match
 // distance has to be decreased by 1. A distance offset of 0xffffffff (eos)
 // indicates the end of the stream.
-func (dc *distCodec) Encode(e *rangeEncoder, dist uint32, l uint32) (err error) {
+func (dc distCodec) Encode(e *rangeEncoder, dist uint32, l uint32) (err error) {
 	// Compute the posSlot using nlz32
 	var posSlot uint32
 	var bits uint32
@@ -96,7 +101,7 @@ func (dc *distCodec) Encode(e *rangeEncoder, dist uint32, l uint32) (err error)
 		posSlot += (dist >> uint(bits)) & 1
 	}
 
-	if err = dc.posSlotCodecs[lenState(l)].Encode(e, posSlot); err != nil {
+	if err = dc.posSlot(l).Encode(e, posSlot); err != nil {
 		return
 	}
 
@@ -104,53 +109,36 @@ func (dc *distCodec) Encode(e *rangeEncoder, dist uint32, l uint32) (err error)
 	case posSlot < startPosModel:
 		return nil
 	case posSlot < endPosModel:
-		tc := &dc.posModel[posSlot-startPosModel]
-		return tc.Encode(dist, e)
+		return dc.posModel(posSlot).Encode(dist, e)
 	}
 	dic := directCodec(bits - alignBits)
 	if err = dic.Encode(e, dist>>alignBits); err != nil {
 		return
 	}
-	return dc.alignCodec.Encode(dist, e)
+	return dc.align().Encode(dist, e)
 }
 
 // Decode decodes the distance offset using the parameter l. The dist value
 // 0xffffffff (eos) indicates the end of the stream. Add one to the distance
 // offset to get the actual match distance.
-func (dc *distCodec) Decode(d *rangeDecoder, l uint32) (dist uint32, err error) {
-	posSlot, err := dc.posSlotCodecs[lenState(l)].Decode(d)
-	if err != nil {
-		return
-	}
+func (dc distCodec) Decode(d *rangeDecoder, l uint32) (dist uint32) {
+	posSlot := dc.posSlot(l).Decode(d)
 
 	// posSlot equals distance
 	if posSlot < startPosModel {
-		return posSlot, nil
+		return posSlot
 	}
 
 	// posSlot uses the individual models
 	bits := (posSlot >> 1) - 1
 	dist = (2 | (posSlot & 1)) << bits
-	var u uint32
 	if posSlot < endPosModel {
-		tc := &dc.posModel[posSlot-startPosModel]
-		if u, err = tc.Decode(d); err != nil {
-			return 0, err
-		}
-		dist += u
-		return dist, nil
+		return dist + dc.posModel(posSlot).Decode(d)
 	}
 
 	// posSlots use direct encoding and a single model for the four align
 	// bits.
 	dic := directCodec(bits - alignBits)
-	if u, err = dic.Decode(d); err != nil {
-		return 0, err
-	}
-	dist += u << alignBits
-	if u, err = dc.alignCodec.Decode(d); err != nil {
-		return 0, err
-	}
-	dist += u
-	return dist, nil
+	dist += dic.Decode(d) << alignBits
+	return dist + dc.align().Decode(d)
 }
@@ -36,20 +36,38 @@ const (
 // it into a byte writer.
 type encoder struct {
 	dict  *encoderDict
-	state *state
+	state *State
 	re    *rangeEncoder
 	start int64
 	// generate eos marker
 	marker bool
 	limit  bool
 	margin int
+	// receives the dedup hints; may be nil
+	dedup *dedupTracker
+	// store moves the data through the dictionary without searching
+	// for matches; the caller stores the data uncompressed
+	store bool
+	// maximum number of bytes moved through the dictionary for a
+	// stored chunk
+	storeLimit int
+	// runs the match finder in a separate goroutine if not nil
+	pipe *pipeline
+	// receives the encoder events if not nil; the candidates are
+	// recorded by the matcher
+	trace  EncoderTrace
+	cands  []match
+	tcands []TraceMatch
+	// uncompressed bytes before the last dictionary reset, which
+	// are added to the traced positions
+	traceBase int64
 }
 
 // newEncoder creates a new encoder. If the byte writer must be
 // limited use LimitedByteWriter provided by this package. The flags
 // argument supports the eosMarker flag, controlling whether a
 // terminating end-of-stream marker must be written.
-func newEncoder(bw io.ByteWriter, state *state, dict *encoderDict,
+func newEncoder(bw io.ByteWriter, state *State, dict *encoderDict,
 	flags encoderFlags) (e *encoder, err error) {
 
 	re, err := newRangeEncoder(bw)
@@ -63,6 +81,8 @@ func newEncoder(bw io.ByteWriter, state *state, dict *encoderDict,
 		marker: flags&eosMarker != 0,
 		start:  dict.Pos(),
 		margin: opLenMargin,
+
+		storeLimit: maxCompressed,
 	}
 	if e.marker {
 		e.margin += 5
@@ -88,12 +108,33 @@ func (e *encoder) Write(p []byte) (n int, err error) {
 	}
 }
 
-// Reopen reopens the encoder with a new byte writer.
-func (e *encoder) Reopen(bw io.ByteWriter) error {
-	var err error
-	if e.re, err = newRangeEncoder(bw); err != nil {
-		return err
+// ReadFrom reads data from r directly into the dictionary buffer until
+// io.EOF is reached. Data is compressed whenever the buffer is full. If
+// the limit of the underlying writer has been reached ErrLimit will be
+// returned.
+func (e *encoder) ReadFrom(r io.Reader) (n int64, err error) {
+	for {
+		k, err := e.dict.readFrom(r)
+		n += int64(k)
+		switch err {
+		case nil:
+			continue
+		case io.EOF:
+			return n, nil
+		case ErrNoSpace:
+			if err = e.compress(0); err != nil {
+				return n, err
+			}
+			continue
+		}
+		return n, err
 	}
+}
+
+// Reopen reopens the encoder with a new byte writer. The output buffer
+// of the range encoder is reused.
+func (e *encoder) Reopen(bw io.ByteWriter) error {
+	e.re.reset(bw)
 	e.start = e.dict.Pos()
 	e.limit = false
 	return nil
@@ -101,18 +142,31 @@ func (e *encoder) Reopen(bw io.ByteWriter) error {
 
 // writeLiteral writes a literal into the LZMA stream
 func (e *encoder) writeLiteral(l lit) error {
+	return encodeLiteral(e.re, e.state, l.b, e.dict.ByteAt(1),
+		e.dict.ByteAt(int(e.state.rep[0])+1), e.dict.Pos())
+}
+
+// encodeLiteral encodes the literal b using the byte prev preceding it
+// and the byte match at the distance of the last match. The argument
+// pos provides the position of the literal in the uncompressed data.
+func encodeLiteral(re *rangeEncoder, s *State, b, prev, match byte,
+	pos int64) error {
+
 	var err error
-	state, state2, _ := e.state.states(e.dict.Pos())
-	if err = e.state.isMatch[state2].Encode(e.re, 0); err != nil {
+	state, state2, _ := s.states(pos)
+	if err = s.isMatch[state2].Encode(re, 0); err != nil {
 		return err
 	}
-	litState := e.state.litState(e.dict.ByteAt(1), e.dict.Pos())
-	match := e.dict.ByteAt(int(e.state.rep[0]) + 1)
-	err = e.state.litCodec.Encode(e.re, l.b, state, match, litState)
+	if s.Properties.LC == 3 && s.Properties.LP == 0 {
+		err = s.litCodec().encodeLC3LP0(re, b, state, match, prev)
+	} else {
+		litState := s.litState(prev, pos)
+		err = s.litCodec().Encode(re, b, state, match, litState)
+	}
 	if err != nil {
 		return err
 	}
-	e.state.updateStateLiteral()
+	s.updateStateLiteral()
 	return nil
 }
 
@@ -127,79 +181,86 @@ func iverson(ok bool) uint32 {
 
 // writeMatch writes a repetition operation into the operation stream
 func (e *encoder) writeMatch(m match) error {
+	return encodeMatch(e.re, e.state, m, e.dict.Pos())
+}
+
+// encodeMatch encodes the match at position pos of the uncompressed
+// data. Distances found in the rep array of the state are encoded as
+// repetitions.
+func encodeMatch(re *rangeEncoder, s *State, m match, pos int64) error {
 	var err error
 	if !(minDistance <= m.distance && m.distance <= maxDistance) {
 		panic(fmt.Errorf("match distance %d out of range", m.distance))
 	}
 	dist := uint32(m.distance - minDistance)
-	if !(minMatchLen <= m.n && m.n <= maxMatchLen) &&
-		!(dist == e.state.rep[0] && m.n == 1) {
+	if !(MinMatchLen <= m.n && m.n <= MaxMatchLen) &&
+		!(dist == s.rep[0] && m.n == 1) {
 		panic(fmt.Errorf(
 			"match length %d out of range; dist %d rep[0] %d",
-			m.n, dist, e.state.rep[0]))
+			m.n, dist, s.rep[0]))
 	}
-	state, state2, posState := e.state.states(e.dict.Pos())
-	if err = e.state.isMatch[state2].Encode(e.re, 1); err != nil {
+	state, state2, posState := s.states(pos)
+	if err = s.isMatch[state2].Encode(re, 1); err != nil {
 		return err
 	}
 	g := 0
 	for ; g < 4; g++ {
-		if e.state.rep[g] == dist {
+		if s.rep[g] == dist {
 			break
 		}
 	}
 	b := iverson(g < 4)
-	if err = e.state.isRep[state].Encode(e.re, b); err != nil {
+	if err = s.isRep[state].Encode(re, b); err != nil {
 		return err
 	}
-	n := uint32(m.n - minMatchLen)
+	n := uint32(m.n - MinMatchLen)
 	if b == 0 {
 		// simple match
-		e.state.rep[3], e.state.rep[2], e.state.rep[1], e.state.rep[0] =
-			e.state.rep[2], e.state.rep[1], e.state.rep[0], dist
-		e.state.updateStateMatch()
-		if err = e.state.lenCodec.Encode(e.re, n, posState); err != nil {
+		s.rep[3], s.rep[2], s.rep[1], s.rep[0] =
+			s.rep[2], s.rep[1], s.rep[0], dist
+		s.updateStateMatch()
+		if err = s.lenCodec().Encode(re, n, posState); err != nil {
 			return err
 		}
-		return e.state.distCodec.Encode(e.re, dist, n)
+		return s.distCodec().Encode(re, dist, n)
 	}
 	b = iverson(g != 0)
-	if err = e.state.isRepG0[state].Encode(e.re, b); err != nil {
+	if err = s.isRepG0[state].Encode(re, b); err != nil {
 		return err
 	}
 	if b == 0 {
 		// g == 0
 		b = iverson(m.n != 1)
-		if err = e.state.isRepG0Long[state2].Encode(e.re, b); err != nil {
+		if err = s.isRepG0Long[state2].Encode(re, b); err != nil {
 			return err
 		}
 		if b == 0 {
-			e.state.updateStateShortRep()
+			s.updateStateShortRep()
 			return nil
 		}
 	} else {
 		// g in {1,2,3}
 		b = iverson(g != 1)
-		if err = e.state.isRepG1[state].Encode(e.re, b); err != nil {
+		if err = s.isRepG1[state].Encode(re, b); err != nil {
 			return err
 		}
 		if b == 1 {
 			// g in {2,3}
 			b = iverson(g != 2)
-			err = e.state.isRepG2[state].Encode(e.re, b)
+			err = s.isRepG2[state].Encode(re, b)
 			if err != nil {
 				return err
 			}
 			if b == 1 {
-				e.state.rep[3] = e.state.rep[2]
+				s.rep[3] = s.rep[2]
 			}
-			e.state.rep[2] = e.state.rep[1]
+			s.rep[2] = s.rep[1]
 		}
-		e.state.rep[1] = e.state.rep[0]
-		e.state.rep[0] = dist
+		s.rep[1] = s.rep[0]
+		s.rep[0] = dist
 	}
-	e.state.updateStateRep()
-	return e.state.repLenCodec.Encode(e.re, n, posState)
+	s.updateStateRep()
+	return s.repLenCodec().Encode(re, n, posState)
 }
 
 // writeOp writes a single operation to the range encoder. The function
@@ -209,11 +270,14 @@ func (e *encoder) writeOp(op operation) error {
 	if e.re.Available() < int64(e.margin) {
 		return ErrLimit
 	}
+	if e.trace != nil {
+		e.traceOp(op)
+	}
 	switch x := op.(type) {
 	case lit:
 		return e.writeLiteral(x)
-	case match:
-		return e.writeMatch(x)
+	case *match:
+		return e.writeMatch(*x)
 	default:
 		panic("unexpected operation")
 	}
@@ -226,22 +290,53 @@ func (e *encoder) writeOp(op operation) error {
 func (e *encoder) compress(flags compressFlags) error {
 	n := 0
 	if flags&all == 0 {
-		n = maxMatchLen - 1
+		n = MaxMatchLen - 1
 	}
 	d := e.dict
+	if e.store {
+		// The data must fit into an uncompressed chunk.
+		for d.Buffered() > n {
+			k := d.Buffered() - n
+			if k > MaxMatchLen {
+				k = MaxMatchLen
+			}
+			if r := e.storeLimit - int(e.Compressed()); k > r {
+				if r <= 0 {
+					return ErrLimit
+				}
+				k = r
+			}
+			d.Discard(k)
+			e.dedup.skip(k)
+		}
+		return nil
+	}
+	if e.pipe != nil {
+		return e.compressPipelined(n)
+	}
 	m := d.m
 	for d.Buffered() > n {
 		op := m.NextOp(e.state.rep)
 		if err := e.writeOp(op); err != nil {
 			return err
 		}
-		d.Discard(op.Len())
+		e.discardOp(op)
 	}
 	return nil
 }
 
+// discardOp discards the data of an encoded operation from the
+// dictionary buffer.
+func (e *encoder) discardOp(op operation) {
+	d := e.dict
+	d.Discard(op.Len())
+	if e.dedup != nil {
+		e.dedup.add(op, d.data[:op.Len()])
+	}
+}
+
 // eosMatch is a pseudo operation that indicates the end of the stream.
-var eosMatch = match{distance: maxDistance, n: minMatchLen}
+var eosMatch = match{distance: maxDistance, n: MinMatchLen}
 
 // Close terminates the LZMA stream. If requested the end-of-stream
 // marker will be written. If the byte writer limit has been or will be
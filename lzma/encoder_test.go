@@ -35,11 +35,11 @@ func cycle(t *testing.T, n int) {
 			len(testString))
 	}
 	const dictCap = MinDictCap
-	m, err := newHashTable(dictCap, 4)
+	m, err := newHashTable(dictCap, 4, CyclicPolyMixer)
 	if err != nil {
 		t.Fatal(err)
 	}
-	encoderDict, err := newEncoderDict(dictCap, dictCap+1024, m)
+	encoderDict, err := newEncoderDict(dictCap, dictCap+1024, m, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -47,7 +47,7 @@ func cycle(t *testing.T, n int) {
 	if err := props.verify(); err != nil {
 		t.Fatalf("properties error %s", err)
 	}
-	state := newState(props)
+	state := NewState(props)
 	var buf bytes.Buffer
 	w, err := newEncoder(&buf, state, encoderDict, eosMarker)
 	if err != nil {
@@ -66,9 +66,9 @@ func cycle(t *testing.T, n int) {
 		t.Fatalf("w.Close error %s", err)
 	}
 	t.Logf("buf.Len() %d len(orig) %d", buf.Len(), len(orig))
-	decoderDict, err := newDecoderDict(dictCap)
+	decoderDict, err := NewDecoderDict(dictCap)
 	if err != nil {
-		t.Fatalf("newDecoderDict error %s", err)
+		t.Fatalf("NewDecoderDict error %s", err)
 	}
 	state.Reset()
 	r, err := newDecoder(&buf, state, decoderDict, -1)
@@ -100,11 +100,11 @@ func TestEncoderCycle2(t *testing.T) {
 	txt := buf.String()
 	buf.Reset()
 	const dictCap = MinDictCap
-	m, err := newHashTable(dictCap, 4)
+	m, err := newHashTable(dictCap, 4, CyclicPolyMixer)
 	if err != nil {
 		t.Fatal(err)
 	}
-	encoderDict, err := newEncoderDict(dictCap, dictCap+1024, m)
+	encoderDict, err := newEncoderDict(dictCap, dictCap+1024, m, nil)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -112,7 +112,7 @@ func TestEncoderCycle2(t *testing.T) {
 	if err := props.verify(); err != nil {
 		t.Fatalf("properties error %s", err)
 	}
-	state := newState(props)
+	state := NewState(props)
 	lbw := &LimitedByteWriter{BW: buf, N: 100}
 	w, err := newEncoder(lbw, state, encoderDict, 0)
 	if err != nil {
@@ -127,7 +127,7 @@ func TestEncoderCycle2(t *testing.T) {
 	}
 	n := w.Compressed()
 	txt = txt[:n]
-	decoderDict, err := newDecoderDict(dictCap)
+	decoderDict, err := NewDecoderDict(dictCap)
 	if err != nil {
 		t.Fatalf("NewDecoderDict error %s", err)
 	}
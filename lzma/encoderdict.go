@@ -5,7 +5,6 @@
 package lzma
 
 import (
-	"errors"
 	"fmt"
 	"io"
 )
@@ -16,6 +15,9 @@ type matcher interface {
 	io.Writer
 	SetDict(d *encoderDict)
 	NextOp(rep [4]uint32) operation
+	// Reset returns the matcher into its initial state without
+	// allocating new tables.
+	Reset()
 }
 
 // encoderDict provides the dictionary of the encoder. It includes an
@@ -26,22 +28,27 @@ type encoderDict struct {
 	head     int64
 	capacity int
 	// preallocated array
-	data [maxMatchLen]byte
+	data [MaxMatchLen]byte
 }
 
 // newEncoderDict creates the encoder dictionary. The argument bufSize
-// defines the size of the additional buffer.
-func newEncoderDict(dictCap, bufSize int, m matcher) (d *encoderDict, err error) {
+// defines the size of the additional buffer. The memory of dictBuf is
+// used for the buffer unless it is nil.
+func newEncoderDict(dictCap, bufSize int, m matcher, dictBuf []byte) (
+	d *encoderDict, err error) {
+
 	if !(1 <= dictCap && int64(dictCap) <= MaxDictCap) {
-		return nil, errors.New(
-			"lzma: dictionary capacity out of range")
+		return nil, ErrDictCap
 	}
 	if bufSize < 1 {
-		return nil, errors.New(
-			"lzma: buffer size must be larger than zero")
+		return nil, ErrBufSize
+	}
+	buf, err := newBufferIn(dictBuf, dictCap+bufSize)
+	if err != nil {
+		return nil, err
 	}
 	d = &encoderDict{
-		buf:      *newBuffer(dictCap + bufSize),
+		buf:      *buf,
 		capacity: dictCap,
 		m:        m,
 	}
@@ -49,6 +56,13 @@ func newEncoderDict(dictCap, bufSize int, m matcher) (d *encoderDict, err error)
 	return d, nil
 }
 
+// Reset clears the dictionary and the matcher. The buffer is reused.
+func (d *encoderDict) Reset() {
+	d.buf.Reset()
+	d.head = 0
+	d.m.Reset()
+}
+
 // Discard discards n bytes. Note that n must not be larger than
 // MaxMatchLen.
 func (d *encoderDict) Discard(n int) {
@@ -61,6 +75,26 @@ func (d *encoderDict) Discard(n int) {
 	d.m.Write(p)
 }
 
+// preset fills the dictionary with the preset dictionary p. The data is
+// handed to the matcher but is not encoded.
+func (d *encoderDict) preset(p []byte) error {
+	for len(p) > 0 {
+		n, err := d.Write(p)
+		if err != nil && err != ErrNoSpace {
+			return err
+		}
+		p = p[n:]
+		for k := d.Buffered(); k > 0; k -= n {
+			n = k
+			if n > MaxMatchLen {
+				n = MaxMatchLen
+			}
+			d.Discard(n)
+		}
+	}
+	return nil
+}
+
 // Len returns the data available in the encoder dictionary.
 func (d *encoderDict) Len() int {
 	n := d.buf.Available()
@@ -100,6 +134,13 @@ func (d *encoderDict) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// readFrom reads data from r directly into the dictionary buffer using
+// a single Read call. The dictionary is not overwritten. If no space is
+// available ErrNoSpace is returned.
+func (d *encoderDict) readFrom(r io.Reader) (n int, err error) {
+	return d.buf.readFrom(r, d.Available())
+}
+
 // Pos returns the position of the head.
 func (d *encoderDict) Pos() int64 { return d.head }
 
@@ -115,34 +156,46 @@ func (d *encoderDict) ByteAt(distance int) byte {
 	return d.buf.data[i]
 }
 
+// DictBytes copies the last bytes of the dictionary into p and returns
+// the number of bytes copied. Only data that has already been encoded
+// is part of the dictionary. At most DictLen bytes are copied.
+func (d *encoderDict) DictBytes(p []byte) int {
+	n := d.DictLen()
+	if n > len(p) {
+		n = len(p)
+	}
+	s, t := d.buf.behind(n)
+	k := copy(p, s)
+	return k + copy(p[k:], t)
+}
+
 // CopyN copies the last n bytes from the dictionary into the provided
 // writer. This is used for copying uncompressed data into an
-// uncompressed segment.
+// uncompressed segment. If the dictionary holds less than n bytes,
+// ErrNoSpace is returned without writing anything. The dictionary is
+// never changed.
 func (d *encoderDict) CopyN(w io.Writer, n int) (written int, err error) {
 	if n <= 0 {
 		return 0, nil
 	}
-	m := d.Len()
-	if n > m {
-		n = m
-		err = ErrNoSpace
+	if n > d.Len() {
+		return 0, ErrNoSpace
 	}
-	i := d.buf.rear - n
-	var e error
-	if i < 0 {
-		i += len(d.buf.data)
-		if written, e = w.Write(d.buf.data[i:]); e != nil {
-			return written, e
+	p, q := d.buf.behind(n)
+	for _, s := range [][]byte{p, q} {
+		if len(s) == 0 {
+			continue
+		}
+		k, err := w.Write(s)
+		written += k
+		if err != nil {
+			return written, err
+		}
+		if k < len(s) {
+			return written, io.ErrShortWrite
 		}
-		i = 0
-	}
-	var k int
-	k, e = w.Write(d.buf.data[i:d.buf.rear])
-	written += k
-	if e != nil {
-		err = e
 	}
-	return written, err
+	return written, nil
 }
 
 // Buffered returns the number of bytes in the buffer.
@@ -0,0 +1,118 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+// TraceMatch describes a match by its distance and length. A distance
+// of 1 refers to the byte preceding the match.
+type TraceMatch struct {
+	Distance int64
+	Len      int
+}
+
+// TraceChunk describes an LZMA2 chunk written by the encoder.
+type TraceChunk struct {
+	// number of uncompressed bytes in the chunk
+	Uncompressed int
+	// number of bytes written for the chunk including its header
+	Size int
+	// the data is stored uncompressed
+	Stored bool
+	// resets of the dictionary, the state and the properties
+	// requested by the chunk header
+	DictReset  bool
+	StateReset bool
+	NewProps   bool
+}
+
+// EncoderTrace receives the decisions of the encoder, which supports
+// the analysis of the compression of specific data. The positions are
+// the offsets of the operations in the uncompressed data. The methods
+// are called synchronously by the goroutine writing to the writer.
+type EncoderTrace interface {
+	// Literal is called for each literal encoded.
+	Literal(pos int64, b byte)
+	// Match is called for each match encoded. If the distance is
+	// one of the last four distances, rep is its index; otherwise
+	// rep is -1. A match of length 1 is the short repetition of
+	// the last distance. The candidates are the matches the match
+	// finder compared completely before selecting the match,
+	// including the repetitions; the slice is reused after the
+	// call returns.
+	Match(pos int64, m TraceMatch, rep int, candidates []TraceMatch)
+	// ChunkClosed is called after a chunk has been written.
+	ChunkClosed(c TraceChunk)
+}
+
+// candidateRecorder is implemented by the matchers that can record the
+// match candidates considered by NextOp.
+type candidateRecorder interface {
+	// recordCandidates lets NextOp append the candidates to c. The
+	// value nil stops the recording.
+	recordCandidates(c *[]match)
+}
+
+func (t *matchFinder) recordCandidates(c *[]match) { t.cands = c }
+func (t *hashTable) recordCandidates(c *[]match)   { t.cands = c }
+
+func (m *longRangeMatcher) recordCandidates(c *[]match) {
+	m.cands = c
+	if r, ok := m.matcher.(candidateRecorder); ok {
+		r.recordCandidates(c)
+	}
+}
+
+// setTrace directs the events of the encoder to tr.
+func (e *encoder) setTrace(tr EncoderTrace) {
+	e.trace = tr
+	r, ok := e.dict.m.(candidateRecorder)
+	if !ok {
+		return
+	}
+	if tr == nil {
+		r.recordCandidates(nil)
+		return
+	}
+	r.recordCandidates(&e.cands)
+}
+
+// traceOp reports the operation that is encoded next and clears the
+// candidates recorded for it.
+func (e *encoder) traceOp(op operation) {
+	pos := e.traceBase + e.dict.Pos()
+	switch x := op.(type) {
+	case lit:
+		e.trace.Literal(pos, x.b)
+	case *match:
+		dist := uint32(x.distance - minDistance)
+		rep := -1
+		for g, r := range e.state.rep {
+			if r == dist {
+				rep = g
+				break
+			}
+		}
+		c := e.tcands[:0]
+		for _, m := range e.cands {
+			c = append(c, TraceMatch{m.distance, m.n})
+		}
+		e.tcands = c
+		e.trace.Match(pos, TraceMatch{x.distance, x.n}, rep, c)
+	}
+	e.cands = e.cands[:0]
+}
+
+// traceChunk reports a chunk of type ctype with u uncompressed bytes and
+// size bytes written.
+func (w *Writer2) traceChunk(ctype chunkType, u, size int) {
+	c := TraceChunk{
+		Uncompressed: u,
+		Size:         size,
+		Stored:       ctype == cU || ctype == cUD,
+		DictReset:    ctype == cUD || ctype == cLRND,
+		StateReset:   ctype >= cLR,
+		NewProps:     ctype >= cLRN,
+	}
+	w.encoder.trace.ChunkClosed(c)
+}
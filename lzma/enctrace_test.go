@@ -0,0 +1,109 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// checkTrace verifies the events against the data compressed.
+type checkTrace struct {
+	t      *testing.T
+	data   []byte
+	pos    int64
+	chunks []TraceChunk
+}
+
+func (c *checkTrace) Literal(pos int64, b byte) {
+	if pos != c.pos {
+		c.t.Fatalf("literal at %d; want %d", pos, c.pos)
+	}
+	if b != c.data[pos] {
+		c.t.Fatalf("literal %q at %d; want %q", b, pos, c.data[pos])
+	}
+	c.pos++
+}
+
+func (c *checkTrace) Match(pos int64, m TraceMatch, rep int,
+	candidates []TraceMatch) {
+
+	if pos != c.pos {
+		c.t.Fatalf("match at %d; want %d", pos, c.pos)
+	}
+	if m.Distance > pos || pos+int64(m.Len) > int64(len(c.data)) {
+		c.t.Fatalf("match %+v at %d out of range", m, pos)
+	}
+	for i := int64(0); i < int64(m.Len); i++ {
+		if c.data[pos+i] != c.data[pos+i-m.Distance] {
+			c.t.Fatalf("match %+v at %d doesn't match", m, pos)
+		}
+	}
+	if rep < -1 || rep > 3 || (m.Len == 1 && rep != 0) {
+		c.t.Fatalf("match %+v at %d with rep %d", m, pos, rep)
+	}
+	found := false
+	for _, cand := range candidates {
+		if cand.Distance == m.Distance {
+			found = true
+		}
+	}
+	if m.Len > 1 && !found {
+		c.t.Fatalf("match %+v at %d not in candidates %v", m, pos,
+			candidates)
+	}
+	c.pos += int64(m.Len)
+}
+
+func (c *checkTrace) ChunkClosed(ch TraceChunk) {
+	c.chunks = append(c.chunks, ch)
+}
+
+func TestWriter2Trace(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<18)
+	data := txt.Bytes()
+	for _, cfg := range []Writer2Config{
+		{Matcher: HashTable4},
+		{Matcher: BinaryTree4},
+		{Matcher: HashChain4, CompressedChunkSize: 4096},
+		{Matcher: BinaryTree4, LongRangeLen: 64, Pipelined: true},
+	} {
+		tr := &checkTrace{t: t, data: data}
+		cfg.Trace = tr
+		var buf bytes.Buffer
+		w, err := cfg.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		if tr.pos != int64(len(data)) {
+			t.Fatalf("%s: operations cover %d bytes; want %d",
+				cfg.Matcher, tr.pos, len(data))
+		}
+		var u, size int
+		for _, ch := range tr.chunks {
+			u += ch.Uncompressed
+			size += ch.Size
+		}
+		if u != len(data) || size+1 != buf.Len() {
+			t.Fatalf("%s: chunks cover %d/%d bytes; want %d/%d",
+				cfg.Matcher, u, size, len(data), buf.Len()-1)
+		}
+		if ch := tr.chunks[0]; !ch.DictReset || !ch.StateReset ||
+			!ch.NewProps || ch.Stored {
+			t.Fatalf("first chunk %+v", ch)
+		}
+	}
+}
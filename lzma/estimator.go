@@ -0,0 +1,151 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+// Estimator estimates the size of the LZMA2 stream for the data written
+// to it without compressing the data. It runs the match finder and
+// tracks the probability model using the prices of the operations, but
+// doesn't range encode them and doesn't produce any output. Storage
+// systems can use it to decide whether the compression of data is
+// worthwhile. The estimate is usually within one percent of the actual
+// size.
+//
+// The speed of the estimator is dominated by the match finder. The
+// default HashTable4 matcher gives the fastest estimates.
+type Estimator struct {
+	dict  *encoderDict
+	state *State
+	store bool
+	// preset dictionary used by Reset
+	presetDict []byte
+	// sum of the prices of all operations
+	price uint64
+	// number of bytes written
+	n int64
+}
+
+// NewEstimator creates an estimator for the LZMA2 compression of data
+// with the configuration c. The parameters for the chunks, the dedup
+// hints, the progress function and the logger are ignored.
+func (c Writer2Config) NewEstimator() (e *Estimator, err error) {
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	var m matcher = nopMatcher{}
+	if !c.Store {
+		if m, err = c.Matcher.new(
+			nearDictCap(c.DictCap, c.LongRangeLen), c.Depth,
+			c.NiceLen, c.Lazy, c.WordLen, c.HashMixer); err != nil {
+			return nil, err
+		}
+		if m, err = newLongRange(m, c.DictCap,
+			c.LongRangeLen); err != nil {
+			return nil, err
+		}
+	}
+	d, err := newEncoderDict(c.DictCap, c.BufSize, m, nil)
+	if err != nil {
+		return nil, err
+	}
+	if err = d.preset(c.PresetDict); err != nil {
+		return nil, err
+	}
+	e = &Estimator{
+		dict:       d,
+		state:      NewState(*c.Properties),
+		store:      c.Store,
+		presetDict: c.PresetDict,
+	}
+	return e, nil
+}
+
+// Write adds the data in p to the estimate. The method never returns
+// an error.
+func (e *Estimator) Write(p []byte) (n int, err error) {
+	for {
+		k, err := e.dict.Write(p[n:])
+		n += k
+		e.n += int64(k)
+		if err != ErrNoSpace {
+			return n, err
+		}
+		e.process(MaxMatchLen - 1)
+	}
+}
+
+// process runs the match finder over the buffered data until only n
+// bytes are left and adds the prices of the operations found.
+func (e *Estimator) process(n int) {
+	d := e.dict
+	if e.store {
+		for d.Buffered() > n {
+			k := d.Buffered() - n
+			if k > MaxMatchLen {
+				k = MaxMatchLen
+			}
+			d.Discard(k)
+		}
+		return
+	}
+	s := e.state
+	for d.Buffered() > n {
+		op := d.m.NextOp(s.rep)
+		switch x := op.(type) {
+		case lit:
+			e.price += uint64(s.adaptLiteral(x.b, d.ByteAt(1),
+				d.ByteAt(int(s.rep[0])+1), d.Pos()))
+		case *match:
+			e.price += uint64(s.adaptMatch(*x, d.Pos()))
+		}
+		d.Discard(op.Len())
+	}
+}
+
+// Overheads of LZMA2 chunks. The compressed chunk requires a header of
+// up to six bytes and the flush of the range encoder. An uncompressed
+// chunk has a three-byte header.
+const (
+	compressedChunkOverhead   = 6 + 4
+	uncompressedChunkOverhead = 3
+)
+
+// Size returns the estimated size of the LZMA2 stream including the
+// end marker for all data written so far. The buffered data is
+// processed first, which limits the matches for the data written after
+// the call like Flush does for a writer.
+func (e *Estimator) Size() int64 {
+	e.process(0)
+	if e.n == 0 {
+		return 1
+	}
+	stored := e.n + uncompressedChunkOverhead*
+		((e.n+maxCompressed-1)/maxCompressed)
+	if e.store {
+		return stored + 1
+	}
+	const bytePrice = 8 << adaptShiftBits
+	c := int64((e.price + bytePrice - 1) / bytePrice)
+	chunks := (e.n + maxUncompressed - 1) / maxUncompressed
+	if k := (c + maxCompressed - 1) / maxCompressed; k > chunks {
+		chunks = k
+	}
+	c += compressedChunkOverhead * chunks
+	if stored < c {
+		c = stored
+	}
+	return c + 1
+}
+
+// Reset discards all data written and puts the estimator into its
+// initial state. The dictionary and the tables of the match finder are
+// reused.
+func (e *Estimator) Reset() {
+	e.dict.Reset()
+	// The preset dictionary has been verified by NewEstimator.
+	e.dict.preset(e.presetDict)
+	e.state.Reset()
+	e.price = 0
+	e.n = 0
+}
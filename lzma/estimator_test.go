@@ -0,0 +1,107 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// estimatorSamples returns text, random and repetitive samples.
+func estimatorSamples(n int) map[string][]byte {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(1)), int64(n))
+	rnd := make([]byte, n)
+	rand.New(rand.NewSource(2)).Read(rnd)
+	rep := bytes.Repeat([]byte("The quick brown fox jumps over the "+
+		"lazy dog.\n"), n/45)
+	return map[string][]byte{"text": txt.Bytes(), "random": rnd,
+		"repeat": rep}
+}
+
+func TestEstimator(t *testing.T) {
+	for name, data := range estimatorSamples(1 << 18) {
+		for _, cfg := range []Writer2Config{
+			{DictCap: 1 << 16},
+			{DictCap: 1 << 16, Matcher: BinaryTree4},
+			{Store: true},
+		} {
+			var buf bytes.Buffer
+			w, err := cfg.NewWriter2(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter2 error %s", err)
+			}
+			if _, err = w.Write(data); err != nil {
+				t.Fatalf("Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("Close error %s", err)
+			}
+			e, err := cfg.NewEstimator()
+			if err != nil {
+				t.Fatalf("NewEstimator error %s", err)
+			}
+			for i := 0; i < 2; i++ {
+				if _, err = e.Write(data); err != nil {
+					t.Fatalf("Estimator.Write error %s", err)
+				}
+				got, want := e.Size(), int64(buf.Len())
+				t.Logf("%s %v: estimate %d; size %d", name,
+					cfg.Matcher, got, want)
+				d := got - want
+				if d < 0 {
+					d = -d
+				}
+				if d > want/100+16 {
+					t.Errorf("%s %v: estimate %d; size %d",
+						name, cfg.Matcher, got, want)
+				}
+				e.Reset()
+			}
+		}
+	}
+	e, err := Writer2Config{}.NewEstimator()
+	if err != nil {
+		t.Fatalf("NewEstimator error %s", err)
+	}
+	if n := e.Size(); n != 1 {
+		t.Fatalf("Size for no data returned %d; want 1", n)
+	}
+}
+
+func BenchmarkEstimator(b *testing.B) {
+	data := estimatorSamples(1 << 20)["text"]
+	e, err := Writer2Config{}.NewEstimator()
+	if err != nil {
+		b.Fatalf("NewEstimator error %s", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		e.Reset()
+		e.Write(data)
+		e.Size()
+	}
+}
+
+func BenchmarkEstimatorWriter2(b *testing.B) {
+	data := estimatorSamples(1 << 20)["text"]
+	w, err := Writer2Config{}.NewWriter2(ioutil.Discard)
+	if err != nil {
+		b.Fatalf("NewWriter2 error %s", err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.Reset(ioutil.Discard)
+		w.Write(data)
+		w.Close()
+	}
+}
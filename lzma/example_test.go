@@ -0,0 +1,100 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma_test
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"sync"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// The example compresses a small message using a preset dictionary
+// containing typical content of the messages. The reader must use the
+// same preset dictionary.
+func Example_presetDict() {
+	dict := []byte(`{"name": "fox", "action": "jumps", "target": "dog"}`)
+	msg := `{"name": "cat", "action": "sleeps", "target": "mat"}`
+
+	compress := func(dict []byte) []byte {
+		var buf bytes.Buffer
+		w, err := lzma.Writer2Config{PresetDict: dict}.NewWriter2(&buf)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err = io.WriteString(w, msg); err != nil {
+			log.Fatal(err)
+		}
+		if err = w.Close(); err != nil {
+			log.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	data := compress(dict)
+	fmt.Println(len(data) < len(compress(nil)))
+
+	r, err := lzma.Reader2Config{PresetDict: dict}.NewReader2(
+		bytes.NewReader(data))
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = io.Copy(os.Stdout, r); err != nil {
+		log.Fatal(err)
+	}
+	fmt.Println()
+	// Output:
+	// true
+	// {"name": "cat", "action": "sleeps", "target": "mat"}
+}
+
+// The example keeps writers in a sync.Pool. Reset reuses the dictionary
+// and the match finder of a writer, so compressing many small messages
+// doesn't allocate new buffers for every message.
+func Example_pool() {
+	cfg := lzma.WriterConfig{DictCap: 1 << 16}
+	pool := sync.Pool{New: func() interface{} {
+		w, err := cfg.NewWriter(ioutil.Discard)
+		if err != nil {
+			log.Fatal(err)
+		}
+		return w
+	}}
+	compress := func(msg string) []byte {
+		var buf bytes.Buffer
+		w := pool.Get().(*lzma.Writer)
+		defer pool.Put(w)
+		if err := w.Reset(&buf); err != nil {
+			log.Fatal(err)
+		}
+		if _, err := io.WriteString(w, msg); err != nil {
+			log.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			log.Fatal(err)
+		}
+		return buf.Bytes()
+	}
+	for _, msg := range []string{"The quick brown fox", "jumps over",
+		"the lazy dog."} {
+
+		r, err := lzma.NewReader(bytes.NewReader(compress(msg)))
+		if err != nil {
+			log.Fatal(err)
+		}
+		if _, err = io.Copy(os.Stdout, r); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println()
+	}
+	// Output:
+	// The quick brown fox
+	// jumps over
+	// the lazy dog.
+}
@@ -0,0 +1,89 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.18
+// +build go1.18
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz/xztestdata"
+)
+
+// fuzzMemoryLimit restricts the memory the decoders may use for
+// fuzzed input.
+const fuzzMemoryLimit = 1 << 24
+
+// fuzzOutputLimit restricts the amount of data decompressed for
+// fuzzed input.
+const fuzzOutputLimit = 1 << 24
+
+// addRegressions adds the regression inputs of the given format to the
+// seed corpus.
+func addRegressions(f *testing.F, format string) {
+	for _, reg := range xztestdata.Regressions() {
+		if reg.Format == format {
+			f.Add(reg.Data)
+		}
+	}
+}
+
+func FuzzReader(f *testing.F) {
+	files, err := filepath.Glob(filepath.Join("examples", "*.lzma"))
+	if err != nil {
+		f.Fatalf("Glob error %s", err)
+	}
+	for _, file := range files {
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			f.Fatalf("ReadFile error %s", err)
+		}
+		f.Add(data)
+	}
+	addRegressions(f, "lzma")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := ReaderConfig{MemoryLimit: fuzzMemoryLimit}
+		r, err := c.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(r, fuzzOutputLimit))
+	})
+}
+
+func FuzzReader2(f *testing.F) {
+	var buf bytes.Buffer
+	w, err := Writer2Config{ChunkSize: 100}.NewWriter2(&buf)
+	if err != nil {
+		f.Fatalf("NewWriter2 error %s", err)
+	}
+	orig, err := ioutil.ReadFile(filepath.Join("examples", "a.txt"))
+	if err != nil {
+		f.Fatalf("ReadFile error %s", err)
+	}
+	if _, err = w.Write(orig); err != nil {
+		f.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		f.Fatalf("w.Close error %s", err)
+	}
+	f.Add(buf.Bytes())
+	f.Add([]byte{1, 0, 0, 'a', 0})
+	addRegressions(f, "lzma2")
+	f.Fuzz(func(t *testing.T, data []byte) {
+		c := Reader2Config{DictCap: MinDictCap,
+			MemoryLimit: fuzzMemoryLimit}
+		r, err := c.NewReader2(bytes.NewReader(data))
+		if err != nil {
+			return
+		}
+		io.Copy(ioutil.Discard, io.LimitReader(r, fuzzOutputLimit))
+	})
+}
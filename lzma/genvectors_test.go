@@ -0,0 +1,298 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build genvectors
+// +build genvectors
+
+package lzma
+
+// TestGenerateVectors creates the conformance vectors in
+// testdata/vectors. It requires the xz command of XZ Utils, whose LZMA
+// implementation is derived from the LZMA SDK, and is only compiled
+// with the build tag genvectors.
+//
+//	$ go test -tags genvectors -run TestGenerateVectors ./lzma
+//
+// Most streams are created by the xz command from inputs crafted to
+// produce all operation types, rotations of the repeated distances
+// and state transitions. The reference encoder never writes some
+// sequences, like consecutive short repetitions, so the stream
+// crafted.lzma is encoded from a list of operations and verified by
+// decoding it with the xz command. For each stream NAME.lzma the
+// SHA-256 hash of the uncompressed data of the reference is written to
+// NAME.sha256 and the operations decoded to NAME.ops.
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// refVector describes a vector created by the reference encoder.
+type refVector struct {
+	name string
+	// options of the --lzma1 flag of the xz command
+	opts string
+	data func() []byte
+}
+
+// text returns n bytes of random text.
+func text(n int64) []byte {
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(42)), n)
+	return buf.Bytes()
+}
+
+// records returns fixed-size binary records with fields repeating at
+// different distances, which produces repetitions of all four
+// distances.
+func records() []byte {
+	rnd := rand.New(rand.NewSource(42))
+	tags := []string{"NAME", "SIZE", "MODE", "TIME"}
+	var buf bytes.Buffer
+	for i := 0; i < 400; i++ {
+		buf.WriteString(tags[rnd.Intn(len(tags))])
+		buf.Write([]byte{byte(i), byte(i >> 8), 0, 0})
+		buf.WriteString(tags[rnd.Intn(len(tags))])
+		for j := 0; j < rnd.Intn(4); j++ {
+			buf.WriteByte(byte(rnd.Intn(256)))
+		}
+	}
+	return buf.Bytes()
+}
+
+// noisy returns data that mostly repeats the byte 16 positions before,
+// which produces long and short repetitions of the last distance.
+func noisy() []byte {
+	rnd := rand.New(rand.NewSource(42))
+	p := make([]byte, 4096)
+	for i := range p {
+		if i < 16 || rnd.Intn(10) == 0 {
+			p[i] = byte(rnd.Intn(256))
+		} else {
+			p[i] = p[i-16]
+		}
+	}
+	return p
+}
+
+// runs copies segments of random data, whose lengths exceed multiples
+// of the maximum match length by one byte. The encoder must continue
+// the longest matches with repetitions and short repetitions. Some
+// segments follow a match and some continue the distance of the
+// previous match after a literal.
+func runs() []byte {
+	rnd := rand.New(rand.NewSource(42))
+	r := make([]byte, 4096)
+	rnd.Read(r)
+	var buf bytes.Buffer
+	buf.Write(r)
+	for i := 0; i < 6; i++ {
+		for k := 1; k <= 4; k++ {
+			off := rnd.Intn(len(r) - 4*273 - 64)
+			buf.WriteByte(byte(rnd.Intn(256)))
+			switch i % 3 {
+			case 1:
+				o := rnd.Intn(len(r) - 32)
+				buf.Write(r[o : o+20])
+			case 2:
+				buf.Write(r[off : off+20])
+				buf.WriteByte(r[off+20] ^ 0xff)
+				off += 21
+			}
+			buf.Write(r[off : off+k*273+1])
+		}
+	}
+	return buf.Bytes()
+}
+
+// singles returns random bytes, of which some repeat the byte 8
+// positions before.
+func singles() []byte {
+	rnd := rand.New(rand.NewSource(42))
+	p := make([]byte, 4096)
+	for i := range p {
+		if i < 8 || rnd.Intn(3) == 0 {
+			p[i] = byte(rnd.Intn(256))
+		} else {
+			p[i] = p[i-8]
+		}
+	}
+	return p
+}
+
+// mixed interleaves text, records and noise.
+func mixed() []byte {
+	var buf bytes.Buffer
+	t, r, n := text(3000), records(), noisy()
+	for i := 0; i < 3; i++ {
+		buf.Write(t[i*1000 : (i+1)*1000])
+		buf.Write(r[i*1000 : (i+1)*1000])
+		buf.Write(n[i*1000 : (i+1)*1000])
+	}
+	return buf.Bytes()
+}
+
+var refVectors = []refVector{
+	{"text", "preset=9e,lc=3,lp=0,pb=2", func() []byte {
+		return text(4096)
+	}},
+	{"records", "preset=9e,lc=0,lp=2,pb=2", records},
+	{"noisy", "preset=9e,lc=4,lp=0,pb=0", noisy},
+	{"mixed", "preset=6,lc=1,lp=3,pb=4", mixed},
+	{"runs", "preset=9e,lc=3,lp=0,pb=2", runs},
+	{"singles", "preset=9e,lc=3,lp=0,pb=0", singles},
+}
+
+// craftedOps returns the operations of the crafted vector. It contains
+// consecutive short repetitions and rotations of all repeated
+// distances.
+func craftedOps() []operation {
+	var ops []operation
+	for _, c := range []byte("abcdefghijklmnop") {
+		ops = append(ops, lit{c})
+	}
+	m := func(dist int64, n int) {
+		ops = append(ops, &match{distance: dist, n: n})
+	}
+	m(4, 4)
+	// short repetitions after a match, a literal and each other
+	m(4, 1)
+	ops = append(ops, lit{'x'})
+	m(4, 1)
+	m(4, 1)
+	m(4, 1)
+	// new distances 9, 7 and 5, then rep3, rep2, rep1 and rep0
+	m(9, 2)
+	m(7, 3)
+	m(5, 2)
+	m(4, 2)
+	m(7, 2)
+	m(4, 2)
+	m(4, 3)
+	ops = append(ops, lit{'y'})
+	m(4, 1)
+	m(4, 5)
+	return ops
+}
+
+// encodeOps encodes the operations into an LZMA file with the given
+// properties. The function returns the file and the uncompressed data.
+func encodeOps(t *testing.T, p Properties, ops []operation) (file,
+	data []byte) {
+
+	var buf bytes.Buffer
+	h := header{properties: p, dictCap: MinDictCap, size: -1}
+	hdata, err := h.marshalBinary()
+	if err != nil {
+		t.Fatalf("marshalBinary error %s", err)
+	}
+	buf.Write(hdata)
+	re, err := newRangeEncoder(&buf)
+	if err != nil {
+		t.Fatalf("newRangeEncoder error %s", err)
+	}
+	s := NewState(p)
+	byteAt := func(dist int) byte {
+		if i := len(data) - dist; i >= 0 {
+			return data[i]
+		}
+		return 0
+	}
+	for _, op := range ops {
+		pos := int64(len(data))
+		switch x := op.(type) {
+		case lit:
+			err = encodeLiteral(re, s, x.b, byteAt(1),
+				byteAt(int(s.rep[0])+1), pos)
+			data = append(data, x.b)
+		case *match:
+			err = encodeMatch(re, s, *x, pos)
+			for i := 0; i < x.n; i++ {
+				data = append(data, byteAt(int(x.distance)))
+			}
+		}
+		if err != nil {
+			t.Fatalf("encode error %s", err)
+		}
+	}
+	if err = encodeMatch(re, s, eosMatch, int64(len(data))); err != nil {
+		t.Fatalf("encode EOS error %s", err)
+	}
+	if err = re.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	return buf.Bytes(), data
+}
+
+// xzCmd runs the xz command with the given arguments on the input.
+func xzCmd(t *testing.T, input []byte, args ...string) []byte {
+	path, err := exec.LookPath("xz")
+	if err != nil {
+		t.Fatalf("xz command not found")
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin = bytes.NewReader(input)
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("xz %v error %s", args, err)
+	}
+	return out
+}
+
+// writeVector writes the stream, the hash of the uncompressed data
+// provided by the reference and the operations decoded from the
+// stream.
+func writeVector(t *testing.T, name string, stream, data []byte) {
+	var c opCoverage
+	ops, p := decodeOps(t, stream, &c)
+	if !bytes.Equal(p, data) {
+		t.Fatalf("%s: decoded data differs", name)
+	}
+	name = filepath.Join("testdata", "vectors", name)
+	if err := ioutil.WriteFile(name+".lzma", stream, 0644); err != nil {
+		t.Fatalf("WriteFile error %s", err)
+	}
+	if err := ioutil.WriteFile(name+".sha256",
+		[]byte(sha256Hex(data)+"\n"), 0644); err != nil {
+		t.Fatalf("WriteFile error %s", err)
+	}
+	if err := ioutil.WriteFile(name+".ops", []byte(ops),
+		0644); err != nil {
+		t.Fatalf("WriteFile error %s", err)
+	}
+}
+
+func TestGenerateVectors(t *testing.T) {
+	for _, v := range refVectors {
+		data := v.data()
+		stream := xzCmd(t, data, "--format=lzma", "--lzma1="+v.opts,
+			"-c")
+		r, err := NewReader(bytes.NewReader(stream))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", v.name, err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", v.name, err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Fatalf("%s: decompressed data differs", v.name)
+		}
+		writeVector(t, v.name, stream, data)
+	}
+	props := Properties{LC: 3, LP: 0, PB: 2}
+	stream, data := encodeOps(t, props, craftedOps())
+	p := xzCmd(t, stream, "--format=lzma", "-dc")
+	if !bytes.Equal(p, data) {
+		t.Fatalf("crafted: xz decompressed %q; want %q", p, data)
+	}
+	writeVector(t, "crafted", stream, p)
+}
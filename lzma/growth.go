@@ -0,0 +1,63 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+// Growth describes how the dictionary buffer of a reader grows. The
+// buffer starts with Initial bytes and doubles its size whenever more
+// space is required until the soft cap is reached. Above the soft cap
+// the buffer grows in steps of SoftCap bytes. The dictionary capacity
+// is the hard cap; it is never exceeded.
+//
+// Small streams require only a fraction of the dictionary capacity, so
+// the strategy saves memory without slowing down the decoding of large
+// streams. The complete dictionary is allocated directly, if Initial is
+// not less than the dictionary capacity.
+type Growth struct {
+	// initial size of the buffer
+	Initial int
+	// size up to which the buffer size is doubled
+	SoftCap int
+}
+
+// fill replaces zero values with default values.
+func (g *Growth) fill() {
+	if g.Initial == 0 {
+		g.Initial = 64 * 1024
+	}
+	if g.SoftCap == 0 {
+		g.SoftCap = 8 * 1024 * 1024
+	}
+}
+
+// Verify checks the growth parameters for errors. Zero values will be
+// replaced by default values.
+func (g *Growth) Verify() error {
+	g.fill()
+	if g.Initial < 1 {
+		return errors.New("lzma: initial buffer size must be positive")
+	}
+	if g.SoftCap < 1 {
+		return errors.New("lzma: soft cap must be positive")
+	}
+	return nil
+}
+
+// next computes the buffer size that is at least need bytes large,
+// starting with the given size. The hard cap limits the result.
+func (g *Growth) next(size, need, hardCap int) int {
+	for size < need && size < hardCap {
+		if size < g.SoftCap {
+			size *= 2
+		} else {
+			size += g.SoftCap
+		}
+	}
+	if size > hardCap {
+		size = hardCap
+	}
+	return size
+}
@@ -0,0 +1,75 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+/* The hash chain matcher links each position to the previous position
+ * with the same hash value. It is faster than the binary tree, because
+ * a new position requires only the update of a single entry, but the
+ * search has to visit all positions of the chain to find the longest
+ * match.
+ */
+
+// Parameters of the hash chain matcher. The values equal the defaults
+// liblzma uses for the preset level 3.
+const (
+	// hcNiceLen is the match length that stops the search for
+	// longer matches.
+	hcNiceLen = MaxMatchLen
+	// hcDepth is the maximum number of chain entries visited per
+	// position.
+	hcDepth = 48
+)
+
+// newHashChain creates a new hash chain matcher for the dictionary
+// capacity using words of length wordLen for the hash table. If depth
+// is zero the default depth is used.
+func newHashChain(capacity, wordLen, depth int) (t *matchFinder, err error) {
+	if !(3 <= wordLen && wordLen <= 4) {
+		return nil, errors.New(
+			"newHashChain: argument wordLen out of range")
+	}
+	if depth == 0 {
+		depth = hcDepth
+	}
+	return newMatchFinder(capacity, wordLen, false, hcNiceLen, depth)
+}
+
+// chain adds the byte sequence at index i to the hash chain starting at
+// curMatch. If find is set the chain is searched and all matches longer
+// than bestLen are appended to the matches field.
+func (t *matchFinder) chain(i, lenLimit int, curMatch uint32, bestLen int,
+	find bool) {
+
+	t.son[t.cyclicPos] = curMatch
+	if !find {
+		return
+	}
+	for depth := t.depth; depth > 0; depth-- {
+		delta := t.cpos - curMatch
+		if delta >= t.cyclicSize {
+			return
+		}
+		p := t.cyclicPos - delta
+		if delta > t.cyclicPos {
+			p += t.cyclicSize
+		}
+		curMatch = t.son[p]
+		j := t.back(i, int(delta))
+		if t.at(j, bestLen) != t.at(i, bestLen) ||
+			t.at(j, 0) != t.at(i, 0) {
+			continue
+		}
+		k := t.cmpLen(i, j, 1, lenLimit)
+		if bestLen < k {
+			bestLen = k
+			t.matches = append(t.matches, match{int64(delta), k})
+			if k == lenLimit {
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,98 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestNewHashChain(t *testing.T) {
+	if _, err := newHashChain(4096, 2, 0); err == nil {
+		t.Fatalf("newHashChain accepted word length 2")
+	}
+	hc, err := newHashChain(4096, 4, 0)
+	if err != nil {
+		t.Fatalf("newHashChain error %s", err)
+	}
+	if hc.depth != hcDepth {
+		t.Fatalf("depth %d; want default %d", hc.depth, hcDepth)
+	}
+	if len(hc.son) != 4097 {
+		t.Fatalf("hash chain has %d entries; want %d", len(hc.son),
+			4097)
+	}
+}
+
+func TestMatchFinderReset(t *testing.T) {
+	hc, err := newHashChain(4096, 4, 0)
+	if err != nil {
+		t.Fatalf("newHashChain error %s", err)
+	}
+	hc.hash[0], hc.son[0] = hc.cpos, hc.cpos
+	hc.Reset()
+	if hc.hash[0] == 0 {
+		t.Fatalf("Reset cleared the hash table")
+	}
+	if d := hc.cpos - hc.hash[0]; d < hc.cyclicSize {
+		t.Fatalf("entry has distance %d after Reset; want at least %d",
+			d, hc.cyclicSize)
+	}
+	hc.cpos = 1<<32 - 2
+	hc.Reset()
+	if hc.cpos != hc.cyclicSize || hc.hash[0] != 0 || hc.son[0] != 0 {
+		t.Fatalf("Reset didn't clear the tables before the overflow")
+	}
+}
+
+func TestHashChain_Cycle(t *testing.T) {
+	const txtlen = 50000
+	txt := new(bytes.Buffer)
+	io.CopyN(txt, randtxt.NewReader(rand.NewSource(42)), txtlen)
+	tests := []struct {
+		matcher MatchAlgorithm
+		depth   int
+	}{
+		{HashChain3, 0},
+		{HashChain3, 1},
+		{HashChain4, 0},
+		{HashChain4, 4},
+	}
+	for _, c := range tests {
+		buf := new(bytes.Buffer)
+		w, err := WriterConfig{
+			DictCap: 4096,
+			Matcher: c.matcher,
+			Depth:   c.depth,
+		}.NewWriter(buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt.Bytes()); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		t.Logf("%s depth %d: compressed %d bytes", c.matcher,
+			c.depth, buf.Len())
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out := new(bytes.Buffer)
+		if _, err = io.Copy(out, r); err != nil {
+			t.Fatalf("io.Copy error %s", err)
+		}
+		if !bytes.Equal(out.Bytes(), txt.Bytes()) {
+			t.Fatalf("%s depth %d: decompressed data differs",
+				c.matcher, c.depth)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/ulikunitz/xz/internal/hash"
+)
+
+// HashMixer identifies the hash function used by the hash table
+// matcher. The choice changes the distribution of the hash values and
+// therewith the matches found, which affects the compression ratio
+// differently for text and binary data.
+type HashMixer byte
+
+// Supported hash mixers. CyclicPolyMixer is the default. CRCMixer uses
+// the CRC-based hash of liblzma and MultiplicativeMixer a Rabin-Karp
+// hash, whose high bits are used for the table index.
+const (
+	CyclicPolyMixer HashMixer = iota
+	CRCMixer
+	MultiplicativeMixer
+)
+
+// hmStrings are used by the String method.
+var hmStrings = map[HashMixer]string{
+	CyclicPolyMixer:     "CyclicPolyMixer",
+	CRCMixer:            "CRCMixer",
+	MultiplicativeMixer: "MultiplicativeMixer",
+}
+
+// String returns a string representation of the hash mixer.
+func (h HashMixer) String() string {
+	if s, ok := hmStrings[h]; ok {
+		return s
+	}
+	return "unknown"
+}
+
+// verify checks whether the hash mixer value is supported.
+func (h HashMixer) verify() error {
+	if _, ok := hmStrings[h]; !ok {
+		return errors.New("lzma: unsupported hash mixer value")
+	}
+	return nil
+}
+
+// newRoller creates the rolling hash for words of length n.
+func (h HashMixer) newRoller(n int) hash.Roller {
+	switch h {
+	case CRCMixer:
+		return hash.NewCRC(n)
+	case MultiplicativeMixer:
+		return highBits{hash.NewRabinKarp(n)}
+	}
+	return hash.NewCyclicPoly(n)
+}
+
+// highBits moves the well-mixed high bits of a multiplicative hash into
+// the low bits, which are used as index into the hash table.
+type highBits struct{ *hash.RabinKarp }
+
+// RollByte hashes the next byte and returns the high half of the hash.
+func (r highBits) RollByte(x byte) uint64 {
+	return r.RabinKarp.RollByte(x) >> 32
+}
+
+// Limits for the word length of the hash table matcher.
+const (
+	minHashWordLen = 2
+	maxHashWordLen = 5
+)
+
+// verifyWordLen checks the word length of a configuration. The value
+// zero selects the default of 4.
+func verifyWordLen(wordLen int) error {
+	if wordLen != 0 &&
+		!(minHashWordLen <= wordLen && wordLen <= maxHashWordLen) {
+		return fmt.Errorf(
+			"lzma: hash word length %d out of range [%d, %d]",
+			wordLen, minHashWordLen, maxHashWordLen)
+	}
+	return nil
+}
@@ -31,10 +31,6 @@ const (
 	maxTableExponent = 20
 )
 
-// newRoller contains the function used to create an instance of the
-// hash.Roller.
-var newRoller = func(n int) hash.Roller { return hash.NewCyclicPoly(n) }
-
 // hashTable stores the hash table including the rolling hash method.
 //
 // We implement chained hashing into a circular buffer. Each entry in
@@ -42,8 +38,12 @@ var newRoller = func(n int) hash.Roller { return hash.NewCyclicPoly(n) }
 // word that has the same hash value.
 type hashTable struct {
 	dict *encoderDict
-	// actual hash table
+	// actual hash table; the positions are offset by off
 	t []int64
+	// offset of the positions stored in the hash table; Reset
+	// increases it beyond all stored positions instead of clearing
+	// the table
+	off int64
 	// circular list data with the offset to the next word
 	data  []uint32
 	front int
@@ -53,6 +53,8 @@ type hashTable struct {
 	hoff int64
 	// length of the hashed word
 	wordLen int
+	// hash function
+	mixer HashMixer
 	// hash roller for computing the hash values for the Write
 	// method
 	wr hash.Roller
@@ -61,6 +63,10 @@ type hashTable struct {
 	// preallocated slices
 	p         [maxMatches]int64
 	distances [maxMatches + shortDists]int
+	// match returned by NextOp
+	op match
+	// receives the match candidates if not nil
+	cands *[]match
 }
 
 // hashTableExponent derives the hash table exponent from the dictionary
@@ -77,13 +83,15 @@ func hashTableExponent(n uint32) int {
 }
 
 // newHashTable creates a new hash table for words of length wordLen
-func newHashTable(capacity int, wordLen int) (t *hashTable, err error) {
+// using the given hash mixer.
+func newHashTable(capacity int, wordLen int, mixer HashMixer,
+) (t *hashTable, err error) {
 	if !(0 < capacity) {
 		return nil, errors.New(
 			"newHashTable: capacity must not be negative")
 	}
 	exp := hashTableExponent(uint32(capacity))
-	if !(1 <= wordLen && wordLen <= 4) {
+	if !(1 <= wordLen && wordLen <= maxHashWordLen) {
 		return nil, errors.New("newHashTable: " +
 			"argument wordLen out of range")
 	}
@@ -97,14 +105,30 @@ func newHashTable(capacity int, wordLen int) (t *hashTable, err error) {
 		mask:    (uint64(1) << uint(exp)) - 1,
 		hoff:    -int64(wordLen),
 		wordLen: wordLen,
-		wr:      newRoller(wordLen),
-		hr:      newRoller(wordLen),
+		mixer:   mixer,
+		wr:      mixer.newRoller(wordLen),
+		hr:      mixer.newRoller(wordLen),
 	}
 	return t, nil
 }
 
 func (t *hashTable) SetDict(d *encoderDict) { t.dict = d }
 
+// Reset puts the hash table into its initial state. The tables are
+// neither reallocated nor cleared. The offset is moved behind all
+// stored positions, so they appear to precede the data and are never
+// returned. Only the chain entries for the new positions are used;
+// they are overwritten before.
+func (t *hashTable) Reset() {
+	if t.hoff >= 0 {
+		t.off += t.hoff + 1
+	}
+	t.front = 0
+	t.hoff = -int64(t.wordLen)
+	t.wr = t.mixer.newRoller(t.wordLen)
+	t.hr = t.mixer.newRoller(t.wordLen)
+}
+
 // buffered returns the number of bytes that are currently hashed.
 func (t *hashTable) buffered() int {
 	n := t.hoff + 1
@@ -141,8 +165,8 @@ func (t *hashTable) putEntry(h uint64, pos int64) {
 		return
 	}
 	i := h & t.mask
-	old := t.t[i] - 1
-	t.t[i] = pos + 1
+	old := t.t[i] - 1 - t.off
+	t.t[i] = pos + 1 + t.off
 	var delta int64
 	if old >= 0 {
 		delta = pos - old
@@ -188,7 +212,7 @@ func (t *hashTable) getMatches(h uint64, positions []int64) (n int) {
 		rear -= len(t.data)
 	}
 	// get the slot for the hash
-	pos := t.t[h&t.mask] - 1
+	pos := t.t[h&t.mask] - 1 - t.off
 	delta := pos - tailPos
 	for {
 		if delta < 0 {
@@ -238,7 +262,7 @@ func (t *hashTable) Matches(p []byte, positions []int64) int {
 // TODO: Use all repetitions to find matches.
 func (t *hashTable) NextOp(rep [4]uint32) operation {
 	// get positions
-	data := t.dict.data[:maxMatchLen]
+	data := t.dict.data[:MaxMatchLen]
 	n, _ := t.dict.buf.Peek(data)
 	data = data[:n]
 	var p []int64
@@ -285,6 +309,9 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 		}
 
 		n := t.dict.buf.matchLen(dist, data)
+		if t.cands != nil && n >= MinMatchLen {
+			*t.cands = append(*t.cands, match{int64(dist), n})
+		}
 		switch n {
 		case 0:
 			continue
@@ -305,5 +332,6 @@ func (t *hashTable) NextOp(rep [4]uint32) operation {
 	if m.n == 0 {
 		return lit{data[0]}
 	}
-	return m
+	t.op = m
+	return &t.op
 }
@@ -5,12 +5,18 @@
 package lzma
 
 import (
+	"bytes"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
 	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
 )
 
 func TestHashTable(t *testing.T) {
-	ht, err := newHashTable(32, 2)
+	ht, err := newHashTable(32, 2, CyclicPolyMixer)
 	if err != nil {
 		t.Fatalf("newHashTable: error %s", err)
 	}
@@ -45,3 +51,49 @@ func TestHashTable(t *testing.T) {
 		}
 	}
 }
+
+func TestHashTableMixers(t *testing.T) {
+	var tb bytes.Buffer
+	io.CopyN(&tb, randtxt.NewReader(rand.NewSource(42)), 1<<16)
+	txt := tb.Bytes()
+	for _, mixer := range []HashMixer{CyclicPolyMixer, CRCMixer,
+		MultiplicativeMixer} {
+		for wordLen := minHashWordLen; wordLen <= maxHashWordLen; wordLen++ {
+			var buf bytes.Buffer
+			cfg := Writer2Config{DictCap: 1 << 16, Matcher: HashTable4,
+				WordLen: wordLen, HashMixer: mixer}
+			w, err := cfg.NewWriter2(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter2 error %s", err)
+			}
+			if _, err = w.Write(txt); err != nil {
+				t.Fatalf("Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("Close error %s", err)
+			}
+			if buf.Len() >= len(txt)*6/10 {
+				t.Errorf("%s word length %d: compressed %d bytes "+
+					"to %d", mixer, wordLen, len(txt), buf.Len())
+			}
+			r, err := Reader2Config{DictCap: 1 << 16}.NewReader2(&buf)
+			if err != nil {
+				t.Fatalf("NewReader2 error %s", err)
+			}
+			got, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(got, txt) {
+				t.Fatalf("%s word length %d: decompressed data "+
+					"differs", mixer, wordLen)
+			}
+		}
+	}
+	for _, cfg := range []Writer2Config{{WordLen: 1}, {WordLen: 6},
+		{HashMixer: 3}} {
+		if err := cfg.Verify(); err == nil {
+			t.Errorf("Verify accepted %+v", cfg)
+		}
+	}
+}
@@ -63,7 +63,9 @@ const HeaderLen = 13
 // header represents the header of an LZMA file.
 type header struct {
 	properties Properties
-	dictCap    int
+	// the capacity is stored as int64, because it may exceed the int
+	// range of 32-bit platforms
+	dictCap int64
 	// uncompressed size; negative value if no size is given
 	size int64
 }
@@ -73,9 +75,9 @@ func (h *header) marshalBinary() (data []byte, err error) {
 	if err = h.properties.verify(); err != nil {
 		return nil, err
 	}
-	if !(0 <= h.dictCap && int64(h.dictCap) <= MaxDictCap) {
-		return nil, fmt.Errorf("lzma: DictCap %d out of range",
-			h.dictCap)
+	if !(0 <= h.dictCap && h.dictCap <= MaxDictCap) {
+		return nil, fmt.Errorf("lzma: DictCap %d out of range [0, %d]",
+			h.dictCap, uint64(MaxDictCap))
 	}
 
 	data = make([]byte, 13)
@@ -88,7 +90,7 @@ func (h *header) marshalBinary() (data []byte, err error) {
 
 	// uncompressed size
 	var s uint64
-	if h.size > 0 {
+	if h.size >= 0 {
 		s = uint64(h.size)
 	} else {
 		s = noHeaderSize
@@ -111,12 +113,7 @@ func (h *header) unmarshalBinary(data []byte) error {
 	}
 
 	// dictionary capacity
-	h.dictCap = int(uint32LE(data[1:]))
-	if h.dictCap < 0 {
-		return errors.New(
-			"LZMA header: dictionary capacity exceeds maximum " +
-				"integer")
-	}
+	h.dictCap = int64(uint32LE(data[1:]))
 
 	// uncompressed size
 	s := uint64LE(data[5:])
@@ -136,8 +133,8 @@ func (h *header) unmarshalBinary(data []byte) error {
 
 // validDictCap checks whether the dictionary capacity is correct. This
 // is used to weed out wrong file headers.
-func validDictCap(dictcap int) bool {
-	if int64(dictcap) == MaxDictCap {
+func validDictCap(dictcap int64) bool {
+	if dictcap == MaxDictCap {
 		return true
 	}
 	for n := uint(10); n < 32; n++ {
@@ -165,3 +162,33 @@ func ValidHeader(data []byte) bool {
 	}
 	return h.size < 0 || h.size <= 1<<38
 }
+
+// CoderPropsLen provides the length of the LZMA coder properties used
+// by 7z archives and the LZMA SDK. They consist of the properties byte
+// and the dictionary capacity as little-endian 32-bit integer. The
+// classic header starts with the coder properties.
+const CoderPropsLen = 5
+
+// MarshalCoderProps encodes the properties and the dictionary capacity
+// as coder properties.
+func MarshalCoderProps(p Properties, dictCap int64) (data []byte, err error) {
+	h := header{properties: p, dictCap: dictCap}
+	if data, err = h.marshalBinary(); err != nil {
+		return nil, err
+	}
+	return data[:CoderPropsLen], nil
+}
+
+// UnmarshalCoderProps decodes the properties and the dictionary
+// capacity from coder properties. The capacity is returned as int64,
+// because it may exceed the int range of 32-bit platforms.
+func UnmarshalCoderProps(data []byte) (p Properties, dictCap int64, err error) {
+	if len(data) != CoderPropsLen {
+		return p, 0, errors.New(
+			"lzma: coder properties have wrong length")
+	}
+	if p, err = PropertiesForCode(data[0]); err != nil {
+		return p, 0, err
+	}
+	return p, int64(uint32LE(data[1:])), nil
+}
@@ -175,25 +175,50 @@ func (h *chunkHeader) UnmarshalBinary(data []byte) error {
 		return nil
 	}
 
-	h.props, err = PropertiesForCode(data[5])
-	return err
+	if h.props, err = PropertiesForCode(data[5]); err != nil {
+		return err
+	}
+	// LZMA2 restricts the literal context to 4 bits.
+	if h.props.LC+h.props.LP > 4 {
+		return ErrProperties
+	}
+	return nil
 }
 
+// maxChunkHeaderLen is the maximum length of a chunk header.
+const maxChunkHeaderLen = 6
+
 // MarshalBinary encodes the chunk header value. The function checks
 // whether the content of the chunk header is correct.
 func (h *chunkHeader) MarshalBinary() (data []byte, err error) {
+	data = make([]byte, maxChunkHeaderLen)
+	n, err := h.put(data)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+// put encodes the chunk header into data, which must provide at least
+// maxChunkHeaderLen bytes. It returns the length of the header. The
+// function allows the encoding without allocating a new slice.
+func (h *chunkHeader) put(data []byte) (n int, err error) {
 	if h.ctype > cLRND {
-		return nil, errors.New("invalid chunk type")
+		return 0, errors.New("invalid chunk type")
 	}
 	if err = h.props.verify(); err != nil {
-		return nil, err
+		return 0, err
 	}
 
-	data = make([]byte, headerLen(h.ctype))
+	n = headerLen(h.ctype)
+	data = data[:n]
+	for i := range data {
+		data[i] = 0
+	}
 
 	switch h.ctype {
 	case cEOS:
-		return data, nil
+		return n, nil
 	case cUD:
 		data[0] = hUD
 	case cU:
@@ -210,38 +235,44 @@ func (h *chunkHeader) MarshalBinary() (data []byte, err error) {
 
 	putUint16BE(data[1:3], uint16(h.uncompressed))
 	if h.ctype <= cU {
-		return data, nil
+		return n, nil
 	}
 	data[0] |= byte(h.uncompressed>>16) &^ hLRND
 
 	putUint16BE(data[3:5], h.compressed)
 	if h.ctype <= cLR {
-		return data, nil
+		return n, nil
 	}
 
 	data[5] = h.props.Code()
-	return data, nil
+	return n, nil
 }
 
 // readChunkHeader reads the chunk header from the IO reader.
 func readChunkHeader(r io.Reader) (h *chunkHeader, err error) {
-	p := make([]byte, 1, 6)
-	if _, err = io.ReadFull(r, p); err != nil {
-		return
+	h = new(chunkHeader)
+	if err = h.read(r, make([]byte, maxChunkHeaderLen)); err != nil {
+		return nil, err
+	}
+	return h, nil
+}
+
+// read reads the chunk header from r using p as buffer. The buffer must
+// provide maxChunkHeaderLen bytes.
+func (h *chunkHeader) read(r io.Reader, p []byte) error {
+	p = p[:1]
+	if _, err := io.ReadFull(r, p); err != nil {
+		return err
 	}
 	c, err := headerChunkType(p[0])
 	if err != nil {
-		return
+		return err
 	}
 	p = p[:headerLen(c)]
 	if _, err = io.ReadFull(r, p[1:]); err != nil {
-		return
-	}
-	h = new(chunkHeader)
-	if err = h.UnmarshalBinary(p); err != nil {
-		return nil, err
+		return err
 	}
-	return h, nil
+	return h.UnmarshalBinary(p)
 }
 
 // uint16BE converts a big-endian uint16 representation to an uint16
@@ -4,7 +4,10 @@
 
 package lzma
 
-import "testing"
+import (
+	"bytes"
+	"testing"
+)
 
 func TestHeaderMarshalling(t *testing.T) {
 	tests := []header{
@@ -50,3 +53,32 @@ func TestValidHeader(t *testing.T) {
 		t.Errorf("ValidHeader returns true for %s; want false", a)
 	}
 }
+
+func TestCoderProps(t *testing.T) {
+	// properties of the LZMA SDK defaults: lc=3, lp=0, pb=2, 8 MiB
+	want := []byte{0x5d, 0x00, 0x00, 0x80, 0x00}
+	p := Properties{LC: 3, LP: 0, PB: 2}
+	data, err := MarshalCoderProps(p, 8*1024*1024)
+	if err != nil {
+		t.Fatalf("MarshalCoderProps error %s", err)
+	}
+	if !bytes.Equal(data, want) {
+		t.Fatalf("MarshalCoderProps returned % x; want % x", data, want)
+	}
+	q, dictCap, err := UnmarshalCoderProps(data)
+	if err != nil {
+		t.Fatalf("UnmarshalCoderProps error %s", err)
+	}
+	if q != p || dictCap != 8*1024*1024 {
+		t.Fatalf("UnmarshalCoderProps returned %v, %d", q, dictCap)
+	}
+	if _, _, err = UnmarshalCoderProps(data[:4]); err == nil {
+		t.Fatalf("UnmarshalCoderProps accepted short data")
+	}
+	if _, _, err = UnmarshalCoderProps([]byte{225, 0, 0, 0, 0}); err == nil {
+		t.Fatalf("UnmarshalCoderProps accepted invalid properties")
+	}
+	if _, err = MarshalCoderProps(Properties{LC: 9}, 4096); err == nil {
+		t.Fatalf("MarshalCoderProps accepted lc=9")
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "io"
+
+// HeaderInfo provides the information stored in the header of an LZMA
+// file.
+type HeaderInfo struct {
+	Properties Properties
+	// dictionary capacity; it may exceed the int range of 32-bit
+	// platforms
+	DictCap int64
+	// uncompressed size; negative if the size is not given in the
+	// header
+	Size int64
+}
+
+// ReadHeaderInfo reads the header of an LZMA file from r. Only the
+// HeaderLen bytes of the header are read; no data will be
+// decompressed.
+func ReadHeaderInfo(r io.Reader) (info HeaderInfo, err error) {
+	data := make([]byte, HeaderLen)
+	if _, err = io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return info, err
+	}
+	var h header
+	if err = h.unmarshalBinary(data); err != nil {
+		return info, err
+	}
+	return HeaderInfo{
+		Properties: h.properties,
+		DictCap:    h.dictCap,
+		Size:       h.size,
+	}, nil
+}
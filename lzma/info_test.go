@@ -0,0 +1,56 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestReadHeaderInfo(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	tests := []struct {
+		cfg  WriterConfig
+		size int64
+	}{
+		{WriterConfig{DictCap: 1 << 16}, -1},
+		{WriterConfig{DictCap: 1 << 12, SizeInHeader: true,
+			Size:       int64(len(text)),
+			Properties: &Properties{LC: 0, LP: 2, PB: 1}},
+			int64(len(text))},
+	}
+	for _, c := range tests {
+		var buf bytes.Buffer
+		w, err := c.cfg.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = io.WriteString(w, text); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		info, err := ReadHeaderInfo(&buf)
+		if err != nil {
+			t.Fatalf("ReadHeaderInfo error %s", err)
+		}
+		if info.DictCap != int64(c.cfg.DictCap) {
+			t.Errorf("DictCap %d; want %d", info.DictCap,
+				c.cfg.DictCap)
+		}
+		if info.Size != c.size {
+			t.Errorf("Size %d; want %d", info.Size, c.size)
+		}
+		if p := c.cfg.Properties; p != nil && info.Properties != *p {
+			t.Errorf("Properties %v; want %v", info.Properties, *p)
+		}
+	}
+	if _, err := ReadHeaderInfo(bytes.NewReader(make([]byte, 5))); err != io.ErrUnexpectedEOF {
+		t.Fatalf("ReadHeaderInfo on short header returned %v; want %v",
+			err, io.ErrUnexpectedEOF)
+	}
+}
@@ -11,49 +11,41 @@ import "errors"
 // for length encoding and decoding.
 const maxPosBits = 4
 
-// minMatchLen and maxMatchLen give the minimum and maximum values for
-// encoding and decoding length values. minMatchLen is also used as base
-// for the encoded length values.
+// MinMatchLen and MaxMatchLen give the minimum and maximum length of a
+// match supported by LZMA. MinMatchLen is also used as base for the
+// encoded length values.
 const (
-	minMatchLen = 2
-	maxMatchLen = minMatchLen + 16 + 256 - 1
+	MinMatchLen = 2
+	MaxMatchLen = MinMatchLen + 16 + 256 - 1
 )
 
-// lengthCodec support the encoding of the length value.
-type lengthCodec struct {
-	choice [2]prob
-	low    [1 << maxPosBits]treeCodec
-	mid    [1 << maxPosBits]treeCodec
-	high   treeCodec
+// lengthCodecProbs is the number of probability values of a length
+// codec: two choice bits, the low and mid trees with three bits for
+// each position state and the high tree with eight bits.
+const lengthCodecProbs = 2 + 2<<maxPosBits*(1<<3) + 1<<8
+
+// lengthCodec support the encoding of the length value. It provides a
+// view on the lengthCodecProbs probability values of the state
+// starting with the choice bits followed by the low, mid and high
+// trees.
+type lengthCodec []prob
+
+// choice returns the probability for the choice bit i.
+func (lc lengthCodec) choice(i int) *prob { return &lc[i] }
+
+// low returns the tree codec for lengths below 8.
+func (lc lengthCodec) low(posState uint32) treeCodec {
+	return makeTreeCodec(lc[2+posState<<3:], 3)
 }
 
-// deepcopy initializes the lc value as deep copy of the source value.
-func (lc *lengthCodec) deepcopy(src *lengthCodec) {
-	if lc == src {
-		return
-	}
-	lc.choice = src.choice
-	for i := range lc.low {
-		lc.low[i].deepcopy(&src.low[i])
-	}
-	for i := range lc.mid {
-		lc.mid[i].deepcopy(&src.mid[i])
-	}
-	lc.high.deepcopy(&src.high)
+// mid returns the tree codec for lengths from 8 to 15.
+func (lc lengthCodec) mid(posState uint32) treeCodec {
+	return makeTreeCodec(lc[2+(1<<maxPosBits+posState)<<3:], 3)
 }
 
-// init initializes a new length codec.
-func (lc *lengthCodec) init() {
-	for i := range lc.choice {
-		lc.choice[i] = probInit
-	}
-	for i := range lc.low {
-		lc.low[i] = makeTreeCodec(3)
-	}
-	for i := range lc.mid {
-		lc.mid[i] = makeTreeCodec(3)
-	}
-	lc.high = makeTreeCodec(8)
+// high returns the tree codec for lengths starting at 16.
+func (lc lengthCodec) high() treeCodec {
+	return makeTreeCodec(lc[2+2<<maxPosBits<<3:], 8)
 }
 
 // lBits gives the number of bits used for the encoding of the l value
@@ -70,60 +62,47 @@ func lBits(l uint32) int {
 }
 
 // Encode encodes the length offset. The length offset l can be compute by
-// subtracting minMatchLen (2) from the actual length.
+// subtracting MinMatchLen (2) from the actual length.
 //
-//   l = length - minMatchLen
+//   l = length - MinMatchLen
 //
-func (lc *lengthCodec) Encode(e *rangeEncoder, l uint32, posState uint32,
+func (lc lengthCodec) Encode(e *rangeEncoder, l uint32, posState uint32,
 ) (err error) {
-	if l > maxMatchLen-minMatchLen {
+	if l > MaxMatchLen-MinMatchLen {
 		return errors.New("lengthCodec.Encode: l out of range")
 	}
 	if l < 8 {
-		if err = lc.choice[0].Encode(e, 0); err != nil {
+		if err = lc.choice(0).Encode(e, 0); err != nil {
 			return
 		}
-		return lc.low[posState].Encode(e, l)
+		return lc.low(posState).Encode(e, l)
 	}
-	if err = lc.choice[0].Encode(e, 1); err != nil {
+	if err = lc.choice(0).Encode(e, 1); err != nil {
 		return
 	}
 	if l < 16 {
-		if err = lc.choice[1].Encode(e, 0); err != nil {
+		if err = lc.choice(1).Encode(e, 0); err != nil {
 			return
 		}
-		return lc.mid[posState].Encode(e, l-8)
+		return lc.mid(posState).Encode(e, l-8)
 	}
-	if err = lc.choice[1].Encode(e, 1); err != nil {
+	if err = lc.choice(1).Encode(e, 1); err != nil {
 		return
 	}
-	if err = lc.high.Encode(e, l-16); err != nil {
+	if err = lc.high().Encode(e, l-16); err != nil {
 		return
 	}
 	return nil
 }
 
-// Decode reads the length offset. Add minMatchLen to compute the actual length
+// Decode reads the length offset. Add MinMatchLen to compute the actual length
 // to the length offset l.
-func (lc *lengthCodec) Decode(d *rangeDecoder, posState uint32,
-) (l uint32, err error) {
-	var b uint32
-	if b, err = lc.choice[0].Decode(d); err != nil {
-		return
-	}
-	if b == 0 {
-		l, err = lc.low[posState].Decode(d)
-		return
-	}
-	if b, err = lc.choice[1].Decode(d); err != nil {
-		return
+func (lc lengthCodec) Decode(d *rangeDecoder, posState uint32) (l uint32) {
+	if d.DecodeBit(lc.choice(0)) == 0 {
+		return lc.low(posState).Decode(d)
 	}
-	if b == 0 {
-		l, err = lc.mid[posState].Decode(d)
-		l += 8
-		return
+	if d.DecodeBit(lc.choice(1)) == 0 {
+		return lc.mid(posState).Decode(d) + 8
 	}
-	l, err = lc.high.Decode(d)
-	l += 16
-	return
+	return lc.high().Decode(d) + 16
 }
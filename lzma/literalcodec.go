@@ -11,16 +11,23 @@ type literalCodec struct {
 	probs []prob
 }
 
-// deepcopy initializes literal codec c as a deep copy of the source.
-func (c *literalCodec) deepcopy(src *literalCodec) {
-	if c == src {
-		return
+// literalProbs returns the number of probability values used by the
+// literal codec for the parameters lc and lp.
+func literalProbs(lc, lp int) int {
+	return 0x300 << uint(lc+lp)
+}
+
+// reuseProbs returns a slice of n probabilities. The array of p is
+// reused if it is large enough. The values of the slice are undefined.
+func reuseProbs(p []prob, n int) []prob {
+	if cap(p) < n {
+		return make([]prob, n)
 	}
-	c.probs = make([]prob, len(src.probs))
-	copy(c.probs, src.probs)
+	return p[:n]
 }
 
-// init initializes the literal codec.
+// init initializes the literal codec. The probability array is reused
+// if possible.
 func (c *literalCodec) init(lc, lp int) {
 	switch {
 	case !(minLC <= lc && lc <= maxLC):
@@ -28,15 +35,13 @@ func (c *literalCodec) init(lc, lp int) {
 	case !(minLP <= lp && lp <= maxLP):
 		panic("lp out of range")
 	}
-	c.probs = make([]prob, 0x300<<uint(lc+lp))
-	for i := range c.probs {
-		c.probs[i] = probInit
-	}
+	c.probs = reuseProbs(c.probs, literalProbs(lc, lp))
+	initProbSlice(c.probs)
 }
 
 // Encode encodes the byte s using a range encoder as well as the current LZMA
 // encoder state, a match byte and the literal state.
-func (c *literalCodec) Encode(e *rangeEncoder, s byte,
+func (c literalCodec) Encode(e *rangeEncoder, s byte,
 	state uint32, match byte, litState uint32,
 ) (err error) {
 	k := litState * 0x300
@@ -76,21 +81,23 @@ func (c *literalCodec) Encode(e *rangeEncoder, s byte,
 
 // Decode decodes a literal byte using the range decoder as well as the LZMA
 // state, a match byte, and the literal state.
-func (c *literalCodec) Decode(d *rangeDecoder,
+func (c literalCodec) Decode(d *rangeDecoder,
 	state uint32, match byte, litState uint32,
-) (s byte, err error) {
+) (s byte) {
 	k := litState * 0x300
 	probs := c.probs[k : k+0x300]
 	symbol := uint32(1)
+	nrange, code := d.nrange, d.code
+	var bit uint32
 	if state >= 7 {
 		m := uint32(match)
 		for {
 			matchBit := (m >> 7) & 1
 			m <<= 1
 			i := ((1 + matchBit) << 8) | symbol
-			bit, err := d.DecodeBit(&probs[i])
-			if err != nil {
-				return 0, err
+			bit, nrange, code = decodeBit(nrange, code, &probs[i])
+			if nrange < 1<<24 {
+				nrange, code = d.normalize(nrange, code)
 			}
 			symbol = (symbol << 1) | bit
 			if matchBit != bit {
@@ -102,14 +109,98 @@ func (c *literalCodec) Decode(d *rangeDecoder,
 		}
 	}
 	for symbol < 0x100 {
-		bit, err := d.DecodeBit(&probs[symbol])
-		if err != nil {
-			return 0, err
+		bit, nrange, code = decodeBit(nrange, code, &probs[symbol])
+		if nrange < 1<<24 {
+			nrange, code = d.normalize(nrange, code)
+		}
+		symbol = (symbol << 1) | bit
+	}
+	d.nrange, d.code = nrange, code
+	return byte(symbol - 0x100)
+}
+
+// The functions encodeLC3LP0 and decodeLC3LP0 are specializations of
+// Encode and Decode for the properties lc=3 and lp=0, which are used by
+// nearly all LZMA streams. The literal state is computed directly from
+// the previous byte and the coding of the bits is inlined. Both
+// functions must produce the same results as the generic versions.
+
+// encodeLC3LP0 encodes the byte s. The argument prev is the byte
+// preceding the literal.
+func (c literalCodec) encodeLC3LP0(e *rangeEncoder, s byte,
+	state uint32, match byte, prev byte,
+) (err error) {
+	k := uint32(prev>>5) * 0x300
+	probs := c.probs[k : k+0x300]
+	symbol := uint32(1)
+	r := uint32(s)
+	m := uint32(match)
+	matched := state >= 7
+	nrange := e.nrange
+	for symbol < 0x100 {
+		bit := (r >> 7) & 1
+		r <<= 1
+		i := symbol
+		if matched {
+			matchBit := (m >> 7) & 1
+			m <<= 1
+			i |= (1 + matchBit) << 8
+			matched = matchBit == bit
+		}
+		p := &probs[i]
+		bound := (nrange >> probbits) * uint32(*p)
+		if bit == 0 {
+			nrange = bound
+			*p += ((1 << probbits) - *p) >> movebits
+		} else {
+			e.low += uint64(bound)
+			nrange -= bound
+			*p -= *p >> movebits
+		}
+		if nrange < 1<<24 {
+			nrange <<= 8
+			if err = e.shiftLow(); err != nil {
+				e.nrange = nrange
+				return err
+			}
+		}
+		symbol = (symbol << 1) | bit
+	}
+	e.nrange = nrange
+	return nil
+}
+
+// decodeLC3LP0 decodes a literal byte. The argument prev is the byte
+// preceding the literal.
+func (c literalCodec) decodeLC3LP0(d *rangeDecoder,
+	state uint32, match byte, prev byte,
+) (s byte) {
+	k := uint32(prev>>5) * 0x300
+	probs := c.probs[k : k+0x300]
+	symbol := uint32(1)
+	matched := state >= 7
+	m := uint32(match)
+	nrange, code := d.nrange, d.code
+	for symbol < 0x100 {
+		i := symbol
+		var matchBit uint32
+		if matched {
+			matchBit = (m >> 7) & 1
+			m <<= 1
+			i |= (1 + matchBit) << 8
+		}
+		var bit uint32
+		bit, nrange, code = decodeBit(nrange, code, &probs[i])
+		if nrange < 1<<24 {
+			nrange, code = d.normalize(nrange, code)
+		}
+		if matched {
+			matched = matchBit == bit
 		}
 		symbol = (symbol << 1) | bit
 	}
-	s = byte(symbol - 0x100)
-	return s, nil
+	d.nrange, d.code = nrange, code
+	return byte(symbol - 0x100)
 }
 
 // minLC and maxLC define the range for LC values.
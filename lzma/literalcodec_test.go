@@ -0,0 +1,122 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+)
+
+// litInput provides literals with their coding context.
+type litInput struct {
+	s, match, prev byte
+	state          uint32
+}
+
+func randomLiterals(n int) []litInput {
+	r := rand.New(rand.NewSource(7))
+	lits := make([]litInput, n)
+	for i := range lits {
+		l := &lits[i]
+		l.s = byte(r.Intn(256))
+		l.prev = byte(r.Intn(256))
+		l.state = uint32(r.Intn(maxState + 1))
+		// matches often share a prefix with the literal
+		l.match = l.s ^ byte(1<<uint(r.Intn(8)))
+	}
+	return lits
+}
+
+// encodeLiterals encodes the literals either with the generic or the
+// specialized function.
+func encodeLiterals(tb testing.TB, lits []litInput, fast bool) []byte {
+	var c literalCodec
+	c.init(3, 0)
+	buf := new(bytes.Buffer)
+	e, err := newRangeEncoder(buf)
+	if err != nil {
+		tb.Fatalf("newRangeEncoder error %s", err)
+	}
+	for _, l := range lits {
+		if fast {
+			err = c.encodeLC3LP0(e, l.s, l.state, l.match, l.prev)
+		} else {
+			litState := uint32(l.prev) >> 5
+			err = c.Encode(e, l.s, l.state, l.match, litState)
+		}
+		if err != nil {
+			tb.Fatalf("Encode error %s", err)
+		}
+	}
+	if err = e.Close(); err != nil {
+		tb.Fatalf("e.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+// decodeLiterals decodes the literals and checks them.
+func decodeLiterals(tb testing.TB, lits []litInput, data []byte,
+	fast bool) {
+
+	var c literalCodec
+	c.init(3, 0)
+	d, err := newRangeDecoder(bytes.NewReader(data))
+	if err != nil {
+		tb.Fatalf("newRangeDecoder error %s", err)
+	}
+	for i, l := range lits {
+		var s byte
+		if fast {
+			s = c.decodeLC3LP0(d, l.state, l.match, l.prev)
+		} else {
+			litState := uint32(l.prev) >> 5
+			s = c.Decode(d, l.state, l.match, litState)
+		}
+		if d.err != nil {
+			tb.Fatalf("Decode error %s", d.err)
+		}
+		if s != l.s {
+			tb.Fatalf("literal %d: got %#02x; want %#02x", i, s,
+				l.s)
+		}
+	}
+}
+
+func TestLiteralCodecLC3LP0(t *testing.T) {
+	lits := randomLiterals(10000)
+	generic := encodeLiterals(t, lits, false)
+	fast := encodeLiterals(t, lits, true)
+	if !bytes.Equal(generic, fast) {
+		t.Fatalf("specialized encoder output differs")
+	}
+	decodeLiterals(t, lits, generic, false)
+	decodeLiterals(t, lits, generic, true)
+}
+
+func benchmarkLiteralDecode(b *testing.B, fast bool) {
+	lits := randomLiterals(10000)
+	data := encodeLiterals(b, lits, false)
+	b.SetBytes(int64(len(lits)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decodeLiterals(b, lits, data, fast)
+	}
+}
+
+func BenchmarkLiteralDecode(b *testing.B)       { benchmarkLiteralDecode(b, false) }
+func BenchmarkLiteralDecodeLC3LP0(b *testing.B) { benchmarkLiteralDecode(b, true) }
+
+func benchmarkLiteralEncode(b *testing.B, fast bool) {
+	lits := randomLiterals(10000)
+	b.SetBytes(int64(len(lits)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encodeLiterals(b, lits, fast)
+	}
+}
+
+func BenchmarkLiteralEncode(b *testing.B)       { benchmarkLiteralEncode(b, false) }
+func BenchmarkLiteralEncodeLC3LP0(b *testing.B) { benchmarkLiteralEncode(b, true) }
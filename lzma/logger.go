@@ -0,0 +1,16 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+// Logger is the interface for the leveled logging of readers and
+// writers. The configurations accept a Logger; the nil value disables
+// logging. The arguments for the log messages are only computed if a
+// logger has been set, so no formatting costs arise in the default
+// case.
+type Logger interface {
+	Debugf(format string, v ...interface{})
+	Infof(format string, v ...interface{})
+	Warnf(format string, v ...interface{})
+}
@@ -0,0 +1,197 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"fmt"
+
+	"github.com/ulikunitz/xz/internal/hash"
+)
+
+// Limits for the block length of the long-range matcher.
+const (
+	minLongRangeLen = 16
+	maxLongRangeLen = 1 << 16
+)
+
+// verifyLongRangeLen checks the block length of the long-range matcher.
+// The value zero disables the long-range matcher.
+func verifyLongRangeLen(n int) error {
+	if n != 0 && !(minLongRangeLen <= n && n <= maxLongRangeLen) {
+		return fmt.Errorf(
+			"lzma: long-range length %d out of range [%d, %d]",
+			n, minLongRangeLen, maxLongRangeLen)
+	}
+	return nil
+}
+
+// maxNearDictCap limits the dictionary capacity of the matcher wrapped
+// by the long-range matcher. Matches at larger distances are only found
+// by the long-range matcher.
+const maxNearDictCap = 1 << 26
+
+// nearDictCap returns the dictionary capacity for the matcher that is
+// wrapped by the long-range matcher if longRangeLen is positive.
+func nearDictCap(dictCap, longRangeLen int) int {
+	if longRangeLen > 0 && dictCap > maxNearDictCap {
+		return maxNearDictCap
+	}
+	return dictCap
+}
+
+// longRangeExponent returns the binary logarithm of the size of the
+// block table of the long-range matcher. The table has at least one
+// slot for every block of the dictionary.
+func longRangeExponent(dictCap, longRangeLen int) uint {
+	e := uint(minTableExponent)
+	for 1<<e < dictCap/longRangeLen {
+		e++
+	}
+	return e
+}
+
+// longRangeMemory returns the number of bytes allocated for the block
+// table of the long-range matcher.
+func longRangeMemory(dictCap, longRangeLen int) int64 {
+	if longRangeLen <= 0 {
+		return 0
+	}
+	return 8 << longRangeExponent(dictCap, longRangeLen)
+}
+
+// longRangeMatcher adds a coarse-grained index to a matcher. The
+// hashes of the blocks of blockLen bytes at the positions that are
+// multiples of blockLen are stored in a table covering the whole
+// dictionary. Before an operation of the wrapped matcher is returned,
+// the block at the dictionary head is looked up in the table, which
+// finds long repeated regions at distances beyond the reach of the
+// wrapped matcher, for instance in virtual machine images or database
+// dumps.
+type longRangeMatcher struct {
+	matcher
+	dict     *encoderDict
+	blockLen int
+	// block table; the positions are offset by off
+	t    []int64
+	off  int64
+	mask uint64
+	// rolling hash of the last blockLen bytes written
+	r *hash.RabinKarp
+	// number of bytes written
+	pos int64
+	// lookahead buffer
+	data [MaxMatchLen]byte
+	op   match
+	// receives the match candidates if not nil
+	cands *[]match
+}
+
+// newLongRange wraps the matcher m into a long-range matcher for the
+// given dictionary capacity. If longRangeLen is zero, m is returned.
+func newLongRange(m matcher, dictCap, longRangeLen int) (lm matcher,
+	err error) {
+
+	if err = verifyLongRangeLen(longRangeLen); err != nil {
+		return nil, err
+	}
+	if longRangeLen == 0 {
+		return m, nil
+	}
+	e := longRangeExponent(dictCap, longRangeLen)
+	lm = &longRangeMatcher{
+		matcher:  m,
+		blockLen: longRangeLen,
+		t:        make([]int64, 1<<e),
+		mask:     1<<e - 1,
+		r:        hash.NewRabinKarp(longRangeLen),
+	}
+	return lm, nil
+}
+
+// SetDict sets the dictionary for both matchers.
+func (m *longRangeMatcher) SetDict(d *encoderDict) {
+	m.dict = d
+	m.matcher.SetDict(d)
+}
+
+// Reset puts the matcher in its initial state. Like for the hash table
+// the block table is not cleared, but the offset is moved behind all
+// stored positions.
+func (m *longRangeMatcher) Reset() {
+	m.matcher.Reset()
+	m.off += m.pos + 1
+	m.pos = 0
+	m.r = hash.NewRabinKarp(m.blockLen)
+}
+
+// Write adds the bytes discarded from the dictionary buffer to both
+// matchers. The method never returns an error.
+func (m *longRangeMatcher) Write(p []byte) (n int, err error) {
+	m.matcher.Write(p)
+	bl := int64(m.blockLen)
+	for _, b := range p {
+		h := m.r.RollByte(b)
+		m.pos++
+		if m.pos%bl == 0 {
+			m.t[h&m.mask] = m.pos - bl + 1 + m.off
+		}
+	}
+	return len(p), nil
+}
+
+// blockHash computes the hash of p, which has the value the rolling hash
+// has after the bytes of p have been written.
+func blockHash(p []byte) uint64 {
+	var h uint64
+	for _, b := range p {
+		h = (h + uint64(b)) * hash.A
+	}
+	return h
+}
+
+// NextOp returns the operation of the wrapped matcher unless a longer
+// match is found for the block at the dictionary head or at the
+// distance of the last match.
+func (m *longRangeMatcher) NextOp(rep [4]uint32) operation {
+	op := m.matcher.NextOp(rep)
+	d := m.dict
+	data := m.data[:]
+	n, _ := d.buf.Peek(data)
+	data = data[:n]
+	best := op.Len()
+	if best >= n {
+		return op
+	}
+	dictLen := int64(d.DictLen())
+	var lm match
+	try := func(dist int64) {
+		if !(0 < dist && dist <= dictLen) {
+			return
+		}
+		k := d.buf.matchLen(int(dist), data)
+		if k < MinMatchLen {
+			return
+		}
+		if m.cands != nil {
+			*m.cands = append(*m.cands, match{dist, k})
+		}
+		if k > best {
+			best = k
+			lm = match{dist, k}
+		}
+	}
+	try(int64(rep[0]) + minDistance)
+	if n >= m.blockLen {
+		h := blockHash(data[:m.blockLen])
+		if pos := m.t[h&m.mask] - 1 - m.off; pos >= 0 {
+			try(d.head - pos)
+		}
+	}
+	if lm.n == 0 {
+		return op
+	}
+	m.op = lm
+	return &m.op
+}
@@ -0,0 +1,128 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// farMatchBytes runs the matcher over data in a dictionary with the
+// given capacity and returns the number of bytes covered by matches
+// with distances beyond near.
+func farMatchBytes(t *testing.T, m matcher, dictCap int, data []byte,
+	near int64) int {
+
+	d, err := newEncoderDict(dictCap, 4096, m, nil)
+	if err != nil {
+		t.Fatalf("newEncoderDict error %s", err)
+	}
+	var rep [4]uint32
+	far := 0
+	for len(data) > 0 || d.Buffered() > 0 {
+		k, _ := d.Write(data)
+		data = data[k:]
+		limit := 0
+		if len(data) > 0 {
+			limit = MaxMatchLen
+		}
+		for d.Buffered() > limit {
+			op := d.m.NextOp(rep)
+			if x, ok := op.(*match); ok {
+				if x.distance > near {
+					far += x.n
+				}
+				rep[0] = uint32(x.distance - minDistance)
+			}
+			d.Discard(op.Len())
+		}
+	}
+	return far
+}
+
+func TestLongRangeMatcher(t *testing.T) {
+	const (
+		dictCap = 1 << 20
+		nearCap = 1 << 12
+	)
+	rnd := rand.New(rand.NewSource(42))
+	x := make([]byte, 1<<16)
+	rnd.Read(x)
+	y := make([]byte, 1<<18)
+	rnd.Read(y)
+	data := bytes.Join([][]byte{x, y, x}, nil)
+
+	m, err := HashTable4.new(nearCap, 0, 0, false, 0, CyclicPolyMixer)
+	if err != nil {
+		t.Fatalf("HashTable4.new error %s", err)
+	}
+	if n := farMatchBytes(t, m, dictCap, data, nearCap); n != 0 {
+		t.Fatalf("hash table found %d bytes at far distances", n)
+	}
+	for _, a := range []MatchAlgorithm{HashTable4, BinaryTree4} {
+		m, err = a.new(nearCap, 0, 0, false, 0, CyclicPolyMixer)
+		if err != nil {
+			t.Fatalf("%s.new error %s", a, err)
+		}
+		if m, err = newLongRange(m, dictCap, 64); err != nil {
+			t.Fatalf("newLongRange error %s", err)
+		}
+		n := farMatchBytes(t, m, dictCap, data, nearCap)
+		if n < len(x)*9/10 {
+			t.Fatalf("%s: long-range matcher found %d bytes "+
+				"at far distances; want at least %d", a, n,
+				len(x)*9/10)
+		}
+	}
+}
+
+// compress2 compresses data with the configuration c.
+func compress2(t *testing.T, c Writer2Config, data []byte) []byte {
+	var buf bytes.Buffer
+	w, err := c.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestWriter2LongRange(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<16)
+	x := txt.Bytes()
+	data := bytes.Join([][]byte{x, make([]byte, 1<<10), x}, nil)
+	cfg := Writer2Config{DictCap: 1 << 20, LongRangeLen: 64}
+	z := compress2(t, cfg, data)
+	if n := len(compress2(t, cfg, x)); len(z) > n+n/10 {
+		t.Fatalf("compressed size %d; repetition not found", len(z))
+	}
+	buf := bytes.NewReader(z)
+	r, err := Reader2Config{DictCap: cfg.DictCap}.NewReader2(buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decompressed data differs")
+	}
+	if _, err = (Writer2Config{LongRangeLen: 8}).NewWriter2(
+		ioutil.Discard); err == nil {
+		t.Fatalf("NewWriter2 accepted LongRangeLen 8")
+	}
+}
@@ -4,22 +4,40 @@
 
 package lzma
 
-import "errors"
+import (
+	"errors"
+	"fmt"
+)
 
 // MatchAlgorithm identifies an algorithm to find matches in the
 // dictionary.
 type MatchAlgorithm byte
 
-// Supported matcher algorithms.
+// Supported matcher algorithms. The binary tree and hash chain
+// matchers correspond to the match finders bt2, bt3, bt4, hc3 and hc4
+// of liblzma; the number gives the number of bytes hashed. The hash
+// chains are faster than binary trees but find fewer matches.
 const (
 	HashTable4 MatchAlgorithm = iota
-	BinaryTree
+	BinaryTree4
+	BinaryTree2
+	BinaryTree3
+	HashChain3
+	HashChain4
 )
 
+// BinaryTree is the name used for BinaryTree4 by earlier versions of
+// the package.
+const BinaryTree = BinaryTree4
+
 // maStrings are used by the String method.
 var maStrings = map[MatchAlgorithm]string{
-	HashTable4: "HashTable4",
-	BinaryTree: "BinaryTree",
+	HashTable4:  "HashTable4",
+	BinaryTree2: "BinaryTree2",
+	BinaryTree3: "BinaryTree3",
+	BinaryTree4: "BinaryTree4",
+	HashChain3:  "HashChain3",
+	HashChain4:  "HashChain4",
 }
 
 // String returns a string representation of the Matcher.
@@ -41,12 +59,59 @@ func (a MatchAlgorithm) verify() error {
 	return nil
 }
 
-func (a MatchAlgorithm) new(dictCap int) (m matcher, err error) {
+// Limits for the nice length of the binary tree and hash chain
+// matchers.
+const (
+	minNiceLen = 4
+	maxNiceLen = MaxMatchLen
+)
+
+// verifyNiceLen checks the nice length of a configuration. The value
+// zero selects the default of the match algorithm.
+func verifyNiceLen(niceLen int) error {
+	if niceLen != 0 && !(minNiceLen <= niceLen && niceLen <= maxNiceLen) {
+		return fmt.Errorf("lzma: nice length %d out of range [%d, %d]",
+			niceLen, minNiceLen, maxNiceLen)
+	}
+	return nil
+}
+
+// new creates the matcher for the given dictionary capacity. The depth
+// limits the search effort of the binary trees and hash chains and the
+// nice length stops their search for longer matches; zero values
+// select the defaults of the algorithm. The lazy flag enables lazy
+// matching. The hash table ignores depth, nice length and the lazy
+// flag, but hashes words of length wordLen with the given hash mixer;
+// a zero word length selects 4. The other algorithms ignore word
+// length and mixer.
+func (a MatchAlgorithm) new(dictCap, depth, niceLen int, lazy bool,
+	wordLen int, mixer HashMixer) (m matcher, err error) {
+	var t *matchFinder
 	switch a {
 	case HashTable4:
-		return newHashTable(dictCap, 4)
-	case BinaryTree:
-		return newBinTree(dictCap)
+		if wordLen == 0 {
+			wordLen = 4
+		}
+		return newHashTable(dictCap, wordLen, mixer)
+	case BinaryTree2:
+		t, err = newBinTree(dictCap, 2, depth)
+	case BinaryTree3:
+		t, err = newBinTree(dictCap, 3, depth)
+	case BinaryTree4:
+		t, err = newBinTree(dictCap, 4, depth)
+	case HashChain3:
+		t, err = newHashChain(dictCap, 3, depth)
+	case HashChain4:
+		t, err = newHashChain(dictCap, 4, depth)
+	default:
+		return nil, errUnsupportedMatchAlgorithm
+	}
+	if err != nil {
+		return nil, err
+	}
+	if niceLen > 0 {
+		t.niceLen = niceLen
 	}
-	return nil, errUnsupportedMatchAlgorithm
+	t.lazy = lazy
+	return t, nil
 }
@@ -0,0 +1,464 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"errors"
+	"hash/crc32"
+)
+
+/* The match finders follow the bt2, bt3, bt4, hc3 and hc4 match finders
+ * of liblzma. A hash table stores for the hash of a word of wordLen
+ * bytes the last position, where the word could be found. The son array
+ * links each position to older positions with the same hash, either as
+ * binary tree or as simple hash chain.
+ *
+ * The variants hashing three or four bytes use additional hash tables
+ * for two- and three-byte sequences to find short matches at small
+ * distances.
+ */
+
+// Sizes of the additional hash tables for the two- and three-byte
+// sequences.
+const (
+	mfHash2Size = 1 << 10
+	mfHash3Size = 1 << 16
+)
+
+// maxMatchFinderCap limits the dictionary capacity supported by the
+// match finders. The position counter must be able to represent twice
+// the capacity.
+const maxMatchFinderCap = 1<<31 - 1
+
+// matchFinder provides the functionality shared by the binary tree and
+// the hash chain match finders.
+//
+// The positions are stored as 32-bit values, which are offset by the
+// cyclic size. The value zero marks an empty entry, since its distance
+// from any position is at least the cyclic size.
+type matchFinder struct {
+	dict *encoderDict
+	// number of bytes hashed to find the first candidate
+	wordLen int
+	// hash tables for two- and three-byte sequences; only used if
+	// the word length is larger
+	hash2 []uint32
+	hash3 []uint32
+	// main hash table
+	hash     []uint32
+	hashMask uint32
+	// son links the positions of the dictionary; the binary tree
+	// requires two entries per position
+	son        []uint32
+	cyclicPos  uint32
+	cyclicSize uint32
+	// tree selects between binary tree and hash chain
+	tree bool
+	// absolute position of the next byte to be added
+	pos int64
+	// position counter for the table entries
+	cpos     uint32
+	niceLen  int
+	depth    int
+	matches  []match
+	matchPos int64
+	// lazy defers a match if the next position provides a better one
+	lazy bool
+	// match returned by NextOp
+	op match
+	// receives the match candidates if not nil
+	cands *[]match
+}
+
+// mfHashMask computes the mask for the main hash table the way liblzma
+// does.
+func mfHashMask(capacity int, wordLen int) uint32 {
+	if wordLen == 2 {
+		return 1<<16 - 1
+	}
+	hs := uint32(capacity - 1)
+	hs |= hs >> 1
+	hs |= hs >> 2
+	hs |= hs >> 4
+	hs |= hs >> 8
+	hs >>= 1
+	hs |= 1<<16 - 1
+	if hs > 1<<24 {
+		if wordLen == 3 {
+			hs = 1<<24 - 1
+		} else {
+			hs >>= 1
+		}
+	}
+	return hs
+}
+
+// newMatchFinder creates the tables of a match finder. The argument
+// tree selects the binary tree. The depth limits the number of
+// positions visited per searched position.
+func newMatchFinder(capacity, wordLen int, tree bool, niceLen, depth int,
+) (t *matchFinder, err error) {
+	if !(1 <= capacity && capacity <= maxMatchFinderCap) {
+		return nil, errors.New("lzma: capacity out of range " +
+			"for match finder")
+	}
+	if depth < 1 {
+		return nil, errors.New("lzma: search depth must be positive")
+	}
+	t = &matchFinder{
+		wordLen:    wordLen,
+		hashMask:   mfHashMask(capacity, wordLen),
+		cyclicSize: uint32(capacity) + 1,
+		tree:       tree,
+		niceLen:    niceLen,
+		depth:      depth,
+		matches:    make([]match, 0, niceLen+2),
+		matchPos:   -1,
+	}
+	t.hash = make([]uint32, t.hashMask+1)
+	if wordLen > 2 {
+		t.hash2 = make([]uint32, mfHash2Size)
+	}
+	if wordLen > 3 {
+		t.hash3 = make([]uint32, mfHash3Size)
+	}
+	n := int(t.cyclicSize)
+	if tree {
+		n *= 2
+	}
+	t.son = make([]uint32, n)
+	t.cpos = t.cyclicSize
+	return t, nil
+}
+
+// SetDict sets the dictionary for the matcher.
+func (t *matchFinder) SetDict(d *encoderDict) { t.dict = d }
+
+// zeroUint32 sets all values of a to zero.
+func zeroUint32(a []uint32) {
+	for i := range a {
+		a[i] = 0
+	}
+}
+
+// Reset puts the match finder into its initial state. The tables are
+// neither reallocated nor cleared. Advancing the position counter by
+// the cyclic size makes all stored positions too old to be used. Only
+// if the counter would overflow, the tables are cleared.
+func (t *matchFinder) Reset() {
+	if uint64(t.cpos)+uint64(t.cyclicSize) < 1<<32-1 {
+		t.cpos += t.cyclicSize
+	} else {
+		zeroUint32(t.hash2)
+		zeroUint32(t.hash3)
+		zeroUint32(t.hash)
+		zeroUint32(t.son)
+		t.cyclicPos = 0
+		t.cpos = t.cyclicSize
+	}
+	t.pos = 0
+	t.matches = t.matches[:0]
+	t.matchPos = -1
+}
+
+// index returns the index of the byte at the given position into the
+// dictionary buffer. The position may be ahead of the dictionary head,
+// if lazy matching searched the next position.
+func (t *matchFinder) index(pos int64) int {
+	i := t.dict.buf.rear - int(t.dict.head-pos)
+	if i < 0 {
+		i += len(t.dict.buf.data)
+	} else if i >= len(t.dict.buf.data) {
+		i -= len(t.dict.buf.data)
+	}
+	return i
+}
+
+// back returns the index of the byte that is dist bytes in front of
+// the byte at index i.
+func (t *matchFinder) back(i int, dist int) int {
+	i -= dist
+	if i < 0 {
+		i += len(t.dict.buf.data)
+	}
+	return i
+}
+
+// at returns the byte at offset k from index i.
+func (t *matchFinder) at(i, k int) byte {
+	i += k
+	if i >= len(t.dict.buf.data) {
+		i -= len(t.dict.buf.data)
+	}
+	return t.dict.buf.data[i]
+}
+
+// cmpLen returns the length of the common prefix of the byte sequences
+// starting at the indexes i and j. The first k bytes are known to be
+// equal and the length is limited by n. The sequences are compared in
+// segments that don't wrap around the end of the buffer, so prefixLen
+// can compare them word by word.
+func (t *matchFinder) cmpLen(i, j, k, n int) int {
+	data := t.dict.buf.data
+	i += k
+	if i >= len(data) {
+		i -= len(data)
+	}
+	j += k
+	if j >= len(data) {
+		j -= len(data)
+	}
+	for k < n {
+		m := n - k
+		if r := len(data) - i; r < m {
+			m = r
+		}
+		if r := len(data) - j; r < m {
+			m = r
+		}
+		l := prefixLen(data[i:i+m], data[j:j+m])
+		k += l
+		if l < m {
+			return k
+		}
+		if i += m; i == len(data) {
+			i = 0
+		}
+		if j += m; j == len(data) {
+			j = 0
+		}
+	}
+	return k
+}
+
+// hashes computes the hash values for the word starting at index i.
+// The hash functions are the same as liblzma uses.
+func (t *matchFinder) hashes(i int) (h2, h3, h uint32) {
+	c0, c1 := t.at(i, 0), t.at(i, 1)
+	if t.wordLen == 2 {
+		return 0, 0, uint32(c0) | uint32(c1)<<8
+	}
+	tmp := crc32.IEEETable[c0] ^ uint32(c1)
+	h2 = tmp & (mfHash2Size - 1)
+	tmp ^= uint32(t.at(i, 2)) << 8
+	if t.wordLen == 3 {
+		return h2, 0, tmp & t.hashMask
+	}
+	h3 = tmp & (mfHash3Size - 1)
+	h = (tmp ^ crc32.IEEETable[t.at(i, 3)]<<5) & t.hashMask
+	return h2, h3, h
+}
+
+// normalize reduces all stored positions before the position counter
+// overflows. Entries that are too old to be used are cleared.
+func (t *matchFinder) normalize() {
+	sub := t.cpos - t.cyclicSize
+	norm := func(a []uint32) {
+		for i, v := range a {
+			if v <= sub {
+				a[i] = 0
+			} else {
+				a[i] = v - sub
+			}
+		}
+	}
+	norm(t.hash2)
+	norm(t.hash3)
+	norm(t.hash)
+	norm(t.son)
+	t.cpos -= sub
+}
+
+// movePos moves the position for the next byte forward.
+func (t *matchFinder) movePos() {
+	t.pos++
+	if t.cyclicPos++; t.cyclicPos == t.cyclicSize {
+		t.cyclicPos = 0
+	}
+	if t.cpos++; t.cpos == 1<<32-1 {
+		t.normalize()
+	}
+}
+
+// insert adds the next position to the match finder. If find is set
+// the matches found are stored in the matches field; the distances of
+// the matches increase with their lengths.
+func (t *matchFinder) insert(find bool) {
+	if find {
+		t.matches = t.matches[:0]
+		t.matchPos = t.pos
+	}
+	i := t.index(t.pos)
+	lenLimit := t.dict.Buffered() + int(t.dict.head-t.pos)
+	if lenLimit > t.niceLen {
+		lenLimit = t.niceLen
+	}
+	if lenLimit < t.wordLen {
+		t.movePos()
+		return
+	}
+	h2, h3, h := t.hashes(i)
+	bestLen := 1
+	if t.wordLen > 2 {
+		delta2 := t.cpos - t.hash2[h2]
+		t.hash2[h2] = t.cpos
+		delta3 := delta2
+		if t.wordLen > 3 {
+			delta3 = t.cpos - t.hash3[h3]
+			t.hash3[h3] = t.cpos
+		}
+		if find {
+			if delta2 < t.cyclicSize {
+				n := t.cmpLen(i, t.back(i, int(delta2)), 0,
+					lenLimit)
+				if n >= 2 {
+					bestLen = n
+					t.matches = append(t.matches,
+						match{int64(delta2), n})
+				}
+			}
+			if delta3 != delta2 && delta3 < t.cyclicSize {
+				n := t.cmpLen(i, t.back(i, int(delta3)), 0,
+					lenLimit)
+				if n > bestLen {
+					bestLen = n
+					t.matches = append(t.matches,
+						match{int64(delta3), n})
+				}
+			}
+			if bestLen == lenLimit {
+				find = false
+			}
+		}
+	}
+	curMatch := t.hash[h]
+	t.hash[h] = t.cpos
+	if bestLen < t.wordLen-1 {
+		bestLen = t.wordLen - 1
+	}
+	if t.tree {
+		t.walk(i, lenLimit, curMatch, bestLen, find)
+	} else {
+		t.chain(i, lenLimit, curMatch, bestLen, find)
+	}
+	t.movePos()
+}
+
+// Write adds the positions of the bytes that have been discarded from
+// the dictionary buffer to the match finder. The bytes must have been
+// already moved out of the buffer. The method will never return an
+// error.
+func (t *matchFinder) Write(p []byte) (n int, err error) {
+	start := t.dict.head - int64(len(p))
+	for t.pos < t.dict.head {
+		if t.pos < start {
+			t.movePos()
+			continue
+		}
+		t.insert(false)
+	}
+	return len(p), nil
+}
+
+// NextOp identifies the next operation using the match finder.
+func (t *matchFinder) NextOp(rep [4]uint32) operation {
+	d := t.dict
+	n := d.Buffered()
+	if n == 0 {
+		panic("lzma: no data in dictionary buffer")
+	}
+	if n > MaxMatchLen {
+		n = MaxMatchLen
+	}
+	if t.matchPos != d.head {
+		if t.pos != d.head {
+			panic("lzma: match finder out of sync")
+		}
+		t.insert(true)
+	}
+	i := d.buf.rear
+	if t.cands != nil {
+		*t.cands = append(*t.cands, t.matches...)
+	}
+	var m match
+	if k := len(t.matches); k > 0 {
+		m = t.matches[k-1]
+		if m.n == t.niceLen && m.n < n {
+			m.n = t.cmpLen(i, t.back(i, int(m.distance)), m.n, n)
+		}
+	}
+
+	// Repetitions are cheaper to encode than matches of the same
+	// length.
+	dictLen := int64(d.DictLen())
+	isRep := false
+	for _, r := range rep {
+		dist := int64(r) + minDistance
+		if dist > dictLen {
+			continue
+		}
+		k := t.cmpLen(i, t.back(i, int(dist)), 0, n)
+		if t.cands != nil && k >= MinMatchLen {
+			*t.cands = append(*t.cands, match{dist, k})
+		}
+		if k >= MinMatchLen && k >= m.n {
+			m = match{dist, k}
+			isRep = true
+		}
+	}
+
+	if t.lazy && !isRep && MinMatchLen <= m.n && m.n < t.niceLen &&
+		m.n < n && t.deferMatch(m) {
+		m = match{}
+	}
+
+	if m.n < MinMatchLen {
+		dist := int64(rep[0]) + minDistance
+		if dist <= dictLen && t.at(t.back(i, int(dist)), 0) ==
+			t.at(i, 0) {
+			t.op = match{dist, 1}
+			return &t.op
+		}
+		return lit{t.at(i, 0)}
+	}
+	t.op = m
+	return &t.op
+}
+
+// changePair reports whether the distance small is so much smaller than
+// the distance big that a match one byte shorter is as good. The
+// heuristic is the one of liblzma.
+func changePair(small, big int64) bool {
+	return small < big>>7
+}
+
+// deferMatch searches the matches at the position following the
+// dictionary head and reports whether a literal followed by a match
+// there is expected to be better than the match m at the head. The
+// heuristic follows the fast mode of liblzma. The matches found are
+// kept for the next call of NextOp.
+func (t *matchFinder) deferMatch(m match) bool {
+	if t.pos != t.dict.head+1 {
+		return false
+	}
+	t.insert(true)
+	k := len(t.matches)
+	if k == 0 {
+		return false
+	}
+	next := t.matches[k-1]
+	switch {
+	case next.n >= m.n && next.distance < m.distance:
+		return true
+	case next.n == m.n+1 && !changePair(m.distance, next.distance):
+		return true
+	case next.n > m.n+1:
+		return true
+	case next.n+1 >= m.n && m.n >= 3 &&
+		changePair(next.distance, m.distance):
+		return true
+	}
+	return false
+}
@@ -0,0 +1,122 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+// The MemoryUsage methods of the configurations compute the memory the
+// readers and writers allocate, like lzma_memusage() of liblzma does.
+// Only the large allocations are considered: the dictionary buffers,
+// the tables of the match finders, the probability models and the
+// buffers for compressed data. The sizes are computed from the
+// configuration alone, so services can check them before creating
+// readers or writers.
+
+// stateMemory returns the number of bytes of the probability model for
+// the properties p. Each probability value requires two bytes.
+func stateMemory(p Properties) int64 {
+	return 2 * int64(stateProbs+literalProbs(p.LC, p.LP))
+}
+
+// memoryUsage returns the number of bytes allocated by the matcher for
+// the given dictionary capacity. It follows the allocations of new.
+func (a MatchAlgorithm) memoryUsage(dictCap int) int64 {
+	var wordLen int
+	tree := false
+	switch a {
+	case HashTable4:
+		// hash table with 8-byte entries and the circular list
+		e := uint(hashTableExponent(uint32(dictCap)))
+		return 8<<e + 4*int64(dictCap)
+	case BinaryTree2:
+		wordLen, tree = 2, true
+	case BinaryTree3:
+		wordLen, tree = 3, true
+	case BinaryTree4:
+		wordLen, tree = 4, true
+	case HashChain3:
+		wordLen = 3
+	case HashChain4:
+		wordLen = 4
+	}
+	n := int64(mfHashMask(dictCap, wordLen)) + 1
+	if wordLen > 2 {
+		n += mfHash2Size
+	}
+	if wordLen > 3 {
+		n += mfHash3Size
+	}
+	son := int64(dictCap) + 1
+	if tree {
+		son *= 2
+	}
+	return 4 * (n + son)
+}
+
+// encoderMemory returns the number of bytes allocated by an encoder
+// with the given parameters. The matcher is not allocated for stored
+// data.
+func encoderMemory(p Properties, dictCap, bufSize int, a MatchAlgorithm,
+	longRangeLen int, store bool) int64 {
+
+	n := int64(dictCap) + int64(bufSize) + 1 + stateMemory(p)
+	if !store {
+		n += a.memoryUsage(nearDictCap(dictCap, longRangeLen)) +
+			longRangeMemory(dictCap, longRangeLen)
+	}
+	return n
+}
+
+// MemoryUsage returns the number of bytes a writer created with the
+// configuration allocates.
+func (c WriterConfig) MemoryUsage() (n int64, err error) {
+	if err = c.Verify(); err != nil {
+		return 0, err
+	}
+	return encoderMemory(*c.Properties, c.DictCap, c.BufSize, c.Matcher,
+		c.LongRangeLen, false), nil
+}
+
+// MemoryUsage returns the number of bytes a writer created with the
+// configuration allocates. It includes the start state kept for the
+// chunks and the buffer for a compressed chunk.
+func (c Writer2Config) MemoryUsage() (n int64, err error) {
+	if err = c.Verify(); err != nil {
+		return 0, err
+	}
+	n = encoderMemory(*c.Properties, c.DictCap, c.BufSize, c.Matcher,
+		c.LongRangeLen, c.Store)
+	n += stateMemory(*c.Properties) + int64(c.CompressedChunkSize)
+	return n, nil
+}
+
+// MemoryUsage returns the number of bytes a reader created with the
+// configuration allocates for a stream with the default properties
+// and a dictionary capacity not exceeding DictCap. A dictionary buffer
+// growing on demand may require less memory.
+func (c ReaderConfig) MemoryUsage() (n int64, err error) {
+	if err = c.Verify(); err != nil {
+		return 0, err
+	}
+	p := Properties{LC: 3, LP: 0, PB: 2}
+	if n, err = RequiredDecoderMemory(p, int64(c.DictCap)); err != nil {
+		return 0, err
+	}
+	return n + int64(c.BufSize), nil
+}
+
+// MemoryUsage returns the number of bytes a reader created with the
+// configuration allocates for a stream with a dictionary capacity not
+// exceeding DictCap. It includes the buffer for a compressed chunk. A
+// dictionary buffer growing on demand may require less memory.
+func (c Reader2Config) MemoryUsage() (n int64, err error) {
+	if err = c.Verify(); err != nil {
+		return 0, err
+	}
+	// the largest literal coder supported by LZMA2
+	p := Properties{LC: 4, LP: 0, PB: 4}
+	if n, err = RequiredDecoderMemory(p, int64(c.DictCap)); err != nil {
+		return 0, err
+	}
+	return n + maxCompressed, nil
+}
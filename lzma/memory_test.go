@@ -0,0 +1,143 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"runtime"
+	"testing"
+)
+
+// skipRace skips tests comparing the memory usage with the heap
+// allocations, which the race detector inflates.
+func skipRace(t *testing.T) {
+	if raceEnabled {
+		t.Skip("allocations are inflated by the race detector")
+	}
+}
+
+// allocated returns the number of bytes allocated by f.
+func allocated(f func()) int64 {
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	f()
+	runtime.ReadMemStats(&after)
+	return int64(after.TotalAlloc - before.TotalAlloc)
+}
+
+// checkUsage checks that the memory usage n reported is close to the
+// bytes allocated.
+func checkUsage(t *testing.T, name string, n, a int64) {
+	t.Logf("%s: MemoryUsage %d; allocated %d", name, n, a)
+	d := n - a
+	if d < 0 {
+		d = -d
+	}
+	if d > a/20+16*1024 {
+		t.Errorf("%s: MemoryUsage %d; allocated %d", name, n, a)
+	}
+}
+
+func TestWriter2ConfigMemoryUsage(t *testing.T) {
+	skipRace(t)
+	for _, cfg := range []Writer2Config{
+		{},
+		{DictCap: 1 << 16},
+		{DictCap: 1 << 20, Matcher: BinaryTree4},
+		{DictCap: 1 << 20, Matcher: BinaryTree2},
+		{DictCap: 1 << 20, Matcher: HashChain3},
+		{DictCap: 1 << 20, LongRangeLen: 64},
+		{Store: true},
+	} {
+		n, err := cfg.MemoryUsage()
+		if err != nil {
+			t.Fatalf("MemoryUsage error %s", err)
+		}
+		var w *Writer2
+		a := allocated(func() {
+			w, err = cfg.NewWriter2(ioutil.Discard)
+		})
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		checkUsage(t, "Writer2 "+cfg.Matcher.String(), n, a)
+		runtime.KeepAlive(w)
+	}
+	if _, err := (Writer2Config{DictCap: -1}).MemoryUsage(); err == nil {
+		t.Fatalf("MemoryUsage accepted negative DictCap")
+	}
+}
+
+func TestWriterConfigMemoryUsage(t *testing.T) {
+	skipRace(t)
+	cfg := WriterConfig{DictCap: 1 << 20, Matcher: HashChain4}
+	n, err := cfg.MemoryUsage()
+	if err != nil {
+		t.Fatalf("MemoryUsage error %s", err)
+	}
+	var w *Writer
+	a := allocated(func() {
+		w, err = cfg.NewWriter(ioutil.Discard)
+	})
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	checkUsage(t, "Writer", n, a)
+	runtime.KeepAlive(w)
+}
+
+func TestReaderConfigMemoryUsage(t *testing.T) {
+	skipRace(t)
+	const dictCap = 1 << 20
+	var buf bytes.Buffer
+	w, err := WriterConfig{DictCap: dictCap}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(make([]byte, 2*dictCap)); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	// The complete dictionary is allocated directly.
+	cfg := ReaderConfig{DictCap: dictCap, Growth: Growth{Initial: dictCap}}
+	n, err := cfg.MemoryUsage()
+	if err != nil {
+		t.Fatalf("MemoryUsage error %s", err)
+	}
+	p := make([]byte, 4096)
+	a := allocated(func() {
+		var r *Reader
+		if r, err = cfg.NewReader(bytes.NewReader(buf.Bytes())); err != nil {
+			return
+		}
+		for err == nil {
+			_, err = r.Read(p)
+		}
+	})
+	if err != io.EOF {
+		t.Fatalf("Read error %s", err)
+	}
+	checkUsage(t, "Reader", n, a)
+}
+
+func TestReader2ConfigMemoryUsage(t *testing.T) {
+	cfg := Reader2Config{DictCap: 1 << 20}
+	n, err := cfg.MemoryUsage()
+	if err != nil {
+		t.Fatalf("MemoryUsage error %s", err)
+	}
+	m, err := RequiredDecoderMemory(Properties{LC: 4, PB: 4}, 1<<20)
+	if err != nil {
+		t.Fatalf("RequiredDecoderMemory error %s", err)
+	}
+	if n != m+maxCompressed {
+		t.Fatalf("MemoryUsage %d; want %d", n, m+maxCompressed)
+	}
+}
@@ -0,0 +1,11 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !race
+// +build !race
+
+package lzma
+
+// raceEnabled is false without the race detector.
+const raceEnabled = false
@@ -0,0 +1,117 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+)
+
+// Dictionary capacities of the pooled writers used by Compress. The
+// capacities are the powers of two in the range.
+const (
+	minPoolDictLog = 12
+	maxPoolDictLog = 23
+)
+
+// poolDictLog returns the binary logarithm of the smallest pooled
+// dictionary capacity that holds n bytes. The largest capacity is
+// returned for large n.
+func poolDictLog(n int) int {
+	k := minPoolDictLog
+	for k < maxPoolDictLog && 1<<uint(k) < n {
+		k++
+	}
+	return k
+}
+
+// writerPools keep the writers used by Compress; one pool for each
+// dictionary capacity.
+var writerPools [maxPoolDictLog - minPoolDictLog + 1]sync.Pool
+
+func init() {
+	for i := range writerPools {
+		dictCap := 1 << uint(minPoolDictLog+i)
+		writerPools[i].New = func() interface{} {
+			w, err := WriterConfig{DictCap: dictCap}.NewWriter(
+				ioutil.Discard)
+			if err != nil {
+				panic(err)
+			}
+			return w
+		}
+	}
+}
+
+// readerPool keeps the readers used by Decompress. It returns nil if
+// it is empty, since readers can only be created for a stream.
+var readerPool sync.Pool
+
+// maxPrealloc limits the memory allocated in advance for decompressed
+// data of the size declared by the header, which cannot be trusted.
+const maxPrealloc = 1 << 26
+
+// Compress appends the data of src compressed in the classic LZMA
+// format to dst and returns the extended slice. If c is nil, the
+// default parameters with a dictionary capacity fitting the size of
+// src are used and the writers are reused from a pool, which makes the
+// function efficient for many small payloads.
+func Compress(dst, src []byte, c *WriterConfig) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	var w *Writer
+	var err error
+	if c == nil {
+		pool := &writerPools[poolDictLog(len(src))-minPoolDictLog]
+		w = pool.Get().(*Writer)
+		if err = w.Reset(buf); err != nil {
+			return dst, err
+		}
+		defer pool.Put(w)
+	} else if w, err = c.NewWriter(buf); err != nil {
+		return dst, err
+	}
+	if _, err = w.Write(src); err != nil {
+		return dst, err
+	}
+	if err = w.Close(); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress appends the decompressed data of the LZMA stream in src
+// to dst and returns the extended slice. Data following the stream is
+// ignored. If c is nil, the default parameters are used and the readers
+// are reused from a pool.
+func Decompress(dst, src []byte, c *ReaderConfig) ([]byte, error) {
+	var r *Reader
+	var err error
+	if c == nil {
+		r, _ = readerPool.Get().(*Reader)
+		if r == nil {
+			r, err = NewReader(bytes.NewReader(src))
+		} else {
+			err = r.Reset(bytes.NewReader(src))
+		}
+		if err != nil {
+			return dst, err
+		}
+		defer readerPool.Put(r)
+	} else if r, err = c.NewReader(bytes.NewReader(src)); err != nil {
+		return dst, err
+	}
+	buf := bytes.NewBuffer(dst)
+	if n := r.h.size; n > 0 {
+		if n > maxPrealloc {
+			n = maxPrealloc
+		}
+		buf.Grow(int(n))
+	}
+	if _, err = r.WriteTo(buf); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
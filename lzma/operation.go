@@ -11,7 +11,10 @@ import (
 )
 
 // operation represents an operation on the dictionary during encoding or
-// decoding.
+// decoding. The matchers and the decoder return matches as pointers to
+// a match value stored in their own structure, so the operations don't
+// need to be allocated on the heap. Such an operation is only valid
+// until the next operation is requested.
 type operation interface {
 	Len() int
 }
@@ -30,7 +33,7 @@ func (m match) verify() error {
 	if !(minDistance <= m.distance && m.distance <= maxDistance) {
 		return errors.New("distance out of range")
 	}
-	if !(1 <= m.n && m.n <= maxMatchLen) {
+	if !(1 <= m.n && m.n <= MaxMatchLen) {
 		return errors.New("length out of range")
 	}
 	return nil
@@ -39,7 +42,7 @@ func (m match) verify() error {
 // l return the l-value for the match, which is the difference of length
 // n and 2.
 func (m match) l() uint32 {
-	return uint32(m.n - minMatchLen)
+	return uint32(m.n - MinMatchLen)
 }
 
 // dist returns the dist value for the match, which is one less of the
@@ -0,0 +1,219 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// Op is a single operation of the LZMA operation stream. A literal has
+// the distance zero and provides the byte in Lit. A match copies Len
+// bytes starting at the distance Dist before the current position.
+// Matches of length one are only supported for the distance of the
+// previous match.
+type Op struct {
+	Lit  byte
+	Dist int64
+	Len  int
+}
+
+// IsLiteral reports whether the operation is a literal.
+func (op Op) IsLiteral() bool { return op.Dist == 0 }
+
+// String returns a string representation of the operation.
+func (op Op) String() string {
+	if op.IsLiteral() {
+		return lit{op.Lit}.String()
+	}
+	return match{distance: op.Dist, n: op.Len}.String()
+}
+
+// OpReader decodes the operations of a raw LZMA stream without header.
+// The operations are applied to the decoder dictionary, which provides
+// the decoded data. The stream must be terminated by an end-of-stream
+// marker, unless the caller stops reading after the known size of the
+// uncompressed data has been reached.
+type OpReader struct {
+	d *decoder
+}
+
+// NewOpReader creates a reader for the operations of the LZMA stream
+// lzma encoded with the properties p. The decoded data is written into
+// dict, which can be primed with a preset dictionary before.
+func NewOpReader(lzma io.Reader, p Properties, dict *DecoderDict) (r *OpReader, err error) {
+	if err = p.verify(); err != nil {
+		return nil, err
+	}
+	d, err := newDecoder(ByteReader(lzma), NewState(p), dict, -1)
+	if err != nil {
+		return nil, err
+	}
+	return &OpReader{d: d}, nil
+}
+
+// Dict returns the dictionary of the reader.
+func (r *OpReader) Dict() *DecoderDict { return r.d.Dict }
+
+// State returns the state of the reader.
+func (r *OpReader) State() *State { return r.d.State }
+
+// ReadOp decodes the next operation and applies it to the dictionary.
+// At the end-of-stream marker io.EOF is returned. ErrNoSpace is
+// returned if the dictionary has no space for the largest match; the
+// decoded data must be read from the dictionary first. Decoding errors
+// are sticky.
+func (r *OpReader) ReadOp() (op Op, err error) {
+	d := r.d
+	if d.err != nil {
+		return op, d.err
+	}
+	if d.eos {
+		return op, io.EOF
+	}
+	if d.Dict.Available() < MaxMatchLen {
+		return op, ErrNoSpace
+	}
+	o, err := d.readOp()
+	switch err {
+	case nil:
+		break
+	case errEOS:
+		d.eos = true
+		if !d.rd.possiblyAtEnd() {
+			d.err = d.corrupt("data after end-of-stream marker")
+			return op, d.err
+		}
+		return op, io.EOF
+	case io.EOF:
+		d.err = io.ErrUnexpectedEOF
+		return op, d.err
+	default:
+		d.err = err
+		return op, err
+	}
+	if err = d.apply(o); err != nil {
+		d.err = err
+		return op, err
+	}
+	switch x := o.(type) {
+	case lit:
+		op.Lit = x.b
+	case *match:
+		op.Dist, op.Len = x.distance, x.n
+	}
+	return op, nil
+}
+
+// OpWriter encodes operations into a raw LZMA stream without header.
+// The writer doesn't search for matches; it only provides the range
+// encoding of the operations given by the caller.
+type OpWriter struct {
+	re    *rangeEncoder
+	state *State
+	dict  *DecoderDict
+	buf   *bufio.Writer
+	err   error
+}
+
+// NewOpWriter creates a writer encoding operations with the properties
+// p into lzma. The dictionary tracks the uncompressed data required
+// for the encoding of literals and may be primed with the preset
+// dictionary of the stream. The writer discards the data buffered in
+// the dictionary.
+func NewOpWriter(lzma io.Writer, p Properties, dict *DecoderDict) (w *OpWriter, err error) {
+	if err = p.verify(); err != nil {
+		return nil, err
+	}
+	w = &OpWriter{state: NewState(p), dict: dict}
+	bw, ok := lzma.(io.ByteWriter)
+	if !ok {
+		w.buf = bufio.NewWriter(fullWriter{lzma})
+		bw = w.buf
+	}
+	if w.re, err = newRangeEncoder(bw); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// State returns the state of the writer. Its probability model is
+// updated by every operation written.
+func (w *OpWriter) State() *State { return w.state }
+
+// Price returns the price of encoding the operation next in units of
+// 1/PriceScale bits. The operation must be valid.
+func (w *OpWriter) Price(op Op) uint32 {
+	d := w.dict
+	if op.IsLiteral() {
+		return w.state.LiteralPrice(op.Lit, d.ByteAt(1),
+			d.ByteAt(int(w.state.rep[0])+1), d.Pos())
+	}
+	return w.state.MatchPrice(op.Dist, op.Len, d.Pos())
+}
+
+// errOpWriterClosed indicates that the OpWriter has been closed.
+var errOpWriterClosed = errors.New("lzma: OpWriter is closed")
+
+// WriteOp encodes a single operation. Matches must point into the
+// dictionary and lengths must be in the range supported by LZMA.
+func (w *OpWriter) WriteOp(op Op) error {
+	if w.err != nil {
+		return w.err
+	}
+	d := w.dict
+	if d.Available() < MaxMatchLen {
+		d.buf.Discard(d.Buffered())
+	}
+	pos := d.Pos()
+	if op.IsLiteral() {
+		err := encodeLiteral(w.re, w.state, op.Lit, d.ByteAt(1),
+			d.ByteAt(int(w.state.rep[0])+1), pos)
+		if err != nil {
+			w.err = err
+			return err
+		}
+		return d.WriteByte(op.Lit)
+	}
+	if !(0 < op.Dist && op.Dist <= int64(d.DictLen())) {
+		return fmt.Errorf("lzma: match distance %d out of range [1, %d]",
+			op.Dist, d.DictLen())
+	}
+	if !(MinMatchLen <= op.Len && op.Len <= MaxMatchLen) &&
+		!(op.Len == 1 && uint32(op.Dist-minDistance) == w.state.rep[0]) {
+		return fmt.Errorf("lzma: match length %d out of range [%d, %d]",
+			op.Len, MinMatchLen, MaxMatchLen)
+	}
+	m := match{distance: op.Dist, n: op.Len}
+	if err := encodeMatch(w.re, w.state, m, pos); err != nil {
+		w.err = err
+		return err
+	}
+	return d.CopyN(op.Dist, op.Len)
+}
+
+// Close finishes the LZMA stream. If eos is set the end-of-stream
+// marker is written before. Close doesn't close the underlying writer.
+func (w *OpWriter) Close(eos bool) error {
+	if w.err != nil {
+		return w.err
+	}
+	w.err = errOpWriterClosed
+	if eos {
+		if err := encodeMatch(w.re, w.state, eosMatch,
+			w.dict.Pos()); err != nil {
+			return err
+		}
+	}
+	if err := w.re.Close(); err != nil {
+		return err
+	}
+	if w.buf != nil {
+		return w.buf.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"testing"
+)
+
+func TestOpReaderWriter(t *testing.T) {
+	orig := readOrigFile(t)
+	p := Properties{LC: 2, LP: 1, PB: 1}
+	var buf bytes.Buffer
+	wc := WriterConfig{Properties: &p, DictCap: MinDictCap}
+	w, err := wc.NewRawWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewRawWriter error %s", err)
+	}
+	if _, err = w.Write(orig); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	compressed := buf.Bytes()
+
+	rdict, err := NewDecoderDict(MinDictCap)
+	if err != nil {
+		t.Fatalf("NewDecoderDict error %s", err)
+	}
+	r, err := NewOpReader(bytes.NewReader(compressed), p, rdict)
+	if err != nil {
+		t.Fatalf("NewOpReader error %s", err)
+	}
+	wdict, err := NewDecoderDict(MinDictCap)
+	if err != nil {
+		t.Fatalf("NewDecoderDict error %s", err)
+	}
+	var reencoded bytes.Buffer
+	ow, err := NewOpWriter(&reencoded, p, wdict)
+	if err != nil {
+		t.Fatalf("NewOpWriter error %s", err)
+	}
+	var out bytes.Buffer
+	for {
+		op, err := r.ReadOp()
+		if err == ErrNoSpace {
+			if _, err = r.Dict().WriteTo(&out); err != nil {
+				t.Fatalf("WriteTo error %s", err)
+			}
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadOp error %s", err)
+		}
+		if err = ow.WriteOp(op); err != nil {
+			t.Fatalf("WriteOp(%v) error %s", op, err)
+		}
+	}
+	if _, err = r.Dict().WriteTo(&out); err != nil {
+		t.Fatalf("WriteTo error %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), orig) {
+		t.Fatalf("decoded data differs from the original")
+	}
+	if err = ow.Close(true); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if !bytes.Equal(reencoded.Bytes(), compressed) {
+		t.Fatalf("reencoded stream differs from the original stream")
+	}
+	if err = ow.WriteOp(Op{Lit: 'a'}); err == nil {
+		t.Fatalf("WriteOp after Close returned no error")
+	}
+}
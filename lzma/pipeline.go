@@ -0,0 +1,165 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+// The pipelined encoder runs the match finder in a separate goroutine
+// that sends the operations found in batches to the range encoder. The
+// match finder must never run ahead of an operation that doesn't fit
+// into the compressed chunk anymore, because the data of the operation
+// has already been discarded from the dictionary buffer. Since every
+// operation requires at most opLenMargin bytes, the match finder gets
+// a credit of operations computed from the bytes still available to
+// the range encoder. If the credit becomes small, the operations are
+// encoded one by one. The compressed output is therefore the same as
+// for the sequential encoder.
+
+// pipeBufSize is the default size of the buffer of a pipelined
+// encoder. The larger buffer allows the match finder to run ahead of
+// the range encoder.
+const pipeBufSize = 1 << 16
+
+// Parameters of the pipeline.
+const (
+	// number of operations in a batch
+	pipeBatchLen = 256
+	// number of batches in the pipeline
+	pipeBatches = 4
+	// minimum credit for starting the match finder goroutine
+	minPipeCredit = pipeBatchLen
+)
+
+// pipeOp is an operation together with the context required to encode
+// it without access to the dictionary. Literals are represented by a
+// match of length zero.
+type pipeOp struct {
+	m     match
+	b     byte
+	prev  byte
+	match byte
+	pos   int64
+}
+
+// pipeline provides the channels between the match finder and the
+// range encoder. The batches are recycled.
+type pipeline struct {
+	ops  chan []pipeOp
+	free chan []pipeOp
+}
+
+// newPipeline allocates a pipeline and its batches.
+func newPipeline() *pipeline {
+	p := &pipeline{
+		ops:  make(chan []pipeOp, pipeBatches),
+		free: make(chan []pipeOp, pipeBatches),
+	}
+	for i := 0; i < pipeBatches; i++ {
+		p.free <- make([]pipeOp, 0, pipeBatchLen)
+	}
+	return p
+}
+
+// updateRep updates the distances of the last matches in rep in the
+// same way as encodeMatch updates the state.
+func updateRep(rep *[4]uint32, m match) {
+	dist := uint32(m.distance - minDistance)
+	g := 0
+	for ; g < 4; g++ {
+		if rep[g] == dist {
+			break
+		}
+	}
+	if g == 4 {
+		// simple match
+		g = 3
+	}
+	for ; g > 0; g-- {
+		rep[g] = rep[g-1]
+	}
+	rep[0] = dist
+}
+
+// compressPipelined compresses the data in the dictionary buffer until
+// only n bytes are left using the pipeline.
+func (e *encoder) compressPipelined(n int) error {
+	d := e.dict
+	for d.Buffered() > n {
+		credit := (e.re.Available() - int64(e.margin)) / opLenMargin
+		// every operation discards at least one byte
+		if k := int64(d.Buffered() - n); credit > k {
+			credit = k
+		}
+		if credit < minPipeCredit {
+			op := d.m.NextOp(e.state.rep)
+			if err := e.writeOp(op); err != nil {
+				return err
+			}
+			e.discardOp(op)
+			continue
+		}
+		if err := e.runPipeline(n, int(credit)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// runPipeline runs the match finder in a goroutine for at most credit
+// operations and encodes the operations found.
+func (e *encoder) runPipeline(n, credit int) error {
+	p := e.pipe
+	go func(rep [4]uint32) {
+		d := e.dict
+		for credit > 0 && d.Buffered() > n {
+			batch := (<-p.free)[:0]
+			for len(batch) < cap(batch) && credit > 0 &&
+				d.Buffered() > n {
+				op := d.m.NextOp(rep)
+				q := pipeOp{
+					prev:  d.ByteAt(1),
+					match: d.ByteAt(int(rep[0]) + 1),
+					pos:   d.Pos(),
+				}
+				switch x := op.(type) {
+				case lit:
+					q.b = x.b
+				case *match:
+					q.m = *x
+					updateRep(&rep, q.m)
+				default:
+					panic("unexpected operation")
+				}
+				e.discardOp(op)
+				batch = append(batch, q)
+				credit--
+			}
+			p.ops <- batch
+		}
+		p.ops <- nil
+	}(e.state.rep)
+
+	var err error
+	for {
+		batch := <-p.ops
+		if batch == nil {
+			return err
+		}
+		for i := 0; i < len(batch) && err == nil; i++ {
+			err = e.encodeOp(&batch[i])
+		}
+		p.free <- batch
+	}
+}
+
+// encodeOp encodes an operation provided by the pipeline.
+func (e *encoder) encodeOp(q *pipeOp) error {
+	if e.re.Available() < int64(e.margin) {
+		panic("lzma: pipeline exceeded the operation credit")
+	}
+	if q.m.n == 0 {
+		return encodeLiteral(e.re, e.state, q.b, q.prev, q.match,
+			q.pos)
+	}
+	return encodeMatch(e.re, e.state, q.m, q.pos)
+}
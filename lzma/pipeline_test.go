@@ -0,0 +1,107 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestUpdateRep(t *testing.T) {
+	s := NewState(Properties{LC: 3, LP: 0, PB: 2})
+	var buf bytes.Buffer
+	re, err := newRangeEncoder(&buf)
+	if err != nil {
+		t.Fatalf("newRangeEncoder error %s", err)
+	}
+	var rep [4]uint32
+	rnd := rand.New(rand.NewSource(42))
+	for i := 0; i < 1000; i++ {
+		m := match{distance: int64(rnd.Intn(8)) + minDistance, n: 5}
+		if err = encodeMatch(re, s, m, int64(i)); err != nil {
+			t.Fatalf("encodeMatch error %s", err)
+		}
+		updateRep(&rep, m)
+		if rep != s.rep {
+			t.Fatalf("rep %v; want %v", rep, s.rep)
+		}
+	}
+}
+
+func TestWriter2Pipelined(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<19)
+	data := txt.Bytes()
+	compress := func(cfg Writer2Config) []byte {
+		var buf bytes.Buffer
+		w, err := cfg.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		return buf.Bytes()
+	}
+	for _, cfg := range []Writer2Config{
+		{BufSize: pipeBufSize},
+		{BufSize: pipeBufSize, Matcher: BinaryTree4},
+		{BufSize: pipeBufSize, CompressedChunkSize: 4096},
+		{BufSize: pipeBufSize, DedupHints: func(DedupHint) {}},
+	} {
+		want := compress(cfg)
+		cfg.Pipelined = true
+		got := compress(cfg)
+		if !bytes.Equal(got, want) {
+			t.Fatalf("pipelined output differs for %+v", cfg)
+		}
+		r, err := NewReader2(bytes.NewReader(got))
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Fatalf("decompressed data differs")
+		}
+	}
+}
+
+func TestWriterPipelined(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<18)
+	data := txt.Bytes()
+	var outputs [2][]byte
+	for i, pipelined := range []bool{false, true} {
+		var buf bytes.Buffer
+		w, err := WriterConfig{
+			BufSize:   pipeBufSize,
+			Pipelined: pipelined,
+		}.NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		outputs[i] = buf.Bytes()
+	}
+	if !bytes.Equal(outputs[0], outputs[1]) {
+		t.Fatalf("pipelined output differs")
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"strconv"
+	"testing"
+)
+
+// The tests in this file verify the handling of dictionary capacities
+// that exceed the int range of 32-bit platforms. Run them with
+//
+//	GOARCH=386 go test -run Platform
+//
+// to exercise the 32-bit code paths.
+
+func TestPlatformHeaderDictCap(t *testing.T) {
+	h := header{properties: Properties{LC: 3, LP: 0, PB: 2},
+		dictCap: MaxDictCap, size: -1}
+	data, err := h.marshalBinary()
+	if err != nil {
+		t.Fatalf("marshalBinary error %s", err)
+	}
+	info, err := ReadHeaderInfo(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("ReadHeaderInfo error %s", err)
+	}
+	if info.DictCap != MaxDictCap {
+		t.Fatalf("DictCap %d; want %d", info.DictCap, int64(MaxDictCap))
+	}
+	_, dictCap, err := UnmarshalCoderProps(data[:CoderPropsLen])
+	if err != nil {
+		t.Fatalf("UnmarshalCoderProps error %s", err)
+	}
+	if dictCap != MaxDictCap {
+		t.Fatalf("UnmarshalCoderProps returned dictCap %d; want %d",
+			dictCap, int64(MaxDictCap))
+	}
+
+	_, err = ReaderConfig{MemoryLimit: 1 << 24}.NewReader(
+		bytes.NewReader(data))
+	if err != ErrMemoryLimit {
+		t.Fatalf("NewReader with memory limit returned %v; want %v",
+			err, ErrMemoryLimit)
+	}
+	if strconv.IntSize == 32 {
+		_, err = NewReader(bytes.NewReader(data))
+		if err != ErrPlatformLimit {
+			t.Fatalf("NewReader returned %v; want %v", err,
+				ErrPlatformLimit)
+		}
+	}
+}
+
+func TestPlatformDictCapInt(t *testing.T) {
+	n, err := dictCapInt(1 << 30)
+	if err != nil || n != 1<<30 {
+		t.Fatalf("dictCapInt(1<<30) returned %d, %v", n, err)
+	}
+	_, err = dictCapInt(MaxDictCap)
+	if strconv.IntSize == 32 && err != ErrPlatformLimit {
+		t.Fatalf("dictCapInt(MaxDictCap) returned %v; want %v", err,
+			ErrPlatformLimit)
+	}
+	if strconv.IntSize == 64 && err != nil {
+		t.Fatalf("dictCapInt(MaxDictCap) error %s", err)
+	}
+}
@@ -0,0 +1,41 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build (amd64 || arm64) && !purego
+// +build amd64 arm64
+// +build !purego
+
+package lzma
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// prefixLen returns the length of the common prefix of a and b.
+//
+// The function compares 8-byte words. The architectures supported
+// support fast unaligned loads, which the compiler generates for
+// binary.LittleEndian.Uint64. The first differing byte is given by the
+// trailing zeros of the XOR of the words. Use the build tag purego to
+// select the byte-by-byte comparison.
+func prefixLen(a, b []byte) int {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	n := 0
+	for ; len(a)-n >= 8; n += 8 {
+		x := binary.LittleEndian.Uint64(a[n:]) ^
+			binary.LittleEndian.Uint64(b[n:])
+		if x != 0 {
+			return n + bits.TrailingZeros64(x)>>3
+		}
+	}
+	for ; n < len(a); n++ {
+		if a[n] != b[n] {
+			return n
+		}
+	}
+	return n
+}
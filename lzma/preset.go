@@ -0,0 +1,82 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "fmt"
+
+// MinPreset and MaxPreset define the range of the compression preset
+// levels.
+const (
+	MinPreset = 0
+	MaxPreset = 9
+)
+
+// presetDictCapExps maps the preset levels to the exponents of the
+// dictionary capacities. The values are the same as used by liblzma.
+var presetDictCapExps = [MaxPreset + 1]uint{
+	18, 20, 21, 22, 22, 23, 23, 24, 25, 26}
+
+// presetHCDepths provides the search depths of the hash chain matchers
+// used by the fast preset levels zero to three.
+var presetHCDepths = [4]int{4, 8, 24, 48}
+
+// presetExtremeDepth is the search depth of the binary tree matcher for
+// the extreme presets.
+const presetExtremeDepth = 512
+
+// presetNiceLen returns the nice length for the preset level. The
+// values are the same as used by liblzma.
+func presetNiceLen(level int, extreme bool) int {
+	switch {
+	case extreme:
+		if level == 3 || level == 5 {
+			return 192
+		}
+		return maxNiceLen
+	case level <= 1:
+		return 128
+	case level <= 3:
+		return maxNiceLen
+	case level == 4:
+		return 16
+	case level == 5:
+		return 32
+	}
+	return 64
+}
+
+// Preset returns the writer configuration for the given compression
+// preset level, which must be in the range [MinPreset,MaxPreset]. The
+// dictionary capacities and match finders are the same as used by
+// liblzma. Level zero uses the HashChain3 matcher, the levels one to
+// three the HashChain4 matcher and the higher levels the binary tree
+// matcher. The extreme flag selects the binary tree matcher with
+// increased search depth for all levels. The nice lengths of the
+// levels are the ones of liblzma too.
+func Preset(level int, extreme bool) (c WriterConfig, err error) {
+	if !(MinPreset <= level && level <= MaxPreset) {
+		return c, fmt.Errorf("lzma: preset level %d out of range [%d, %d]",
+			level, MinPreset, MaxPreset)
+	}
+	c = WriterConfig{
+		Properties: &Properties{LC: 3, LP: 0, PB: 2},
+		DictCap:    1 << presetDictCapExps[level],
+		Matcher:    BinaryTree4,
+		NiceLen:    presetNiceLen(level, extreme),
+	}
+	switch {
+	case extreme:
+		if level != 3 && level != 5 {
+			c.Depth = presetExtremeDepth
+		}
+	case level == 0:
+		c.Matcher = HashChain3
+		c.Depth = presetHCDepths[0]
+	case level <= 3:
+		c.Matcher = HashChain4
+		c.Depth = presetHCDepths[level]
+	}
+	return c, nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "testing"
+
+func TestPreset(t *testing.T) {
+	tests := []struct {
+		level   int
+		extreme bool
+		dictCap int
+		matcher MatchAlgorithm
+		depth   int
+		niceLen int
+	}{
+		{0, false, 1 << 18, HashChain3, 4, 128},
+		{1, false, 1 << 20, HashChain4, 8, 128},
+		{3, false, 1 << 22, HashChain4, 48, 273},
+		{3, true, 1 << 22, BinaryTree4, 0, 192},
+		{4, false, 1 << 22, BinaryTree4, 0, 16},
+		{5, false, 1 << 23, BinaryTree4, 0, 32},
+		{6, false, 1 << 23, BinaryTree4, 0, 64},
+		{6, true, 1 << 23, BinaryTree4, 512, 273},
+		{9, false, 1 << 26, BinaryTree4, 0, 64},
+	}
+	for _, c := range tests {
+		cfg, err := Preset(c.level, c.extreme)
+		if err != nil {
+			t.Fatalf("Preset(%d, %t) error %s", c.level, c.extreme,
+				err)
+		}
+		if cfg.DictCap != c.dictCap {
+			t.Errorf("Preset(%d, %t): DictCap %d; want %d",
+				c.level, c.extreme, cfg.DictCap, c.dictCap)
+		}
+		if cfg.Matcher != c.matcher {
+			t.Errorf("Preset(%d, %t): Matcher %s; want %s",
+				c.level, c.extreme, cfg.Matcher, c.matcher)
+		}
+		if cfg.Depth != c.depth {
+			t.Errorf("Preset(%d, %t): Depth %d; want %d",
+				c.level, c.extreme, cfg.Depth, c.depth)
+		}
+		if cfg.NiceLen != c.niceLen {
+			t.Errorf("Preset(%d, %t): NiceLen %d; want %d",
+				c.level, c.extreme, cfg.NiceLen, c.niceLen)
+		}
+		if err = cfg.Verify(); err != nil {
+			t.Errorf("Preset(%d, %t): Verify error %s",
+				c.level, c.extreme, err)
+		}
+	}
+	for _, level := range []int{MinPreset - 1, MaxPreset + 1} {
+		if _, err := Preset(level, false); err == nil {
+			t.Errorf("Preset(%d, false) returned no error", level)
+		}
+	}
+}
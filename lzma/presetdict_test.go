@@ -0,0 +1,130 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+// jsonMessage creates a small JSON message similar to the messages
+// with other indexes.
+func jsonMessage(i int) []byte {
+	return []byte(fmt.Sprintf(`{"id":%d,"user":"user%d",`+
+		`"action":"login","status":"ok","client":"browser"}`, i, i*7))
+}
+
+func presetDict(n int) []byte {
+	var buf bytes.Buffer
+	for i := 0; buf.Len() < n; i++ {
+		buf.Write(jsonMessage(1000 + i))
+	}
+	return buf.Bytes()
+}
+
+func compressPreset(t *testing.T, msg, dict []byte, m MatchAlgorithm) []byte {
+	var buf bytes.Buffer
+	w, err := WriterConfig{Matcher: m, DictCap: MinDictCap,
+		PresetDict: dict}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(msg); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	return buf.Bytes()
+}
+
+func TestPresetDict(t *testing.T) {
+	msg := jsonMessage(1)
+	matchers := []MatchAlgorithm{HashTable4, BinaryTree2, BinaryTree4,
+		HashChain3, HashChain4}
+	for _, n := range []int{2000, 3 * MinDictCap} {
+		dict := presetDict(n)
+		for _, m := range matchers {
+			plain := compressPreset(t, msg, nil, m)
+			preset := compressPreset(t, msg, dict, m)
+			t.Logf("%s dict %d: %d bytes without, %d bytes with "+
+				"preset dictionary", m, len(dict), len(plain),
+				len(preset))
+			if len(preset) >= len(plain) {
+				t.Errorf("%s: preset dictionary doesn't improve "+
+					"compression", m)
+			}
+			// the small dictionary capacity stores only the
+			// end of the preset dictionary
+			for _, dictCap := range []int{MinDictCap, 1 << 20} {
+				r, err := ReaderConfig{DictCap: dictCap,
+					PresetDict: dict}.NewReader(
+					bytes.NewReader(preset))
+				if err != nil {
+					t.Fatalf("NewReader error %s", err)
+				}
+				out, err := ioutil.ReadAll(r)
+				if err != nil {
+					t.Fatalf("ReadAll error %s", err)
+				}
+				if !bytes.Equal(out, msg) {
+					t.Fatalf("%s: got %q; want %q", m, out,
+						msg)
+				}
+			}
+		}
+	}
+}
+
+func TestPresetDict2(t *testing.T) {
+	dict := presetDict(5000)
+	var data bytes.Buffer
+	for i := 0; i < 20; i++ {
+		data.Write(jsonMessage(i))
+	}
+	var buf bytes.Buffer
+	w, err := Writer2Config{DictCap: MinDictCap, ChunkSize: 500,
+		PresetDict: dict}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	h, err := readChunkHeader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("readChunkHeader error %s", err)
+	}
+	if h.ctype == cLRND || h.ctype == cUD {
+		t.Fatalf("first chunk resets the dictionary")
+	}
+	// without preset dictionary the first chunk is rejected
+	r, err := NewReader2(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err != errChunkType {
+		t.Fatalf("ReadAll returned error %v; want %v", err,
+			errChunkType)
+	}
+	r, err = Reader2Config{DictCap: MinDictCap, PresetDict: dict}.
+		NewReader2(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	var out bytes.Buffer
+	if _, err = io.Copy(&out, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), data.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
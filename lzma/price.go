@@ -0,0 +1,373 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "math"
+
+// The price functions estimate the number of bits the range encoder
+// requires for an operation using the current probability model. They
+// follow the price machinery of the LZMA SDK and don't modify the state.
+
+// priceShiftBits is the number of fractional bits of a price.
+const priceShiftBits = 4
+
+// PriceScale is the price of a single bit. Prices are given in
+// fractions of a bit; divide them by PriceScale to get bits.
+const PriceScale = 1 << priceShiftBits
+
+// moveReducingBits is the number of low bits of a probability value
+// ignored by the price table.
+const moveReducingBits = 4
+
+// probPrices contains the price for encoding a zero bit with a
+// probability value, indexed by the probability shifted right by
+// moveReducingBits.
+var probPrices = initProbPrices()
+
+// initProbPrices computes the prices -log2(p) for all probability
+// values with the integer algorithm of the LZMA SDK.
+func initProbPrices() (prices [1 << (probbits - moveReducingBits)]uint32) {
+	for i := range prices {
+		w := uint32(i)<<moveReducingBits + 1<<(moveReducingBits-1)
+		bitCount := uint32(0)
+		for j := 0; j < priceShiftBits; j++ {
+			w *= w
+			bitCount <<= 1
+			for w >= 1<<16 {
+				w >>= 1
+				bitCount++
+			}
+		}
+		prices[i] = probbits<<priceShiftBits - 15 - bitCount
+	}
+	return prices
+}
+
+// price returns the price of encoding bit b with the probability p.
+func (p prob) price(b uint32) uint32 {
+	q := uint32(p)
+	if b != 0 {
+		q ^= 1<<probbits - 1
+	}
+	return probPrices[q>>moveReducingBits]
+}
+
+// price returns the price of the value v.
+func (tc treeCodec) price(v uint32) (price uint32) {
+	m := uint32(1)
+	for i := int(tc.bits) - 1; i >= 0; i-- {
+		b := (v >> uint(i)) & 1
+		price += tc.probs[m].price(b)
+		m = (m << 1) | b
+	}
+	return price
+}
+
+// price returns the price of the value v.
+func (tc treeReverseCodec) price(v uint32) (price uint32) {
+	m := uint32(1)
+	for i := uint(0); i < uint(tc.bits); i++ {
+		b := (v >> i) & 1
+		price += tc.probs[m].price(b)
+		m = (m << 1) | b
+	}
+	return price
+}
+
+// price returns the price of the length offset l.
+func (lc lengthCodec) price(l uint32, posState uint32) uint32 {
+	if l < 8 {
+		return lc.choice(0).price(0) + lc.low(posState).price(l)
+	}
+	price := lc.choice(0).price(1)
+	if l < 16 {
+		return price + lc.choice(1).price(0) +
+			lc.mid(posState).price(l-8)
+	}
+	return price + lc.choice(1).price(1) + lc.high().price(l-16)
+}
+
+// price returns the price of the distance offset dist for the length
+// offset l.
+func (dc distCodec) price(dist uint32, l uint32) uint32 {
+	var posSlot, bits uint32
+	if dist < startPosModel {
+		posSlot = dist
+	} else {
+		bits = uint32(30 - nlz32(dist))
+		posSlot = startPosModel - 2 + (bits << 1)
+		posSlot += (dist >> uint(bits)) & 1
+	}
+	price := dc.posSlot(l).price(posSlot)
+	switch {
+	case posSlot < startPosModel:
+		return price
+	case posSlot < endPosModel:
+		return price + dc.posModel(posSlot).price(dist)
+	}
+	price += (bits - alignBits) << priceShiftBits
+	return price + dc.align().price(dist)
+}
+
+// price returns the price of the literal s.
+func (c literalCodec) price(s byte, state uint32, match byte,
+	litState uint32) (price uint32) {
+
+	k := litState * 0x300
+	probs := c.probs[k : k+0x300]
+	symbol := uint32(1)
+	r := uint32(s)
+	m := uint32(match)
+	matched := state >= 7
+	for symbol < 0x100 {
+		bit := (r >> 7) & 1
+		r <<= 1
+		i := symbol
+		if matched {
+			matchBit := (m >> 7) & 1
+			m <<= 1
+			i |= (1 + matchBit) << 8
+			matched = matchBit == bit
+		}
+		price += probs[i].price(bit)
+		symbol = (symbol << 1) | bit
+	}
+	return price
+}
+
+// LiteralPrice returns the price of encoding the literal b at position
+// pos of the uncompressed data. The byte prev precedes the literal and
+// match is the byte at the distance of the last match. The price is
+// given in units of 1/PriceScale bits.
+func (s *State) LiteralPrice(b, prev, match byte, pos int64) uint32 {
+	state, state2, _ := s.states(pos)
+	price := s.isMatch[state2].price(0)
+	litState := s.litState(prev, pos)
+	return price + s.litCodec().price(b, state, match, litState)
+}
+
+// MatchPrice returns the price of encoding a match with the given
+// distance and length at position pos of the uncompressed data. The
+// price considers the encoding as repetition of one of the last four
+// distances. The match must be valid as described for Op. The price is
+// given in units of 1/PriceScale bits.
+func (s *State) MatchPrice(dist int64, n int, pos int64) uint32 {
+	state, state2, posState := s.states(pos)
+	price := s.isMatch[state2].price(1)
+	d := uint32(dist - minDistance)
+	g := 0
+	for ; g < 4; g++ {
+		if s.rep[g] == d {
+			break
+		}
+	}
+	l := uint32(n - MinMatchLen)
+	if g == 4 {
+		price += s.isRep[state].price(0)
+		return price + s.lenCodec().price(l, posState) +
+			s.distCodec().price(d, l)
+	}
+	price += s.isRep[state].price(1)
+	if g == 0 {
+		price += s.isRepG0[state].price(0)
+		if n == 1 {
+			return price + s.isRepG0Long[state2].price(0)
+		}
+		price += s.isRepG0Long[state2].price(1)
+	} else {
+		price += s.isRepG0[state].price(1)
+		if g == 1 {
+			price += s.isRepG1[state].price(0)
+		} else {
+			price += s.isRepG1[state].price(1)
+			price += s.isRepG2[state].price(iverson(g != 2))
+		}
+	}
+	return price + s.repLenCodec().price(l, posState)
+}
+
+// The adapt functions update the probabilities like the encoding of
+// the value would and return its price. They allow the tracking of the
+// probability model without range encoding. The prices of the SDK
+// table are too coarse for summing up whole streams; they overestimate
+// the size by a few percent. The adapt functions use exact prices with
+// adaptShiftBits fractional bits instead.
+
+// adaptShiftBits is the number of fractional bits of the prices
+// returned by the adapt functions.
+const adaptShiftBits = 8
+
+// adaptPrices contains the exact prices -log2(p) for all probability
+// values p.
+var adaptPrices = initAdaptPrices()
+
+// initAdaptPrices computes the table adaptPrices.
+func initAdaptPrices() (prices [1 << probbits]uint32) {
+	for i := 1; i < len(prices); i++ {
+		p := float64(i) / (1 << probbits)
+		prices[i] = uint32(-math.Log2(p)*(1<<adaptShiftBits) + 0.5)
+	}
+	return prices
+}
+
+// adapt returns the price of bit b and updates the probability.
+func (p *prob) adapt(b uint32) uint32 {
+	q := uint32(*p)
+	if b != 0 {
+		q ^= 1<<probbits - 1
+	}
+	price := adaptPrices[q]
+	if b == 0 {
+		p.inc()
+	} else {
+		p.dec()
+	}
+	return price
+}
+
+// adapt returns the price of the value v and updates the
+// probabilities.
+func (tc treeCodec) adapt(v uint32) (price uint32) {
+	m := uint32(1)
+	for i := int(tc.bits) - 1; i >= 0; i-- {
+		b := (v >> uint(i)) & 1
+		price += tc.probs[m].adapt(b)
+		m = (m << 1) | b
+	}
+	return price
+}
+
+// adapt returns the price of the value v and updates the
+// probabilities.
+func (tc treeReverseCodec) adapt(v uint32) (price uint32) {
+	m := uint32(1)
+	for i := uint(0); i < uint(tc.bits); i++ {
+		b := (v >> i) & 1
+		price += tc.probs[m].adapt(b)
+		m = (m << 1) | b
+	}
+	return price
+}
+
+// adapt returns the price of the length offset l and updates the
+// probabilities.
+func (lc lengthCodec) adapt(l uint32, posState uint32) uint32 {
+	if l < 8 {
+		return lc.choice(0).adapt(0) + lc.low(posState).adapt(l)
+	}
+	price := lc.choice(0).adapt(1)
+	if l < 16 {
+		return price + lc.choice(1).adapt(0) +
+			lc.mid(posState).adapt(l-8)
+	}
+	return price + lc.choice(1).adapt(1) + lc.high().adapt(l-16)
+}
+
+// adapt returns the price of the distance offset dist for the length
+// offset l and updates the probabilities.
+func (dc distCodec) adapt(dist uint32, l uint32) uint32 {
+	var posSlot, bits uint32
+	if dist < startPosModel {
+		posSlot = dist
+	} else {
+		bits = uint32(30 - nlz32(dist))
+		posSlot = startPosModel - 2 + (bits << 1)
+		posSlot += (dist >> uint(bits)) & 1
+	}
+	price := dc.posSlot(l).adapt(posSlot)
+	switch {
+	case posSlot < startPosModel:
+		return price
+	case posSlot < endPosModel:
+		return price + dc.posModel(posSlot).adapt(dist)
+	}
+	price += (bits - alignBits) << adaptShiftBits
+	return price + dc.align().adapt(dist)
+}
+
+// adapt returns the price of the literal s and updates the
+// probabilities.
+func (c literalCodec) adapt(s byte, state uint32, match byte,
+	litState uint32) (price uint32) {
+
+	k := litState * 0x300
+	probs := c.probs[k : k+0x300]
+	symbol := uint32(1)
+	r := uint32(s)
+	m := uint32(match)
+	matched := state >= 7
+	for symbol < 0x100 {
+		bit := (r >> 7) & 1
+		r <<= 1
+		i := symbol
+		if matched {
+			matchBit := (m >> 7) & 1
+			m <<= 1
+			i |= (1 + matchBit) << 8
+			matched = matchBit == bit
+		}
+		price += probs[i].adapt(bit)
+		symbol = (symbol << 1) | bit
+	}
+	return price
+}
+
+// adaptLiteral returns the price of the literal b at position pos and
+// updates the state as encodeLiteral would.
+func (s *State) adaptLiteral(b, prev, match byte, pos int64) uint32 {
+	state, state2, _ := s.states(pos)
+	price := s.isMatch[state2].adapt(0)
+	price += s.litCodec().adapt(b, state, match, s.litState(prev, pos))
+	s.updateStateLiteral()
+	return price
+}
+
+// adaptMatch returns the price of the match m at position pos and
+// updates the state as encodeMatch would.
+func (s *State) adaptMatch(m match, pos int64) uint32 {
+	state, state2, posState := s.states(pos)
+	price := s.isMatch[state2].adapt(1)
+	dist := uint32(m.distance - minDistance)
+	g := 0
+	for ; g < 4; g++ {
+		if s.rep[g] == dist {
+			break
+		}
+	}
+	l := uint32(m.n - MinMatchLen)
+	if g == 4 {
+		price += s.isRep[state].adapt(0)
+		s.rep[3], s.rep[2], s.rep[1], s.rep[0] =
+			s.rep[2], s.rep[1], s.rep[0], dist
+		s.updateStateMatch()
+		price += s.lenCodec().adapt(l, posState)
+		return price + s.distCodec().adapt(dist, l)
+	}
+	price += s.isRep[state].adapt(1)
+	if g == 0 {
+		price += s.isRepG0[state].adapt(0)
+		if m.n == 1 {
+			s.updateStateShortRep()
+			return price + s.isRepG0Long[state2].adapt(0)
+		}
+		price += s.isRepG0Long[state2].adapt(1)
+	} else {
+		price += s.isRepG0[state].adapt(1)
+		if g == 1 {
+			price += s.isRepG1[state].adapt(0)
+		} else {
+			price += s.isRepG1[state].adapt(1)
+			price += s.isRepG2[state].adapt(iverson(g != 2))
+			if g == 3 {
+				s.rep[3] = s.rep[2]
+			}
+			s.rep[2] = s.rep[1]
+		}
+		s.rep[1] = s.rep[0]
+		s.rep[0] = dist
+	}
+	s.updateStateRep()
+	return price + s.repLenCodec().adapt(l, posState)
+}
@@ -0,0 +1,90 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+)
+
+func TestProbPrices(t *testing.T) {
+	if p := probInit.price(0); p != PriceScale {
+		t.Fatalf("price of probability 0.5 is %d; want %d", p,
+			PriceScale)
+	}
+	var low prob = 1 << (probbits - 3)
+	if p0, p1 := low.price(0), low.price(1); p0 <= p1 {
+		t.Fatalf("prices %d and %d for probability 1/8", p0, p1)
+	}
+}
+
+// TestPrices compares the sum of the prices of all operations of a
+// stream with the size of the stream.
+func TestPrices(t *testing.T) {
+	orig := readOrigFile(t)
+	p := Properties{LC: 3, LP: 0, PB: 2}
+	var buf bytes.Buffer
+	wc := WriterConfig{Properties: &p, DictCap: MinDictCap}
+	w, err := wc.NewRawWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewRawWriter error %s", err)
+	}
+	if _, err = w.Write(orig); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	compressed := buf.Len()
+
+	rdict, err := NewDecoderDict(MinDictCap)
+	if err != nil {
+		t.Fatalf("NewDecoderDict error %s", err)
+	}
+	r, err := NewOpReader(&buf, p, rdict)
+	if err != nil {
+		t.Fatalf("NewOpReader error %s", err)
+	}
+	var ops []Op
+	for {
+		op, err := r.ReadOp()
+		if err == ErrNoSpace {
+			rdict.WriteTo(ioutil.Discard)
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadOp error %s", err)
+		}
+		ops = append(ops, op)
+	}
+
+	wdict, err := NewDecoderDict(MinDictCap)
+	if err != nil {
+		t.Fatalf("NewDecoderDict error %s", err)
+	}
+	ow, err := NewOpWriter(ioutil.Discard, p, wdict)
+	if err != nil {
+		t.Fatalf("NewOpWriter error %s", err)
+	}
+	var total uint64
+	for _, op := range ops {
+		total += uint64(ow.Price(op))
+		if err = ow.WriteOp(op); err != nil {
+			t.Fatalf("WriteOp error %s", err)
+		}
+	}
+	estimate := int(total / (8 * PriceScale))
+	// The range encoder adds a few bytes and the EOS marker isn't
+	// included in the estimate.
+	if d := compressed - estimate; d < 0 || d > 8+compressed/100 {
+		t.Fatalf("estimated size %d; compressed size %d", estimate,
+			compressed)
+	}
+}
@@ -32,10 +32,14 @@ func (p *Properties) String() string {
 	return fmt.Sprintf("LC %d LP %d PB %d", p.LC, p.LP, p.PB)
 }
 
+// ErrProperties indicates an invalid properties code or properties not
+// supported by the format.
+var ErrProperties = errors.New("lzma: invalid properties code")
+
 // PropertiesForCode converts a properties code byte into a Properties value.
 func PropertiesForCode(code byte) (p Properties, err error) {
 	if code > maxPropertyCode {
-		return p, errors.New("lzma: invalid properties code")
+		return p, ErrProperties
 	}
 	p.LC = int(code % 9)
 	code /= 9
@@ -51,17 +55,56 @@ func (p *Properties) verify() error {
 		return errors.New("lzma: properties are nil")
 	}
 	if !(minLC <= p.LC && p.LC <= maxLC) {
-		return errors.New("lzma: lc out of range")
+		return fmt.Errorf("lzma: lc %d out of range [%d, %d]", p.LC,
+			minLC, maxLC)
 	}
 	if !(minLP <= p.LP && p.LP <= maxLP) {
-		return errors.New("lzma: lp out of range")
+		return fmt.Errorf("lzma: lp %d out of range [%d, %d]", p.LP,
+			minLP, maxLP)
 	}
 	if !(minPB <= p.PB && p.PB <= maxPB) {
-		return errors.New("lzma: pb out of range")
+		return fmt.Errorf("lzma: pb %d out of range [%d, %d]", p.PB,
+			minPB, maxPB)
+	}
+	return nil
+}
+
+// ErrLCLP indicates properties whose literal context and literal
+// position bits add up to more than 4, which LZMA2 doesn't support.
+var ErrLCLP = errors.New("lzma: sum of lc and lp exceeds 4")
+
+// verify2 checks the properties for correctness and for the restriction
+// of the literal context in LZMA2.
+func (p *Properties) verify2() error {
+	if err := p.verify(); err != nil {
+		return err
+	}
+	if p.LC+p.LP > 4 {
+		return ErrLCLP
 	}
 	return nil
 }
 
+// NewProperties creates the properties for the classic LZMA format and
+// checks that the parameters are in range.
+func NewProperties(lc, lp, pb int) (p Properties, err error) {
+	p = Properties{LC: lc, LP: lp, PB: pb}
+	if err = p.verify(); err != nil {
+		return Properties{}, err
+	}
+	return p, nil
+}
+
+// NewProperties2 creates the properties for LZMA2. In addition to the
+// checks of NewProperties it returns ErrLCLP if lc+lp exceeds 4.
+func NewProperties2(lc, lp, pb int) (p Properties, err error) {
+	p = Properties{LC: lc, LP: lp, PB: pb}
+	if err = p.verify2(); err != nil {
+		return Properties{}, err
+	}
+	return p, nil
+}
+
 // Code converts the properties to a byte. The function assumes that
 // the properties components are all in range.
 func (p Properties) Code() byte {
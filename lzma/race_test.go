@@ -0,0 +1,12 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build race
+// +build race
+
+package lzma
+
+// raceEnabled reports whether the tests run with the race detector,
+// which inflates the heap allocations.
+const raceEnabled = true
@@ -7,13 +7,19 @@ package lzma
 import (
 	"errors"
 	"io"
+
+	"github.com/ulikunitz/xz/internal/xio"
 )
 
 // rangeEncoder implements range encoding of single bits. The low value can
 // overflow therefore we need uint64. The cache value is used to handle
 // overflows.
 type rangeEncoder struct {
-	lbw      *LimitedByteWriter
+	lbw *LimitedByteWriter
+	// If the underlying byte writer supports Write, the output is
+	// collected in buf and written in large blocks to w.
+	w        io.Writer
+	buf      []byte
 	nrange   uint32
 	low      uint64
 	cacheLen int64
@@ -23,16 +29,36 @@ type rangeEncoder struct {
 // maxInt64 provides the  maximal value of the int64 type
 const maxInt64 = 1<<63 - 1
 
+// rangeEncoderBufSize defines the size of the output buffer of the
+// range encoder.
+const rangeEncoderBufSize = 4096
+
 // newRangeEncoder creates a new range encoder.
 func newRangeEncoder(bw io.ByteWriter) (re *rangeEncoder, err error) {
+	re = new(rangeEncoder)
+	re.reset(bw)
+	return re, nil
+}
+
+// reset initializes the range encoder for a new byte writer. The output
+// buffer will be reused.
+func (e *rangeEncoder) reset(bw io.ByteWriter) {
 	lbw, ok := bw.(*LimitedByteWriter)
 	if !ok {
 		lbw = &LimitedByteWriter{BW: bw, N: maxInt64}
 	}
-	return &rangeEncoder{
+	*e = rangeEncoder{
 		lbw:      lbw,
+		buf:      e.buf[:0],
 		nrange:   0xffffffff,
-		cacheLen: 1}, nil
+		cacheLen: 1,
+	}
+	if w, ok := lbw.BW.(io.Writer); ok {
+		e.w = w
+		if e.buf == nil {
+			e.buf = make([]byte, 0, rangeEncoderBufSize)
+		}
+	}
 }
 
 // Available returns the number of bytes that still can be written. The
@@ -49,7 +75,27 @@ func (e *rangeEncoder) writeByte(c byte) error {
 	if e.Available() < 1 {
 		return ErrLimit
 	}
-	return e.lbw.WriteByte(c)
+	if e.w == nil {
+		return e.lbw.WriteByte(c)
+	}
+	if len(e.buf) == cap(e.buf) {
+		if err := e.flush(); err != nil {
+			return err
+		}
+	}
+	e.buf = append(e.buf, c)
+	e.lbw.N--
+	return nil
+}
+
+// flush writes the buffered output to the underlying writer.
+func (e *rangeEncoder) flush() error {
+	if len(e.buf) == 0 {
+		return nil
+	}
+	_, err := xio.WriteFull(e.w, e.buf)
+	e.buf = e.buf[:0]
+	return err
 }
 
 // DirectEncodeBit encodes the least-significant bit of b with probability 1/2.
@@ -88,14 +134,18 @@ func (e *rangeEncoder) EncodeBit(b uint32, p *prob) error {
 	return e.shiftLow()
 }
 
-// Close writes a complete copy of the low value.
+// Close writes a complete copy of the low value and flushes the output
+// buffer.
 func (e *rangeEncoder) Close() error {
 	for i := 0; i < 5; i++ {
 		if err := e.shiftLow(); err != nil {
 			return err
 		}
 	}
-	return nil
+	if e.w == nil {
+		return nil
+	}
+	return e.flush()
 }
 
 // shiftLow shifts the low value for 8 bit. The shifted byte is written into
@@ -125,33 +175,99 @@ func (e *rangeEncoder) shiftLow() error {
 }
 
 // rangeDecoder decodes single bits of the range encoding stream.
+//
+// The decoder reads the bytes in buf before it asks the byte reader br
+// for more data. Errors are not returned by the bit decoding methods
+// but recorded in err. After the first error the decoder provides only
+// zero bytes, so callers can check err after decoding a complete
+// operation.
 type rangeDecoder struct {
 	br     io.ByteReader
+	buf    []byte
 	nrange uint32
 	code   uint32
+	err    error
+	// bytes read from br since init
+	n int64
+	// If src is set, the compressed data is read in blocks into win
+	// and buf is the unread part of it. The number of bytes read
+	// from src is given by srcN; srcEOF is set at the end of src.
+	src    io.Reader
+	win    []byte
+	srcN   int64
+	srcEOF bool
+}
+
+// maxOpInput is the maximum number of input bytes the decoding of a
+// single operation requires. The value corresponds to
+// LZMA_REQUIRED_INPUT_MAX of the LZMA SDK.
+const maxOpInput = 20
+
+// prefetch reads the data from src into the window until the input for
+// the next operation is available or the end of src has been reached.
+// The decoder doesn't change its state for an error of src, so the
+// call can be retried. The method does nothing, if src is not used.
+func (d *rangeDecoder) prefetch() error {
+	if d.src == nil || d.srcEOF || len(d.buf) >= maxOpInput {
+		return nil
+	}
+	k := copy(d.win, d.buf)
+	d.buf = d.win[:k]
+	for len(d.buf) < maxOpInput {
+		n, err := d.src.Read(d.win[len(d.buf):])
+		d.srcN += int64(n)
+		d.buf = d.win[:len(d.buf)+n]
+		if err == io.EOF {
+			d.srcEOF = true
+			return nil
+		}
+		if err != nil {
+			return &sourceError{err}
+		}
+	}
+	return nil
 }
 
-// init initializes the range decoder, by reading from the byte reader.
+// consumed returns the number of compressed bytes consumed since init.
+func (d *rangeDecoder) consumed() int64 {
+	if d.src != nil {
+		return d.srcN - int64(len(d.buf))
+	}
+	return d.n
+}
+
+// Errors returned for invalid initial bytes of the range decoder.
+var (
+	errFirstByte = errors.New("first byte of range decoder not zero")
+	errInitCode  = errors.New("initial code of range decoder out of range")
+)
+
+// init initializes the range decoder, by reading from the input.
 func (d *rangeDecoder) init() error {
 	d.nrange = 0xffffffff
 	d.code = 0
+	d.err = nil
+	d.n = 0
 
-	b, err := d.br.ReadByte()
-	if err != nil {
-		return err
+	b := d.readByte()
+	if d.err != nil {
+		return d.err
 	}
 	if b != 0 {
-		return errors.New("newRangeDecoder: first byte not zero")
+		d.err = errFirstByte
+		return d.err
 	}
 
 	for i := 0; i < 4; i++ {
-		if err = d.updateCode(); err != nil {
-			return err
-		}
+		d.code = (d.code << 8) | uint32(d.readByte())
+	}
+	if d.err != nil {
+		return d.err
 	}
 
 	if d.code >= d.nrange {
-		return errors.New("newRangeDecoder: d.code >= d.nrange")
+		d.err = errInitCode
+		return d.err
 	}
 
 	return nil
@@ -160,26 +276,10 @@ func (d *rangeDecoder) init() error {
 // newRangeDecoder initializes a range decoder. It reads five bytes from the
 // reader and therefore may return an error.
 func newRangeDecoder(br io.ByteReader) (d *rangeDecoder, err error) {
-	d = &rangeDecoder{br: br, nrange: 0xffffffff}
-
-	b, err := d.br.ReadByte()
-	if err != nil {
+	d = &rangeDecoder{br: br}
+	if err = d.init(); err != nil {
 		return nil, err
 	}
-	if b != 0 {
-		return nil, errors.New("newRangeDecoder: first byte not zero")
-	}
-
-	for i := 0; i < 4; i++ {
-		if err = d.updateCode(); err != nil {
-			return nil, err
-		}
-	}
-
-	if d.code >= d.nrange {
-		return nil, errors.New("newRangeDecoder: d.code >= d.nrange")
-	}
-
 	return d, nil
 }
 
@@ -191,7 +291,7 @@ func (d *rangeDecoder) possiblyAtEnd() bool {
 // DirectDecodeBit decodes a bit with probability 1/2. The return value b will
 // contain the bit at the least-significant position. All other bits will be
 // zero.
-func (d *rangeDecoder) DirectDecodeBit() (b uint32, err error) {
+func (d *rangeDecoder) DirectDecodeBit() (b uint32) {
 	d.nrange >>= 1
 	d.code -= d.nrange
 	t := 0 - (d.code >> 31)
@@ -203,46 +303,78 @@ func (d *rangeDecoder) DirectDecodeBit() (b uint32, err error) {
 	// normalize
 	// assume d.code < d.nrange
 	const top = 1 << 24
-	if d.nrange >= top {
-		return b, nil
+	if d.nrange < top {
+		d.shift()
 	}
-	d.nrange <<= 8
-	// d.code < d.nrange will be maintained
-	return b, d.updateCode()
+	return b
 }
 
-// decodeBit decodes a single bit. The bit will be returned at the
-// least-significant position. All other bits will be zero. The probability
-// value will be updated.
-func (d *rangeDecoder) DecodeBit(p *prob) (b uint32, err error) {
-	bound := p.bound(d.nrange)
-	if d.code < bound {
-		d.nrange = bound
-		p.inc()
-		b = 0
-	} else {
-		d.code -= bound
-		d.nrange -= bound
-		p.dec()
-		b = 1
-	}
+// decodeBit decodes a single bit using the given range and code values
+// and updates the probability value. It returns the bit and the new
+// range and code values, but doesn't normalize them. The bit is
+// computed without branches, since the bits of compressed data are hard
+// to predict. The function is small enough to be inlined into the loops
+// of the tree decoders, which keep range and code in local variables.
+func decodeBit(nrange, code uint32, p *prob) (b, r, c uint32) {
+	v := uint32(*p)
+	bound := (nrange >> probbits) * v
+	b = uint32((uint64(bound) - uint64(code) - 1) >> 63)
+	mask := 0 - b
+	c = code - bound&mask
+	r = bound&^mask | (nrange-bound)&mask
+	// p += (1<<probbits - p) >> movebits for b == 0
+	// p -= p >> movebits for b == 1
+	*p = prob(int32(v) + (int32((1<<probbits)&^mask)-int32(v)+
+		int32(31&mask))>>movebits)
+	return b, r, c
+}
+
+// DecodeBit decodes a single bit. The bit will be returned at the
+// least-significant position. All other bits will be zero. The
+// probability value will be updated.
+func (d *rangeDecoder) DecodeBit(p *prob) (b uint32) {
+	b, d.nrange, d.code = decodeBit(d.nrange, d.code, p)
 	// normalize
 	// assume d.code < d.nrange
 	const top = 1 << 24
-	if d.nrange >= top {
-		return b, nil
+	if d.nrange < top {
+		d.shift()
 	}
-	d.nrange <<= 8
-	// d.code < d.nrange will be maintained
-	return b, d.updateCode()
+	return b
 }
 
-// updateCode reads a new byte into the code.
-func (d *rangeDecoder) updateCode() error {
-	b, err := d.br.ReadByte()
+// shift normalizes the range and reads a new byte into the code.
+func (d *rangeDecoder) shift() {
+	d.nrange, d.code = d.normalize(d.nrange, d.code)
+}
+
+// normalize shifts the range and reads a new byte into the code for the
+// values given. It is used by the loops keeping range and code in local
+// variables. The invariant code < range is maintained.
+func (d *rangeDecoder) normalize(nrange, code uint32) (r, c uint32) {
+	return nrange << 8, (code << 8) | uint32(d.readByte())
+}
+
+// readByte returns the next input byte. If no byte can be read the
+// error is recorded and zero is returned.
+func (d *rangeDecoder) readByte() byte {
+	if len(d.buf) > 0 {
+		c := d.buf[0]
+		d.buf = d.buf[1:]
+		return c
+	}
+	if d.err != nil {
+		return 0
+	}
+	if d.br == nil {
+		d.err = io.EOF
+		return 0
+	}
+	c, err := d.br.ReadByte()
 	if err != nil {
-		return err
+		d.err = err
+		return 0
 	}
-	d.code = (d.code << 8) | uint32(b)
-	return nil
+	d.n++
+	return c
 }
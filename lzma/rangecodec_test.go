@@ -0,0 +1,133 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/ulikunitz/xz/rangecodec"
+)
+
+// byteOnlyWriter supports only the WriteByte method of bytes.Buffer.
+type byteOnlyWriter struct{ buf bytes.Buffer }
+
+func (w *byteOnlyWriter) WriteByte(c byte) error { return w.buf.WriteByte(c) }
+
+// callCounter counts the calls of Write and WriteByte.
+type callCounter struct {
+	buf        bytes.Buffer
+	writes     int
+	byteWrites int
+}
+
+func (w *callCounter) Write(p []byte) (n int, err error) {
+	w.writes++
+	return w.buf.Write(p)
+}
+
+func (w *callCounter) WriteByte(c byte) error {
+	w.byteWrites++
+	return w.buf.WriteByte(c)
+}
+
+func encodeBits(t *testing.T, e *rangeEncoder, n int) {
+	var p prob = probInit
+	for i := 0; i < n; i++ {
+		if err := e.EncodeBit(uint32(i*7/5), &p); err != nil {
+			t.Fatalf("EncodeBit error %s", err)
+		}
+		if err := e.DirectEncodeBit(uint32(i)); err != nil {
+			t.Fatalf("DirectEncodeBit error %s", err)
+		}
+	}
+	if err := e.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+}
+
+func TestRangeEncoderBuffer(t *testing.T) {
+	const bits = 100000
+	var bw byteOnlyWriter
+	e, err := newRangeEncoder(&bw)
+	if err != nil {
+		t.Fatalf("newRangeEncoder error %s", err)
+	}
+	encodeBits(t, e, bits)
+
+	var cc callCounter
+	if e, err = newRangeEncoder(&cc); err != nil {
+		t.Fatalf("newRangeEncoder error %s", err)
+	}
+	encodeBits(t, e, bits)
+	if !bytes.Equal(cc.buf.Bytes(), bw.buf.Bytes()) {
+		t.Fatalf("buffered output differs")
+	}
+	if cc.byteWrites != 0 {
+		t.Fatalf("WriteByte has been called %d times", cc.byteWrites)
+	}
+	want := (cc.buf.Len() + rangeEncoderBufSize - 1) / rangeEncoderBufSize
+	if cc.writes != want {
+		t.Fatalf("Write has been called %d times; want %d", cc.writes,
+			want)
+	}
+}
+
+func TestRangeCodecCompat(t *testing.T) {
+	const bits = 10000
+	var buf bytes.Buffer
+	e, err := newRangeEncoder(&buf)
+	if err != nil {
+		t.Fatalf("newRangeEncoder error %s", err)
+	}
+	encodeBits(t, e, bits)
+	d, err := rangecodec.NewDecoder(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("rangecodec.NewDecoder error %s", err)
+	}
+	var rp rangecodec.Prob = rangecodec.ProbInit
+	for i := 0; i < bits; i++ {
+		if b := d.DecodeBit(&rp); b != uint32(i*7/5)&1 {
+			t.Fatalf("bit %d: DecodeBit returned %d", i, b)
+		}
+		if b := d.DecodeDirect(1); b != uint32(i)&1 {
+			t.Fatalf("bit %d: DecodeDirect returned %d", i, b)
+		}
+	}
+	if d.Err() != nil || !d.PossiblyAtEnd() {
+		t.Fatalf("rangecodec decoder not at end; error %v", d.Err())
+	}
+
+	buf.Reset()
+	re := rangecodec.NewEncoder(&buf)
+	rp = rangecodec.ProbInit
+	for i := 0; i < bits; i++ {
+		if err = re.EncodeBit(uint32(i*7/5), &rp); err != nil {
+			t.Fatalf("EncodeBit error %s", err)
+		}
+		if err = re.EncodeDirect(uint32(i), 1); err != nil {
+			t.Fatalf("EncodeDirect error %s", err)
+		}
+	}
+	if err = re.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	ld, err := newRangeDecoder(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("newRangeDecoder error %s", err)
+	}
+	var p prob = probInit
+	for i := 0; i < bits; i++ {
+		if b := ld.DecodeBit(&p); b != uint32(i*7/5)&1 {
+			t.Fatalf("bit %d: DecodeBit returned %d", i, b)
+		}
+		if b := ld.DirectDecodeBit(); b != uint32(i)&1 {
+			t.Fatalf("bit %d: DirectDecodeBit returned %d", i, b)
+		}
+	}
+	if ld.err != nil || !ld.possiblyAtEnd() {
+		t.Fatalf("lzma decoder not at end; error %v", ld.err)
+	}
+}
@@ -8,6 +8,12 @@
 //
 // The package is written completely in Go and doesn't rely on any external
 // library.
+//
+// The writers produce deterministic output: the same data written with
+// the same configuration is always compressed to the same bytes,
+// independent of the platform and the sizes of the Write calls. The
+// exception is a positive Writer2Config.MaxDelay: the flushes by its
+// timer depend on the timing of the Write calls and change the output.
 package lzma
 
 import (
@@ -19,6 +25,39 @@ import (
 // format.
 type ReaderConfig struct {
 	DictCap int
+	// Tee receives all decompressed data before it is returned by
+	// Read. The data is directly copied out of the dictionary, so no
+	// additional buffer is required.
+	Tee io.Writer
+	// Growth controls the allocation of the dictionary buffer. Zero
+	// values select the defaults.
+	Growth Growth
+	// DictBuf provides the memory for the dictionary buffer, which
+	// is then neither allocated nor grown by the reader. Its
+	// capacity must exceed the dictionary capacity of the stream by
+	// at least one byte; otherwise ErrDictBuf is returned.
+	DictBuf []byte
+	// PresetDict provides the initial content of the dictionary. It
+	// must be the preset dictionary used for compression.
+	PresetDict []byte
+	// MemoryLimit limits the memory in bytes required by the decoder
+	// for the dictionary and the probability model. Streams requiring
+	// more memory are rejected with ErrMemoryLimit. The value 0
+	// disables the limit.
+	MemoryLimit int64
+	// BufSize is the size of the buffer for compressed data read
+	// from sources that don't implement io.ByteReader. Such sources
+	// are read byte by byte, if BufSize is zero. Note that the
+	// buffer may consume data following the LZMA stream. The buffer
+	// holds the input for the next operation before it is decoded,
+	// so errors of the source, like timeouts, can be retried.
+	BufSize int
+	// FillSize limits the number of bytes decoded in one step. Read
+	// returns the decoded data without decoding more to fill p
+	// completely. Small values reduce the latency for interactive
+	// streams, while the value 0 fills the whole dictionary buffer
+	// for the best throughput.
+	FillSize int
 }
 
 // fill converts the zero values of the configuration to the default values.
@@ -33,7 +72,19 @@ func (c *ReaderConfig) fill() {
 func (c *ReaderConfig) Verify() error {
 	c.fill()
 	if !(MinDictCap <= c.DictCap && int64(c.DictCap) <= MaxDictCap) {
-		return errors.New("lzma: dictionary capacity is out of range")
+		return ErrDictCap
+	}
+	if err := c.Growth.Verify(); err != nil {
+		return err
+	}
+	if c.MemoryLimit < 0 {
+		return errors.New("lzma: MemoryLimit must not be negative")
+	}
+	if c.BufSize < 0 {
+		return errors.New("lzma: BufSize must not be negative")
+	}
+	if c.FillSize < 0 {
+		return errors.New("lzma: FillSize must not be negative")
 	}
 	return nil
 }
@@ -43,6 +94,11 @@ type Reader struct {
 	lzma io.Reader
 	h    header
 	d    *decoder
+	c    ReaderConfig
+	// no header is read by Reset
+	raw bool
+	// window for sources without ReadByte method; kept for Reset
+	win []byte
 }
 
 // NewReader creates a new reader for an LZMA stream using the classic
@@ -54,47 +110,202 @@ func NewReader(lzma io.Reader) (r *Reader, err error) {
 // NewReader creates a new reader for an LZMA stream in the classic
 // format. The function reads and verifies the the header of the LZMA
 // stream.
+//
+// If the header declares the uncompressed size, the stream may end with
+// or without end-of-stream marker. A size of all ones declares the size
+// as unknown and the end-of-stream marker is required. Read returns
+// *ErrSizeMismatch if the marker is found before the declared size has
+// been reached or if the stream contains more data than declared; the
+// error unwraps to ErrUnexpectedEOS or ErrStreamTooLong. If the stream
+// is truncated, io.ErrUnexpectedEOF is returned.
 func (c ReaderConfig) NewReader(lzma io.Reader) (r *Reader, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
+	h, err := c.readHeader(lzma)
+	if err != nil {
+		return nil, err
+	}
+	return c.newReader(lzma, h)
+}
+
+// readHeader reads the header of the classic format and adapts the
+// dictionary capacity to the configuration.
+func (c *ReaderConfig) readHeader(lzma io.Reader) (h header, err error) {
 	data := make([]byte, HeaderLen)
 	if _, err := io.ReadFull(lzma, data); err != nil {
 		if err == io.EOF {
-			return nil, errors.New("lzma: unexpected EOF")
+			err = io.ErrUnexpectedEOF
 		}
+		return h, err
+	}
+	if err = h.unmarshalBinary(data); err != nil {
+		return h, err
+	}
+	if h.dictCap < MinDictCap {
+		return h, ErrDictCap
+	}
+	// With a memory limit only the dictionary capacity declared by
+	// the header is used.
+	if int64(c.DictCap) > h.dictCap && c.MemoryLimit == 0 {
+		h.dictCap = int64(c.DictCap)
+	}
+	return h, nil
+}
+
+// NewRawReader creates a reader for an LZMA stream without the header
+// of the classic format, as it is embedded by container formats. The
+// properties and the size of the uncompressed data must be provided by
+// the caller; a negative size indicates an unknown size and requires
+// the end-of-stream marker. The dictionary capacity is given by the
+// configuration.
+func (c ReaderConfig) NewRawReader(lzma io.Reader, p Properties,
+	size int64) (r *Reader, err error) {
+
+	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	r = &Reader{lzma: lzma}
-	if err = r.h.unmarshalBinary(data); err != nil {
+	if err = p.verify(); err != nil {
 		return nil, err
 	}
-	if r.h.dictCap < MinDictCap {
-		return nil, errors.New("lzma: dictionary capacity too small")
+	if size < 0 {
+		size = -1
 	}
-	dictCap := r.h.dictCap
-	if c.DictCap > dictCap {
-		dictCap = c.DictCap
+	h := header{properties: p, dictCap: int64(c.DictCap), size: size}
+	if r, err = c.newReader(lzma, h); err != nil {
+		return nil, err
 	}
+	r.raw = true
+	return r, nil
+}
+
+// NewRawReader creates a reader for an LZMA stream without header
+// using the given properties, dictionary capacity and uncompressed
+// size. A negative size indicates an unknown size.
+func NewRawReader(lzma io.Reader, p Properties, dictCap int,
+	size int64) (r *Reader, err error) {
+	return ReaderConfig{DictCap: dictCap}.NewRawReader(lzma, p, size)
+}
+
+// newReader creates the reader for the stream following the header.
+func (c *ReaderConfig) newReader(lzma io.Reader, h header) (r *Reader,
+	err error) {
 
-	state := newState(r.h.properties)
-	dict, err := newDecoderDict(dictCap)
+	if err = checkMemory(c.MemoryLimit, h.properties, h.dictCap); err != nil {
+		return nil, err
+	}
+	dictCap, err := dictCapInt(h.dictCap)
 	if err != nil {
 		return nil, err
 	}
-	r.d, err = newDecoder(ByteReader(lzma), state, dict, r.h.size)
+	r = &Reader{lzma: lzma, h: h, c: *c}
+	state := NewState(h.properties)
+	dict, err := newGrowingDecoderDict(dictCap, c.Growth, c.DictBuf)
 	if err != nil {
 		return nil, err
 	}
+	dict.Preset(c.PresetDict)
+	dict.tee = c.Tee
+	r.d = &decoder{State: state, Dict: dict, rd: new(rangeDecoder),
+		fillSize: c.FillSize}
+	if err = r.reopen(lzma, h.size); err != nil {
+		return nil, err
+	}
 	return r, nil
 }
 
+// Reset discards the state of the reader and starts reading a new
+// stream from lzma using the original configuration. The header of the
+// new stream is read, unless the reader has been created by
+// NewRawReader, which reuses properties and size. The dictionary
+// buffer is reused if the dictionary capacity doesn't change, so
+// readers for many small streams can be kept in a sync.Pool.
+func (r *Reader) Reset(lzma io.Reader) error {
+	h := r.h
+	if !r.raw {
+		var err error
+		if h, err = r.c.readHeader(lzma); err != nil {
+			return err
+		}
+	}
+	err := checkMemory(r.c.MemoryLimit, h.properties, h.dictCap)
+	if err != nil {
+		return err
+	}
+	dictCap, err := dictCapInt(h.dictCap)
+	if err != nil {
+		return err
+	}
+	d := r.d
+	if dictCap == d.Dict.capacity {
+		d.Dict.clear()
+	} else {
+		dict, err := newGrowingDecoderDict(dictCap, r.c.Growth,
+			r.c.DictBuf)
+		if err != nil {
+			return err
+		}
+		dict.tee = r.c.Tee
+		d.Dict = dict
+	}
+	d.Dict.Preset(r.c.PresetDict)
+	// The probability array is reused if it is large enough.
+	d.State.Properties = h.properties
+	d.State.Reset()
+	d.eosMarker = false
+	d.resetStats()
+	r.lzma = lzma
+	r.h = h
+	return r.reopen(lzma, h.size)
+}
+
+// reopen restarts the decoder for the compressed data provided by
+// lzma. A source that doesn't implement io.ByteReader is read in blocks
+// of BufSize bytes, if BufSize is positive.
+func (r *Reader) reopen(lzma io.Reader, size int64) error {
+	if _, ok := lzma.(io.ByteReader); ok || r.c.BufSize == 0 {
+		return r.d.Reopen(ByteReader(lzma), size)
+	}
+	if r.win == nil {
+		n := r.c.BufSize
+		if n < 2*maxOpInput {
+			n = 2 * maxOpInput
+		}
+		r.win = make([]byte, n)
+	}
+	return r.d.ReopenReader(lzma, r.win, size)
+}
+
+// Size returns the size of the uncompressed data declared by the
+// header. A negative value indicates that the size is unknown and the
+// stream must be terminated by an EOS marker.
+func (r *Reader) Size() int64 {
+	return r.h.size
+}
+
 // EOSMarker indicates that an EOS marker has been encountered.
 func (r *Reader) EOSMarker() bool {
 	return r.d.eosMarker
 }
 
-// Read returns uncompressed data.
+// Read returns uncompressed data. Errors for malformed streams and
+// io.ErrUnexpectedEOF are fatal. Errors of the underlying reader are
+// fatal too, unless the compressed data is read in blocks as described
+// for ReaderConfig.BufSize; then Read can be called again after them.
 func (r *Reader) Read(p []byte) (n int, err error) {
 	return r.d.Read(p)
 }
+
+// ReadByte returns the next byte of the uncompressed data. It implements
+// io.ByteReader and is much faster than Read for single bytes, so
+// parsers reading byte by byte don't need a bufio.Reader. The errors
+// are the same as for Read.
+func (r *Reader) ReadByte() (c byte, err error) {
+	return r.d.ReadByte()
+}
+
+// WriteTo writes the uncompressed data to w. It implements io.WriterTo
+// and avoids the copying of the data into the buffer of io.Copy.
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	return r.d.WriteTo(w)
+}
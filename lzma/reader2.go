@@ -7,14 +7,44 @@ package lzma
 import (
 	"errors"
 	"io"
-
-	"github.com/ulikunitz/xz/internal/xlog"
 )
 
 // Reader2Config stores the parameters for the LZMA2 reader.
 // format.
 type Reader2Config struct {
 	DictCap int
+	// Tee receives all decompressed data before it is returned by
+	// Read. The data is directly copied out of the dictionary, so no
+	// additional buffer is required.
+	Tee io.Writer
+	// Growth controls the allocation of the dictionary buffer. Zero
+	// values select the defaults.
+	Growth Growth
+	// DictBuf provides the memory for the dictionary buffer, which
+	// is then neither allocated nor grown by the reader. Its
+	// capacity must be at least DictCap+1 bytes.
+	DictBuf []byte
+	// PresetDict provides the initial content of the dictionary. It
+	// must be the preset dictionary used for compression.
+	PresetDict []byte
+	// MemoryLimit limits the memory in bytes required by the decoder
+	// for the dictionary and the probability model. Streams requiring
+	// more memory are rejected with ErrMemoryLimit. The value 0
+	// disables the limit.
+	MemoryLimit int64
+	// Progress is called after each chunk has been read with the
+	// total numbers of compressed and uncompressed bytes read so
+	// far.
+	Progress func(compressed, uncompressed int64)
+	// Logger receives debug messages for the chunk headers read. If
+	// it is nil, nothing is logged.
+	Logger Logger
+	// FillSize limits the number of bytes decoded in one step. Read
+	// returns the decoded data without decoding more to fill p
+	// completely. Small values reduce the latency for interactive
+	// streams, while the value 0 fills the whole dictionary buffer
+	// for the best throughput.
+	FillSize int
 }
 
 // fill converts the zero values of the configuration to the default values.
@@ -29,7 +59,19 @@ func (c *Reader2Config) fill() {
 func (c *Reader2Config) Verify() error {
 	c.fill()
 	if !(MinDictCap <= c.DictCap && int64(c.DictCap) <= MaxDictCap) {
-		return errors.New("lzma: dictionary capacity is out of range")
+		return ErrDictCap
+	}
+	if err := c.Growth.Verify(); err != nil {
+		return err
+	}
+	if err := verifyDictBuf(c.DictBuf, c.DictCap); err != nil {
+		return err
+	}
+	if c.MemoryLimit < 0 {
+		return errors.New("lzma: MemoryLimit must not be negative")
+	}
+	if c.FillSize < 0 {
+		return errors.New("lzma: FillSize must not be negative")
 	}
 	return nil
 }
@@ -38,17 +80,45 @@ func (c *Reader2Config) Verify() error {
 // first chunk should have a dictionary reset and the first compressed
 // chunk a properties reset. The chunk sequence may not be terminated by
 // an end-of-stream chunk.
+//
+// Errors of the underlying reader don't stop the reader. The data read
+// before the error is kept, so Read can be called again, for instance
+// after a network timeout. Errors for malformed streams and
+// io.ErrUnexpectedEOF are fatal; they are returned by all further calls.
 type Reader2 struct {
 	r   io.Reader
 	err error
 
-	dict        *decoderDict
+	dict        *DecoderDict
 	ur          *uncompressedReader
 	decoder     *decoder
-	chunkReader io.Reader
+	chunkReader chunkReader
 
 	cstate chunkState
 	ctype  chunkType
+
+	// chunk header, its buffer and the number of header bytes read
+	header chunkHeader
+	hbuf   [maxChunkHeaderLen]byte
+	hn     int
+	// buffer for the data of a compressed chunk and the number of
+	// bytes read into it; the header of the chunk has been read
+	// completely, if cpending is set
+	cbuf     []byte
+	cn       int
+	cpending bool
+
+	// byte counts for the progress function
+	progress     func(compressed, uncompressed int64)
+	compressed   int64
+	uncompressed int64
+	// sizes of the current chunk
+	chunkCompressed   int64
+	chunkUncompressed int64
+
+	presetDict []byte
+	logger     Logger
+	fillSize   int
 }
 
 // NewReader2 creates a reader for an LZMA2 chunk sequence.
@@ -61,45 +131,120 @@ func (c Reader2Config) NewReader2(lzma2 io.Reader) (r *Reader2, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	r = &Reader2{r: lzma2, cstate: start}
-	r.dict, err = newDecoderDict(c.DictCap)
+	// The properties may change with every chunk, so the largest
+	// literal coder supported by LZMA2 must be assumed.
+	p := Properties{LC: 4, LP: 0, PB: 4}
+	if err = checkMemory(c.MemoryLimit, p, int64(c.DictCap)); err != nil {
+		return nil, err
+	}
+	r = &Reader2{r: lzma2, cstate: start, progress: c.Progress,
+		presetDict: c.PresetDict, logger: c.Logger,
+		fillSize: c.FillSize}
+	r.dict, err = newGrowingDecoderDict(c.DictCap, c.Growth, c.DictBuf)
 	if err != nil {
 		return nil, err
 	}
+	if len(c.PresetDict) > 0 {
+		r.dict.Preset(c.PresetDict)
+		// the first chunk must not reset the dictionary
+		r.cstate = 'R'
+	}
+	r.dict.tee = c.Tee
 	if err = r.startChunk(); err != nil {
-		r.err = err
+		sticky(&r.err, err)
 	}
 	return r, nil
 }
 
+// Reset discards the state of the reader and starts reading a new LZMA2
+// stream from lzma2 using the original configuration. The dictionary
+// buffer is reused, so readers can be kept in a sync.Pool. An error
+// reading the first chunk header is returned by Reset and by Read.
+func (r *Reader2) Reset(lzma2 io.Reader) error {
+	r.r = lzma2
+	r.err = nil
+	r.chunkReader = nil
+	r.hn, r.cpending = 0, false
+	r.cstate = start
+	r.dict.clear()
+	if len(r.presetDict) > 0 {
+		r.dict.Preset(r.presetDict)
+		r.cstate = 'R'
+	}
+	r.compressed = 0
+	r.uncompressed = 0
+	if r.decoder != nil {
+		r.decoder.resetStats()
+	}
+	if err := r.startChunk(); err != nil {
+		if err = sticky(&r.err, err); err != io.EOF {
+			return err
+		}
+	}
+	return nil
+}
+
 // uncompressed tests whether the chunk type specifies an uncompressed
 // chunk.
 func uncompressed(ctype chunkType) bool {
 	return ctype == cU || ctype == cUD
 }
 
-// startChunk parses a new chunk.
+// readHeader reads the next chunk header. The bytes read are kept, so
+// the method can be called again after an error of the source.
+func (r *Reader2) readHeader() error {
+	n := 1
+	for {
+		if err := readSource(r.r, r.hbuf[:n], &r.hn); err != nil {
+			return err
+		}
+		if n > 1 {
+			break
+		}
+		c, err := headerChunkType(r.hbuf[0])
+		if err != nil {
+			return err
+		}
+		if n = headerLen(c); n == 1 {
+			break
+		}
+	}
+	r.hn = 0
+	return r.header.UnmarshalBinary(r.hbuf[:n])
+}
+
+// startChunk parses a new chunk. After an error of the source, which
+// is returned as sourceError, startChunk continues where it stopped.
 func (r *Reader2) startChunk() error {
 	r.chunkReader = nil
-	header, err := readChunkHeader(r.r)
+	if r.cpending {
+		return r.readCompressed()
+	}
+	header := &r.header
+	err := r.readHeader()
 	if err != nil {
-		if err == io.EOF {
-			err = io.ErrUnexpectedEOF
-		}
 		return err
 	}
-	xlog.Debugf("chunk header %v", header)
+	if r.logger != nil {
+		r.logger.Debugf("chunk header %v", header)
+	}
 	if err = r.cstate.next(header.ctype); err != nil {
 		return err
 	}
 	if r.cstate == stop {
+		r.compressed++
+		r.reportProgress()
 		return io.EOF
 	}
 	if header.ctype == cUD || header.ctype == cLRND {
 		r.dict.Reset()
 	}
 	size := int64(header.uncompressed) + 1
+	r.chunkUncompressed = size
+	r.chunkCompressed = int64(headerLen(header.ctype)) +
+		int64(header.compressed) + 1
 	if uncompressed(header.ctype) {
+		r.chunkCompressed = uncompressedHeaderLen + size
 		if r.ur != nil {
 			r.ur.Reopen(r.r, size)
 		} else {
@@ -108,73 +253,151 @@ func (r *Reader2) startChunk() error {
 		r.chunkReader = r.ur
 		return nil
 	}
-	br := ByteReader(io.LimitReader(r.r, int64(header.compressed)+1))
+	r.cpending, r.cn = true, 0
+	return r.readCompressed()
+}
+
+// readCompressed reads the data of the compressed chunk completely, so
+// the range decoder can work on the byte slice, and prepares the
+// decoder for the chunk.
+func (r *Reader2) readCompressed() error {
+	header := &r.header
+	size := int64(header.uncompressed) + 1
+	if r.cbuf == nil {
+		r.cbuf = make([]byte, maxCompressed)
+	}
+	p := r.cbuf[:int(header.compressed)+1]
+	if err := readSource(r.r, p, &r.cn); err != nil {
+		return err
+	}
+	r.cpending = false
 	if r.decoder == nil {
-		state := newState(header.props)
-		r.decoder, err = newDecoder(br, state, r.dict, size)
-		if err != nil {
-			return err
+		r.decoder = &decoder{
+			State:    NewState(header.props),
+			Dict:     r.dict,
+			rd:       new(rangeDecoder),
+			fillSize: r.fillSize,
+		}
+	} else {
+		switch header.ctype {
+		case cLR:
+			r.decoder.State.Reset()
+		case cLRN, cLRND:
+			r.decoder.State.Properties = header.props
+			r.decoder.State.Reset()
 		}
-		r.chunkReader = r.decoder
-		return nil
-	}
-	switch header.ctype {
-	case cLR:
-		r.decoder.State.Reset()
-	case cLRN, cLRND:
-		r.decoder.State = newState(header.props)
 	}
-	err = r.decoder.Reopen(br, size)
-	if err != nil {
+	if err := r.decoder.ReopenBytes(p, size); err != nil {
 		return err
 	}
 	r.chunkReader = r.decoder
 	return nil
 }
 
-// Read reads data from the LZMA2 chunk sequence.
+// Read reads data from the LZMA2 chunk sequence. If data has been read,
+// Read returns at the end of a chunk without waiting for the next chunk
+// header.
 func (r *Reader2) Read(p []byte) (n int, err error) {
 	if r.err != nil {
 		return 0, r.err
 	}
 	for n < len(p) {
+		if r.chunkReader == nil {
+			// Don't wait for the next chunk header, if data
+			// is available.
+			if n > 0 {
+				return n, nil
+			}
+			if err = r.startChunk(); err != nil {
+				return n, sticky(&r.err, err)
+			}
+		}
 		var k int
 		k, err = r.chunkReader.Read(p[n:])
 		n += k
 		if err != nil {
 			if err == io.EOF {
-				err = r.startChunk()
-				if err == nil {
-					continue
-				}
+				r.endChunk()
+				continue
 			}
-			r.err = err
+			// Errors stopping the chunk reader are sticky
+			// there.
 			return n, err
 		}
 		if k == 0 {
 			r.err = errors.New("lzma: Reader2 doesn't get data")
 			return n, r.err
 		}
+		if r.fillSize > 0 {
+			return n, nil
+		}
 	}
 	return n, nil
 }
 
+// WriteTo writes the uncompressed data to w. It implements io.WriterTo
+// and avoids the copying of the data into the buffer of io.Copy.
+func (r *Reader2) WriteTo(w io.Writer) (n int64, err error) {
+	for r.err == nil {
+		if r.chunkReader == nil {
+			if err = r.startChunk(); err != nil {
+				if err = sticky(&r.err, err); err != io.EOF {
+					return n, err
+				}
+				break
+			}
+		}
+		var k int64
+		k, err = r.chunkReader.WriteTo(w)
+		n += k
+		if err != nil {
+			return n, err
+		}
+		r.endChunk()
+	}
+	if r.err == io.EOF {
+		return n, nil
+	}
+	return n, r.err
+}
+
+// endChunk finishes the current chunk and reports the progress.
+func (r *Reader2) endChunk() {
+	r.chunkReader = nil
+	r.compressed += r.chunkCompressed
+	r.uncompressed += r.chunkUncompressed
+	r.reportProgress()
+}
+
+// reportProgress calls the progress function if it has been set.
+func (r *Reader2) reportProgress() {
+	if r.progress != nil {
+		r.progress(r.compressed, r.uncompressed)
+	}
+}
+
 // EOS returns whether the LZMA2 stream has been terminated by an
 // end-of-stream chunk.
 func (r *Reader2) EOS() bool {
 	return r.cstate == stop
 }
 
+// chunkReader is implemented by the readers for the chunk types.
+type chunkReader interface {
+	io.Reader
+	io.WriterTo
+}
+
 // uncompressedReader is used to read uncompressed chunks.
 type uncompressedReader struct {
 	lr   io.LimitedReader
-	Dict *decoderDict
+	Dict *DecoderDict
 	eof  bool
 	err  error
 }
 
 // newUncompressedReader initializes a new uncompressedReader.
-func newUncompressedReader(r io.Reader, dict *decoderDict, size int64) *uncompressedReader {
+func newUncompressedReader(r io.Reader, dict *DecoderDict, size int64) *uncompressedReader {
 	ur := &uncompressedReader{
 		lr:   io.LimitedReader{R: r, N: size},
 		Dict: dict,
@@ -194,7 +417,10 @@ func (ur *uncompressedReader) fill() error {
 	if !ur.eof {
 		n, err := io.CopyN(ur.Dict, &ur.lr, int64(ur.Dict.Available()))
 		if err != io.EOF {
-			return err
+			if err != nil {
+				return &sourceError{err}
+			}
+			return nil
 		}
 		ur.eof = true
 		if n > 0 {
@@ -216,17 +442,41 @@ func (ur *uncompressedReader) Read(p []byte) (n int, err error) {
 		var k int
 		k, err = ur.Dict.Read(p[n:])
 		n += k
+		if err != nil {
+			break
+		}
 		if n >= len(p) {
 			return n, nil
 		}
+		err = ur.fill()
 		if err != nil {
 			break
 		}
-		err = ur.fill()
+	}
+	return n, sticky(&ur.err, err)
+}
+
+// WriteTo writes the uncompressed data to w.
+func (ur *uncompressedReader) WriteTo(w io.Writer) (n int64, err error) {
+	if ur.err != nil {
+		if ur.err == io.EOF {
+			return 0, nil
+		}
+		return 0, ur.err
+	}
+	for {
+		var k int64
+		k, err = ur.Dict.WriteTo(w)
+		n += k
 		if err != nil {
 			break
 		}
+		if err = ur.fill(); err != nil {
+			break
+		}
+	}
+	if err = sticky(&ur.err, err); err == io.EOF {
+		return n, nil
 	}
-	ur.err = err
 	return n, err
 }
@@ -7,13 +7,17 @@ package lzma
 import (
 	"bufio"
 	"bytes"
+	"errors"
 	"io"
 	"io/ioutil"
 	"log"
+	"math/rand"
 	"os"
 	"path/filepath"
 	"testing"
 	"testing/iotest"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
 )
 
 func TestNewReader(t *testing.T) {
@@ -112,6 +116,92 @@ func TestReaderAll(t *testing.T) {
 	}
 }
 
+// withSize returns a copy of the LZMA file with the size in the header
+// replaced. A negative size marks the size as unknown.
+func withSize(data []byte, size int64) []byte {
+	p := append([]byte(nil), data...)
+	s := noHeaderSize
+	if size >= 0 {
+		s = uint64(size)
+	}
+	putUint64LE(p[5:], s)
+	return p
+}
+
+func TestReaderSizeModes(t *testing.T) {
+	read := func(name string) []byte {
+		p, err := ioutil.ReadFile(filepath.Join("examples", name))
+		if err != nil {
+			t.Fatalf("ReadFile error %s", err)
+		}
+		return p
+	}
+	a, aEOS, aEOSSize := read("a.lzma"), read("a_eos.lzma"),
+		read("a_eos_and_size.lzma")
+	orig := readOrigFile(t)
+	n := int64(len(orig))
+	tests := []struct {
+		name   string
+		data   []byte
+		size   int64
+		marker bool
+		err    error
+	}{
+		{"size without EOS", a, n, false, nil},
+		{"unknown size with EOS", aEOS, -1, true, nil},
+		{"size with EOS", aEOSSize, n, true, nil},
+		{"size added", withSize(aEOS, n), n, true, nil},
+		{"size too small", withSize(a, n-10), n - 10, false,
+			ErrStreamTooLong},
+		{"size too large", withSize(a, n+10), n + 10, false,
+			io.ErrUnexpectedEOF},
+		{"size too large with EOS", withSize(aEOSSize, n+10), n + 10,
+			true, ErrUnexpectedEOS},
+		{"unknown size without EOS", withSize(a, -1), -1, false,
+			io.ErrUnexpectedEOF},
+		{"truncated", aEOS[:len(aEOS)-5], -1, false,
+			io.ErrUnexpectedEOF},
+	}
+	for _, c := range tests {
+		r, err := NewReader(bytes.NewReader(c.data))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", c.name, err)
+		}
+		if r.Size() != c.size {
+			t.Errorf("%s: Size returned %d; want %d", c.name,
+				r.Size(), c.size)
+		}
+		p, err := ioutil.ReadAll(r)
+		if !errors.Is(err, c.err) {
+			t.Errorf("%s: got error %v; want %v", c.name, err, c.err)
+			continue
+		}
+		if c.err == ErrStreamTooLong || c.err == ErrUnexpectedEOS {
+			e, ok := err.(*ErrSizeMismatch)
+			if !ok {
+				t.Errorf("%s: got error %v; want *ErrSizeMismatch",
+					c.name, err)
+				continue
+			}
+			if e.Want != c.size || e.Got != n {
+				t.Errorf("%s: got sizes %d, %d; want %d, %d",
+					c.name, e.Got, e.Want, n, c.size)
+			}
+			continue
+		}
+		if err != nil {
+			continue
+		}
+		if !bytes.Equal(p, orig) {
+			t.Errorf("%s: decompressed data differs", c.name)
+		}
+		if r.EOSMarker() != c.marker {
+			t.Errorf("%s: EOSMarker returned %t; want %t", c.name,
+				r.EOSMarker(), c.marker)
+		}
+	}
+}
+
 //
 func Example_reader() {
 	f, err := os.Open("fox.lzma")
@@ -310,3 +400,443 @@ func TestReaderErrAgain(t *testing.T) {
 		}
 	}
 }
+
+func TestReaderTee(t *testing.T) {
+	orig := readOrigFile(t)
+	f, err := os.Open(filepath.Join(dirname, "a.lzma"))
+	if err != nil {
+		t.Fatalf("Open error %s", err)
+	}
+	defer f.Close()
+	var tee bytes.Buffer
+	r, err := ReaderConfig{Tee: &tee}.NewReader(bufio.NewReader(f))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(decoded, orig) {
+		t.Fatalf("decoded file differs from original")
+	}
+	if !bytes.Equal(tee.Bytes(), orig) {
+		t.Fatalf("tee received %d bytes; want %d", tee.Len(),
+			len(orig))
+	}
+}
+
+func TestReaderWriteTo(t *testing.T) {
+	orig := readOrigFile(t)
+	for _, name := range []string{"a.lzma", "a_eos.lzma"} {
+		f, err := os.Open(filepath.Join(dirname, name))
+		if err != nil {
+			t.Fatalf("Open error %s", err)
+		}
+		r, err := NewReader(bufio.NewReader(f))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		var out bytes.Buffer
+		n, err := r.WriteTo(&out)
+		f.Close()
+		if err != nil {
+			t.Fatalf("%s: WriteTo error %s", name, err)
+		}
+		if n != int64(len(orig)) || !bytes.Equal(out.Bytes(), orig) {
+			t.Fatalf("%s: WriteTo output differs from original",
+				name)
+		}
+	}
+}
+
+// strictReader reports violations of the io.Reader contract by the
+// wrapped reader. It calls Read with an empty slice before each read to
+// check that such calls have no effect. They may only return io.EOF if
+// no more data is available.
+type strictReader struct {
+	t   *testing.T
+	r   io.Reader
+	eof bool
+}
+
+func (s *strictReader) Read(p []byte) (n int, err error) {
+	k, emptyErr := s.r.Read(p[:0])
+	if k != 0 || (emptyErr != nil && emptyErr != io.EOF) {
+		s.t.Errorf("Read of empty slice returned %d, %v", k, emptyErr)
+	}
+	n, err = s.r.Read(p)
+	switch {
+	case emptyErr == io.EOF && (n > 0 || err != io.EOF):
+		s.t.Errorf("Read returned %d, %v after io.EOF for empty slice",
+			n, err)
+	case n < 0 || n > len(p):
+		s.t.Fatalf("Read returned %d for slice length %d", n, len(p))
+	case n == 0 && err == nil:
+		s.t.Errorf("Read returned (0, nil)")
+	case n > 0 && err == io.EOF:
+		s.t.Errorf("Read returned io.EOF with %d bytes", n)
+	case s.eof && (n > 0 || err != io.EOF):
+		s.t.Errorf("Read after io.EOF returned %d, %v", n, err)
+	}
+	if err == io.EOF {
+		s.eof = true
+	}
+	return n, err
+}
+
+// readStrict reads all data from r using reads of the given size through
+// a strictReader.
+func readStrict(t *testing.T, r io.Reader, size int) []byte {
+	s := &strictReader{t: t, r: r}
+	var out bytes.Buffer
+	p := make([]byte, size)
+	for {
+		n, err := s.Read(p)
+		out.Write(p[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("Read error %s", err)
+		}
+	}
+	// another read must return io.EOF again
+	s.Read(p)
+	return out.Bytes()
+}
+
+func TestReaderContract(t *testing.T) {
+	orig := readOrigFile(t)
+	inputs := []struct {
+		name string
+		wrap func(io.Reader) io.Reader
+	}{
+		{"plain", func(r io.Reader) io.Reader { return r }},
+		{"OneByteReader", iotest.OneByteReader},
+		{"HalfReader", iotest.HalfReader},
+	}
+	for _, file := range []string{"a.lzma", "a_eos.lzma",
+		"a_eos_and_size.lzma", "a_lp1_lc2_pb1.lzma"} {
+		data, err := ioutil.ReadFile(filepath.Join(dirname, file))
+		if err != nil {
+			t.Fatalf("ReadFile error %s", err)
+		}
+		for _, in := range inputs {
+			for _, size := range []int{1, 7, 4096} {
+				r, err := NewReader(
+					in.wrap(bytes.NewReader(data)))
+				if err != nil {
+					t.Fatalf("NewReader error %s", err)
+				}
+				out := readStrict(t, r, size)
+				if !bytes.Equal(out, orig) {
+					t.Fatalf("%s %s size %d: data differs",
+						file, in.name, size)
+				}
+			}
+		}
+	}
+}
+
+func TestReaderMemoryLimit(t *testing.T) {
+	h := header{
+		properties: Properties{LC: 3, LP: 0, PB: 2},
+		dictCap:    1 << 30,
+		size:       -1,
+	}
+	data, err := h.marshalBinary()
+	if err != nil {
+		t.Fatalf("marshalBinary error %s", err)
+	}
+	c := ReaderConfig{MemoryLimit: 64 << 20}
+	_, err = c.NewReader(bytes.NewReader(data))
+	if err != ErrMemoryLimit {
+		t.Fatalf("NewReader returned error %v; want %v", err,
+			ErrMemoryLimit)
+	}
+
+	var buf bytes.Buffer
+	w, err := WriterConfig{DictCap: 1 << 16}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	const text = "The quick brown fox jumps over the lazy dog."
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	// the configured DictCap of 8 MiB is ignored
+	c.MemoryLimit = 1 << 20
+	r, err := c.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(p) != text {
+		t.Fatalf("got %q; want %q", p, text)
+	}
+}
+
+func TestReader2MemoryLimit(t *testing.T) {
+	c := Reader2Config{DictCap: 1 << 24, MemoryLimit: 1 << 22}
+	_, err := c.NewReader2(bytes.NewReader([]byte{0}))
+	if err != ErrMemoryLimit {
+		t.Fatalf("NewReader2 returned error %v; want %v", err,
+			ErrMemoryLimit)
+	}
+	c.MemoryLimit = 1 << 25
+	if _, err = c.NewReader2(bytes.NewReader([]byte{0})); err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+}
+
+// readCounter counts the Read calls. It doesn't implement
+// io.ByteReader.
+type readCounter struct {
+	r     io.Reader
+	calls int
+}
+
+func (r *readCounter) Read(p []byte) (n int, err error) {
+	r.calls++
+	return r.r.Read(p)
+}
+
+func TestReaderBufSize(t *testing.T) {
+	orig := readOrigFile(t)
+	data, err := ioutil.ReadFile(filepath.Join(dirname, "a.lzma"))
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	src := &readCounter{r: bytes.NewReader(data)}
+	r, err := ReaderConfig{BufSize: 4096}.NewReader(src)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	decoded, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(decoded, orig) {
+		t.Fatalf("decoded file differs from original")
+	}
+	if src.calls > len(data)/4096+3 {
+		t.Fatalf("%d Read calls for %d bytes", src.calls, len(data))
+	}
+
+	src = &readCounter{r: bytes.NewReader(data)}
+	if err = r.Reset(src); err != nil {
+		t.Fatalf("Reset error %s", err)
+	}
+	if decoded, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("ReadAll after Reset error %s", err)
+	}
+	if !bytes.Equal(decoded, orig) {
+		t.Fatalf("decoded file after Reset differs from original")
+	}
+	if src.calls > len(data)/4096+3 {
+		t.Fatalf("%d Read calls after Reset", src.calls)
+	}
+}
+
+func TestReaderFillSize(t *testing.T) {
+	const fillSize = 100
+	orig := readOrigFile(t)
+	data, err := ioutil.ReadFile(filepath.Join(dirname, "a.lzma"))
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(orig); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	r, err := ReaderConfig{FillSize: fillSize}.NewReader(
+		bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	r2, err := Reader2Config{FillSize: fillSize}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	for _, r := range []io.Reader{r, r2} {
+		p := make([]byte, 4096)
+		var out []byte
+		for {
+			n, err := r.Read(p)
+			if n >= fillSize+MaxMatchLen {
+				t.Fatalf("Read returned %d bytes", n)
+			}
+			out = append(out, p[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Read error %s", err)
+			}
+		}
+		if !bytes.Equal(out, orig) {
+			t.Fatalf("decoded data differs from original")
+		}
+	}
+}
+
+func TestReaderReadByte(t *testing.T) {
+	const size = 50000
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(42)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	buf := new(bytes.Buffer)
+	w, err := WriterConfig{DictCap: MinDictCap}.NewWriter(buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	tee := new(bytes.Buffer)
+	r, err := ReaderConfig{Tee: tee}.NewReader(buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	var _ io.ByteReader = r
+	out := make([]byte, 0, size)
+	p := make([]byte, 100)
+	for i := 0; ; i++ {
+		// mix ReadByte and Read calls
+		if i%1000 == 999 {
+			n, err := r.Read(p)
+			out = append(out, p[:n]...)
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("r.Read error %s", err)
+			}
+			continue
+		}
+		c, err := r.ReadByte()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("r.ReadByte error %s", err)
+		}
+		out = append(out, c)
+	}
+	if !bytes.Equal(out, txt) {
+		t.Fatalf("data read differs from original")
+	}
+	if !bytes.Equal(tee.Bytes(), txt) {
+		t.Fatalf("data written to tee differs from original")
+	}
+	if _, err = r.ReadByte(); err != io.EOF {
+		t.Fatalf("r.ReadByte returned %v; want io.EOF", err)
+	}
+}
+
+func benchmarkReaderBytes(b *testing.B, readByte bool) {
+	const size = 50000
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(49)), size))
+	if err != nil {
+		b.Fatalf("ReadAll error %s", err)
+	}
+	buf := new(bytes.Buffer)
+	w, err := WriterConfig{DictCap: 0x4000}.NewWriter(buf)
+	if err != nil {
+		b.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt); err != nil {
+		b.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		b.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(size)
+	b.ResetTimer()
+	var p [1]byte
+	for i := 0; i < b.N; i++ {
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			b.Fatalf("NewReader error %s", err)
+		}
+		for {
+			if readByte {
+				_, err = r.ReadByte()
+			} else {
+				_, err = r.Read(p[:])
+			}
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				b.Fatalf("read error %s", err)
+			}
+		}
+	}
+}
+
+func BenchmarkReaderReadByte(b *testing.B)  { benchmarkReaderBytes(b, true) }
+func BenchmarkReaderReadSlice(b *testing.B) { benchmarkReaderBytes(b, false) }
+
+func TestReaderReadByteTeeError(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	tee := &errWriter{n: 10}
+	r, err := ReaderConfig{Tee: tee}.NewReader(buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	var out []byte
+	for {
+		c, err := r.ReadByte()
+		if err == errWriterFull {
+			if len(out) != 10 {
+				t.Fatalf("tee failed after %d bytes; want 10",
+					len(out))
+			}
+			tee.n = len(text)
+			continue
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("ReadByte error %s", err)
+		}
+		out = append(out, c)
+	}
+	if string(out) != text {
+		t.Fatalf("ReadByte returned %q; want %q", out, text)
+	}
+}
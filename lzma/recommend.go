@@ -0,0 +1,84 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+// minSampleLen is the minimum length of a sample that is analyzed by
+// RecommendProperties. Shorter samples get the default properties.
+const minSampleLen = 64
+
+// RecommendProperties recommends the properties for data resembling the
+// given sample. Text gets no position bits, data of 16-bit units one
+// and data of 32-bit units two literal position and position bits with
+// no literal context. Otherwise the default properties LC 3, LP 0 and
+// PB 2 are returned. The properties returned are always supported by
+// LZMA2.
+func RecommendProperties(sample []byte) Properties {
+	if len(sample) < minSampleLen {
+		return Properties{LC: 3, LP: 0, PB: 2}
+	}
+	if isText(sample) {
+		return Properties{LC: 3, LP: 0, PB: 0}
+	}
+	switch alignment(sample) {
+	case 4:
+		return Properties{LC: 0, LP: 2, PB: 2}
+	case 2:
+		return Properties{LC: 3, LP: 1, PB: 1}
+	}
+	return Properties{LC: 3, LP: 0, PB: 2}
+}
+
+// isText reports whether the sample looks like text. At least 95% of
+// the bytes must be printable ASCII characters, white space or bytes
+// of UTF-8 sequences; zero bytes are not allowed.
+func isText(p []byte) bool {
+	bad := 0
+	for _, c := range p {
+		switch {
+		case c == 0:
+			return false
+		case c == '\t' || c == '\n' || c == '\r':
+		case c < 0x20 || c == 0x7f:
+			bad++
+		}
+	}
+	return bad*20 <= len(p)
+}
+
+// alignment returns 4 or 2 if the byte statistics of the sample
+// indicate that it consists of 32-bit or 16-bit units and 1 otherwise.
+// The statistics are the collision probabilities of the bytes at the
+// positions with the same remainder modulo 4. Bytes at different
+// positions in the units have different distributions.
+func alignment(p []byte) int {
+	var counts [4][256]int
+	for i, c := range p {
+		counts[i&3][c]++
+	}
+	var coll [4]float64
+	for r := range counts {
+		n := (len(p) - r + 3) / 4
+		var s float64
+		for _, k := range counts[r] {
+			s += float64(k) * float64(k)
+		}
+		coll[r] = s / (float64(n) * float64(n))
+	}
+	// differs reports whether the collision probabilities differ by
+	// more than the factor 2.
+	differs := func(a, b float64) bool {
+		if a < b {
+			a, b = b, a
+		}
+		return a > 2*b
+	}
+	switch {
+	case differs(coll[0], coll[2]) || differs(coll[1], coll[3]):
+		return 4
+	case differs(coll[0]+coll[2], coll[1]+coll[3]):
+		return 2
+	}
+	return 1
+}
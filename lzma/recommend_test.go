@@ -0,0 +1,76 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestRecommendProperties(t *testing.T) {
+	const n = 1 << 12
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), n)
+	rnd := rand.New(rand.NewSource(42))
+	random := make([]byte, n)
+	rnd.Read(random)
+	words := make([]byte, n)
+	for i := 0; i < n; i += 2 {
+		binary.LittleEndian.PutUint16(words[i:],
+			uint16(rnd.Intn(300)))
+	}
+	dwords := make([]byte, n)
+	for i := 0; i < n; i += 4 {
+		binary.LittleEndian.PutUint32(dwords[i:],
+			uint32(rnd.Intn(1<<12)))
+	}
+	tests := []struct {
+		name   string
+		sample []byte
+		want   Properties
+	}{
+		{"short", []byte("abc"), Properties{LC: 3, LP: 0, PB: 2}},
+		{"text", txt.Bytes(), Properties{LC: 3, LP: 0, PB: 0}},
+		{"random", random, Properties{LC: 3, LP: 0, PB: 2}},
+		{"16-bit", words, Properties{LC: 3, LP: 1, PB: 1}},
+		{"32-bit", dwords, Properties{LC: 0, LP: 2, PB: 2}},
+	}
+	for _, tc := range tests {
+		p := RecommendProperties(tc.sample)
+		if p != tc.want {
+			t.Fatalf("%s: got %s; want %s", tc.name, &p, &tc.want)
+		}
+		if err := p.verify2(); err != nil {
+			t.Fatalf("%s: verify2 error %s", tc.name, err)
+		}
+	}
+}
+
+func TestNewProperties(t *testing.T) {
+	if _, err := NewProperties(4, 4, 4); err != nil {
+		t.Fatalf("NewProperties error %s", err)
+	}
+	if _, err := NewProperties(9, 0, 0); err == nil {
+		t.Fatalf("NewProperties accepted lc 9")
+	}
+	if _, err := NewProperties2(3, 1, 2); err != nil {
+		t.Fatalf("NewProperties2 error %s", err)
+	}
+	if _, err := NewProperties2(4, 1, 2); err != ErrLCLP {
+		t.Fatalf("NewProperties2 returned %v; want %v", err, ErrLCLP)
+	}
+	_, err := Writer2Config{
+		Properties: &Properties{LC: 3, LP: 2, PB: 2},
+	}.NewWriter2(ioutil.Discard)
+	if err != ErrLCLP {
+		t.Fatalf("NewWriter2 returned %v; want %v", err, ErrLCLP)
+	}
+}
@@ -4,12 +4,18 @@
 
 package lzma
 
+import "errors"
+
 // states defines the overall state count
 const states = 12
 
 // State maintains the full state of the operation encoding or decoding
-// process.
-type state struct {
+// process: the probability model, the state of the operation sequence
+// and the distances of the last matches. Encoders implementing the
+// state resets of LZMA2 or trying alternative encodings of the same
+// data can checkpoint the state with Clone and return to it with
+// Restore.
+type State struct {
 	rep         [4]uint32
 	isMatch     [states << maxPosBits]prob
 	isRepG0Long [states << maxPosBits]prob
@@ -17,13 +23,41 @@ type state struct {
 	isRepG0     [states]prob
 	isRepG1     [states]prob
 	isRepG2     [states]prob
-	litCodec    literalCodec
-	lenCodec    lengthCodec
-	repLenCodec lengthCodec
-	distCodec   distCodec
-	state       uint32
-	posBitMask  uint32
-	Properties  Properties
+	// probs holds the probability values of the length, distance and
+	// literal codecs in a single array; the codecs are views on it
+	probs      []prob
+	state      uint32
+	posBitMask uint32
+	Properties Properties
+}
+
+// Offsets of the codecs in the probability array of the state. The
+// literal codec uses the rest of the array.
+const (
+	lenCodecOffset    = 0
+	repLenCodecOffset = lenCodecOffset + lengthCodecProbs
+	distCodecOffset   = repLenCodecOffset + lengthCodecProbs
+	litCodecOffset    = distCodecOffset + distCodecProbs
+)
+
+// lenCodec returns the codec for the lengths of simple matches.
+func (s *State) lenCodec() lengthCodec {
+	return lengthCodec(s.probs[lenCodecOffset:repLenCodecOffset])
+}
+
+// repLenCodec returns the codec for the lengths of repetitions.
+func (s *State) repLenCodec() lengthCodec {
+	return lengthCodec(s.probs[repLenCodecOffset:distCodecOffset])
+}
+
+// distCodec returns the codec for the distances of simple matches.
+func (s *State) distCodec() distCodec {
+	return distCodec(s.probs[distCodecOffset:litCodecOffset])
+}
+
+// litCodec returns the codec for literals.
+func (s *State) litCodec() literalCodec {
+	return literalCodec{probs: s.probs[litCodecOffset:]}
 }
 
 // initProbSlice initializes a slice of probabilities.
@@ -34,40 +68,94 @@ func initProbSlice(p []prob) {
 }
 
 // Reset sets all state information to the original values.
-func (s *state) Reset() {
+func (s *State) Reset() {
 	p := s.Properties
-	*s = state{
+	switch {
+	case !(minLC <= p.LC && p.LC <= maxLC):
+		panic("lc out of range")
+	case !(minLP <= p.LP && p.LP <= maxLP):
+		panic("lp out of range")
+	}
+	// keep the probability array
+	probs := s.probs
+	*s = State{
 		Properties: p,
-		// dict:       s.dict,
 		posBitMask: (uint32(1) << uint(p.PB)) - 1,
 	}
+	s.probs = reuseProbs(probs, litCodecOffset+literalProbs(p.LC, p.LP))
+	initProbSlice(s.probs)
 	initProbSlice(s.isMatch[:])
 	initProbSlice(s.isRep[:])
 	initProbSlice(s.isRepG0[:])
 	initProbSlice(s.isRepG1[:])
 	initProbSlice(s.isRepG2[:])
 	initProbSlice(s.isRepG0Long[:])
-	s.litCodec.init(p.LC, p.LP)
-	s.lenCodec.init()
-	s.repLenCodec.init()
-	s.distCodec.init()
 }
 
 // initState initializes the state.
-func initState(s *state, p Properties) {
-	*s = state{Properties: p}
+func initState(s *State, p Properties) {
+	*s = State{Properties: p}
 	s.Reset()
 }
 
-// newState creates a new state from the give Properties.
-func newState(p Properties) *state {
-	s := &state{Properties: p}
+// NewState creates a new state for the given properties. Every state
+// allocates its own probability array, whose size depends on lc and lp;
+// states aren't shared between decoders. Reset, Restore and the Reset
+// methods of the readers reuse the array of a state, so a reader reset
+// for many streams allocates it only once.
+func NewState(p Properties) *State {
+	s := &State{Properties: p}
 	s.Reset()
 	return s
 }
 
+// stateProbs gives the number of probability values of the state
+// without the literal codec: the isMatch, isRepG0Long and the four isRep
+// arrays, two length codecs with 514 values each and the distance codec
+// with 256 values for the position slots, 124 for the position models
+// and 16 for the alignment bits.
+const stateProbs = 2*states<<maxPosBits + 4*states + litCodecOffset
+
+// RequiredDecoderMemory returns the number of bytes a decoder for the
+// properties p and the dictionary capacity dictCap requires for its
+// probability model and its dictionary buffer. A reader growing its
+// dictionary buffer on demand might use less memory.
+func RequiredDecoderMemory(p Properties, dictCap int64) (n int64, err error) {
+	if err = p.verify(); err != nil {
+		return 0, err
+	}
+	if !(1 <= dictCap && dictCap <= MaxDictCap) {
+		return 0, ErrDictCap
+	}
+	probs := stateProbs + literalProbs(p.LC, p.LP)
+	// each probability value requires two bytes
+	n = 2*int64(probs) + dictCap
+	return n, nil
+}
+
+// ErrMemoryLimit indicates that the decoding of a stream requires more
+// memory than the configured memory limit allows.
+var ErrMemoryLimit = errors.New("lzma: memory limit exceeded")
+
+// checkMemory returns ErrMemoryLimit if a decoder for the properties p
+// and the dictionary capacity dictCap requires more than limit bytes.
+// A limit of zero doesn't restrict the memory.
+func checkMemory(limit int64, p Properties, dictCap int64) error {
+	if limit == 0 {
+		return nil
+	}
+	n, err := RequiredDecoderMemory(p, dictCap)
+	if err != nil {
+		return err
+	}
+	if n > limit {
+		return ErrMemoryLimit
+	}
+	return nil
+}
+
 // deepcopy initializes s as a deep copy of the source.
-func (s *state) deepcopy(src *state) {
+func (s *State) deepcopy(src *State) {
 	if s == src {
 		return
 	}
@@ -78,24 +166,29 @@ func (s *state) deepcopy(src *state) {
 	s.isRepG0 = src.isRepG0
 	s.isRepG1 = src.isRepG1
 	s.isRepG2 = src.isRepG2
-	s.litCodec.deepcopy(&src.litCodec)
-	s.lenCodec.deepcopy(&src.lenCodec)
-	s.repLenCodec.deepcopy(&src.repLenCodec)
-	s.distCodec.deepcopy(&src.distCodec)
+	s.probs = reuseProbs(s.probs, len(src.probs))
+	copy(s.probs, src.probs)
 	s.state = src.state
 	s.posBitMask = src.posBitMask
 	s.Properties = src.Properties
 }
 
-// cloneState creates a new clone of the give state.
-func cloneState(src *state) *state {
-	s := new(state)
+// Clone returns a deep copy of the state.
+func (s *State) Clone() *State {
+	c := new(State)
+	c.deepcopy(s)
+	return c
+}
+
+// Restore sets s to a deep copy of src, for instance a checkpoint
+// created by Clone. The probability arrays of s are reused if they are
+// large enough, so restoring a checkpoint doesn't allocate memory.
+func (s *State) Restore(src *State) {
 	s.deepcopy(src)
-	return s
 }
 
 // updateStateLiteral updates the state for a literal.
-func (s *state) updateStateLiteral() {
+func (s *State) updateStateLiteral() {
 	switch {
 	case s.state < 4:
 		s.state = 0
@@ -108,7 +201,7 @@ func (s *state) updateStateLiteral() {
 }
 
 // updateStateMatch updates the state for a match.
-func (s *state) updateStateMatch() {
+func (s *State) updateStateMatch() {
 	if s.state < 7 {
 		s.state = 7
 	} else {
@@ -117,7 +210,7 @@ func (s *state) updateStateMatch() {
 }
 
 // updateStateRep updates the state for a repetition.
-func (s *state) updateStateRep() {
+func (s *State) updateStateRep() {
 	if s.state < 7 {
 		s.state = 8
 	} else {
@@ -126,7 +219,7 @@ func (s *state) updateStateRep() {
 }
 
 // updateStateShortRep updates the state for a short repetition.
-func (s *state) updateStateShortRep() {
+func (s *State) updateStateShortRep() {
 	if s.state < 7 {
 		s.state = 9
 	} else {
@@ -135,7 +228,7 @@ func (s *state) updateStateShortRep() {
 }
 
 // states computes the states of the operation codec.
-func (s *state) states(dictHead int64) (state1, state2, posState uint32) {
+func (s *State) states(dictHead int64) (state1, state2, posState uint32) {
 	state1 = s.state
 	posState = uint32(dictHead) & s.posBitMask
 	state2 = (s.state << maxPosBits) | posState
@@ -143,7 +236,7 @@ func (s *state) states(dictHead int64) (state1, state2, posState uint32) {
 }
 
 // litState computes the literal state.
-func (s *state) litState(prev byte, dictHead int64) uint32 {
+func (s *State) litState(prev byte, dictHead int64) uint32 {
 	lp, lc := uint(s.Properties.LP), uint(s.Properties.LC)
 	litState := ((uint32(dictHead) & ((1 << lp) - 1)) << lc) |
 		(uint32(prev) >> (8 - lc))
@@ -0,0 +1,186 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+)
+
+// countProbs counts the probability values of the state without the
+// literal codec.
+func countProbs(s *State) int {
+	n := len(s.isMatch) + len(s.isRepG0Long) + len(s.isRep) +
+		len(s.isRepG0) + len(s.isRepG1) + len(s.isRepG2)
+	for _, lc := range []lengthCodec{s.lenCodec(), s.repLenCodec()} {
+		n += 2 + len(lc.high().probs)
+		for i := uint32(0); i < 1<<maxPosBits; i++ {
+			n += len(lc.low(i).probs) + len(lc.mid(i).probs)
+		}
+	}
+	dc := s.distCodec()
+	for l := uint32(0); l < lenStates; l++ {
+		n += len(dc.posSlot(l).probs)
+	}
+	for slot := uint32(startPosModel); slot < endPosModel; slot++ {
+		n += len(dc.posModel(slot).probs)
+	}
+	return n + len(dc.align().probs)
+}
+
+func TestRequiredDecoderMemory(t *testing.T) {
+	p := Properties{LC: 3, LP: 0, PB: 2}
+	s := NewState(p)
+	if n := countProbs(s); n != stateProbs {
+		t.Fatalf("state has %d probabilities; want %d", n, stateProbs)
+	}
+	n, err := RequiredDecoderMemory(p, 1<<20)
+	if err != nil {
+		t.Fatalf("RequiredDecoderMemory error %s", err)
+	}
+	want := int64(2*(stateProbs+len(s.litCodec().probs)) + 1<<20)
+	if n != want {
+		t.Fatalf("RequiredDecoderMemory returned %d; want %d", n, want)
+	}
+	if _, err = RequiredDecoderMemory(Properties{LC: 9}, 1<<20); err == nil {
+		t.Fatalf("RequiredDecoderMemory accepted lc=9")
+	}
+}
+
+func TestStateResetReusesLiterals(t *testing.T) {
+	s := NewState(Properties{LC: 3, LP: 1, PB: 2})
+	a := &s.litCodec().probs[0]
+	s.litCodec().probs[0] = 1
+	s.Properties = Properties{LC: 2, LP: 1, PB: 2}
+	s.Reset()
+	if &s.litCodec().probs[0] != a {
+		t.Fatalf("Reset reallocated the literal probabilities")
+	}
+	if s.litCodec().probs[0] != probInit {
+		t.Fatalf("Reset didn't initialize the literal probabilities")
+	}
+	if len(s.litCodec().probs) != literalProbs(2, 1) {
+		t.Fatalf("literal codec has %d probabilities; want %d",
+			len(s.litCodec().probs), literalProbs(2, 1))
+	}
+}
+
+func TestReaderResetReusesProbs(t *testing.T) {
+	var streams [2]bytes.Buffer
+	for i, p := range []Properties{{LC: 3, LP: 1, PB: 2},
+		{LC: 2, LP: 0, PB: 2}} {
+		w, err := WriterConfig{Properties: &p}.NewWriter(&streams[i])
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write([]byte("probabilities")); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+	}
+	r, err := NewReader(&streams[0])
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	a := &r.d.State.probs[0]
+	if err = r.Reset(&streams[1]); err != nil {
+		t.Fatalf("Reset error %s", err)
+	}
+	if &r.d.State.probs[0] != a {
+		t.Fatalf("Reset reallocated the probabilities")
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(p) != "probabilities" {
+		t.Fatalf("ReadAll returned %q; want %q", p, "probabilities")
+	}
+}
+
+func TestStateCloneRestore(t *testing.T) {
+	p := Properties{LC: 3, LP: 0, PB: 2}
+	dict, err := NewDecoderDict(MinDictCap)
+	if err != nil {
+		t.Fatalf("NewDecoderDict error %s", err)
+	}
+	w, err := NewOpWriter(ioutil.Discard, p, dict)
+	if err != nil {
+		t.Fatalf("NewOpWriter error %s", err)
+	}
+	write := func(ops ...Op) {
+		for _, op := range ops {
+			if err := w.WriteOp(op); err != nil {
+				t.Fatalf("WriteOp(%v) error %s", op, err)
+			}
+		}
+	}
+	write(Op{Lit: 'a'}, Op{Lit: 'b'}, Op{Dist: 2, Len: 4})
+	s := w.State()
+	checkpoint := s.Clone()
+	if !reflect.DeepEqual(s, checkpoint) {
+		t.Fatalf("clone differs from the state")
+	}
+	write(Op{Lit: 'c'}, Op{Dist: 3, Len: 2}, Op{Dist: 3, Len: 1})
+	if reflect.DeepEqual(s, checkpoint) {
+		t.Fatalf("clone has been modified with the state")
+	}
+	s.Restore(checkpoint)
+	if !reflect.DeepEqual(s, checkpoint) {
+		t.Fatalf("restored state differs from the checkpoint")
+	}
+	s.Reset()
+	if !reflect.DeepEqual(s, NewState(p)) {
+		t.Fatalf("reset state differs from a new state")
+	}
+}
+
+func TestStateCodecLayout(t *testing.T) {
+	s := NewState(Properties{LC: 3, LP: 0, PB: 2})
+	used := make([]int, litCodecOffset)
+	mark := func(p []prob) {
+		k := len(s.probs) - cap(p)
+		for i := range p {
+			used[k+i]++
+		}
+	}
+	for _, lc := range []lengthCodec{s.lenCodec(), s.repLenCodec()} {
+		mark(lc[:2])
+		mark(lc.high().probs)
+		for i := uint32(0); i < 1<<maxPosBits; i++ {
+			mark(lc.low(i).probs)
+			mark(lc.mid(i).probs)
+		}
+	}
+	dc := s.distCodec()
+	for l := uint32(0); l < lenStates; l++ {
+		mark(dc.posSlot(l).probs)
+	}
+	for slot := uint32(startPosModel); slot < endPosModel; slot++ {
+		mark(dc.posModel(slot).probs)
+	}
+	mark(dc.align().probs)
+	for i, k := range used {
+		if k != 1 {
+			t.Fatalf("probability %d used %d times", i, k)
+		}
+	}
+}
+
+func TestStateResetAllocs(t *testing.T) {
+	s := NewState(Properties{LC: 3, LP: 0, PB: 2})
+	c := s.Clone()
+	allocs := testing.AllocsPerRun(10, func() {
+		s.Reset()
+		c.Restore(s)
+	})
+	if allocs != 0 {
+		t.Fatalf("Reset and Restore allocated %.0f times", allocs)
+	}
+}
@@ -0,0 +1,67 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+// DecoderStats provides statistics about the data decoded by a reader.
+// Tools analyzing the compression of a stream can use them to find out
+// how the data has been encoded.
+type DecoderStats struct {
+	// number of literals decoded
+	Literals int64
+	// number of matches with a new distance
+	Matches int64
+	// number of matches repeating one of the last four distances,
+	// including the short repetitions of a single byte
+	RepMatches int64
+	// total number of operations decoded
+	Ops int64
+	// number of compressed bytes read
+	Compressed int64
+	// number of uncompressed bytes decoded
+	Uncompressed int64
+	// DictHighWater is the size of the dictionary buffer. It grows
+	// up to the dictionary capacity as required by the data.
+	DictHighWater int
+}
+
+// addOps adds the operation counts of the decoder to the statistics.
+func (d *decoder) addOps(s *DecoderStats) {
+	s.Literals += d.literals
+	s.Matches += d.matches
+	s.RepMatches += d.repMatches
+	s.Ops += d.literals + d.matches + d.repMatches
+}
+
+// resetStats sets the operation counts of the decoder to zero.
+func (d *decoder) resetStats() {
+	d.literals, d.matches, d.repMatches = 0, 0, 0
+}
+
+// Stats returns the statistics for the stream read so far. The
+// compressed bytes include the header of the classic format.
+func (r *Reader) Stats() DecoderStats {
+	var s DecoderStats
+	r.d.addOps(&s)
+	s.Compressed = r.d.rd.consumed()
+	if !r.raw {
+		s.Compressed += HeaderLen
+	}
+	s.Uncompressed = r.d.Decompressed()
+	s.DictHighWater = r.d.Dict.buf.Cap()
+	return s
+}
+
+// Stats returns the statistics for the LZMA2 stream read so far. The
+// byte counts cover only the chunks that have been read completely.
+func (r *Reader2) Stats() DecoderStats {
+	var s DecoderStats
+	if r.decoder != nil {
+		r.decoder.addOps(&s)
+	}
+	s.Compressed = r.compressed
+	s.Uncompressed = r.uncompressed
+	s.DictHighWater = r.dict.buf.Cap()
+	return s
+}
@@ -0,0 +1,84 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+// checkStats verifies the statistics for the text compressed.
+func checkStats(t *testing.T, s DecoderStats, text string, compressed int) {
+	t.Helper()
+	if s.Ops != s.Literals+s.Matches+s.RepMatches {
+		t.Errorf("Ops %d; want %d", s.Ops,
+			s.Literals+s.Matches+s.RepMatches)
+	}
+	if s.Literals < 3 || s.Matches+s.RepMatches == 0 {
+		t.Errorf("got %d literals and %d matches; want more",
+			s.Literals, s.Matches+s.RepMatches)
+	}
+	if s.Uncompressed != int64(len(text)) {
+		t.Errorf("Uncompressed %d; want %d", s.Uncompressed, len(text))
+	}
+	if s.Compressed != int64(compressed) {
+		t.Errorf("Compressed %d; want %d", s.Compressed, compressed)
+	}
+	if s.DictHighWater < len(text) {
+		t.Errorf("DictHighWater %d; want at least %d",
+			s.DictHighWater, len(text))
+	}
+}
+
+func TestReaderStats(t *testing.T) {
+	text := strings.Repeat("abc", 100)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	n := buf.Len()
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	checkStats(t, r.Stats(), text, n)
+}
+
+func TestReader2Stats(t *testing.T) {
+	text := strings.Repeat("abc", 100)
+	var buf bytes.Buffer
+	w, err := NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	n := buf.Len()
+	r, err := NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	checkStats(t, r.Stats(), text, n)
+}
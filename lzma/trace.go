@@ -0,0 +1,45 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build debug
+// +build debug
+
+package lzma
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// traceEnabled reports whether the package has been built with the
+// build tag debug, which enables the tracing of decoded operations.
+const traceEnabled = true
+
+// trace stores the writer receiving the trace stream.
+var trace struct {
+	sync.Mutex
+	w io.Writer
+}
+
+// SetTrace directs the trace of all decoded operations to w. Each line
+// contains the dictionary position and the operation. The value nil
+// stops the tracing. SetTrace is only available if the package has
+// been built with the build tag debug.
+func SetTrace(w io.Writer) {
+	trace.Lock()
+	trace.w = w
+	trace.Unlock()
+}
+
+// traceOp writes the operation decoded at position pos to the trace
+// writer.
+func traceOp(pos int64, op operation) {
+	trace.Lock()
+	defer trace.Unlock()
+	if trace.w == nil {
+		return
+	}
+	fmt.Fprintf(trace.w, "%d %v\n", pos, op)
+}
@@ -0,0 +1,15 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !debug
+// +build !debug
+
+package lzma
+
+// traceEnabled is false without the build tag debug. The compiler
+// removes the tracing code guarded by the constant.
+const traceEnabled = false
+
+// traceOp does nothing without the build tag debug.
+func traceOp(pos int64, op operation) {}
@@ -0,0 +1,51 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build debug
+// +build debug
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestTrace(t *testing.T) {
+	const text = "abcabcabc"
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	var trace bytes.Buffer
+	SetTrace(&trace)
+	defer SetTrace(nil)
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(trace.String()), "\n")
+	want := []string{"0 L{a/61}", "1 L{b/62}", "2 L{c/63}", "3 M{3,6}"}
+	if len(lines) != len(want) {
+		t.Fatalf("trace %q; want %q", lines, want)
+	}
+	for i, l := range lines {
+		if l != want[i] {
+			t.Errorf("trace line %d is %q; want %q", i, l, want[i])
+		}
+	}
+}
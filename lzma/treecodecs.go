@@ -10,19 +10,14 @@ type treeCodec struct {
 	probTree
 }
 
-// makeTreeCodec makes a tree codec. The bits value must be inside the range
-// [1,32].
-func makeTreeCodec(bits int) treeCodec {
-	return treeCodec{makeProbTree(bits)}
-}
-
-// deepcopy initializes tc as a deep copy of the source.
-func (tc *treeCodec) deepcopy(src *treeCodec) {
-	tc.probTree.deepcopy(&src.probTree)
+// makeTreeCodec makes a tree codec using the probabilities at the start
+// of p. The bits value must be inside the range [1,16].
+func makeTreeCodec(p []prob, bits int) treeCodec {
+	return treeCodec{makeProbTree(p, bits)}
 }
 
 // Encode uses the range encoder to encode a fixed-bit-size value.
-func (tc *treeCodec) Encode(e *rangeEncoder, v uint32) (err error) {
+func (tc treeCodec) Encode(e *rangeEncoder, v uint32) (err error) {
 	m := uint32(1)
 	for i := int(tc.bits) - 1; i >= 0; i-- {
 		b := (v >> uint(i)) & 1
@@ -34,18 +29,21 @@ func (tc *treeCodec) Encode(e *rangeEncoder, v uint32) (err error) {
 	return nil
 }
 
-// Decodes uses the range decoder to decode a fixed-bit-size value. Errors may
-// be caused by the range decoder.
-func (tc *treeCodec) Decode(d *rangeDecoder) (v uint32, err error) {
+// Decodes uses the range decoder to decode a fixed-bit-size value. Errors
+// are recorded by the range decoder.
+func (tc treeCodec) Decode(d *rangeDecoder) (v uint32) {
+	nrange, code := d.nrange, d.code
 	m := uint32(1)
 	for j := 0; j < int(tc.bits); j++ {
-		b, err := d.DecodeBit(&tc.probs[m])
-		if err != nil {
-			return 0, err
+		var b uint32
+		b, nrange, code = decodeBit(nrange, code, &tc.probs[m])
+		if nrange < 1<<24 {
+			nrange, code = d.normalize(nrange, code)
 		}
 		m = (m << 1) | b
 	}
-	return m - (1 << uint(tc.bits)), nil
+	d.nrange, d.code = nrange, code
+	return m - (1 << uint(tc.bits))
 }
 
 // treeReverseCodec is another tree codec, where the least-significant bit is
@@ -54,21 +52,16 @@ type treeReverseCodec struct {
 	probTree
 }
 
-// deepcopy initializes the treeReverseCodec as a deep copy of the
-// source.
-func (tc *treeReverseCodec) deepcopy(src *treeReverseCodec) {
-	tc.probTree.deepcopy(&src.probTree)
-}
-
-// makeTreeReverseCodec creates treeReverseCodec value. The bits argument must
-// be in the range [1,32].
-func makeTreeReverseCodec(bits int) treeReverseCodec {
-	return treeReverseCodec{makeProbTree(bits)}
+// makeTreeReverseCodec creates treeReverseCodec value using the
+// probabilities at the start of p. The bits argument must be in the
+// range [1,16].
+func makeTreeReverseCodec(p []prob, bits int) treeReverseCodec {
+	return treeReverseCodec{makeProbTree(p, bits)}
 }
 
 // Encode uses range encoder to encode a fixed-bit-size value. The range
 // encoder may cause errors.
-func (tc *treeReverseCodec) Encode(v uint32, e *rangeEncoder) (err error) {
+func (tc treeReverseCodec) Encode(v uint32, e *rangeEncoder) (err error) {
 	m := uint32(1)
 	for i := uint(0); i < uint(tc.bits); i++ {
 		b := (v >> i) & 1
@@ -81,53 +74,41 @@ func (tc *treeReverseCodec) Encode(v uint32, e *rangeEncoder) (err error) {
 }
 
 // Decodes uses the range decoder to decode a fixed-bit-size value. Errors
-// returned by the range decoder will be returned.
-func (tc *treeReverseCodec) Decode(d *rangeDecoder) (v uint32, err error) {
+// are recorded by the range decoder.
+func (tc treeReverseCodec) Decode(d *rangeDecoder) (v uint32) {
+	nrange, code := d.nrange, d.code
 	m := uint32(1)
 	for j := uint(0); j < uint(tc.bits); j++ {
-		b, err := d.DecodeBit(&tc.probs[m])
-		if err != nil {
-			return 0, err
+		var b uint32
+		b, nrange, code = decodeBit(nrange, code, &tc.probs[m])
+		if nrange < 1<<24 {
+			nrange, code = d.normalize(nrange, code)
 		}
 		m = (m << 1) | b
 		v |= b << j
 	}
-	return v, nil
+	d.nrange, d.code = nrange, code
+	return v
 }
 
-// probTree stores enough probability values to be used by the treeEncode and
-// treeDecode methods of the range coder types.
+// probTree provides the probability values used by the tree codecs. The
+// values are part of the probability array of the state, so the codecs
+// don't own any memory and are created on the fly.
 type probTree struct {
 	probs []prob
 	bits  byte
 }
 
-// deepcopy initializes the probTree value as a deep copy of the source.
-func (t *probTree) deepcopy(src *probTree) {
-	if t == src {
-		return
-	}
-	t.probs = make([]prob, len(src.probs))
-	copy(t.probs, src.probs)
-	t.bits = src.bits
-}
-
-// makeProbTree initializes a probTree structure.
-func makeProbTree(bits int) probTree {
-	if !(1 <= bits && bits <= 32) {
-		panic("bits outside of range [1,32]")
-	}
-	t := probTree{
-		bits:  byte(bits),
-		probs: make([]prob, 1<<uint(bits)),
-	}
-	for i := range t.probs {
-		t.probs[i] = probInit
+// makeProbTree initializes a probTree structure for the first 1<<bits
+// probabilities of p.
+func makeProbTree(p []prob, bits int) probTree {
+	if !(1 <= bits && bits <= 16) {
+		panic("bits outside of range [1,16]")
 	}
-	return t
+	return probTree{probs: p[:1<<uint(bits)], bits: byte(bits)}
 }
 
 // Bits provides the number of bits for the values to de- or encode.
-func (t *probTree) Bits() int {
+func (t probTree) Bits() int {
 	return int(t.bits)
 }
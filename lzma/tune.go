@@ -0,0 +1,123 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "io"
+
+// maxTuneSample is the maximum number of bytes at the start of the data
+// that are analyzed by Tune.
+const maxTuneSample = 1 << 20
+
+// Tune supports a two-pass compression of data that can be read twice,
+// for instance files on disk. It runs a first pass over a sample of the
+// data of the given size provided by r and returns a copy of the
+// configuration with the parameters that give the smallest estimated
+// size for the sample. The properties are selected from the candidates
+// including the recommendation of RecommendProperties. Afterwards the
+// word length of the HashTable4 matcher or the nice length and lazy
+// matching of the other matchers are tuned. The dictionary capacity is
+// reduced to the size of the data. The parameters are only changed if
+// the estimate improves; the selected properties are supported by
+// LZMA2.
+func (c Writer2Config) Tune(r io.ReaderAt, size int64) (t Writer2Config,
+	err error) {
+
+	if err = c.Verify(); err != nil {
+		return c, err
+	}
+	c.DictCap = fitDictCap(c.DictCap, size, len(c.PresetDict))
+	if c.Store || size <= 0 {
+		return c, nil
+	}
+	n := size
+	if n > maxTuneSample {
+		n = maxTuneSample
+	}
+	sample := make([]byte, n)
+	k, err := r.ReadAt(sample, 0)
+	if k < len(sample) {
+		if err == nil || err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return c, err
+	}
+
+	best := c
+	bestSize, err := estimateSize(c, sample)
+	if err != nil {
+		return c, err
+	}
+	// try replaces best by u if u results in a smaller estimate.
+	try := func(u Writer2Config) error {
+		s, err := estimateSize(u, sample)
+		if err != nil {
+			return err
+		}
+		if s < bestSize {
+			best, bestSize = u, s
+		}
+		return nil
+	}
+
+	rec := RecommendProperties(sample)
+	for _, p := range []Properties{
+		rec,
+		{LC: 3, LP: 0, PB: 0},
+		{LC: 4, LP: 0, PB: 0},
+		{LC: 3, LP: 1, PB: 1},
+		{LC: 0, LP: 2, PB: 2},
+	} {
+		if p == *best.Properties {
+			continue
+		}
+		u := c
+		u.Properties = &Properties{LC: p.LC, LP: p.LP, PB: p.PB}
+		if err = try(u); err != nil {
+			return c, err
+		}
+	}
+
+	c = best
+	if c.Matcher == HashTable4 {
+		for _, wordLen := range []int{3, 5} {
+			u := c
+			u.WordLen = wordLen
+			if err = try(u); err != nil {
+				return c, err
+			}
+		}
+		return best, nil
+	}
+	if c.NiceLen != maxNiceLen {
+		u := c
+		u.NiceLen = maxNiceLen
+		if err = try(u); err != nil {
+			return c, err
+		}
+	}
+	if !best.Lazy {
+		u := best
+		u.Lazy = true
+		if err = try(u); err != nil {
+			return c, err
+		}
+	}
+	return best, nil
+}
+
+// estimateSize returns the estimated size of the LZMA2 stream for the
+// sample compressed with the configuration c.
+func estimateSize(c Writer2Config, sample []byte) (n int64, err error) {
+	c.DictCap = fitDictCap(c.DictCap, int64(len(sample)),
+		len(c.PresetDict))
+	e, err := c.NewEstimator()
+	if err != nil {
+		return 0, err
+	}
+	if _, err = e.Write(sample); err != nil {
+		return 0, err
+	}
+	return e.Size(), nil
+}
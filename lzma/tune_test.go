@@ -0,0 +1,74 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+)
+
+func TestWriter2ConfigTune(t *testing.T) {
+	const n = 1 << 16
+	rnd := rand.New(rand.NewSource(42))
+	data := make([]byte, n)
+	for i := 0; i < n; i += 4 {
+		binary.LittleEndian.PutUint32(data[i:],
+			uint32(rnd.Intn(1<<12)))
+	}
+	for _, cfg := range []Writer2Config{{}, {Matcher: BinaryTree4}} {
+		tuned, err := cfg.Tune(bytes.NewReader(data), n)
+		if err != nil {
+			t.Fatalf("Tune error %s", err)
+		}
+		if tuned.DictCap != n {
+			t.Fatalf("DictCap %d; want %d", tuned.DictCap, n)
+		}
+		if tuned.Properties.LC+tuned.Properties.LP > 4 {
+			t.Fatalf("Tune selected properties %s",
+				tuned.Properties)
+		}
+		want, err := estimateSize(cfg, data)
+		if err != nil {
+			t.Fatalf("estimateSize error %s", err)
+		}
+		got, err := estimateSize(tuned, data)
+		if err != nil {
+			t.Fatalf("estimateSize error %s", err)
+		}
+		if got > want {
+			t.Fatalf("tuned estimate %d exceeds %d", got, want)
+		}
+
+		var buf bytes.Buffer
+		w, err := tuned.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		r, err := Reader2Config{DictCap: tuned.DictCap}.NewReader2(&buf)
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Fatalf("decompressed data differs")
+		}
+	}
+	if _, err := (Writer2Config{}).Tune(bytes.NewReader(data),
+		n+1); err == nil {
+		t.Fatalf("Tune didn't report the short read")
+	}
+}
@@ -0,0 +1,210 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// The conformance vectors in testdata/vectors have been created by
+// TestGenerateVectors in genvectors_test.go. Each stream NAME.lzma
+// comes with the SHA-256 hash NAME.sha256 of its uncompressed data,
+// which has been provided to or returned by the reference
+// implementation, and the operations NAME.ops decoded from it.
+// TestVectors asserts that the decoder returns the data of the
+// reference and exactly these operations, and that the vectors
+// together contain every operation type and every state transition.
+
+// opKind classifies the decoded operations.
+type opKind int
+
+const (
+	kLit opKind = iota
+	kMatch
+	kShortRep
+	kRep0
+	kRep1
+	kRep2
+	kRep3
+	kEOS
+	numOpKinds
+)
+
+var opKindNames = [numOpKinds]string{
+	"literal", "match", "short rep", "rep0", "rep1", "rep2", "rep3",
+	"EOS",
+}
+
+// opCoverage records the operation kinds and the state transitions of
+// the decoder.
+type opCoverage struct {
+	kinds [numOpKinds]int
+	// transitions by the state before the operation; the long
+	// repetitions are counted as kRep0
+	transitions [states][kRep0 + 1]int
+}
+
+// classify returns the kind of the operation decoded by d. The argument
+// reps provides the repeated distances before the operation.
+func classify(d *decoder, op operation, reps [4]uint32) opKind {
+	m, ok := op.(*match)
+	if !ok {
+		return kLit
+	}
+	switch d.State.state {
+	case 7, 10:
+		return kMatch
+	}
+	if m.n == 1 {
+		return kShortRep
+	}
+	dist := uint32(m.distance - minDistance)
+	for g := 0; g < 4; g++ {
+		if reps[g] == dist {
+			return kRep0 + opKind(g)
+		}
+	}
+	panic("repetition with distance not in rep")
+}
+
+// decodeOps decodes the LZMA file and returns the operations in the
+// format of the decoder trace and the uncompressed data.
+func decodeOps(t *testing.T, data []byte, c *opCoverage) (ops string,
+	p []byte) {
+
+	var h header
+	if err := h.unmarshalBinary(data[:HeaderLen]); err != nil {
+		t.Fatalf("unmarshalBinary error %s", err)
+	}
+	dict, err := NewDecoderDict(int(h.dictCap))
+	if err != nil {
+		t.Fatalf("NewDecoderDict error %s", err)
+	}
+	d, err := newDecoder(bytes.NewReader(data[HeaderLen:]),
+		NewState(h.properties), dict, h.size)
+	if err != nil {
+		t.Fatalf("newDecoder error %s", err)
+	}
+	var sb strings.Builder
+	var buf bytes.Buffer
+	for h.size < 0 || d.Decompressed() < h.size {
+		if dict.Available() < MaxMatchLen {
+			dict.WriteTo(&buf)
+		}
+		if err = d.rd.prefetch(); err != nil {
+			t.Fatalf("prefetch error %s", err)
+		}
+		s0, reps := d.State.state, d.State.rep
+		pos := dict.Pos()
+		op, err := d.readOp()
+		if err == errEOS {
+			c.kinds[kEOS]++
+			break
+		}
+		if err != nil {
+			t.Fatalf("readOp error %s", err)
+		}
+		fmt.Fprintf(&sb, "%d %v\n", pos, op)
+		k := classify(d, op, reps)
+		c.kinds[k]++
+		if k > kRep0 {
+			k = kRep0
+		}
+		c.transitions[s0][k]++
+		if err = d.apply(op); err != nil {
+			t.Fatalf("apply error %s", err)
+		}
+	}
+	if !d.rd.possiblyAtEnd() {
+		t.Fatalf("data after the end of the stream")
+	}
+	dict.WriteTo(&buf)
+	return sb.String(), buf.Bytes()
+}
+
+// sha256Hex returns the SHA-256 hash of p in hexadecimal.
+func sha256Hex(p []byte) string {
+	h := sha256.Sum256(p)
+	return hex.EncodeToString(h[:])
+}
+
+func TestVectors(t *testing.T) {
+	files, err := filepath.Glob("testdata/vectors/*.lzma")
+	if err != nil {
+		t.Fatalf("Glob error %s", err)
+	}
+	if len(files) == 0 {
+		t.Fatalf("no vectors found")
+	}
+	var c opCoverage
+	for _, name := range files {
+		data, err := ioutil.ReadFile(name)
+		if err != nil {
+			t.Fatalf("ReadFile error %s", err)
+		}
+		base := strings.TrimSuffix(name, ".lzma")
+		sum, err := ioutil.ReadFile(base + ".sha256")
+		if err != nil {
+			t.Fatalf("ReadFile error %s", err)
+		}
+		wantSum := strings.TrimSpace(string(sum))
+		r, err := NewReader(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: NewReader error %s", name, err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", name, err)
+		}
+		if s := sha256Hex(p); s != wantSum {
+			t.Fatalf("%s: Reader returned data with SHA-256 %s;"+
+				" want %s", name, s, wantSum)
+		}
+		opsName := base + ".ops"
+		want, err := ioutil.ReadFile(opsName)
+		if err != nil {
+			t.Fatalf("ReadFile error %s", err)
+		}
+		got, p := decodeOps(t, data, &c)
+		if s := sha256Hex(p); s != wantSum {
+			t.Fatalf("%s: operations decode data with SHA-256 %s;"+
+				" want %s", name, s, wantSum)
+		}
+		if got == string(want) {
+			continue
+		}
+		g, w := strings.Split(got, "\n"), strings.Split(string(want), "\n")
+		for i := range g {
+			if i >= len(w) || g[i] != w[i] {
+				t.Fatalf("%s: line %d is %q; want %q", opsName,
+					i+1, g[i], w[i])
+			}
+		}
+		t.Fatalf("%s: %d operations missing", opsName, len(w)-len(g))
+	}
+	for k, n := range c.kinds {
+		if n == 0 {
+			t.Errorf("no %s decoded", opKindNames[k])
+		}
+	}
+	for s := range c.transitions {
+		for k, n := range c.transitions[s] {
+			name := opKindNames[k]
+			if k == int(kRep0) {
+				name = "long rep"
+			}
+			if n == 0 {
+				t.Errorf("no %s decoded in state %d", name, s)
+			}
+		}
+	}
+}
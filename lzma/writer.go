@@ -7,7 +7,10 @@ package lzma
 import (
 	"bufio"
 	"errors"
+	"fmt"
 	"io"
+
+	"github.com/ulikunitz/xz/internal/xio"
 )
 
 // MinDictCap and MaxDictCap provide the range of supported dictionary
@@ -17,6 +20,67 @@ const (
 	MaxDictCap = 1<<32 - 1
 )
 
+// Errors for invalid dictionary capacities and buffer sizes.
+var (
+	// ErrDictCap indicates a dictionary capacity outside the range
+	// [MinDictCap, MaxDictCap].
+	ErrDictCap = fmt.Errorf(
+		"lzma: dictionary capacity out of range [%d, %d]",
+		MinDictCap, uint64(MaxDictCap))
+	// ErrBufSize indicates a lookahead buffer that cannot hold a
+	// match of maximum length or that is larger than MaxBufSize.
+	ErrBufSize = fmt.Errorf(
+		"lzma: lookahead buffer size out of range [%d, %d]",
+		MinBufCap(0), MaxBufSize)
+	// ErrDictBuf indicates a dictionary buffer provided by the
+	// caller whose capacity is too small for the dictionary.
+	ErrDictBuf = errors.New("lzma: dictionary buffer too small")
+	// ErrPlatformLimit indicates a dictionary capacity that is valid
+	// but cannot be represented by the int type of the platform,
+	// which happens for capacities of 2 GiB or more on 32-bit
+	// platforms like 386 or wasm.
+	ErrPlatformLimit = errors.New(
+		"lzma: dictionary capacity exceeds the int range of the platform")
+)
+
+// maxInt is the largest value of the int type of the platform.
+const maxInt = int(^uint(0) >> 1)
+
+// dictCapInt converts a dictionary capacity into an int. It returns
+// ErrPlatformLimit if the capacity exceeds the int range.
+func dictCapInt(dictCap int64) (int, error) {
+	if dictCap > int64(maxInt) {
+		return 0, ErrPlatformLimit
+	}
+	return int(dictCap), nil
+}
+
+// MinBufCap returns the smallest capacity of the encoder buffer that
+// holds a dictionary of capacity dictCap and the lookahead buffer. The
+// lookahead buffer must be able to hold a match of maximum length, so
+// the BufSize of a writer configuration must be at least
+// MinBufCap(dictCap) - dictCap.
+func MinBufCap(dictCap int) int {
+	return dictCap + MaxMatchLen
+}
+
+// MaxBufSize is the largest supported size of the lookahead buffer of
+// a writer.
+const MaxBufSize = 1 << 30
+
+// verifyBufSize checks the size of the lookahead buffer for the
+// dictionary capacity.
+func verifyBufSize(dictCap, bufSize int) error {
+	if !(MinBufCap(0) <= bufSize && bufSize <= MaxBufSize) {
+		return ErrBufSize
+	}
+	// the encoder buffer requires an additional byte
+	if dictCap > maxInt-bufSize-1 {
+		return ErrPlatformLimit
+	}
+	return nil
+}
+
 // WriterConfig defines the configuration parameter for a writer.
 type WriterConfig struct {
 	// Properties for the encoding. If the it is nil the value
@@ -25,21 +89,105 @@ type WriterConfig struct {
 	// The capacity of the dictionary. If DictCap is zero, the value
 	// 8 MiB will be chosen.
 	DictCap int
-	// Size of the lookahead buffer; value 0 indicates default size
-	// 4096
+	// BufSize is the size of the lookahead buffer, which holds the
+	// data written but not yet compressed in addition to the
+	// dictionary. The match finder processes the buffer whenever it
+	// is full, so a larger buffer reduces the number of these runs
+	// for many small writes at the cost of BufSize bytes of memory.
+	// The compressed output doesn't depend on it. The size must be
+	// in the range MinBufCap(0) to MaxBufSize. The value 0 selects
+	// 4096 bytes, 64 KiB if Pipelined is set.
 	BufSize int
 	// Match algorithm
 	Matcher MatchAlgorithm
+	// Depth limits the number of positions the binary tree and hash
+	// chain matchers visit to find a match. The value 0 selects the
+	// default depth of the match algorithm.
+	Depth int
+	// NiceLen is the match length at which the binary tree and hash
+	// chain matchers stop searching for longer matches. The value 0
+	// selects the default of the match algorithm.
+	NiceLen int
+	// Lazy enables lazy matching for the binary tree and hash chain
+	// matchers. A match is replaced by a literal, if the match found
+	// at the next position is expected to be better. This improves
+	// the compression ratio at the cost of additional searches.
+	Lazy bool
+	// WordLen is the number of bytes hashed by the HashTable4
+	// matcher in the range 2 to 5. Short words find more matches in
+	// text. The value 0 selects 4.
+	WordLen int
+	// HashMixer selects the hash function of the HashTable4 matcher.
+	HashMixer HashMixer
+	// LongRangeLen enables the long-range matcher, which indexes the
+	// blocks of LongRangeLen bytes at the positions that are
+	// multiples of it in a table covering the whole dictionary. It
+	// finds repeated regions at large distances in dictionaries of
+	// hundreds of MiB; the matcher selected by Matcher covers then
+	// only the last 64 MiB of the dictionary. The length must be in
+	// the range 16 to 65536; the value 0 disables the long-range
+	// matcher.
+	LongRangeLen int
+	// Pipelined runs the match finder in a separate goroutine; see
+	// Writer2Config.
+	Pipelined bool
+	// Trace receives the literals and matches encoded; see
+	// Writer2Config.
+	Trace EncoderTrace
 	// SizeInHeader indicates that the header will contain an
 	// explicit size.
 	SizeInHeader bool
 	// Size of the data to be encoded. A positive value will imply
-	// than an explicit size will be set in the header.
+	// than an explicit size will be set in the header. Set
+	// SizeInHeader to write a size of zero. The writer doesn't write
+	// an end-of-stream marker for streams with a size in the header
+	// unless EOSMarker is set, and Close returns an error if the
+	// number of bytes written differs from Size. A dictionary
+	// capacity larger than Size and the preset dictionary is reduced
+	// accordingly, since the additional capacity would never be used.
 	Size int64
 	// EOSMarker requests whether the EOSMarker needs to be written.
 	// If no explicit size is been given the EOSMarker will be
 	// set automatically.
 	EOSMarker bool
+	// PresetDict provides the initial content of the dictionary.
+	// Compressing small payloads with a dictionary of similar data
+	// improves the compression ratio. The reader must use the same
+	// preset dictionary.
+	PresetDict []byte
+	// DedupHints receives hints for long regions of the uncompressed
+	// data that repeat earlier data. The offsets of the hints are
+	// relative to the start of the data written. If the field is
+	// nil, no hints are generated. The function is called by the
+	// goroutine calling Write; Pipelined is ignored if it is set.
+	DedupHints func(h DedupHint)
+	// DedupMinLen is the minimum length of a region reported by
+	// DedupHints. The value 0 selects the default of 1024 bytes.
+	DedupMinLen int
+	// DictBuf provides the memory for the dictionary buffer, which
+	// is then not allocated by the writer. Its capacity must be at
+	// least DictCap+BufSize+1 bytes. The buffer must not be used
+	// otherwise while the writer is in use.
+	DictBuf []byte
+}
+
+// fitDictCap reduces the dictionary capacity to the number of bytes
+// that the dictionary will ever hold, which are the data of the given
+// size and the preset dictionary. A non-positive size is unknown and
+// the capacity is returned unchanged. The result is never smaller
+// than MinDictCap.
+func fitDictCap(dictCap int, size int64, preset int) int {
+	if size <= 0 {
+		return dictCap
+	}
+	n := size + int64(preset)
+	if n >= int64(dictCap) {
+		return dictCap
+	}
+	if n < MinDictCap {
+		return MinDictCap
+	}
+	return int(n)
 }
 
 // fill converts zero-value fields to their explicit default values.
@@ -50,8 +198,16 @@ func (c *WriterConfig) fill() {
 	if c.DictCap == 0 {
 		c.DictCap = 8 * 1024 * 1024
 	}
+	c.DictCap = fitDictCap(c.DictCap, c.Size, len(c.PresetDict))
 	if c.BufSize == 0 {
-		c.BufSize = 4096
+		if c.Pipelined {
+			c.BufSize = pipeBufSize
+		} else {
+			c.BufSize = 4096
+		}
+	}
+	if c.DedupMinLen == 0 {
+		c.DedupMinLen = defaultDedupMinLen
 	}
 	if c.Size > 0 {
 		c.SizeInHeader = true
@@ -76,10 +232,13 @@ func (c *WriterConfig) Verify() error {
 		return err
 	}
 	if !(MinDictCap <= c.DictCap && int64(c.DictCap) <= MaxDictCap) {
-		return errors.New("lzma: dictionary capacity is out of range")
+		return ErrDictCap
 	}
-	if !(maxMatchLen <= c.BufSize) {
-		return errors.New("lzma: lookahead buffer size too small")
+	if err = verifyBufSize(c.DictCap, c.BufSize); err != nil {
+		return err
+	}
+	if err = verifyDictBuf(c.DictBuf, c.DictCap+c.BufSize); err != nil {
+		return err
 	}
 	if c.SizeInHeader {
 		if c.Size < 0 {
@@ -91,6 +250,24 @@ func (c *WriterConfig) Verify() error {
 	if err = c.Matcher.verify(); err != nil {
 		return err
 	}
+	if c.Depth < 0 {
+		return errors.New("lzma: search depth must not be negative")
+	}
+	if err = verifyNiceLen(c.NiceLen); err != nil {
+		return err
+	}
+	if err = verifyWordLen(c.WordLen); err != nil {
+		return err
+	}
+	if err = verifyLongRangeLen(c.LongRangeLen); err != nil {
+		return err
+	}
+	if err = c.HashMixer.verify(); err != nil {
+		return err
+	}
+	if c.DedupMinLen < 0 {
+		return errors.New("lzma: DedupMinLen must not be negative")
+	}
 
 	return nil
 }
@@ -99,7 +276,7 @@ func (c *WriterConfig) Verify() error {
 func (c *WriterConfig) header() header {
 	h := header{
 		properties: *c.Properties,
-		dictCap:    c.DictCap,
+		dictCap:    int64(c.DictCap),
 		size:       -1,
 	}
 	if c.SizeInHeader {
@@ -109,36 +286,66 @@ func (c *WriterConfig) header() header {
 }
 
 // Writer writes an LZMA stream in the classic format.
+//
+// Short writes of the underlying writer without an error, as returned
+// by writers for non-blocking sinks, are retried. After 100 consecutive
+// writes without progress the Writer gives up with io.ErrShortWrite.
 type Writer struct {
 	h   header
 	bw  io.ByteWriter
 	buf *bufio.Writer
 	e   *encoder
+	// the header of the classic format is written
+	header     bool
+	presetDict []byte
 }
 
 // NewWriter creates a new LZMA writer for the classic format. The
 // method will write the header to the underlying stream.
 func (c WriterConfig) NewWriter(lzma io.Writer) (w *Writer, err error) {
+	if w, err = c.NewRawWriter(lzma); err != nil {
+		return nil, err
+	}
+	w.header = true
+	if err = w.writeHeader(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// NewRawWriter creates a new LZMA writer that doesn't write the header
+// of the classic format. The reader of the stream must know the
+// properties and the dictionary capacity. If a size is given, the
+// reader must know it too; otherwise the end-of-stream marker is
+// written.
+func (c WriterConfig) NewRawWriter(lzma io.Writer) (w *Writer, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
-	w = &Writer{h: c.header()}
+	w = &Writer{h: c.header(), presetDict: c.PresetDict}
 
 	var ok bool
 	w.bw, ok = lzma.(io.ByteWriter)
 	if !ok {
-		w.buf = bufio.NewWriter(lzma)
+		w.buf = bufio.NewWriter(fullWriter{lzma})
 		w.bw = w.buf
 	}
-	state := newState(w.h.properties)
-	m, err := c.Matcher.new(w.h.dictCap)
+	state := NewState(w.h.properties)
+	m, err := c.Matcher.new(nearDictCap(c.DictCap, c.LongRangeLen),
+		c.Depth, c.NiceLen, c.Lazy, c.WordLen, c.HashMixer)
 	if err != nil {
 		return nil, err
 	}
-	dict, err := newEncoderDict(w.h.dictCap, c.BufSize, m)
+	if m, err = newLongRange(m, c.DictCap, c.LongRangeLen); err != nil {
+		return nil, err
+	}
+	dict, err := newEncoderDict(c.DictCap, c.BufSize, m, c.DictBuf)
 	if err != nil {
 		return nil, err
 	}
+	if err = dict.preset(c.PresetDict); err != nil {
+		return nil, err
+	}
 	var flags encoderFlags
 	if c.EOSMarker {
 		flags = eosMarker
@@ -146,9 +353,13 @@ func (c WriterConfig) NewWriter(lzma io.Writer) (w *Writer, err error) {
 	if w.e, err = newEncoder(w.bw, state, dict, flags); err != nil {
 		return nil, err
 	}
-
-	if err = w.writeHeader(); err != nil {
-		return nil, err
+	if c.DedupHints != nil {
+		w.e.dedup = newDedupTracker(c.DedupHints, c.DedupMinLen)
+	}
+	if c.Trace != nil {
+		w.e.setTrace(c.Trace)
+	} else if c.Pipelined && c.DedupHints == nil {
+		w.e.pipe = newPipeline()
 	}
 	return w, nil
 }
@@ -159,13 +370,51 @@ func NewWriter(lzma io.Writer) (w *Writer, err error) {
 	return WriterConfig{}.NewWriter(lzma)
 }
 
+// NewRawWriter creates a new LZMA writer using the default parameters
+// without writing a header.
+func NewRawWriter(lzma io.Writer) (w *Writer, err error) {
+	return WriterConfig{}.NewRawWriter(lzma)
+}
+
+// Reset discards the state of the writer and prepares it for a new
+// stream written to lzma using the original configuration. The
+// dictionary buffer and the tables of the match finder are reused, so
+// writers for many small streams can be kept in a sync.Pool. Data not
+// written by Close before is discarded.
+func (w *Writer) Reset(lzma io.Writer) error {
+	if bw, ok := lzma.(io.ByteWriter); ok {
+		w.bw = bw
+	} else {
+		if w.buf == nil {
+			w.buf = bufio.NewWriter(fullWriter{lzma})
+		} else {
+			w.buf.Reset(fullWriter{lzma})
+		}
+		w.bw = w.buf
+	}
+	e := w.e
+	e.dict.Reset()
+	if err := e.dict.preset(w.presetDict); err != nil {
+		return err
+	}
+	e.state.Reset()
+	e.dedup.reset()
+	if err := e.Reopen(w.bw); err != nil {
+		return err
+	}
+	if w.header {
+		return w.writeHeader()
+	}
+	return nil
+}
+
 // writeHeader writes the LZMA header into the stream.
 func (w *Writer) writeHeader() error {
 	data, err := w.h.marshalBinary()
 	if err != nil {
 		return err
 	}
-	_, err = w.bw.(io.Writer).Write(data)
+	_, err = xio.WriteFull(w.bw.(io.Writer), data)
 	return err
 }
 
@@ -189,16 +438,82 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// ReadFrom reads the data to compress from r until io.EOF is reached.
+// It implements io.ReaderFrom and reads the data directly into the
+// dictionary buffer. If the size of the stream has been given in the
+// header and r provides more data, ErrNoSpace is returned.
+func (w *Writer) ReadFrom(r io.Reader) (n int64, err error) {
+	if w.h.size < 0 {
+		return w.e.ReadFrom(r)
+	}
+	m := w.h.size
+	m -= w.e.Compressed() + int64(w.e.dict.Buffered())
+	if m < 0 {
+		m = 0
+	}
+	if n, err = w.e.ReadFrom(io.LimitReader(r, m)); err != nil {
+		return n, err
+	}
+	p := make([]byte, 1)
+	if _, err = io.ReadFull(r, p); err != io.EOF {
+		if err == nil {
+			err = ErrNoSpace
+		}
+		return n, err
+	}
+	return n, nil
+}
+
+// ErrSizeMismatch reports that the number of uncompressed bytes
+// differs from the size declared for the stream. The errors of the
+// Reader unwrap to ErrUnexpectedEOS or ErrStreamTooLong.
+type ErrSizeMismatch struct {
+	// declared size
+	Want int64
+	// actual number of bytes; for streams exceeding the declared size
+	// the number of bytes decoded until the excess has been detected
+	Got int64
+	// sentinel error of the decoder or nil
+	err error
+}
+
+// Error returns the error message.
+func (e *ErrSizeMismatch) Error() string {
+	if e.err != nil {
+		return fmt.Sprintf("%s: uncompressed size %d; want %d",
+			e.err, e.Got, e.Want)
+	}
+	return fmt.Sprintf("lzma: uncompressed size %d; want %d", e.Got,
+		e.Want)
+}
+
+// Unwrap returns ErrUnexpectedEOS or ErrStreamTooLong for the errors of
+// the decoder and nil otherwise.
+func (e *ErrSizeMismatch) Unwrap() error {
+	return e.err
+}
+
+// DictBytes copies the last bytes of the encoder dictionary into p and
+// returns the number of bytes copied. The dictionary contains only
+// data that has already been encoded; data still buffered by the writer
+// is not included.
+func (w *Writer) DictBytes(p []byte) int {
+	return w.e.dict.DictBytes(p)
+}
+
 // Close closes the writer stream. It ensures that all data from the
-// buffer will be compressed and the LZMA stream will be finished.
+// buffer will be compressed and the LZMA stream will be finished. If
+// the number of bytes written differs from the size in the header,
+// *ErrSizeMismatch is returned and the stream is not finished.
 func (w *Writer) Close() error {
 	if w.h.size >= 0 {
 		n := w.e.Compressed() + int64(w.e.dict.Buffered())
 		if n != w.h.size {
-			return errSize
+			return &ErrSizeMismatch{Want: w.h.size, Got: n}
 		}
 	}
 	err := w.e.Close()
+	w.e.dedup.flush()
 	if w.buf != nil {
 		ferr := w.buf.Flush()
 		if err == nil {
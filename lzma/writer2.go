@@ -7,7 +7,10 @@ package lzma
 import (
 	"bytes"
 	"errors"
+	"fmt"
 	"io"
+	"sync"
+	"time"
 )
 
 // Writer2Config is used to create a Writer2 using parameters.
@@ -18,13 +21,150 @@ type Writer2Config struct {
 	// The capacity of the dictionary. If DictCap is zero, the value
 	// 8 MiB will be chosen.
 	DictCap int
-	// Size of the lookahead buffer; value 0 indicates default size
-	// 4096
+	// BufSize is the size of the lookahead buffer, which holds the
+	// data written but not yet compressed in addition to the
+	// dictionary. The match finder processes the buffer whenever it
+	// is full, so a larger buffer reduces the number of these runs
+	// for many small writes at the cost of BufSize bytes of memory.
+	// The compressed output doesn't depend on it. The size must be
+	// in the range MinBufCap(0) to MaxBufSize. The value 0 selects
+	// 4096 bytes, 64 KiB if Store or Pipelined is set.
 	BufSize int
 	// Match algorithm
 	Matcher MatchAlgorithm
+	// Depth limits the number of positions the binary tree and hash
+	// chain matchers visit to find a match. The value 0 selects the
+	// default depth of the match algorithm.
+	Depth int
+	// NiceLen is the match length at which the binary tree and hash
+	// chain matchers stop searching for longer matches. The value 0
+	// selects the default of the match algorithm.
+	NiceLen int
+	// Lazy enables lazy matching for the binary tree and hash chain
+	// matchers. A match is replaced by a literal, if the match found
+	// at the next position is expected to be better. This improves
+	// the compression ratio at the cost of additional searches.
+	Lazy bool
+	// WordLen is the number of bytes hashed by the HashTable4
+	// matcher in the range 2 to 5. Short words find more matches in
+	// text. The value 0 selects 4.
+	WordLen int
+	// HashMixer selects the hash function of the HashTable4 matcher.
+	HashMixer HashMixer
+	// LongRangeLen enables the long-range matcher, which indexes the
+	// blocks of LongRangeLen bytes at the positions that are
+	// multiples of it in a table covering the whole dictionary. It
+	// finds repeated regions at large distances in dictionaries of
+	// hundreds of MiB; the matcher selected by Matcher covers then
+	// only the last 64 MiB of the dictionary. The length must be in
+	// the range 16 to 65536; the value 0 disables the long-range
+	// matcher.
+	LongRangeLen int
+	// Pipelined runs the match finder in a separate goroutine, which
+	// sends the operations found to the range encoder. It increases
+	// the throughput on multicore machines; the compressed output
+	// doesn't change. If BufSize is zero, a buffer of 64 KiB is
+	// used, so the match finder can run ahead. Pipelined is ignored
+	// if Trace or DedupHints is set.
+	Pipelined bool
+	// Maximum number of uncompressed bytes in a chunk. Smaller
+	// chunks reduce the amount of data that is buffered before it
+	// is written, but require more chunk headers. The value 0
+	// selects the maximum of 2 MiB supported by LZMA2.
+	ChunkSize int
+	// Maximum number of compressed bytes in a chunk. The value 0
+	// selects the maximum of 64 KiB supported by LZMA2.
+	CompressedChunkSize int
+	// AdaptiveChunks adapts the chunk size to the compressibility of
+	// the input, which improves speed and compression ratio for
+	// mixed content like disk images. Every chunk stored
+	// uncompressed halves the chunk size down to 4 KiB, so the
+	// writer detects the end of incompressible regions early, while
+	// every compressed chunk doubles it up to ChunkSize. The first
+	// compressed chunk after an incompressible region resets the
+	// state, because the probabilities don't describe the new data
+	// anymore. The dictionary is never reset, since it may still
+	// provide matches.
+	AdaptiveChunks bool
+	// DictResetInterval requests a dictionary reset every
+	// DictResetInterval bytes of uncompressed data. Chunks never
+	// cross the resets, so identical data aligned at the resets is
+	// compressed into identical chunks, if the settings are the
+	// same. Deduplicating storage profits from such streams, while
+	// the compression ratio degrades for short intervals. The value
+	// 0 disables the periodic resets; FlushReset requests resets
+	// explicitly.
+	DictResetInterval int64
+	// MaxDelay bounds the time written data lingers in the writer.
+	// If it is positive, a timer flushes the buffered data MaxDelay
+	// after the first byte written since the last flush, even if the
+	// writer isn't called again. Together with ChunkSize it bounds
+	// the latency of streams that cannot be flushed for every
+	// record. The methods of the writer are serialized with the
+	// timer, but they still must not be called concurrently. An
+	// error of a flush by the timer is returned by all following
+	// calls.
+	MaxDelay time.Duration
+	// PresetDict provides the initial content of the dictionary.
+	// Compressing small payloads with a dictionary of similar data
+	// improves the compression ratio. The first chunk will not reset
+	// the dictionary and the reader must use the same preset
+	// dictionary.
+	PresetDict []byte
+	// DedupHints receives hints for long regions of the uncompressed
+	// data that repeat earlier data. The offsets of the hints are
+	// relative to the start of the data written. If the field is
+	// nil, no hints are generated. The function is called by the
+	// goroutine calling Write; Pipelined is ignored if it is set.
+	DedupHints func(h DedupHint)
+	// DedupMinLen is the minimum length of a region reported by
+	// DedupHints. The value 0 selects the default of 1024 bytes.
+	DedupMinLen int
+	// Progress is called after each chunk has been written with the
+	// total numbers of compressed and uncompressed bytes written so
+	// far.
+	Progress func(compressed, uncompressed int64)
+	// Logger receives debug messages for the chunk headers written.
+	// If it is nil, nothing is logged.
+	Logger Logger
+	// Trace receives events for the literals and matches encoded
+	// and the chunks written. It slows down the compression and
+	// Pipelined is ignored if it is set. The operations of a chunk
+	// that is stored uncompressed, because it has been compressed
+	// badly, are reported nevertheless.
+	Trace EncoderTrace
+	// StoreThreshold is the size of a compressed chunk in percent of
+	// the uncompressed chunk, above which the data is stored in an
+	// uncompressed chunk instead. After such a chunk the following
+	// chunks are stored without searching for matches, which avoids
+	// the expensive match search for incompressible input like
+	// already compressed data. The match search is never skipped if
+	// DedupHints is set. The value 0 selects 100, which stores only
+	// chunks that would expand.
+	StoreThreshold int
+	// Store requests that all data is written in uncompressed
+	// chunks. No match finder is allocated and no CPU time is spent
+	// on compression. Matcher and Depth are ignored.
+	Store bool
+	// Size is the number of bytes that will be written, if it is
+	// known. A positive value reduces a larger dictionary capacity
+	// to the size and the length of the preset dictionary, which
+	// avoids allocating a large dictionary for small payloads. Close
+	// returns *ErrSizeMismatch if a different number of bytes has
+	// been written.
+	Size int64
+	// DictBuf provides the memory for the dictionary buffer, which
+	// is then not allocated by the writer. Its capacity must be at
+	// least DictCap+BufSize+1 bytes. The buffer must not be used
+	// otherwise while the writer is in use.
+	DictBuf []byte
 }
 
+// minCompressedChunkSize is the smallest supported limit for the
+// compressed data of a chunk. It leaves room for some operations and
+// the flush of the range encoder.
+const minCompressedChunkSize = 64
+
 // fill replaces zero values with default values.
 func (c *Writer2Config) fill() {
 	if c.Properties == nil {
@@ -33,8 +173,28 @@ func (c *Writer2Config) fill() {
 	if c.DictCap == 0 {
 		c.DictCap = 8 * 1024 * 1024
 	}
+	c.DictCap = fitDictCap(c.DictCap, c.Size, len(c.PresetDict))
 	if c.BufSize == 0 {
-		c.BufSize = 4096
+		if c.Store {
+			// room for a full uncompressed chunk
+			c.BufSize = maxCompressed
+		} else if c.Pipelined {
+			c.BufSize = pipeBufSize
+		} else {
+			c.BufSize = 4096
+		}
+	}
+	if c.DedupMinLen == 0 {
+		c.DedupMinLen = defaultDedupMinLen
+	}
+	if c.ChunkSize == 0 {
+		c.ChunkSize = maxUncompressed
+	}
+	if c.CompressedChunkSize == 0 {
+		c.CompressedChunkSize = maxCompressed
+	}
+	if c.StoreThreshold == 0 {
+		c.StoreThreshold = 100
 	}
 }
 
@@ -49,24 +209,82 @@ func (c *Writer2Config) Verify() error {
 	if c.Properties == nil {
 		return errors.New("lzma: WriterConfig has no Properties set")
 	}
-	if err = c.Properties.verify(); err != nil {
+	if err = c.Properties.verify2(); err != nil {
 		return err
 	}
 	if !(MinDictCap <= c.DictCap && int64(c.DictCap) <= MaxDictCap) {
-		return errors.New("lzma: dictionary capacity is out of range")
+		return ErrDictCap
 	}
-	if !(maxMatchLen <= c.BufSize) {
-		return errors.New("lzma: lookahead buffer size too small")
+	if err = verifyBufSize(c.DictCap, c.BufSize); err != nil {
+		return err
 	}
-	if c.Properties.LC+c.Properties.LP > 4 {
-		return errors.New("lzma: sum of lc and lp exceeds 4")
+	if err = verifyDictBuf(c.DictBuf, c.DictCap+c.BufSize); err != nil {
+		return err
 	}
 	if err = c.Matcher.verify(); err != nil {
 		return err
 	}
+	if c.Depth < 0 {
+		return errors.New("lzma: search depth must not be negative")
+	}
+	if err = verifyNiceLen(c.NiceLen); err != nil {
+		return err
+	}
+	if err = verifyWordLen(c.WordLen); err != nil {
+		return err
+	}
+	if err = verifyLongRangeLen(c.LongRangeLen); err != nil {
+		return err
+	}
+	if err = c.HashMixer.verify(); err != nil {
+		return err
+	}
+	if c.DedupMinLen < 0 {
+		return errors.New("lzma: DedupMinLen must not be negative")
+	}
+	if !(1 <= c.ChunkSize && c.ChunkSize <= maxUncompressed) {
+		return fmt.Errorf("lzma: chunk size %d out of range [1, %d]",
+			c.ChunkSize, maxUncompressed)
+	}
+	if !(minCompressedChunkSize <= c.CompressedChunkSize &&
+		c.CompressedChunkSize <= maxCompressed) {
+		return fmt.Errorf(
+			"lzma: compressed chunk size %d out of range [%d, %d]",
+			c.CompressedChunkSize, minCompressedChunkSize,
+			maxCompressed)
+	}
+	if !(1 <= c.StoreThreshold && c.StoreThreshold <= 100) {
+		return fmt.Errorf(
+			"lzma: store threshold %d out of range [1, 100]",
+			c.StoreThreshold)
+	}
+	if c.MaxDelay < 0 {
+		return errors.New("lzma: MaxDelay must not be negative")
+	}
+	if c.DictResetInterval < 0 {
+		return errors.New(
+			"lzma: DictResetInterval must not be negative")
+	}
 	return nil
 }
 
+// nopMatcher is used by writers storing all data uncompressed. It
+// doesn't index the dictionary and never searches for matches.
+type nopMatcher struct{}
+
+func (nopMatcher) Write(p []byte) (n int, err error) { return len(p), nil }
+func (nopMatcher) SetDict(d *encoderDict)            {}
+func (nopMatcher) Reset()                            {}
+
+func (nopMatcher) NextOp(rep [4]uint32) operation {
+	panic("lzma: no match search for stored data")
+}
+
+// storeChunks is the number of chunks stored without match search after
+// a chunk has been found to be incompressible. Compression is tried
+// again afterwards.
+const storeChunks = 8
+
 // Writer2 supports the creation of an LZMA2 stream. But note that
 // written data is buffered, so call Flush or Close to write data to the
 // underlying writer. The Close method writes the end-of-stream marker
@@ -76,10 +294,13 @@ func (c *Writer2Config) Verify() error {
 //
 // Any change to the fields Properties, DictCap must be done before the
 // first call to Write, Flush or Close.
+//
+// Like Writer, Writer2 retries short writes of the underlying writer
+// and returns io.ErrShortWrite if 100 writes in a row make no progress.
 type Writer2 struct {
 	w io.Writer
 
-	start   *state
+	start   *State
 	encoder *encoder
 
 	cstate chunkState
@@ -87,6 +308,48 @@ type Writer2 struct {
 
 	buf bytes.Buffer
 	lbw LimitedByteWriter
+	// buffer for the chunk headers
+	hbuf [maxChunkHeaderLen]byte
+
+	// limits for the uncompressed and compressed chunk sizes
+	chunkSize           int
+	compressedChunkSize int
+
+	// percentage for the decision to store a chunk and the number of
+	// chunks still to be stored without match search
+	storeThreshold int
+	storeChunks    int
+	// all data is stored uncompressed
+	storeOnly bool
+	// adaptive chunk size and whether the state has to be reset
+	// for the next compressed chunk; see AdaptiveChunks
+	adaptive     bool
+	adaptiveSize int
+	resetState   bool
+	// minimum type of the next compressed chunk requested by
+	// FlushReset; cEOS if none is pending
+	reset chunkType
+	// interval of the periodic dictionary resets and the
+	// uncompressed offset of the next one
+	resetInterval int64
+	nextReset     int64
+	// declared number of bytes; zero if unknown
+	size int64
+
+	// byte counts for the progress function
+	progress     func(compressed, uncompressed int64)
+	compressed   int64
+	uncompressed int64
+
+	presetDict []byte
+	logger     Logger
+
+	// flushing by timer if maxDelay is positive; mu protects the
+	// writer against the timer
+	maxDelay time.Duration
+	mu       sync.Mutex
+	timer    *time.Timer
+	timerErr error
 }
 
 // NewWriter2 creates an LZMA2 chunk sequence writer with the default
@@ -101,28 +364,110 @@ func (c Writer2Config) NewWriter2(lzma2 io.Writer) (w *Writer2, err error) {
 		return nil, err
 	}
 	w = &Writer2{
-		w:      lzma2,
-		start:  newState(*c.Properties),
+		w:      fullWriter{lzma2},
+		start:  NewState(*c.Properties),
 		cstate: start,
 		ctype:  start.defaultChunkType(),
+
+		chunkSize:           c.ChunkSize,
+		compressedChunkSize: c.CompressedChunkSize,
+		storeThreshold:      c.StoreThreshold,
+		storeOnly:           c.Store,
+		adaptive:            c.AdaptiveChunks,
+		adaptiveSize:        c.ChunkSize,
+		resetInterval:       c.DictResetInterval,
+		nextReset:           c.DictResetInterval,
+		size:                c.Size,
+
+		progress: c.Progress,
+
+		presetDict: c.PresetDict,
+		logger:     c.Logger,
+		maxDelay:   c.MaxDelay,
+	}
+	w.buf.Grow(c.CompressedChunkSize)
+	w.lbw = LimitedByteWriter{BW: &w.buf,
+		N: int64(c.CompressedChunkSize)}
+	var m matcher = nopMatcher{}
+	if !c.Store {
+		if m, err = c.Matcher.new(
+			nearDictCap(c.DictCap, c.LongRangeLen), c.Depth,
+			c.NiceLen, c.Lazy, c.WordLen, c.HashMixer); err != nil {
+			return nil, err
+		}
+		if m, err = newLongRange(m, c.DictCap,
+			c.LongRangeLen); err != nil {
+			return nil, err
+		}
 	}
-	w.buf.Grow(maxCompressed)
-	w.lbw = LimitedByteWriter{BW: &w.buf, N: maxCompressed}
-	m, err := c.Matcher.new(c.DictCap)
+	d, err := newEncoderDict(c.DictCap, c.BufSize, m, c.DictBuf)
 	if err != nil {
 		return nil, err
 	}
-	d, err := newEncoderDict(c.DictCap, c.BufSize, m)
-	if err != nil {
-		return nil, err
+	if len(c.PresetDict) > 0 {
+		if err = d.preset(c.PresetDict); err != nil {
+			return nil, err
+		}
+		// keep the preset dictionary
+		w.cstate = 'R'
+		w.ctype = w.cstate.defaultChunkType()
 	}
-	w.encoder, err = newEncoder(&w.lbw, cloneState(w.start), d, 0)
+	w.encoder, err = newEncoder(&w.lbw, w.start.Clone(), d, 0)
 	if err != nil {
 		return nil, err
 	}
+	if c.DedupHints != nil {
+		w.encoder.dedup = newDedupTracker(c.DedupHints, c.DedupMinLen)
+	}
+	w.encoder.store = c.Store
+	w.encoder.storeLimit = w.chunkLimit()
+	if c.Trace != nil {
+		w.encoder.setTrace(c.Trace)
+	} else if c.Pipelined && !c.Store && c.DedupHints == nil {
+		w.encoder.pipe = newPipeline()
+	}
 	return w, nil
 }
 
+// Reset discards the state of the writer and prepares it for a new
+// LZMA2 stream written to lzma2 using the original configuration. The
+// dictionary buffer, the tables of the match finder and the chunk
+// buffer are reused, so writers can be kept in a sync.Pool. Data not
+// written by Close before is discarded.
+func (w *Writer2) Reset(lzma2 io.Writer) error {
+	w.lock()
+	defer w.unlock()
+	w.stopTimer()
+	w.timerErr = nil
+	w.w = fullWriter{lzma2}
+	w.buf.Reset()
+	w.lbw.N = int64(w.compressedChunkSize)
+	w.cstate = start
+	e := w.encoder
+	e.dict.Reset()
+	if len(w.presetDict) > 0 {
+		if err := e.dict.preset(w.presetDict); err != nil {
+			return err
+		}
+		w.cstate = 'R'
+	}
+	w.ctype = w.cstate.defaultChunkType()
+	w.start.Reset()
+	e.state.deepcopy(w.start)
+	e.dedup.reset()
+	e.store = w.storeOnly
+	w.storeChunks = 0
+	w.adaptiveSize = w.chunkSize
+	w.resetState = false
+	w.reset = cEOS
+	w.nextReset = w.resetInterval
+	e.storeLimit = w.chunkLimit()
+	e.traceBase = 0
+	w.compressed = 0
+	w.uncompressed = 0
+	return e.Reopen(&w.lbw)
+}
+
 // written returns the number of bytes written to the current chunk
 func (w *Writer2) written() int {
 	if w.encoder == nil {
@@ -131,6 +476,27 @@ func (w *Writer2) written() int {
 	return int(w.encoder.Compressed()) + w.encoder.dict.Buffered()
 }
 
+// chunkLimit returns the maximum number of uncompressed bytes for the
+// current chunk. Chunks stored without match search are limited by the
+// size of uncompressed chunks and by the encoder buffer, from which
+// the data is copied.
+func (w *Writer2) chunkLimit() int {
+	n := w.chunkSize
+	if w.adaptive {
+		n = w.adaptiveSize
+	}
+	if !w.encoder.store {
+		return n
+	}
+	if n > maxCompressed {
+		n = maxCompressed
+	}
+	if c := w.encoder.dict.buf.Cap(); n > c {
+		n = c
+	}
+	return n
+}
+
 // errClosed indicates that the writer is closed.
 var errClosed = errors.New("lzma: writer closed")
 
@@ -138,13 +504,27 @@ var errClosed = errors.New("lzma: writer closed")
 // Use Flush or Close to ensure that data is written to the underlying
 // writer.
 func (w *Writer2) Write(p []byte) (n int, err error) {
+	if err = w.lock(); err != nil {
+		w.unlock()
+		return 0, err
+	}
+	defer w.unlock()
+	return w.write(p)
+}
+
+// write writes the data to the chunks of the stream.
+func (w *Writer2) write(p []byte) (n int, err error) {
 	if w.cstate == stop {
 		return 0, errClosed
 	}
 	for n < len(p) {
-		m := maxUncompressed - w.written()
+		m := w.room()
 		if m <= 0 {
-			panic("lzma: maxUncompressed reached")
+			// data left by the previous chunk fills this one
+			if err = w.endChunk(); err != nil {
+				return n, err
+			}
+			continue
 		}
 		var q []byte
 		if n+m < len(p) {
@@ -158,7 +538,7 @@ func (w *Writer2) Write(p []byte) (n int, err error) {
 			return n, err
 		}
 		if err == ErrLimit || k == m {
-			if err = w.flushChunk(); err != nil {
+			if err = w.endChunk(); err != nil {
 				return n, err
 			}
 		}
@@ -166,6 +546,42 @@ func (w *Writer2) Write(p []byte) (n int, err error) {
 	return n, nil
 }
 
+// ReadFrom reads the data to compress from r until io.EOF is reached.
+// It implements io.ReaderFrom and reads the data directly into the
+// dictionary buffer of the encoder.
+func (w *Writer2) ReadFrom(r io.Reader) (n int64, err error) {
+	if err = w.lock(); err != nil {
+		w.unlock()
+		return 0, err
+	}
+	defer w.unlock()
+	if w.cstate == stop {
+		return 0, errClosed
+	}
+	for {
+		m := w.room()
+		if m <= 0 {
+			// data left by the previous chunk fills this one
+			if err = w.endChunk(); err != nil {
+				return n, err
+			}
+			continue
+		}
+		lr := &io.LimitedReader{R: r, N: int64(m)}
+		k, err := w.encoder.ReadFrom(lr)
+		n += k
+		if err != nil && err != ErrLimit {
+			return n, err
+		}
+		if err != ErrLimit && lr.N > 0 {
+			return n, nil
+		}
+		if err = w.endChunk(); err != nil {
+			return n, err
+		}
+	}
+}
+
 // writeUncompressedChunk writes an uncompressed chunk to the LZMA2
 // stream.
 func (w *Writer2) writeUncompressedChunk() error {
@@ -182,20 +598,27 @@ func (w *Writer2) writeUncompressedChunk() error {
 	default:
 		w.ctype = cU
 	}
-	w.encoder.state = w.start
+	w.encoder.state.deepcopy(w.start)
 
 	header := chunkHeader{
 		ctype:        w.ctype,
 		uncompressed: uint32(u - 1),
 	}
-	hdata, err := header.MarshalBinary()
+	k, err := header.put(w.hbuf[:])
 	if err != nil {
 		return err
 	}
+	if w.logger != nil {
+		// copy to keep header on the stack
+		h := header
+		w.logger.Debugf("chunk header %v", &h)
+	}
+	hdata := w.hbuf[:k]
 	if _, err = w.w.Write(hdata); err != nil {
 		return err
 	}
 	_, err = w.encoder.dict.CopyN(w.w, int(u))
+	w.compressed += int64(len(hdata)) + u
 	return err
 }
 
@@ -206,7 +629,12 @@ func (w *Writer2) writeCompressedChunk() error {
 		panic("chunk type uncompressed")
 	}
 
-	u := w.encoder.Compressed()
+	return w.putCompressedChunk(w.encoder.Compressed())
+}
+
+// putCompressedChunk writes the header and the compressed data in the
+// buffer of a chunk with u uncompressed bytes.
+func (w *Writer2) putCompressedChunk(u int64) error {
 	if u <= 0 {
 		return errors.New("writeCompressedChunk: empty chunk")
 	}
@@ -226,22 +654,40 @@ func (w *Writer2) writeCompressedChunk() error {
 		compressed:   uint16(c - 1),
 		props:        w.encoder.state.Properties,
 	}
-	hdata, err := header.MarshalBinary()
+	k, err := header.put(w.hbuf[:])
 	if err != nil {
 		return err
 	}
+	if w.logger != nil {
+		// copy to keep header on the stack
+		h := header
+		w.logger.Debugf("chunk header %v", &h)
+	}
+	hdata := w.hbuf[:k]
 	if _, err = w.w.Write(hdata); err != nil {
 		return err
 	}
 	_, err = io.Copy(w.w, &w.buf)
+	w.compressed += int64(len(hdata) + c)
 	return err
 }
 
-// writes a single chunk to the underlying writer.
+// writes a single chunk to the underlying writer. A compressed chunk
+// exceeding the store threshold is replaced by an uncompressed chunk,
+// if the data fits into it.
 func (w *Writer2) writeChunk() error {
-	u := int(uncompressedHeaderLen + w.encoder.Compressed())
+	if w.encoder.store {
+		return w.writeUncompressedChunk()
+	}
+	n := w.encoder.Compressed()
+	u := int(uncompressedHeaderLen + n)
 	c := headerLen(w.ctype) + w.buf.Len()
-	if u < c {
+	if n <= maxCompressed && n <= int64(w.encoder.dict.Len()) &&
+		c*100 > u*w.storeThreshold {
+		// The dedup hints require the match search.
+		if w.encoder.dedup == nil {
+			w.storeChunks = storeChunks
+		}
 		return w.writeUncompressedChunk()
 	}
 	return w.writeCompressedChunk()
@@ -257,11 +703,26 @@ func (w *Writer2) flushChunk() error {
 	if err = w.encoder.Close(); err != nil {
 		return err
 	}
+	u := w.encoder.Compressed()
+	size := w.compressed
 	if err = w.writeChunk(); err != nil {
 		return err
 	}
+	if w.encoder.trace != nil {
+		w.traceChunk(w.ctype, int(u), int(w.compressed-size))
+	}
+	stored := w.ctype == cU || w.ctype == cUD
+	if w.ctype == cUD || (!stored && w.ctype >= w.reset) {
+		w.reset = cEOS
+	}
+	w.uncompressed += u
+	w.reportProgress()
+	w.encoder.store = w.storeOnly || w.storeChunks > 0
+	if w.storeChunks > 0 {
+		w.storeChunks--
+	}
 	w.buf.Reset()
-	w.lbw.N = maxCompressed
+	w.lbw.N = int64(w.compressedChunkSize)
 	if err = w.encoder.Reopen(&w.lbw); err != nil {
 		return err
 	}
@@ -269,13 +730,61 @@ func (w *Writer2) flushChunk() error {
 		return err
 	}
 	w.ctype = w.cstate.defaultChunkType()
-	w.start = cloneState(w.encoder.state)
+	w.start.deepcopy(w.encoder.state)
+	if w.adaptive {
+		w.adapt(int(u), stored)
+	}
+	w.requestReset()
+	w.encoder.storeLimit = w.chunkLimit()
 	return nil
 }
 
+// minAdaptiveChunkSize is the smallest chunk size selected by adapt.
+const minAdaptiveChunkSize = 1 << 12
+
+// adapt adjusts the chunk size after a chunk of u uncompressed bytes has
+// been written and resets the state for the first compressed chunk
+// after stored chunks. A compressed chunk with a state reset that is stored instead leaves
+// the decoder state unchanged; since the encoder state is then taken
+// from the reset start state, the reset is requested again.
+func (w *Writer2) adapt(u int, stored bool) {
+	if stored {
+		// stored chunks may be shorter than the limit
+		w.adaptiveSize = u / 2
+		if w.adaptiveSize < minAdaptiveChunkSize {
+			w.adaptiveSize = minAdaptiveChunkSize
+		}
+		if w.adaptiveSize > w.chunkSize {
+			w.adaptiveSize = w.chunkSize
+		}
+		w.resetState = true
+	} else {
+		w.resetState = false
+		w.adaptiveSize *= 2
+		if w.adaptiveSize > w.chunkSize {
+			w.adaptiveSize = w.chunkSize
+		}
+	}
+	if w.resetState && !w.encoder.store && w.ctype == cL {
+		w.ctype = cLR
+		w.start.Reset()
+		w.encoder.state.deepcopy(w.start)
+	}
+}
+
 // Flush writes all buffered data out to the underlying stream. This
 // could result in multiple chunks to be created.
 func (w *Writer2) Flush() error {
+	err := w.lock()
+	defer w.unlock()
+	if err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// flush writes all buffered data as chunks to the underlying stream.
+func (w *Writer2) flush() error {
 	if w.cstate == stop {
 		return errClosed
 	}
@@ -287,19 +796,93 @@ func (w *Writer2) Flush() error {
 	return nil
 }
 
-// Close terminates the LZMA2 stream with an EOS chunk.
+// DictBytes copies the last bytes of the encoder dictionary into p and
+// returns the number of bytes copied. Call Flush before to include all
+// data written.
+func (w *Writer2) DictBytes(p []byte) int {
+	w.lock()
+	defer w.unlock()
+	return w.encoder.dict.DictBytes(p)
+}
+
+// Close terminates the LZMA2 stream with an EOS chunk. If Size has
+// been given and a different number of bytes has been written,
+// *ErrSizeMismatch is returned and the stream is not terminated.
 func (w *Writer2) Close() error {
+	err := w.lock()
+	defer w.unlock()
+	if err != nil {
+		return err
+	}
 	if w.cstate == stop {
 		return errClosed
 	}
-	if err := w.Flush(); err != nil {
-		return nil
+	if w.size > 0 {
+		e := w.encoder
+		n := w.uncompressed + e.Compressed() + int64(e.dict.Buffered())
+		if n != w.size {
+			return &ErrSizeMismatch{Want: w.size, Got: n}
+		}
 	}
+	if err = w.flush(); err != nil {
+		return err
+	}
+	w.encoder.dedup.flush()
 	// write zero byte EOS chunk
-	_, err := w.w.Write([]byte{0})
-	if err != nil {
+	if _, err = w.w.Write([]byte{0}); err != nil {
 		return err
 	}
+	w.stopTimer()
 	w.cstate = stop
+	w.compressed++
+	w.reportProgress()
 	return nil
 }
+
+// lock locks the writer against the flush timer if MaxDelay is set. It
+// returns the error of a flush by the timer.
+func (w *Writer2) lock() error {
+	if w.maxDelay <= 0 {
+		return nil
+	}
+	w.mu.Lock()
+	return w.timerErr
+}
+
+// unlock starts the flush timer if data is buffered and unlocks the
+// writer.
+func (w *Writer2) unlock() {
+	if w.maxDelay <= 0 {
+		return
+	}
+	if w.timer == nil && w.cstate != stop && w.written() > 0 {
+		w.timer = time.AfterFunc(w.maxDelay, w.timedFlush)
+	}
+	w.mu.Unlock()
+}
+
+// stopTimer stops the flush timer. The writer must be locked.
+func (w *Writer2) stopTimer() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
+
+// timedFlush is called by the flush timer.
+func (w *Writer2) timedFlush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if w.cstate == stop || w.timerErr != nil {
+		return
+	}
+	w.timerErr = w.flush()
+}
+
+// reportProgress calls the progress function if it has been set.
+func (w *Writer2) reportProgress() {
+	if w.progress != nil {
+		w.progress(w.compressed, w.uncompressed)
+	}
+}
@@ -6,10 +6,15 @@ package lzma
 
 import (
 	"bytes"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"math/rand"
 	"strings"
+	"sync"
 	"testing"
+	"testing/iotest"
+	"time"
 
 	"github.com/ulikunitz/xz/internal/randtxt"
 )
@@ -107,3 +112,914 @@ func TestCycle2(t *testing.T) {
 		t.Fatal("decompressed data differs from original")
 	}
 }
+
+func TestReader2Tee(t *testing.T) {
+	const txtlen = 50000
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(43)), txtlen)
+	txt := buf.String()
+	buf.Reset()
+	w, err := Writer2Config{DictCap: 4096}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = io.WriteString(w, txt); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	var tee bytes.Buffer
+	r, err := Reader2Config{DictCap: 4096, Tee: &tee}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	var out bytes.Buffer
+	if _, err = io.Copy(&out, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if out.String() != txt {
+		t.Fatal("decompressed data differs from original")
+	}
+	if tee.String() != txt {
+		t.Fatal("tee data differs from original")
+	}
+}
+
+func TestReader2Growth(t *testing.T) {
+	const txtlen = 200000
+	txt := new(bytes.Buffer)
+	io.CopyN(txt, randtxt.NewReader(rand.NewSource(42)), txtlen)
+	buf := new(bytes.Buffer)
+	w, err := Writer2Config{DictCap: 1 << 16}.NewWriter2(buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	r, err := Reader2Config{
+		DictCap: 1 << 16,
+		Growth:  Growth{Initial: 16, SoftCap: 1024},
+	}.NewReader2(buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if k := r.dict.buf.Cap(); k != 16 {
+		t.Fatalf("initial buffer capacity %d; want %d", k, 16)
+	}
+	out := new(bytes.Buffer)
+	// small reads keep data buffered while the buffer grows
+	p := make([]byte, 100)
+	for {
+		n, err := r.Read(p)
+		out.Write(p[:n])
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatalf("r.Read error %s", err)
+		}
+	}
+	if !bytes.Equal(out.Bytes(), txt.Bytes()) {
+		t.Fatal("decompressed data differs from original")
+	}
+	if k := r.dict.buf.Cap(); k != 1<<16 {
+		t.Fatalf("final buffer capacity %d; want %d", k, 1<<16)
+	}
+}
+
+func TestDictBuf(t *testing.T) {
+	const dictCap = 1 << 16
+	txt := new(bytes.Buffer)
+	io.CopyN(txt, randtxt.NewReader(rand.NewSource(42)), 200000)
+	var want, got bytes.Buffer
+	dictBuf := make([]byte, dictCap+4096+1)
+	for _, c := range []Writer2Config{
+		{DictCap: dictCap},
+		{DictCap: dictCap, DictBuf: dictBuf},
+	} {
+		buf := &want
+		if c.DictBuf != nil {
+			buf = &got
+		}
+		w, err := c.NewWriter2(buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(txt.Bytes()); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+	}
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Fatalf("compressed data differs with DictBuf")
+	}
+	r, err := Reader2Config{DictCap: dictCap,
+		DictBuf: dictBuf[:0]}.NewReader2(&got)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if &r.dict.buf.data[0] != &dictBuf[0] {
+		t.Fatalf("dictionary buffer not used by the reader")
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, txt.Bytes()) {
+		t.Fatal("decompressed data differs from original")
+	}
+
+	small := dictBuf[:dictCap:dictCap]
+	if _, err = (Writer2Config{DictCap: dictCap,
+		DictBuf: small}).NewWriter2(ioutil.Discard); err != ErrDictBuf {
+		t.Fatalf("NewWriter2 returned error %v; want %s", err,
+			ErrDictBuf)
+	}
+	if _, err = (Reader2Config{DictCap: dictCap,
+		DictBuf: small}).NewReader2(&want); err != ErrDictBuf {
+		t.Fatalf("NewReader2 returned error %v; want %s", err,
+			ErrDictBuf)
+	}
+}
+
+func TestWriter2ChunkSize(t *testing.T) {
+	const (
+		txtlen              = 20000
+		chunkSize           = 1000
+		compressedChunkSize = 256
+	)
+	txt := new(bytes.Buffer)
+	io.CopyN(txt, randtxt.NewReader(rand.NewSource(42)), txtlen)
+	buf := new(bytes.Buffer)
+	w, err := Writer2Config{
+		DictCap:             4096,
+		ChunkSize:           chunkSize,
+		CompressedChunkSize: compressedChunkSize,
+	}.NewWriter2(buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	stream := bytes.NewReader(buf.Bytes())
+	chunks := 0
+	for {
+		h, err := readChunkHeader(stream)
+		if err != nil {
+			t.Fatalf("readChunkHeader error %s", err)
+		}
+		if h.ctype == cEOS {
+			break
+		}
+		chunks++
+		u := int(h.uncompressed) + 1
+		if u > chunkSize {
+			t.Fatalf("chunk has %d uncompressed bytes; limit %d",
+				u, chunkSize)
+		}
+		n := u
+		if !uncompressed(h.ctype) {
+			n = int(h.compressed) + 1
+			if n > compressedChunkSize {
+				t.Fatalf("chunk has %d compressed bytes; "+
+					"limit %d", n, compressedChunkSize)
+			}
+		}
+		if _, err = stream.Seek(int64(n), io.SeekCurrent); err != nil {
+			t.Fatalf("Seek error %s", err)
+		}
+	}
+	if chunks < txtlen/chunkSize {
+		t.Fatalf("got %d chunks; want at least %d", chunks,
+			txtlen/chunkSize)
+	}
+	r, err := Reader2Config{DictCap: 4096}.NewReader2(buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	out := new(bytes.Buffer)
+	if _, err = io.Copy(out, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), txt.Bytes()) {
+		t.Fatal("decompressed data differs from original")
+	}
+	for _, c := range []Writer2Config{
+		{ChunkSize: maxUncompressed + 1},
+		{CompressedChunkSize: minCompressedChunkSize - 1},
+		{CompressedChunkSize: maxCompressed + 1},
+	} {
+		if err = c.Verify(); err == nil {
+			t.Errorf("Verify accepted %+v", c)
+		}
+	}
+}
+
+func TestWriter2BufSize(t *testing.T) {
+	txt := new(bytes.Buffer)
+	io.CopyN(txt, randtxt.NewReader(rand.NewSource(42)), 200000)
+	var want []byte
+	for _, bufSize := range []int{MinBufCap(0), 4096, 1 << 20} {
+		cfg := Writer2Config{
+			BufSize:             bufSize,
+			CompressedChunkSize: 4096,
+		}
+		buf := new(bytes.Buffer)
+		w, err := cfg.NewWriter2(buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		// small writes let the buffer fill up
+		p := txt.Bytes()
+		for len(p) > 0 {
+			n := 100
+			if n > len(p) {
+				n = len(p)
+			}
+			if _, err = w.Write(p[:n]); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			p = p[n:]
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		if want == nil {
+			want = buf.Bytes()
+		} else if !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("BufSize %d: compressed output differs",
+				bufSize)
+		}
+		m, err := cfg.MemoryUsage()
+		if err != nil {
+			t.Fatalf("MemoryUsage error %s", err)
+		}
+		cfg.BufSize = 2 * bufSize
+		m2, err := cfg.MemoryUsage()
+		if err != nil {
+			t.Fatalf("MemoryUsage error %s", err)
+		}
+		if m2-m != int64(bufSize) {
+			t.Fatalf("MemoryUsage grows by %d for %d additional "+
+				"bytes", m2-m, bufSize)
+		}
+	}
+}
+
+func TestWriter2Flush(t *testing.T) {
+	const s = "The quick brown fox jumps over the lazy dog.\n"
+	buf := new(bytes.Buffer)
+	w, err := NewWriter2(buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = io.WriteString(w, s); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Flush(); err != nil {
+		t.Fatalf("w.Flush error %s", err)
+	}
+	r, err := NewReader2(buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	// Read must not wait for the next chunk header.
+	p := make([]byte, 2*len(s))
+	n, err := r.Read(p)
+	if err != nil {
+		t.Fatalf("r.Read error %s", err)
+	}
+	if string(p[:n]) != s {
+		t.Fatalf("read %q; want %q", p[:n], s)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if n, err = r.Read(p); n != 0 || err != io.EOF {
+		t.Fatalf("r.Read returned %d, %v; want 0, io.EOF", n, err)
+	}
+}
+
+func TestWriter2DictBytes(t *testing.T) {
+	const dictCap = 1 << 12
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 3*dictCap+17)
+	data := txt.Bytes()
+	w, err := Writer2Config{DictCap: dictCap}.NewWriter2(ioutil.Discard)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	p := make([]byte, 2*dictCap)
+	start := 0
+	for _, end := range []int{100, dictCap + 100, len(data)} {
+		if _, err = w.Write(data[start:end]); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("Flush error %s", err)
+		}
+		start = end
+		n := w.DictBytes(p)
+		want := data[:end]
+		if len(want) > dictCap {
+			want = want[len(want)-dictCap:]
+		}
+		if !bytes.Equal(p[:n], want) {
+			t.Fatalf("after %d bytes: DictBytes returned %d "+
+				"bytes; want %d", end, n, len(want))
+		}
+	}
+}
+
+func TestReader2WriteTo(t *testing.T) {
+	// random bytes create uncompressed chunks
+	var data bytes.Buffer
+	io.CopyN(&data, randtxt.NewReader(rand.NewSource(44)), 100000)
+	io.CopyN(&data, rand.New(rand.NewSource(45)), 100000)
+	io.CopyN(&data, randtxt.NewReader(rand.NewSource(46)), 100000)
+	var buf bytes.Buffer
+	w, err := Writer2Config{DictCap: 1 << 16}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	var tee bytes.Buffer
+	r, err := Reader2Config{DictCap: 1 << 16, Tee: &tee}.NewReader2(
+		bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	var out bytes.Buffer
+	n, err := r.WriteTo(&out)
+	if err != nil {
+		t.Fatalf("r.WriteTo error %s", err)
+	}
+	if n != int64(data.Len()) || !bytes.Equal(out.Bytes(), data.Bytes()) {
+		t.Fatalf("WriteTo output differs from original")
+	}
+	if !bytes.Equal(tee.Bytes(), data.Bytes()) {
+		t.Fatalf("tee data differs from original")
+	}
+	if !r.EOS() {
+		t.Fatalf("EOS returned false after WriteTo")
+	}
+	if n, err = r.WriteTo(&out); n != 0 || err != nil {
+		t.Fatalf("second WriteTo returned %d, %v; want 0, nil", n, err)
+	}
+}
+
+func TestReader2Contract(t *testing.T) {
+	var data bytes.Buffer
+	io.CopyN(&data, randtxt.NewReader(rand.NewSource(47)), 20000)
+	io.CopyN(&data, rand.New(rand.NewSource(48)), 20000)
+	var buf bytes.Buffer
+	w, err := Writer2Config{DictCap: 4096, ChunkSize: 5000}.NewWriter2(
+		&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(data.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	for _, size := range []int{1, 100, 10000, 100000} {
+		r, err := Reader2Config{DictCap: 4096}.NewReader2(
+			iotest.HalfReader(bytes.NewReader(buf.Bytes())))
+		if err != nil {
+			t.Fatalf("NewReader2 error %s", err)
+		}
+		out := readStrict(t, r, size)
+		if !bytes.Equal(out, data.Bytes()) {
+			t.Fatalf("size %d: data differs", size)
+		}
+	}
+}
+
+func TestWriter2ReadFrom(t *testing.T) {
+	var data bytes.Buffer
+	io.CopyN(&data, randtxt.NewReader(rand.NewSource(51)), 100000)
+	io.CopyN(&data, rand.New(rand.NewSource(52)), 20000)
+	var buf bytes.Buffer
+	w, err := Writer2Config{DictCap: 4096, ChunkSize: 30000}.NewWriter2(
+		&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	n, err := w.ReadFrom(iotest.OneByteReader(
+		bytes.NewReader(data.Bytes())))
+	if err != nil {
+		t.Fatalf("w.ReadFrom error %s", err)
+	}
+	if n != int64(data.Len()) {
+		t.Fatalf("w.ReadFrom read %d bytes; want %d", n, data.Len())
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if _, err = w.ReadFrom(bytes.NewReader(nil)); err != errClosed {
+		t.Fatalf("ReadFrom after Close returned %v; want %v", err,
+			errClosed)
+	}
+	r, err := Reader2Config{DictCap: 4096}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	var out bytes.Buffer
+	if _, err = io.Copy(&out, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if !bytes.Equal(out.Bytes(), data.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+}
+
+// progressRecorder records the calls of a progress function.
+type progressRecorder []struct{ c, u int64 }
+
+func (p *progressRecorder) progress(c, u int64) {
+	*p = append(*p, struct{ c, u int64 }{c, u})
+}
+
+// check verifies that the calls are monotonic and end with the given
+// totals.
+func (p progressRecorder) check(t *testing.T, c, u int64) {
+	if len(p) == 0 {
+		t.Fatalf("progress hasn't been called")
+	}
+	for i := 1; i < len(p); i++ {
+		if p[i].c < p[i-1].c || p[i].u < p[i-1].u {
+			t.Fatalf("progress not monotonic: %v", p)
+		}
+	}
+	last := p[len(p)-1]
+	if last.c != c || last.u != u {
+		t.Fatalf("last progress (%d, %d); want (%d, %d)",
+			last.c, last.u, c, u)
+	}
+}
+
+func TestWriter2Progress(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(53)), 100000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var wp progressRecorder
+	var buf bytes.Buffer
+	w, err := Writer2Config{ChunkSize: 10000,
+		Progress: wp.progress}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	wp.check(t, int64(buf.Len()), int64(len(txt)))
+	if len(wp) < 10 {
+		t.Fatalf("got %d progress calls; want at least 10", len(wp))
+	}
+
+	var rp progressRecorder
+	n := int64(buf.Len())
+	r, err := Reader2Config{Progress: rp.progress}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	rp.check(t, n, int64(len(txt)))
+}
+
+func TestWriter2Reset(t *testing.T) {
+	texts := resetTexts(t)
+	for _, cfg := range []Writer2Config{
+		{DictCap: 1 << 16, ChunkSize: 4096},
+		{DictCap: 1 << 16, PresetDict: texts[3]},
+		{DictCap: 1 << 16, Matcher: BinaryTree4},
+		{DictCap: 1 << 16, Matcher: HashChain4},
+	} {
+		var want [][]byte
+		for _, txt := range texts {
+			var buf bytes.Buffer
+			w, err := cfg.NewWriter2(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter2 error %s", err)
+			}
+			if _, err = w.Write(txt); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			want = append(want, buf.Bytes())
+		}
+		var w *Writer2
+		var r *Reader2
+		rcfg := Reader2Config{DictCap: 1 << 16,
+			PresetDict: cfg.PresetDict}
+		for i, txt := range texts {
+			buf := new(bytes.Buffer)
+			var err error
+			if w == nil {
+				w, err = cfg.NewWriter2(buf)
+			} else {
+				err = w.Reset(buf)
+			}
+			if err != nil {
+				t.Fatalf("NewWriter2 or Reset error %s", err)
+			}
+			if _, err = w.Write(txt); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			if !bytes.Equal(buf.Bytes(), want[i]) {
+				t.Fatalf("stream %d after Reset differs", i)
+			}
+			if r == nil {
+				r, err = rcfg.NewReader2(buf)
+			} else {
+				err = r.Reset(buf)
+			}
+			if err != nil {
+				t.Fatalf("NewReader2 or Reset error %s", err)
+			}
+			q, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(q, txt) {
+				t.Fatalf("stream %d decompressed after Reset "+
+					"differs", i)
+			}
+		}
+	}
+}
+
+func TestWriter2Allocs(t *testing.T) {
+	const step = 1 << 16
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(1)), 1<<21))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := Writer2Config{DictCap: 1 << 16}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt[:step]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	i := step
+	a := testing.AllocsPerRun(8, func() {
+		if _, err := w.Write(txt[i : i+step]); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		i += step
+	})
+	if a != 0 {
+		t.Errorf("Write: got %g allocations per call; want 0", a)
+	}
+	if _, err = w.Write(txt[i:]); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+
+	r, err := Reader2Config{DictCap: 1 << 16}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	p := make([]byte, step)
+	if _, err = io.ReadFull(r, p); err != nil {
+		t.Fatalf("ReadFull error %s", err)
+	}
+	a = testing.AllocsPerRun(8, func() {
+		if _, err := io.ReadFull(r, p); err != nil {
+			t.Fatalf("ReadFull error %s", err)
+		}
+	})
+	if a != 0 {
+		t.Errorf("Read: got %g allocations per call; want 0", a)
+	}
+}
+
+func BenchmarkReader2(b *testing.B) {
+	const (
+		seed = 49
+		size = 50000
+	)
+	r := io.LimitReader(randtxt.NewReader(rand.NewSource(seed)), size)
+	txt, err := ioutil.ReadAll(r)
+	if err != nil {
+		b.Fatalf("ReadAll error %s", err)
+	}
+	buf := &bytes.Buffer{}
+	w, err := Writer2Config{DictCap: 0x4000}.NewWriter2(buf)
+	if err != nil {
+		b.Fatalf("Writer2Config{}.NewWriter2 error %s", err)
+	}
+	if _, err = w.Write(txt); err != nil {
+		b.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		b.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+	b.SetBytes(int64(len(txt)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		lr, err := Reader2Config{DictCap: 0x4000}.NewReader2(
+			bytes.NewReader(data))
+		if err != nil {
+			b.Fatalf("NewReader2 error %s", err)
+		}
+		if _, err = ioutil.ReadAll(lr); err != nil {
+			b.Fatalf("ReadAll(lr) error %s", err)
+		}
+	}
+}
+
+// debugLogger records the debug messages.
+type debugLogger struct {
+	msgs []string
+}
+
+func (l *debugLogger) Debugf(format string, v ...interface{}) {
+	l.msgs = append(l.msgs, fmt.Sprintf(format, v...))
+}
+
+func (l *debugLogger) Infof(format string, v ...interface{}) {}
+func (l *debugLogger) Warnf(format string, v ...interface{}) {}
+
+func TestWriter2Logger(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf bytes.Buffer
+	wl := new(debugLogger)
+	w, err := Writer2Config{Logger: wl}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	rl := new(debugLogger)
+	r, err := Reader2Config{Logger: rl}.NewReader2(&buf)
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if len(wl.msgs) == 0 {
+		t.Fatalf("writer logged no messages")
+	}
+	// The reader logs the end-of-stream chunk in addition.
+	if len(rl.msgs) != len(wl.msgs)+1 {
+		t.Fatalf("reader logged %d messages; want %d",
+			len(rl.msgs), len(wl.msgs)+1)
+	}
+	for i, m := range wl.msgs {
+		if rl.msgs[i] != m {
+			t.Errorf("message %d: reader %q; writer %q", i,
+				rl.msgs[i], m)
+		}
+	}
+}
+
+func TestWriter2Store(t *testing.T) {
+	const size = 1 << 20
+	rnd := make([]byte, size)
+	rand.New(rand.NewSource(7)).Read(rnd)
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(8)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	tests := []struct {
+		name      string
+		data      []byte
+		threshold int
+		maxSize   int
+	}{
+		// uncompressed chunks add three bytes per chunk
+		{"random", rnd, 0, size + 3*(size>>15) + 1},
+		{"mixed", append(append([]byte{}, rnd...), txt...), 0,
+			size + 3*(size>>15) + size*3/5},
+		{"threshold", txt, 10, size + 3*(size>>15) + 1},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			cfg := Writer2Config{DictCap: 1 << 20,
+				StoreThreshold: tc.threshold}
+			w, err := cfg.NewWriter2(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter2 error %s", err)
+			}
+			if _, err = w.Write(tc.data); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			if buf.Len() > tc.maxSize {
+				t.Errorf("compressed size %d; want <= %d",
+					buf.Len(), tc.maxSize)
+			}
+			r, err := Reader2Config{DictCap: 1 << 20}.NewReader2(
+				&buf)
+			if err != nil {
+				t.Fatalf("NewReader2 error %s", err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(out, tc.data) {
+				t.Fatalf("decompressed data differs")
+			}
+		})
+	}
+	cfg := Writer2Config{StoreThreshold: 101}
+	if err := cfg.Verify(); err == nil {
+		t.Fatalf("Verify accepted StoreThreshold %d",
+			cfg.StoreThreshold)
+	}
+}
+
+func TestWriter2SizeMismatch(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	for _, size := range []int64{int64(len(text)) - 1,
+		int64(len(text)) + 1} {
+		var buf bytes.Buffer
+		w, err := Writer2Config{Size: size}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = io.WriteString(w, text); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		err = w.Close()
+		e, ok := err.(*ErrSizeMismatch)
+		if !ok {
+			t.Fatalf("w.Close returned %v; want *ErrSizeMismatch",
+				err)
+		}
+		if e.Want != size || e.Got != int64(len(text)) {
+			t.Fatalf("got %+v; want {Want:%d Got:%d}", *e, size,
+				len(text))
+		}
+	}
+}
+
+// lockedBuffer is a buffer that can be written by the flush timer of a
+// writer while it is read by the test.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of the buffered data.
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+// waitFor waits until the buffer has at least n bytes.
+func (b *lockedBuffer) waitFor(n int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for len(b.Bytes()) < n {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(time.Millisecond)
+	}
+	return true
+}
+
+func TestWriter2MaxDelay(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf lockedBuffer
+	w, err := Writer2Config{MaxDelay: 10 * time.Millisecond}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	for i := 0; i < 2; i++ {
+		n := len(buf.Bytes())
+		if _, err = io.WriteString(w, text); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if !buf.waitFor(n+1, 5*time.Second) {
+			t.Fatalf("data not flushed by the timer")
+		}
+	}
+	r, err := NewReader2(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	p := make([]byte, 2*len(text))
+	if _, err = io.ReadFull(r, p); err != nil {
+		t.Fatalf("ReadFull error %s", err)
+	}
+	if string(p) != text+text {
+		t.Fatalf("read %q; want %q", p, text+text)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if _, err = (Writer2Config{MaxDelay: -1}).NewWriter2(&buf); err == nil {
+		t.Fatalf("NewWriter2 accepted negative MaxDelay")
+	}
+}
+
+func TestWriter2AdaptiveChunks(t *testing.T) {
+	// mixed content: text, random data and text again
+	var mixed bytes.Buffer
+	io.CopyN(&mixed, randtxt.NewReader(rand.NewSource(42)), 1<<18)
+	io.CopyN(&mixed, rand.New(rand.NewSource(42)), 1<<18)
+	io.CopyN(&mixed, randtxt.NewReader(rand.NewSource(43)), 1<<18)
+	data := mixed.Bytes()
+	compress := func(adaptive bool) ([]byte, []string) {
+		var buf bytes.Buffer
+		l := new(debugLogger)
+		w, err := Writer2Config{
+			AdaptiveChunks: adaptive,
+			Logger:         l,
+		}.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter2 error %s", err)
+		}
+		if _, err = w.Write(data); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		return buf.Bytes(), l.msgs
+	}
+	fixed, _ := compress(false)
+	out, msgs := compress(true)
+	if len(out) >= len(fixed) {
+		t.Fatalf("adaptive chunks output %d bytes; want less than %d",
+			len(out), len(fixed))
+	}
+	var small, reset bool
+	for _, m := range msgs {
+		if strings.HasPrefix(m, "chunk header U 4095 ") {
+			small = true
+		}
+		if strings.HasPrefix(m, "chunk header LR ") {
+			reset = true
+		}
+	}
+	if !small || !reset {
+		t.Fatalf("no small stored chunk or state reset in %q", msgs)
+	}
+	r, err := NewReader2(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("NewReader2 error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decompressed data differs")
+	}
+}
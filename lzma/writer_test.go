@@ -12,7 +12,9 @@ import (
 	"log"
 	"math/rand"
 	"os"
+	"strings"
 	"testing"
+	"testing/iotest"
 
 	"github.com/ulikunitz/xz/internal/randtxt"
 )
@@ -120,8 +122,13 @@ func TestWriter_Size(t *testing.T) {
 		}
 		q[0]++
 	}
-	if err := w.Close(); err != errSize {
-		t.Fatalf("expected errSize, but got %v", err)
+	err = w.Close()
+	e, ok := err.(*ErrSizeMismatch)
+	if !ok {
+		t.Fatalf("expected *ErrSizeMismatch, but got %v", err)
+	}
+	if e.Want != 10 || e.Got != 9 {
+		t.Fatalf("got %+v; want {Want:10 Got:9}", *e)
 	}
 	n, err := w.Write(q)
 	if err != nil {
@@ -149,6 +156,47 @@ func TestWriter_Size(t *testing.T) {
 	}
 }
 
+func TestWriterSizeHeader(t *testing.T) {
+	for _, n := range []int{0, 1, 1000} {
+		data := bytes.Repeat([]byte("x"), n)
+		var lens [2]int
+		for i, marker := range []bool{false, true} {
+			buf := new(bytes.Buffer)
+			w, err := WriterConfig{Size: int64(n), SizeInHeader: true,
+				EOSMarker: marker}.NewWriter(buf)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			if _, err = w.Write(data); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			lens[i] = buf.Len()
+			if s := uint64LE(buf.Bytes()[5:]); s != uint64(n) {
+				t.Fatalf("size in header %d; want %d", s, n)
+			}
+			r, err := NewReader(buf)
+			if err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+			p, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(p, data) {
+				t.Fatalf("size %d: read %d bytes; want %d", n,
+					len(p), n)
+			}
+		}
+		if lens[0] >= lens[1] {
+			t.Fatalf("size %d: stream without EOS marker has %d"+
+				" bytes; with marker %d", n, lens[0], lens[1])
+		}
+	}
+}
+
 // The example uses the buffered reader and writer from package bufio.
 func Example_writer() {
 	pr, pw := io.Pipe()
@@ -247,3 +295,442 @@ func BenchmarkWriter(b *testing.B) {
 		}
 	}
 }
+
+func TestWriterReadFrom(t *testing.T) {
+	const size = 82237
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(50)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	tests := []WriterConfig{
+		{DictCap: 0x4000},
+		{DictCap: 0x4000, Size: size, EOSMarker: true},
+	}
+	for _, c := range tests {
+		buf := new(bytes.Buffer)
+		w, err := c.NewWriter(buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		n, err := w.ReadFrom(iotest.HalfReader(bytes.NewReader(txt)))
+		if err != nil {
+			t.Fatalf("w.ReadFrom error %s", err)
+		}
+		if n != size {
+			t.Fatalf("w.ReadFrom read %d bytes; want %d", n, size)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		r, err := NewReader(buf)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, txt) {
+			t.Fatalf("decompressed data differs from original")
+		}
+	}
+
+	// more data than given by the size
+	w, err := WriterConfig{Size: 10}.NewWriter(new(bytes.Buffer))
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	n, err := w.ReadFrom(bytes.NewReader(txt[:11]))
+	if err != ErrNoSpace || n != 10 {
+		t.Fatalf("w.ReadFrom returned %d, %v; want 10, %v", n, err,
+			ErrNoSpace)
+	}
+}
+
+func TestRawWriterReader(t *testing.T) {
+	orig := readOrigFile(t)
+	p := Properties{LC: 2, LP: 1, PB: 1}
+	tests := []struct {
+		size int64
+		eos  bool
+	}{
+		{-1, true},
+		{int64(len(orig)), false},
+		{int64(len(orig)), true},
+	}
+	for _, c := range tests {
+		var buf bytes.Buffer
+		wc := WriterConfig{Properties: &p, DictCap: MinDictCap,
+			EOSMarker: c.eos}
+		if c.size >= 0 {
+			wc.Size = c.size
+		}
+		w, err := wc.NewRawWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewRawWriter error %s", err)
+		}
+		if _, err = w.Write(orig); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		// compare with the stream including the header
+		var full bytes.Buffer
+		if w, err = wc.NewWriter(&full); err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(orig); err != nil {
+			t.Fatalf("w.Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		if !bytes.Equal(full.Bytes()[HeaderLen:], buf.Bytes()) {
+			t.Fatalf("raw stream differs from stream with header")
+		}
+		r, err := NewRawReader(&buf, p, MinDictCap, c.size)
+		if err != nil {
+			t.Fatalf("NewRawReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, orig) {
+			t.Fatalf("decompressed data differs from original")
+		}
+		if r.EOSMarker() != c.eos {
+			t.Fatalf("EOSMarker returned %t; want %t",
+				r.EOSMarker(), c.eos)
+		}
+	}
+	if _, err := NewRawReader(new(bytes.Buffer), Properties{LC: 9}, 0,
+		-1); err == nil {
+		t.Fatalf("NewRawReader accepted lc=9")
+	}
+}
+
+// trickle writes p in pieces of n bytes to w.
+func trickle(w io.Writer, p []byte, n int) error {
+	for len(p) > 0 {
+		k := n
+		if k > len(p) {
+			k = len(p)
+		}
+		if _, err := w.Write(p[:k]); err != nil {
+			return err
+		}
+		p = p[k:]
+	}
+	return nil
+}
+
+func TestWriterTrickle(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(51)), 60000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	compress := func(n int, lzma2 bool) []byte {
+		buf := new(bytes.Buffer)
+		var w io.WriteCloser
+		if lzma2 {
+			w, err = Writer2Config{DictCap: 0x4000,
+				ChunkSize: 10000}.NewWriter2(buf)
+		} else {
+			w, err = WriterConfig{DictCap: 0x4000}.NewWriter(buf)
+		}
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if err = trickle(w, txt, n); err != nil {
+			t.Fatalf("trickle(%d) error %s", n, err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("w.Close error %s", err)
+		}
+		return buf.Bytes()
+	}
+	for _, lzma2 := range []bool{false, true} {
+		want := compress(len(txt), lzma2)
+		for _, n := range []int{1, 3, 16, 4095} {
+			if got := compress(n, lzma2); !bytes.Equal(got, want) {
+				t.Fatalf("lzma2 %t: writes of %d bytes change "+
+					"the output", lzma2, n)
+			}
+		}
+	}
+}
+
+func benchmarkWriterTrickle(b *testing.B, n int) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(49)), 50000))
+	if err != nil {
+		b.Fatalf("ReadAll error %s", err)
+	}
+	buf := &bytes.Buffer{}
+	b.SetBytes(int64(len(txt)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		buf.Reset()
+		w, err := WriterConfig{DictCap: 0x4000}.NewWriter(buf)
+		if err != nil {
+			b.Fatalf("NewWriter error %s", err)
+		}
+		if err = trickle(w, txt, n); err != nil {
+			b.Fatalf("trickle error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			b.Fatalf("w.Close error %s", err)
+		}
+	}
+}
+
+func BenchmarkWriterTrickle1(b *testing.B)   { benchmarkWriterTrickle(b, 1) }
+func BenchmarkWriterTrickle16(b *testing.B)  { benchmarkWriterTrickle(b, 16) }
+func BenchmarkWriterTrickleAll(b *testing.B) { benchmarkWriterTrickle(b, 50000) }
+
+func TestConfigCapErrors(t *testing.T) {
+	const dictCap = 1 << 16
+	bufSize := MinBufCap(dictCap) - dictCap
+	tests := []struct {
+		verify func() error
+		want   error
+	}{
+		{(&WriterConfig{DictCap: 100}).Verify, ErrDictCap},
+		{(&Writer2Config{DictCap: 100}).Verify, ErrDictCap},
+		{(&ReaderConfig{DictCap: 100}).Verify, ErrDictCap},
+		{(&Reader2Config{DictCap: 100}).Verify, ErrDictCap},
+		{(&WriterConfig{DictCap: dictCap,
+			BufSize: bufSize - 1}).Verify, ErrBufSize},
+		{(&Writer2Config{DictCap: dictCap,
+			BufSize: bufSize - 1}).Verify, ErrBufSize},
+		{(&WriterConfig{DictCap: dictCap,
+			BufSize: bufSize}).Verify, nil},
+		{(&Writer2Config{DictCap: dictCap,
+			BufSize: bufSize}).Verify, nil},
+		{(&WriterConfig{DictCap: dictCap,
+			BufSize: MaxBufSize + 1}).Verify, ErrBufSize},
+		{(&Writer2Config{DictCap: dictCap,
+			BufSize: MaxBufSize + 1}).Verify, ErrBufSize},
+		{(&Writer2Config{DictCap: dictCap,
+			BufSize: -1}).Verify, ErrBufSize},
+	}
+	for i, c := range tests {
+		if err := c.verify(); err != c.want {
+			t.Errorf("test %d: Verify returned %v; want %v", i,
+				err, c.want)
+		}
+	}
+}
+
+func TestConfigRangeErrors(t *testing.T) {
+	tests := []struct {
+		c    Writer2Config
+		want string
+	}{
+		{Writer2Config{DictCap: 100}, "[4096, 4294967295]"},
+		{Writer2Config{BufSize: 10}, "[273, 1073741824]"},
+		{Writer2Config{NiceLen: 300},
+			"nice length 300 out of range [4, 273]"},
+		{Writer2Config{WordLen: 7}, "[2, 5]"},
+		{Writer2Config{LongRangeLen: 8}, "[16, 65536]"},
+		{Writer2Config{Properties: &Properties{LC: 9}},
+			"lc 9 out of range [0, 8]"},
+		{Writer2Config{ChunkSize: -1}, "[1, 2097152]"},
+		{Writer2Config{StoreThreshold: 101}, "[1, 100]"},
+	}
+	for _, tc := range tests {
+		err := tc.c.Verify()
+		if err == nil {
+			t.Fatalf("Verify accepted %+v", tc.c)
+		}
+		if !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("error %q doesn't contain %q", err, tc.want)
+		}
+	}
+	if MaxMatchLen != 273 || MinMatchLen != 2 {
+		t.Fatalf("match length range [%d, %d]; want [2, 273]",
+			MinMatchLen, MaxMatchLen)
+	}
+}
+
+// resetTexts returns texts of different sizes for the reset tests.
+func resetTexts(t *testing.T) [][]byte {
+	var texts [][]byte
+	for i, n := range []int64{5000, 0, 20000, 300} {
+		p, err := ioutil.ReadAll(io.LimitReader(
+			randtxt.NewReader(rand.NewSource(int64(60+i))), n))
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		texts = append(texts, p)
+	}
+	return texts
+}
+
+func TestWriterReset(t *testing.T) {
+	texts := resetTexts(t)
+	configs := []WriterConfig{
+		{DictCap: 1 << 16},
+		{DictCap: 1 << 16, Matcher: HashTable4},
+		{DictCap: 1 << 16, Matcher: HashChain4},
+		{DictCap: 1 << 16, PresetDict: texts[3]},
+	}
+	for _, cfg := range configs {
+		var want, got [][]byte
+		for _, txt := range texts {
+			var buf bytes.Buffer
+			w, err := cfg.NewWriter(&buf)
+			if err != nil {
+				t.Fatalf("NewWriter error %s", err)
+			}
+			if _, err = w.Write(txt); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			want = append(want, buf.Bytes())
+		}
+		var w *Writer
+		for _, txt := range texts {
+			buf := new(bytes.Buffer)
+			var err error
+			if w == nil {
+				w, err = cfg.NewWriter(buf)
+			} else {
+				err = w.Reset(buf)
+			}
+			if err != nil {
+				t.Fatalf("NewWriter or Reset error %s", err)
+			}
+			if _, err = w.Write(txt); err != nil {
+				t.Fatalf("w.Write error %s", err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("w.Close error %s", err)
+			}
+			got = append(got, buf.Bytes())
+		}
+		for i := range want {
+			if !bytes.Equal(got[i], want[i]) {
+				t.Fatalf("%v: stream %d after Reset differs",
+					cfg.Matcher, i)
+			}
+		}
+
+		// one reader for all streams and a stream with a
+		// different dictionary capacity
+		rcfg := ReaderConfig{DictCap: 1 << 16,
+			PresetDict: cfg.PresetDict}
+		var r *Reader
+		for i, p := range append(got, got[0]) {
+			var err error
+			if r == nil {
+				r, err = rcfg.NewReader(bytes.NewReader(p))
+			} else {
+				err = r.Reset(bytes.NewReader(p))
+			}
+			if err != nil {
+				t.Fatalf("NewReader or Reset error %s", err)
+			}
+			q, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("ReadAll error %s", err)
+			}
+			if !bytes.Equal(q, texts[i%len(texts)]) {
+				t.Fatalf("stream %d decompressed after Reset "+
+					"differs", i)
+			}
+			rcfg.DictCap = 1 << 20
+		}
+	}
+}
+
+func TestWriterSizeDictCap(t *testing.T) {
+	tests := []struct {
+		dictCap int
+		size    int64
+		preset  int
+		want    int
+	}{
+		{1 << 20, 0, 0, 1 << 20},
+		{1 << 20, 100, 0, MinDictCap},
+		{1 << 20, 10000, 0, 10000},
+		{1 << 20, 10000, 5000, 15000},
+		{1 << 20, 1 << 21, 0, 1 << 20},
+	}
+	for _, c := range tests {
+		wc := WriterConfig{DictCap: c.dictCap, Size: c.size,
+			PresetDict: make([]byte, c.preset)}
+		if err := wc.Verify(); err != nil {
+			t.Fatalf("WriterConfig.Verify error %s", err)
+		}
+		if wc.DictCap != c.want {
+			t.Errorf("WriterConfig with size %d and preset %d:"+
+				" DictCap %d; want %d", c.size, c.preset,
+				wc.DictCap, c.want)
+		}
+		wc2 := Writer2Config{DictCap: c.dictCap, Size: c.size,
+			PresetDict: make([]byte, c.preset)}
+		if err := wc2.Verify(); err != nil {
+			t.Fatalf("Writer2Config.Verify error %s", err)
+		}
+		if wc2.DictCap != c.want {
+			t.Errorf("Writer2Config with size %d and preset %d:"+
+				" DictCap %d; want %d", c.size, c.preset,
+				wc2.DictCap, c.want)
+		}
+	}
+}
+
+func TestWriterLazy(t *testing.T) {
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(53)), 200000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	matchers := []MatchAlgorithm{BinaryTree2, BinaryTree3, BinaryTree4,
+		HashChain3, HashChain4}
+	for _, a := range matchers {
+		var sizes [2]int
+		for i, lazy := range []bool{false, true} {
+			var buf bytes.Buffer
+			cfg := WriterConfig{Matcher: a, NiceLen: 32, Lazy: lazy}
+			w, err := cfg.NewWriter(&buf)
+			if err != nil {
+				t.Fatalf("%v: NewWriter error %s", a, err)
+			}
+			if _, err = w.Write(data); err != nil {
+				t.Fatalf("%v: Write error %s", a, err)
+			}
+			if err = w.Close(); err != nil {
+				t.Fatalf("%v: Close error %s", a, err)
+			}
+			sizes[i] = buf.Len()
+			r, err := NewReader(&buf)
+			if err != nil {
+				t.Fatalf("%v: NewReader error %s", a, err)
+			}
+			out, err := ioutil.ReadAll(r)
+			if err != nil {
+				t.Fatalf("%v: ReadAll error %s", a, err)
+			}
+			if !bytes.Equal(out, data) {
+				t.Fatalf("%v: lazy %t: data differs", a, lazy)
+			}
+		}
+		t.Logf("%v: compressed %d bytes; lazy %d bytes", a,
+			sizes[0], sizes[1])
+		if sizes[1] > sizes[0] {
+			t.Errorf("%v: lazy matching increased size from %d to %d",
+				a, sizes[0], sizes[1])
+		}
+	}
+	if err := (&WriterConfig{NiceLen: 2}).Verify(); err == nil {
+		t.Fatalf("Verify accepted NiceLen 2")
+	}
+}
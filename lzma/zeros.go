@@ -0,0 +1,124 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import "errors"
+
+// zeroBuf provides zeros for writers storing zero runs uncompressed.
+var zeroBuf [1 << 15]byte
+
+// WriteZeros writes n zero bytes. The run is encoded as a literal
+// followed by repetitions of the last byte without running the match
+// finder, which is much faster for the long zero runs of sparse files
+// and disk images. The dictionary is reset before and after the run,
+// so the data following the run cannot refer to the data before it.
+// Runs shorter than a few kilobytes should be written with Write.
+func (w *Writer2) WriteZeros(n int64) error {
+	err := w.lock()
+	defer w.unlock()
+	if err != nil {
+		return err
+	}
+	if n < 0 {
+		return errors.New("lzma: negative number of zeros")
+	}
+	if w.cstate == stop {
+		return errClosed
+	}
+	if n == 0 {
+		return nil
+	}
+	if w.storeOnly {
+		for n > 0 {
+			p := zeroBuf[:]
+			if n < int64(len(p)) {
+				p = p[:n]
+			}
+			if _, err = w.write(p); err != nil {
+				return err
+			}
+			n -= int64(len(p))
+		}
+		return nil
+	}
+	if err = w.flushReset(DictReset); err != nil {
+		return err
+	}
+	e := w.encoder
+	for pos := int64(0); pos < n; {
+		u := 0
+		for u < w.chunkSize && pos < n &&
+			e.re.Available() >= int64(e.margin) {
+			k := MaxMatchLen
+			if r := w.chunkSize - u; k > r {
+				k = r
+			}
+			if r := n - pos; int64(k) > r {
+				k = int(r)
+			}
+			if pos == 0 {
+				// The first byte has no predecessor.
+				k = 1
+				if e.trace != nil {
+					e.trace.Literal(e.traceBase, 0)
+				}
+				err = encodeLiteral(e.re, e.state, 0, 0, 0, 0)
+			} else {
+				// A length of 1 is encoded as short
+				// repetition, since rep[0] is 0.
+				m := match{distance: 1, n: k}
+				if e.trace != nil {
+					e.trace.Match(e.traceBase+pos,
+						TraceMatch{1, k}, 0, nil)
+				}
+				err = encodeMatch(e.re, e.state, m, pos)
+			}
+			if err != nil {
+				return err
+			}
+			u += k
+			pos += int64(k)
+		}
+		if err = w.flushZeroChunk(u); err != nil {
+			return err
+		}
+	}
+	e.traceBase += n
+	if w.resetInterval > 0 {
+		for w.nextReset <= w.uncompressed {
+			w.nextReset += w.resetInterval
+		}
+	}
+	return w.resetDict()
+}
+
+// flushZeroChunk writes the chunk of u zeros encoded by WriteZeros.
+func (w *Writer2) flushZeroChunk(u int) error {
+	e := w.encoder
+	if err := e.re.Close(); err != nil {
+		return err
+	}
+	size := w.compressed
+	if err := w.putCompressedChunk(int64(u)); err != nil {
+		return err
+	}
+	if e.trace != nil {
+		w.traceChunk(w.ctype, u, int(w.compressed-size))
+	}
+	e.dedup.skip(u)
+	w.uncompressed += int64(u)
+	w.reportProgress()
+	w.buf.Reset()
+	w.lbw.N = int64(w.compressedChunkSize)
+	if err := e.Reopen(&w.lbw); err != nil {
+		return err
+	}
+	if err := w.cstate.next(w.ctype); err != nil {
+		return err
+	}
+	w.ctype = w.cstate.defaultChunkType()
+	w.start.deepcopy(e.state)
+	return nil
+}
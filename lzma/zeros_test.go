@@ -0,0 +1,79 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package lzma
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestWriter2WriteZeros(t *testing.T) {
+	var text bytes.Buffer
+	io.CopyN(&text, randtxt.NewReader(rand.NewSource(42)), 1<<15)
+	tests := []struct {
+		name  string
+		cfg   Writer2Config
+		zeros []int64
+	}{
+		{"default", Writer2Config{}, []int64{1, 2, 274, 5 << 20}},
+		{"small chunks", Writer2Config{ChunkSize: 1000},
+			[]int64{1 << 16}},
+		{"store", Writer2Config{Store: true}, []int64{1 << 17}},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		w, err := tc.cfg.NewWriter2(&buf)
+		if err != nil {
+			t.Fatalf("%s: NewWriter2 error %s", tc.name, err)
+		}
+		var want []byte
+		for _, n := range tc.zeros {
+			if _, err = w.Write(text.Bytes()); err != nil {
+				t.Fatalf("%s: Write error %s", tc.name, err)
+			}
+			if err = w.WriteZeros(n); err != nil {
+				t.Fatalf("%s: WriteZeros(%d) error %s",
+					tc.name, n, err)
+			}
+			want = append(want, text.Bytes()...)
+			want = append(want, make([]byte, n)...)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("%s: Close error %s", tc.name, err)
+		}
+		// the zeros must be compressed well
+		if !tc.cfg.Store && buf.Len() > text.Len()*len(tc.zeros) {
+			t.Fatalf("%s: compressed size %d too large",
+				tc.name, buf.Len())
+		}
+		if !bytes.Equal(readAll2(t, buf.Bytes()), want) {
+			t.Fatalf("%s: decompressed data differs", tc.name)
+		}
+	}
+}
+
+func TestWriter2WriteZerosSize(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := Writer2Config{Size: 1 << 20}.NewWriter2(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter2 error %s", err)
+	}
+	if err = w.WriteZeros(-1); err == nil {
+		t.Fatalf("WriteZeros(-1) returned no error")
+	}
+	if err = w.WriteZeros(1 << 20); err != nil {
+		t.Fatalf("WriteZeros error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if p := readAll2(t, buf.Bytes()); !bytes.Equal(p, make([]byte, 1<<20)) {
+		t.Fatalf("decompressed data differs")
+	}
+}
@@ -66,14 +66,27 @@ func (f lzmaFilter) reader(r io.Reader, c *ReaderConfig) (fr io.Reader,
 	config := new(lzma.Reader2Config)
 	if c != nil {
 		config.DictCap = c.DictCap
+		config.Tee = c.Tee
+		config.Growth = c.Growth
+		config.DictBuf = c.DictBuf
+		config.MemoryLimit = c.MemoryLimit
+		config.Logger = c.Logger
+		config.FillSize = c.FillSize
 	}
-	dc := int(f.dictCap)
-	if dc < 1 {
-		return nil, errors.New("xz: LZMA2 filter parameter " +
-			"dictionary capacity overflow")
-	}
-	if dc > config.DictCap {
-		config.DictCap = dc
+	// With a memory limit or a dictionary buffer provided by the
+	// caller only the dictionary capacity declared by the filter is
+	// used.
+	if f.dictCap > int64(config.DictCap) || config.MemoryLimit > 0 ||
+		config.DictBuf != nil {
+		if f.dictCap > int64(maxInt) {
+			// The dictionary alone exceeds the limit.
+			if config.MemoryLimit > 0 &&
+				f.dictCap > config.MemoryLimit {
+				return nil, lzma.ErrMemoryLimit
+			}
+			return nil, lzma.ErrPlatformLimit
+		}
+		config.DictCap = int(f.dictCap)
 	}
 
 	fr, err = config.NewReader2(r)
@@ -88,21 +101,14 @@ func (f lzmaFilter) writeCloser(w io.WriteCloser, c *WriterConfig,
 ) (fw io.WriteCloser, err error) {
 	config := new(lzma.Writer2Config)
 	if c != nil {
-		*config = lzma.Writer2Config{
-			Properties: c.Properties,
-			DictCap:    c.DictCap,
-			BufSize:    c.BufSize,
-			Matcher:    c.Matcher,
-		}
+		*config = c.writer2Config()
 	}
 
-	dc := int(f.dictCap)
-	if dc < 1 {
-		return nil, errors.New("xz: LZMA2 filter parameter " +
-			"dictionary capacity overflow")
-	}
-	if dc > config.DictCap {
-		config.DictCap = dc
+	if f.dictCap > int64(config.DictCap) {
+		if f.dictCap > int64(maxInt) {
+			return nil, lzma.ErrPlatformLimit
+		}
+		config.DictCap = int(f.dictCap)
 	}
 
 	fw, err = config.NewWriter2(w)
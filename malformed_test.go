@@ -0,0 +1,104 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// malformedText is the text compressed by the streams in
+// testdata/malformed. The streams are created by
+// testdata/genmalformed.go.
+var malformedText = bytes.Repeat(
+	[]byte("The quick brown fox jumps over the lazy dog.\n"), 20)
+
+// decodeMalformed decodes the file in testdata/malformed with the
+// reader for its format.
+func decodeMalformed(t *testing.T, name string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", "malformed",
+		name))
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	var r io.Reader
+	br := bytes.NewReader(data)
+	switch filepath.Ext(name) {
+	case ".xz":
+		r, err = NewReader(br)
+	case ".lzma":
+		r, err = lzma.ReaderConfig{MemoryLimit: 64 << 20}.NewReader(br)
+	case ".lzma2":
+		r, err = lzma.NewReader2(br)
+	default:
+		t.Fatalf("unsupported file %s", name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadAll(r)
+}
+
+// isChecksum returns a function testing for a checksum error for the
+// given part of the xz stream.
+func isChecksum(part string) func(error) bool {
+	return func(err error) bool {
+		e, ok := err.(*ErrChecksum)
+		return ok && e.Part == part
+	}
+}
+
+func TestMalformed(t *testing.T) {
+	is := func(target error) func(error) bool {
+		return func(err error) bool { return errors.Is(err, target) }
+	}
+	tests := []struct {
+		name string
+		// nil for streams that must decode correctly
+		want func(error) bool
+	}{
+		{"empty.lzma", is(io.ErrUnexpectedEOF)},
+		{"truncated-header.lzma", is(io.ErrUnexpectedEOF)},
+		{"truncated-data.lzma", is(io.ErrUnexpectedEOF)},
+		{"properties.lzma", is(lzma.ErrProperties)},
+		{"small-dict.lzma", is(lzma.ErrDictCap)},
+		{"huge-dict.lzma", is(lzma.ErrMemoryLimit)},
+		{"size-too-long.lzma", is(lzma.ErrUnexpectedEOS)},
+		{"size-too-short.lzma", is(lzma.ErrStreamTooLong)},
+		{"missing-eos.lzma", is(io.ErrUnexpectedEOF)},
+		{"lc0-lp4-pb4.lzma", nil},
+		{"lc8-lp0-pb0.lzma", nil},
+		{"lc4-lp4-pb1.lzma", nil},
+		{"lc-lp.lzma2", is(lzma.ErrProperties)},
+		{"truncated.lzma2", is(io.ErrUnexpectedEOF)},
+		{"truncated-header.xz", is(io.ErrUnexpectedEOF)},
+		{"header-crc.xz", isChecksum("stream header")},
+		{"block-header-crc.xz", isChecksum("block header")},
+		{"block-check.xz", isChecksum("block")},
+		{"index-crc.xz", isChecksum("index")},
+		{"footer-crc.xz", isChecksum("stream footer")},
+		{"lc-lp.xz", is(lzma.ErrProperties)},
+	}
+	for _, c := range tests {
+		out, err := decodeMalformed(t, c.name)
+		if c.want == nil {
+			if err != nil {
+				t.Errorf("%s: error %s", c.name, err)
+			} else if !bytes.Equal(out, malformedText) {
+				t.Errorf("%s: decoded text differs", c.name)
+			}
+			continue
+		}
+		if err == nil || !c.want(err) {
+			t.Errorf("%s: unexpected error %v", c.name, err)
+		}
+	}
+}
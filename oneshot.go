@@ -0,0 +1,125 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+)
+
+// Dictionary capacities of the pooled writers and dictionary buffers
+// used by Compress and Decompress. The capacities are the powers of two
+// in the range.
+const (
+	minPoolDictLog = 12
+	maxPoolDictLog = 23
+)
+
+// poolDictLog returns the binary logarithm of the smallest pooled
+// dictionary capacity that holds n bytes. The largest capacity is
+// returned for large n.
+func poolDictLog(n int64) int {
+	k := minPoolDictLog
+	for k < maxPoolDictLog && 1<<uint(k) < n {
+		k++
+	}
+	return k
+}
+
+// writerPools keep the writers used by Compress and dictPools the
+// dictionary buffers used by Decompress; one pool for each dictionary
+// capacity.
+var (
+	writerPools [maxPoolDictLog - minPoolDictLog + 1]sync.Pool
+	dictPools   [maxPoolDictLog - minPoolDictLog + 1]sync.Pool
+)
+
+func init() {
+	for i := range writerPools {
+		dictCap := 1 << uint(minPoolDictLog+i)
+		writerPools[i].New = func() interface{} {
+			w, err := WriterConfig{DictCap: dictCap}.NewWriter(
+				ioutil.Discard)
+			if err != nil {
+				panic(err)
+			}
+			return w
+		}
+		dictPools[i].New = func() interface{} {
+			// A pointer avoids an allocation by Put.
+			p := make([]byte, dictCap+1)
+			return &p
+		}
+	}
+}
+
+// maxPrealloc limits the memory allocated in advance for decompressed
+// data of the size declared by the indexes, which cannot be trusted.
+const maxPrealloc = 1 << 26
+
+// Compress appends the data of src compressed as xz stream to dst and
+// returns the extended slice. If c is nil, the default parameters with
+// a dictionary capacity fitting the size of src are used and the
+// writers are reused from a pool, which makes the function efficient
+// for many small payloads.
+func Compress(dst, src []byte, c *WriterConfig) ([]byte, error) {
+	buf := bytes.NewBuffer(dst)
+	var w *Writer
+	var err error
+	if c == nil {
+		pool := &writerPools[poolDictLog(int64(len(src)))-minPoolDictLog]
+		w = pool.Get().(*Writer)
+		if err = w.Reset(buf); err != nil {
+			return dst, err
+		}
+		defer pool.Put(w)
+	} else if w, err = c.NewWriter(buf); err != nil {
+		return dst, err
+	}
+	if _, err = w.Write(src); err != nil {
+		return dst, err
+	}
+	if err = w.Close(); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Decompress appends the data decompressed from the xz streams in src
+// to dst and returns the extended slice. The indexes of the streams are
+// used to allocate the space for the decompressed data in advance. If
+// c is nil, the default parameters are used and the dictionary buffers
+// are reused from a pool.
+func Decompress(dst, src []byte, c *ReaderConfig) ([]byte, error) {
+	var cfg ReaderConfig
+	if c != nil {
+		cfg = *c
+	}
+	buf := bytes.NewBuffer(dst)
+	// Damaged indexes are detected by the reader.
+	if info, err := StatAt(bytes.NewReader(src),
+		int64(len(src))); err == nil {
+		n := info.UncompressedSize
+		if n > maxPrealloc {
+			n = maxPrealloc
+		}
+		buf.Grow(int(n))
+		if d := info.DictCap(); c == nil && d <= 1<<maxPoolDictLog {
+			pool := &dictPools[poolDictLog(d)-minPoolDictLog]
+			dictBuf := pool.Get().(*[]byte)
+			defer pool.Put(dictBuf)
+			cfg.DictBuf = *dictBuf
+		}
+	}
+	r, err := cfg.NewReader(bytes.NewReader(src))
+	if err != nil {
+		return dst, err
+	}
+	if _, err = r.WriteTo(buf); err != nil {
+		return dst, err
+	}
+	return buf.Bytes(), nil
+}
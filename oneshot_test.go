@@ -0,0 +1,57 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+func TestCompressDecompress(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 100000)
+	prefix := []byte("prefix")
+	for _, n := range []int{0, 10, 5000, 100000} {
+		data := txt.Bytes()[:n]
+		// The second round uses the pooled writers and buffers.
+		for i := 0; i < 2; i++ {
+			z, err := Compress(prefix, data, nil)
+			if err != nil {
+				t.Fatalf("Compress error %s", err)
+			}
+			if !bytes.HasPrefix(z, prefix) {
+				t.Fatalf("Compress didn't append to dst")
+			}
+			p, err := Decompress(prefix, z[len(prefix):], nil)
+			if err != nil {
+				t.Fatalf("Decompress error %s", err)
+			}
+			if !bytes.Equal(p[len(prefix):], data) ||
+				!bytes.HasPrefix(p, prefix) {
+				t.Fatalf("n=%d: decompressed data differs", n)
+			}
+		}
+	}
+	data := txt.Bytes()
+	z, err := Compress(nil, data, &WriterConfig{BlockSize: 10000})
+	if err != nil {
+		t.Fatalf("Compress error %s", err)
+	}
+	p, err := Decompress(nil, z, &ReaderConfig{SingleStream: true})
+	if err != nil {
+		t.Fatalf("Decompress error %s", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decompressed data differs")
+	}
+	z[len(z)/2] ^= 0x55
+	if _, err = Decompress(nil, z, nil); err == nil {
+		t.Fatalf("Decompress of damaged stream returned no error")
+	}
+}
@@ -0,0 +1,307 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"hash"
+	"io"
+	"runtime"
+	"sync"
+)
+
+// ParallelReader decompresses the blocks of an xz file with multiple
+// goroutines. The blocks are located using the indexes of the streams,
+// so the file must be provided by an io.ReaderAt. Each worker
+// decompresses a complete block and verifies its check, so expensive
+// checks like SHA-256 are computed in parallel too. The goroutine
+// returning the blocks in the order of the file doesn't compute any
+// hashes.
+//
+// Up to two blocks per worker are held in memory, so the reader is
+// suited for files with small blocks; see WriterConfig.BlockSize. Every
+// worker allocates its own LZMA2 decoder and the DictBuf field of the
+// reader configuration is not used. The Logger is called by the
+// workers concurrently. BlockHook, Progress and Tee are called by the
+// goroutine calling Read.
+type ParallelReader struct {
+	ReaderConfig
+
+	// queue of the results of the blocks in the order of the file
+	results chan chan *blockResult
+	done    chan struct{}
+	once    sync.Once
+	// data of the current block that hasn't been returned
+	data []byte
+	// compressed and uncompressed sizes of the blocks returned
+	compressed   int64
+	uncompressed int64
+	err          error
+}
+
+// blockJob describes a block to be decoded by a worker of the
+// ParallelReader.
+type blockJob struct {
+	BlockInfo
+	check   byte
+	newHash func() hash.Hash
+	result  chan *blockResult
+}
+
+// blockResult provides the data of a decoded block.
+type blockResult struct {
+	data []byte
+	// offset of the end of the block in the file
+	end  int64
+	info BlockHeaderInfo
+	err  error
+}
+
+// errReaderClosed indicates that the reader has been closed.
+var errReaderClosed = errors.New("xz: reader already closed")
+
+// NewParallelReader creates a parallel reader for the xz file of the
+// given size provided by the io.ReaderAt using the default parameters.
+func NewParallelReader(xz io.ReaderAt, size int64) (r *ParallelReader,
+	err error) {
+
+	return ReaderConfig{}.NewParallelReader(xz, size)
+}
+
+// NewParallelReader creates a parallel reader for the xz file of the
+// given size provided by the io.ReaderAt. The stream footers, indexes
+// and headers are read by the function. The Workers field of the
+// configuration sets the number of goroutines decoding blocks.
+func (c ReaderConfig) NewParallelReader(xz io.ReaderAt, size int64) (
+	r *ParallelReader, err error) {
+
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	if c.Workers == 0 {
+		c.Workers = runtime.GOMAXPROCS(0)
+	}
+	c.DictBuf = nil
+	info, err := StatAt(xz, size)
+	if err != nil {
+		return nil, err
+	}
+	if c.SingleStream && (len(info.Streams) > 1 ||
+		info.Streams[0].Padding > 0) {
+		return nil, errUnexpectedData
+	}
+	var jobs []*blockJob
+	var u int64
+	max := c.MaxUncompressedSize
+	for _, s := range info.Streams {
+		newHash, err := c.newCheck(s.Check)
+		if err != nil {
+			return nil, err
+		}
+		pos := s.Offset + HeaderLen
+		for _, rec := range s.Index.records {
+			// Blocks starting after the limit are not needed to
+			// detect that it is exceeded.
+			if max > 0 && u > max {
+				break
+			}
+			jobs = append(jobs, &blockJob{
+				BlockInfo: BlockInfo{
+					BlockRecord: BlockRecord{
+						UnpaddedSize:     rec.unpaddedSize,
+						UncompressedSize: rec.uncompressedSize,
+					},
+					Offset:             pos,
+					UncompressedOffset: u,
+				},
+				check:   s.Check,
+				newHash: newHash,
+				result:  make(chan *blockResult, 1),
+			})
+			pos += rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+			u += rec.uncompressedSize
+		}
+	}
+	r = &ParallelReader{
+		ReaderConfig: c,
+		results:      make(chan chan *blockResult, c.Workers),
+		done:         make(chan struct{}),
+	}
+	go r.dispatch(xz, jobs)
+	return r, nil
+}
+
+// dispatch distributes the jobs to the workers and queues their results
+// in the order of the file.
+func (r *ParallelReader) dispatch(xz io.ReaderAt, jobs []*blockJob) {
+	defer close(r.results)
+	work := make(chan *blockJob)
+	defer close(work)
+	n := r.Workers
+	if n > len(jobs) {
+		n = len(jobs)
+	}
+	for i := 0; i < n; i++ {
+		go r.work(xz, work)
+	}
+	for _, j := range jobs {
+		select {
+		case r.results <- j.result:
+		case <-r.done:
+			return
+		}
+		select {
+		case work <- j:
+		case <-r.done:
+			return
+		}
+	}
+}
+
+// work decodes the blocks received from jobs.
+func (r *ParallelReader) work(xz io.ReaderAt, jobs <-chan *blockJob) {
+	for j := range jobs {
+		j.result <- r.decodeBlock(xz, j)
+	}
+}
+
+// decodeBlock decompresses the block and verifies its check and its
+// record in the index.
+func (r *ParallelReader) decodeBlock(xz io.ReaderAt,
+	j *blockJob) *blockResult {
+
+	size := j.UnpaddedSize + int64(padLen(j.UnpaddedSize))
+	res := &blockResult{end: j.Offset + size}
+	sr := io.NewSectionReader(xz, j.Offset, size)
+	bh, hlen, err := readBlockHeader(sr)
+	if err != nil {
+		if err == io.EOF || err == errIndexIndicator {
+			err = errStat
+		}
+		res.err = err
+		return res
+	}
+	if r.Logger != nil {
+		r.Logger.Debugf("block %v", *bh)
+	}
+	br, err := r.ReaderConfig.newBlockReader(sr, bh, hlen, j.newHash())
+	if err != nil {
+		res.err = err
+		return res
+	}
+	var buf bytes.Buffer
+	if n := j.UncompressedSize; n <= maxPrealloc {
+		buf.Grow(int(n))
+	}
+	// The record limits the memory required for the block.
+	lw := &limitWriter{w: &buf, n: j.UncompressedSize}
+	if _, err = br.WriteTo(lw); err != nil {
+		if lw.exceeded {
+			err = errIndex
+		}
+		res.err = err
+		return res
+	}
+	if br.record() != (record{j.UnpaddedSize, j.UncompressedSize}) {
+		res.err = errIndex
+		return res
+	}
+	if r.BlockHook != nil {
+		if res.info, err = newBlockHeaderInfo(bh, hlen,
+			j.check); err != nil {
+			res.err = err
+			return res
+		}
+		res.info.BlockInfo = j.BlockInfo
+	}
+	res.data = buf.Bytes()
+	return res
+}
+
+// nextBlock waits for the next block and reports it. The function
+// returns io.EOF after the last block and ErrLimit if the block exceeds
+// MaxUncompressedSize. The data of the block up to the limit is
+// available nevertheless.
+func (r *ParallelReader) nextBlock() error {
+	rc, ok := <-r.results
+	if !ok {
+		return io.EOF
+	}
+	res := <-rc
+	if res.err != nil {
+		return res.err
+	}
+	if r.BlockHook != nil {
+		r.BlockHook(res.info)
+	}
+	r.compressed = res.end
+	r.uncompressed += int64(len(res.data))
+	if r.Progress != nil {
+		r.Progress(r.compressed, r.uncompressed)
+	}
+	r.data = res.data
+	if max := r.MaxUncompressedSize; max > 0 && r.uncompressed > max {
+		r.data = r.data[:int64(len(r.data))-(r.uncompressed-max)]
+		return ErrLimit
+	}
+	return nil
+}
+
+// Read reads uncompressed data. It waits for the next block only if all
+// data of the current block has been returned.
+func (r *ParallelReader) Read(p []byte) (n int, err error) {
+	for len(r.data) == 0 {
+		if r.err != nil {
+			return 0, r.err
+		}
+		r.err = r.nextBlock()
+	}
+	n = copy(p, r.data)
+	if r.Tee != nil {
+		if n, err = r.Tee.Write(r.data[:n]); err != nil {
+			r.data = r.data[n:]
+			return n, err
+		}
+	}
+	r.data = r.data[n:]
+	return n, nil
+}
+
+// WriteTo writes the uncompressed data to w. It implements io.WriterTo,
+// so the blocks aren't copied into an intermediate buffer.
+func (r *ParallelReader) WriteTo(w io.Writer) (n int64, err error) {
+	if r.Tee != nil {
+		w = io.MultiWriter(r.Tee, w)
+	}
+	for {
+		for len(r.data) == 0 {
+			switch r.err {
+			case nil:
+				r.err = r.nextBlock()
+				continue
+			case io.EOF:
+				return n, nil
+			}
+			return n, r.err
+		}
+		k, err := w.Write(r.data)
+		n += int64(k)
+		r.data = r.data[k:]
+		if err != nil {
+			return n, err
+		}
+	}
+}
+
+// Close stops the workers. The reader cannot be used afterwards. Close
+// must be called if the reader is not read until io.EOF; otherwise the
+// goroutines of the reader are not terminated.
+func (r *ParallelReader) Close() error {
+	r.once.Do(func() { close(r.done) })
+	r.data = nil
+	r.err = errReaderClosed
+	return nil
+}
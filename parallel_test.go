@@ -0,0 +1,224 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// barrierCheck identifies a SHA-256 check whose hashes wait in their
+// first Write until the hashes of two blocks have been started. The
+// wait times out unless the blocks are verified concurrently.
+const barrierCheck = 0xb
+
+var barrier = struct {
+	sync.Mutex
+	started  int
+	all      chan struct{}
+	timeouts int
+}{all: make(chan struct{})}
+
+type barrierHash struct {
+	hash.Hash
+	started bool
+}
+
+func (h *barrierHash) Write(p []byte) (n int, err error) {
+	if !h.started {
+		h.started = true
+		barrier.Lock()
+		if barrier.started++; barrier.started == 2 {
+			close(barrier.all)
+		}
+		barrier.Unlock()
+		select {
+		case <-barrier.all:
+		case <-time.After(5 * time.Second):
+			barrier.Lock()
+			barrier.timeouts++
+			barrier.Unlock()
+		}
+	}
+	return h.Hash.Write(p)
+}
+
+func init() {
+	err := RegisterCheck(barrierCheck, "barrier", func() hash.Hash {
+		return &barrierHash{Hash: sha256.New()}
+	})
+	if err != nil {
+		panic(err)
+	}
+}
+
+// parallelData returns text and a stream compressing it in small
+// blocks with SHA-256 checks.
+func parallelData(t *testing.T) (data, xz []byte) {
+	var text bytes.Buffer
+	io.CopyN(&text, randtxt.NewReader(rand.NewSource(42)), 200000)
+	var buf bytes.Buffer
+	w, err := WriterConfig{BlockSize: 1 << 14, CheckSum: SHA256}.NewWriter(
+		&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(text.Bytes()); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	return text.Bytes(), buf.Bytes()
+}
+
+func TestParallelReader(t *testing.T) {
+	data, xz := parallelData(t)
+	// two streams separated by stream padding
+	file := append(append(append([]byte(nil), xz...), 0, 0, 0, 0), xz...)
+	data = append(append([]byte(nil), data...), data...)
+	info, err := StatAt(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("StatAt error %s", err)
+	}
+	want := info.BlockMap()
+	for _, workers := range []int{1, 3, 0} {
+		var blocks []BlockInfo
+		cfg := ReaderConfig{
+			Workers: workers,
+			BlockHook: func(b BlockHeaderInfo) {
+				blocks = append(blocks, b.BlockInfo)
+			},
+		}
+		r, err := cfg.NewParallelReader(bytes.NewReader(file),
+			int64(len(file)))
+		if err != nil {
+			t.Fatalf("NewParallelReader error %s", err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("workers %d: ReadAll error %s", workers, err)
+		}
+		if !bytes.Equal(p, data) {
+			t.Fatalf("workers %d: decompressed data differs",
+				workers)
+		}
+		if len(blocks) != len(want) {
+			t.Fatalf("workers %d: BlockHook called %d times; want %d",
+				workers, len(blocks), len(want))
+		}
+		for i, b := range blocks {
+			if b != want[i] {
+				t.Fatalf("workers %d: block %d is %+v; want %+v",
+					workers, i, b, want[i])
+			}
+		}
+	}
+
+	r, err := NewParallelReader(bytes.NewReader(file), int64(len(file)))
+	if err != nil {
+		t.Fatalf("NewParallelReader error %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if !bytes.Equal(buf.Bytes(), data) {
+		t.Fatalf("WriteTo: decompressed data differs")
+	}
+}
+
+func TestParallelReaderChecksum(t *testing.T) {
+	data, xz := parallelData(t)
+	info, err := StatAt(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("StatAt error %s", err)
+	}
+	b := info.BlockMap()[2]
+	xz[b.Offset+b.UnpaddedSize-1] ^= 1
+	r, err := ReaderConfig{Workers: 2}.NewParallelReader(
+		bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("NewParallelReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if _, ok := err.(*ErrChecksum); !ok {
+		t.Fatalf("ReadAll returned error %v; want *ErrChecksum", err)
+	}
+	if int64(len(p)) != b.UncompressedOffset {
+		t.Fatalf("ReadAll returned %d bytes; want %d", len(p),
+			b.UncompressedOffset)
+	}
+	if err = r.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if _, err = r.Read(p); err != errReaderClosed {
+		t.Fatalf("Read after Close returned %v; want %v", err,
+			errReaderClosed)
+	}
+
+	r, err = ReaderConfig{IgnoreChecks: true}.NewParallelReader(
+		bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("NewParallelReader error %s", err)
+	}
+	if p, err = ioutil.ReadAll(r); err != nil {
+		t.Fatalf("IgnoreChecks: ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("IgnoreChecks: decompressed data differs")
+	}
+}
+
+// TestParallelReaderWorkerChecks verifies that the checks of the blocks
+// are computed by the workers concurrently.
+func TestParallelReaderWorkerChecks(t *testing.T) {
+	data, xz := parallelData(t)
+	setCheck(xz, barrierCheck)
+	r, err := ReaderConfig{Workers: 2}.NewParallelReader(
+		bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("NewParallelReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("decompressed data differs")
+	}
+	barrier.Lock()
+	defer barrier.Unlock()
+	if barrier.timeouts > 0 {
+		t.Fatalf("checks of the blocks not computed concurrently")
+	}
+}
+
+func TestParallelReaderLimit(t *testing.T) {
+	data, xz := parallelData(t)
+	max := int64(len(data)) / 3
+	r, err := ReaderConfig{MaxUncompressedSize: max}.NewParallelReader(
+		bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("NewParallelReader error %s", err)
+	}
+	defer r.Close()
+	p, err := ioutil.ReadAll(r)
+	if err != ErrLimit {
+		t.Fatalf("ReadAll returned error %v; want %v", err, ErrLimit)
+	}
+	if !bytes.Equal(p, data[:max]) {
+		t.Fatalf("ReadAll returned %d bytes; want %d", len(p), max)
+	}
+}
@@ -0,0 +1,349 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package rangecodec provides the binary range coder of LZMA as a
+// reusable entropy coder. Bits are encoded either with an adaptive
+// probability or directly with the probability 1/2. Bit trees encode
+// values of a fixed number of bits using a tree of probabilities.
+//
+// The encoded streams are compatible with the range coder of the LZMA
+// and LZMA2 formats: the stream starts with a zero byte and the
+// encoder writes five bytes on Close to flush its state. The package
+// lzma uses its own copy of the coder, which is specialized for the
+// decoding loops of LZMA.
+package rangecodec
+
+import (
+	"errors"
+	"io"
+)
+
+// Parameters of the probability model.
+const (
+	// ProbBits is the number of bits of a probability value.
+	ProbBits = 11
+	// MoveBits controls the adaptation speed of the probabilities.
+	MoveBits = 5
+)
+
+// Prob is the probability that the next bit encoded or decoded with it
+// is zero in units of 2^-ProbBits. It adapts to the bits coded.
+type Prob uint16
+
+// ProbInit is the probability 1/2, which is the initial value for all
+// probabilities.
+const ProbInit Prob = 1 << (ProbBits - 1)
+
+// InitProbs sets all probabilities in p to ProbInit.
+func InitProbs(p []Prob) {
+	for i := range p {
+		p[i] = ProbInit
+	}
+}
+
+// bound computes the bound dividing the range for the probability.
+func (p Prob) bound(r uint32) uint32 {
+	return (r >> ProbBits) * uint32(p)
+}
+
+// inc increases the probability after a zero bit.
+func (p *Prob) inc() {
+	*p += ((1 << ProbBits) - *p) >> MoveBits
+}
+
+// dec decreases the probability after a one bit.
+func (p *Prob) dec() {
+	*p -= *p >> MoveBits
+}
+
+// top is the limit below which the range is normalized.
+const top = 1 << 24
+
+// Encoder encodes bits into a byte stream. The low value can overflow,
+// therefore it is an uint64. The cache handles the carries into bytes
+// already computed.
+type Encoder struct {
+	bw       io.ByteWriter
+	nrange   uint32
+	low      uint64
+	cacheLen int64
+	cache    byte
+	n        int64
+}
+
+// NewEncoder creates an encoder writing to bw.
+func NewEncoder(bw io.ByteWriter) *Encoder {
+	e := new(Encoder)
+	e.Reset(bw)
+	return e
+}
+
+// Reset puts the encoder into its initial state for writing to bw.
+func (e *Encoder) Reset(bw io.ByteWriter) {
+	*e = Encoder{bw: bw, nrange: 0xffffffff, cacheLen: 1}
+}
+
+// Written returns the number of bytes written to the byte writer.
+func (e *Encoder) Written() int64 { return e.n }
+
+// EncodeBit encodes the least significant bit of b using the
+// probability p, which is updated.
+func (e *Encoder) EncodeBit(b uint32, p *Prob) error {
+	bound := p.bound(e.nrange)
+	if b&1 == 0 {
+		e.nrange = bound
+		p.inc()
+	} else {
+		e.low += uint64(bound)
+		e.nrange -= bound
+		p.dec()
+	}
+	if e.nrange >= top {
+		return nil
+	}
+	e.nrange <<= 8
+	return e.shiftLow()
+}
+
+// EncodeDirect encodes the n least significant bits of v with the
+// probability 1/2, starting with the most significant bit.
+func (e *Encoder) EncodeDirect(v uint32, n int) error {
+	for i := n - 1; i >= 0; i-- {
+		e.nrange >>= 1
+		e.low += uint64(e.nrange) & (0 - (uint64(v>>uint(i)) & 1))
+		if e.nrange >= top {
+			continue
+		}
+		e.nrange <<= 8
+		if err := e.shiftLow(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Close flushes the state of the encoder to the byte writer. The
+// encoder must be reset before it can be used again.
+func (e *Encoder) Close() error {
+	for i := 0; i < 5; i++ {
+		if err := e.shiftLow(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// shiftLow shifts the low value by 8 bits and writes the bytes that
+// cannot change anymore.
+func (e *Encoder) shiftLow() error {
+	if uint32(e.low) < 0xff000000 || (e.low>>32) != 0 {
+		tmp := e.cache
+		for {
+			err := e.bw.WriteByte(tmp + byte(e.low>>32))
+			if err != nil {
+				return err
+			}
+			e.n++
+			tmp = 0xff
+			e.cacheLen--
+			if e.cacheLen <= 0 {
+				break
+			}
+		}
+		e.cache = byte(uint32(e.low) >> 24)
+	}
+	e.cacheLen++
+	e.low = uint64(uint32(e.low) << 8)
+	return nil
+}
+
+// Errors returned for streams that cannot have been produced by the
+// encoder.
+var (
+	ErrFirstByte = errors.New("rangecodec: first byte not zero")
+	ErrCode      = errors.New("rangecodec: code out of range")
+)
+
+// Decoder decodes bits from a byte stream. The decoding methods don't
+// return errors to keep them fast. The first error is recorded and
+// returned by Err; after it the decoder reads only zero bytes. Callers
+// should check Err after decoding a unit of data.
+type Decoder struct {
+	br     io.ByteReader
+	nrange uint32
+	code   uint32
+	err    error
+	n      int64
+}
+
+// NewDecoder creates a decoder reading from br. It reads the first five
+// bytes of the stream.
+func NewDecoder(br io.ByteReader) (d *Decoder, err error) {
+	d = new(Decoder)
+	if err = d.Reset(br); err != nil {
+		return nil, err
+	}
+	return d, nil
+}
+
+// Reset puts the decoder into its initial state for reading from br
+// and reads the first five bytes of the stream.
+func (d *Decoder) Reset(br io.ByteReader) error {
+	*d = Decoder{br: br, nrange: 0xffffffff}
+	if d.readByte() != 0 && d.err == nil {
+		d.err = ErrFirstByte
+	}
+	for i := 0; i < 4; i++ {
+		d.code = d.code<<8 | uint32(d.readByte())
+	}
+	if d.code >= d.nrange && d.err == nil {
+		d.err = ErrCode
+	}
+	return d.err
+}
+
+// Err returns the first error that occurred while decoding.
+func (d *Decoder) Err() error { return d.err }
+
+// Consumed returns the number of bytes read from the byte reader.
+func (d *Decoder) Consumed() int64 { return d.n }
+
+// PossiblyAtEnd reports whether the stream may be complete. The code
+// of a stream flushed by Encoder.Close is zero after the last bit has
+// been decoded.
+func (d *Decoder) PossiblyAtEnd() bool { return d.code == 0 }
+
+// DecodeBit decodes a bit using the probability p, which is updated.
+// The bit is returned in the least significant bit.
+func (d *Decoder) DecodeBit(p *Prob) (b uint32) {
+	bound := p.bound(d.nrange)
+	if d.code < bound {
+		d.nrange = bound
+		p.inc()
+	} else {
+		d.code -= bound
+		d.nrange -= bound
+		p.dec()
+		b = 1
+	}
+	if d.nrange < top {
+		d.normalize()
+	}
+	return b
+}
+
+// DecodeDirect decodes n bits with the probability 1/2. The first bit
+// decoded is the most significant bit of the value returned.
+func (d *Decoder) DecodeDirect(n int) (v uint32) {
+	for i := 0; i < n; i++ {
+		d.nrange >>= 1
+		d.code -= d.nrange
+		t := 0 - (d.code >> 31)
+		d.code += d.nrange & t
+		v = v<<1 | (t+1)&1
+		if d.nrange < top {
+			d.normalize()
+		}
+	}
+	return v
+}
+
+// normalize shifts the range and reads the next byte into the code. A
+// code exceeding the range is recorded as error.
+func (d *Decoder) normalize() {
+	d.nrange <<= 8
+	d.code = d.code<<8 | uint32(d.readByte())
+	if d.code >= d.nrange && d.err == nil {
+		d.err = ErrCode
+	}
+}
+
+// readByte returns the next byte of the stream or zero if the byte
+// reader fails.
+func (d *Decoder) readByte() byte {
+	if d.err != nil {
+		return 0
+	}
+	c, err := d.br.ReadByte()
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		d.err = err
+		return 0
+	}
+	d.n++
+	return c
+}
+
+// BitTree encodes and decodes values with a fixed number of bits. Each
+// bit is coded with a probability selected by the bits preceding it.
+type BitTree struct {
+	probs []Prob
+	bits  int
+}
+
+// NewBitTree creates a bit tree for values with the given number of
+// bits in the range 1 to 16.
+func NewBitTree(bits int) *BitTree {
+	if !(1 <= bits && bits <= 16) {
+		panic("rangecodec: bits out of range [1,16]")
+	}
+	t := &BitTree{probs: make([]Prob, 1<<uint(bits)), bits: bits}
+	t.Reset()
+	return t
+}
+
+// Reset sets all probabilities of the tree to ProbInit.
+func (t *BitTree) Reset() { InitProbs(t.probs) }
+
+// Bits returns the number of bits of the values coded by the tree.
+func (t *BitTree) Bits() int { return t.bits }
+
+// Encode encodes the value v starting with the most significant bit.
+func (t *BitTree) Encode(e *Encoder, v uint32) error {
+	m := uint32(1)
+	for i := t.bits - 1; i >= 0; i-- {
+		b := (v >> uint(i)) & 1
+		if err := e.EncodeBit(b, &t.probs[m]); err != nil {
+			return err
+		}
+		m = m<<1 | b
+	}
+	return nil
+}
+
+// Decode decodes a value encoded by Encode.
+func (t *BitTree) Decode(d *Decoder) (v uint32) {
+	m := uint32(1)
+	for i := 0; i < t.bits; i++ {
+		m = m<<1 | d.DecodeBit(&t.probs[m])
+	}
+	return m - 1<<uint(t.bits)
+}
+
+// EncodeReverse encodes the value v starting with the least
+// significant bit.
+func (t *BitTree) EncodeReverse(e *Encoder, v uint32) error {
+	m := uint32(1)
+	for i := 0; i < t.bits; i++ {
+		b := (v >> uint(i)) & 1
+		if err := e.EncodeBit(b, &t.probs[m]); err != nil {
+			return err
+		}
+		m = m<<1 | b
+	}
+	return nil
+}
+
+// DecodeReverse decodes a value encoded by EncodeReverse.
+func (t *BitTree) DecodeReverse(d *Decoder) (v uint32) {
+	m := uint32(1)
+	for i := 0; i < t.bits; i++ {
+		b := d.DecodeBit(&t.probs[m])
+		m = m<<1 | b
+		v |= b << uint(i)
+	}
+	return v
+}
@@ -0,0 +1,165 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package rangecodec
+
+import (
+	"bytes"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+// value describes a value coded in the tests.
+type value struct {
+	// 0 for a single bit, 1 for direct bits, 2 for a bit tree and 3
+	// for a reverse bit tree
+	kind int
+	v    uint32
+}
+
+// randomValues returns n values with skewed bits, so the probabilities
+// adapt.
+func randomValues(n int) []value {
+	rnd := rand.New(rand.NewSource(42))
+	values := make([]value, n)
+	for i := range values {
+		kind := rnd.Intn(4)
+		var v uint32
+		switch kind {
+		case 0:
+			if rnd.Intn(10) == 0 {
+				v = 1
+			}
+		case 1:
+			v = uint32(rnd.Intn(1 << 13))
+		default:
+			v = uint32(rnd.Intn(16))
+		}
+		values[i] = value{kind, v}
+	}
+	return values
+}
+
+// encodeValues encodes the values and returns the stream.
+func encodeValues(t testing.TB, values []value) []byte {
+	var buf bytes.Buffer
+	e := NewEncoder(&buf)
+	var p Prob = ProbInit
+	tree := NewBitTree(6)
+	var err error
+	for _, x := range values {
+		switch x.kind {
+		case 0:
+			err = e.EncodeBit(x.v, &p)
+		case 1:
+			err = e.EncodeDirect(x.v, 13)
+		case 2:
+			err = tree.Encode(e, x.v)
+		case 3:
+			err = tree.EncodeReverse(e, x.v)
+		}
+		if err != nil {
+			t.Fatalf("Encode error %s", err)
+		}
+	}
+	if err = e.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if e.Written() != int64(buf.Len()) {
+		t.Fatalf("Written returned %d; want %d", e.Written(),
+			buf.Len())
+	}
+	return buf.Bytes()
+}
+
+// decodeValues decodes the values and checks them against the values
+// given.
+func decodeValues(t testing.TB, stream []byte, values []value) {
+	d, err := NewDecoder(bytes.NewReader(stream))
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	var p Prob = ProbInit
+	tree := NewBitTree(6)
+	for i, x := range values {
+		var v uint32
+		switch x.kind {
+		case 0:
+			v = d.DecodeBit(&p)
+		case 1:
+			v = d.DecodeDirect(13)
+		case 2:
+			v = tree.Decode(d)
+		case 3:
+			v = tree.DecodeReverse(d)
+		}
+		if v != x.v {
+			t.Fatalf("value %d: decoded %d; want %d", i, v, x.v)
+		}
+	}
+	if err = d.Err(); err != nil {
+		t.Fatalf("decoder error %s", err)
+	}
+	if !d.PossiblyAtEnd() {
+		t.Fatalf("decoder not at end")
+	}
+	if d.Consumed() != int64(len(stream)) {
+		t.Fatalf("Consumed returned %d; want %d", d.Consumed(),
+			len(stream))
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	values := randomValues(100000)
+	stream := encodeValues(t, values)
+	if stream[0] != 0 {
+		t.Fatalf("first byte %#02x; want 0", stream[0])
+	}
+	decodeValues(t, stream, values)
+}
+
+func TestDecoderErrors(t *testing.T) {
+	_, err := NewDecoder(bytes.NewReader([]byte{1, 0, 0, 0, 0}))
+	if err != ErrFirstByte {
+		t.Fatalf("NewDecoder returned %v; want %v", err, ErrFirstByte)
+	}
+	_, err = NewDecoder(bytes.NewReader([]byte{0, 0}))
+	if err != io.ErrUnexpectedEOF {
+		t.Fatalf("NewDecoder returned %v; want %v", err,
+			io.ErrUnexpectedEOF)
+	}
+	values := randomValues(1000)
+	stream := encodeValues(t, values)
+	d, err := NewDecoder(bytes.NewReader(stream[:len(stream)/2]))
+	if err != nil {
+		t.Fatalf("NewDecoder error %s", err)
+	}
+	d.DecodeDirect(32)
+	for d.Err() == nil {
+		d.DecodeDirect(32)
+	}
+	if d.Err() != io.ErrUnexpectedEOF {
+		t.Fatalf("Err returned %v; want %v", d.Err(),
+			io.ErrUnexpectedEOF)
+	}
+}
+
+func BenchmarkEncode(b *testing.B) {
+	values := randomValues(1 << 16)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		encodeValues(b, values)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	values := randomValues(1 << 16)
+	stream := encodeValues(b, values)
+	b.SetBytes(int64(len(stream)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		decodeValues(b, stream, values)
+	}
+}
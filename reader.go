@@ -5,6 +5,15 @@
 // Package xz supports the compression and decompression of xz files. It
 // supports version 1.0.4 of the specification without the non-LZMA2
 // filters. See http://tukaani.org/xz/xz-file-format-1.0.4.txt
+//
+// The compressed output is deterministic. The same data written with
+// the same configuration results in byte-identical output, independent
+// of the platform, the sizes of the Write calls and the number of
+// goroutines compressing other data at the same time. A writer reused
+// by Reset produces the same output as a new writer. Calls of Flush
+// change the output, so does a positive WriterConfig.MaxDelay, whose
+// timer flushes the writer depending on the timing of the Write calls.
+// The output may differ between versions of the package.
 package xz
 
 import (
@@ -13,8 +22,9 @@ import (
 	"fmt"
 	"hash"
 	"io"
+	"io/ioutil"
 
-	"github.com/ulikunitz/xz/internal/xlog"
+	"github.com/ulikunitz/xz/checks"
 	"github.com/ulikunitz/xz/lzma"
 )
 
@@ -24,6 +34,53 @@ import (
 type ReaderConfig struct {
 	DictCap      int
 	SingleStream bool
+	// Tee receives the decompressed data before it is returned by
+	// Read. Note that the data is written before the check of the
+	// block has been verified.
+	Tee io.Writer
+	// Growth controls the allocation of the dictionary buffers. Zero
+	// values select the defaults.
+	Growth lzma.Growth
+	// DictBuf provides the memory for the dictionary buffer of the
+	// LZMA2 decoder, which is shared by the blocks and not
+	// allocated. Its capacity must exceed the dictionary capacity of
+	// every block by at least one byte; otherwise lzma.ErrDictBuf is
+	// returned.
+	DictBuf []byte
+	// MemoryLimit limits the memory in bytes required by the LZMA2
+	// decoder of a block. Blocks requiring more memory are rejected
+	// with lzma.ErrMemoryLimit. The value 0 disables the limit.
+	MemoryLimit int64
+	// MaxUncompressedSize limits the number of bytes decompressed by
+	// the reader. Once the decompressed data exceeds the limit, Read
+	// and WriteTo return ErrLimit. It protects against decompression
+	// bombs. The value 0 disables the limit.
+	MaxUncompressedSize int64
+	// Progress is called after each block and at the end of each
+	// stream with the total numbers of compressed and uncompressed
+	// bytes read so far.
+	Progress func(compressed, uncompressed int64)
+	// BlockHook is called for each block after it has been read
+	// completely and its check has been verified. It receives the
+	// position, the sizes and the header information of the block,
+	// which allows to record block maps while streaming.
+	BlockHook func(b BlockHeaderInfo)
+	// Logger receives debug messages for the headers, footers and
+	// chunks read. If it is nil, nothing is logged.
+	Logger lzma.Logger
+	// FillSize limits the number of bytes decoded in one step; see
+	// lzma.Reader2Config.
+	FillSize int
+	// IgnoreChecks disables the computation and verification of the
+	// block checks like xzdec does. It speeds up decoding of trusted
+	// data, since a SHA-256 check may take more time than the
+	// decompression. The CRC-32 values of headers and indexes are
+	// still verified, but corrupted data in blocks may be returned
+	// without error. BlockHook is called nevertheless.
+	IgnoreChecks bool
+	// Workers is the number of goroutines of the ParallelReader
+	// decoding blocks. The value 0 selects runtime.GOMAXPROCS(0).
+	Workers int
 }
 
 // fill replaces all zero values with their default values.
@@ -39,30 +96,87 @@ func (c *ReaderConfig) Verify() error {
 	if c == nil {
 		return errors.New("xz: reader parameters are nil")
 	}
-	lc := lzma.Reader2Config{DictCap: c.DictCap}
+	if c.MaxUncompressedSize < 0 {
+		return errors.New(
+			"xz: MaxUncompressedSize must not be negative")
+	}
+	if c.Workers < 0 {
+		return errors.New("xz: Workers must not be negative")
+	}
+	lc := c.reader2Config()
 	if err := lc.Verify(); err != nil {
 		return err
 	}
 	return nil
 }
 
-// Reader supports the reading of one or multiple xz streams.
+// reader2Config returns the configuration of the LZMA2 reader for the
+// dictionary capacity of the reader configuration.
+func (c *ReaderConfig) reader2Config() lzma.Reader2Config {
+	return lzma.Reader2Config{
+		DictCap:     c.DictCap,
+		Growth:      c.Growth,
+		MemoryLimit: c.MemoryLimit,
+		FillSize:    c.FillSize,
+	}
+}
+
+// MemoryUsage returns the number of bytes a reader created with the
+// configuration allocates for the LZMA2 decoder of a block with a
+// dictionary capacity not exceeding DictCap; see
+// lzma.Reader2Config.MemoryUsage.
+func (c ReaderConfig) MemoryUsage() (n int64, err error) {
+	if err = c.Verify(); err != nil {
+		return 0, err
+	}
+	return c.reader2Config().MemoryUsage()
+}
+
+// Reader supports the reading of one or multiple xz streams. A Reader
+// must not be used by multiple goroutines at the same time; use
+// SyncReader to share it.
+//
+// Errors of the underlying reader while the LZMA2 data of a block is
+// read can be retried by calling Read again; see lzma.Reader2. Errors
+// reading the headers, the index or the check of a block are fatal and
+// the Reader must not be used after them.
 type Reader struct {
 	ReaderConfig
 
-	xz io.Reader
-	sr *streamReader
+	xz  io.Reader
+	cxz countingReader
+	sr  *streamReader
+	// indexes of the streams read completely
+	indexes []*Index
+	// number of blocks of the current stream reported and the
+	// uncompressed bytes of all blocks reported
+	blocks       int
+	uncompressed int64
+	// uncompressed size of all streams read completely
+	uoffset int64
+	// number of bytes decompressed, which may exceed the bytes
+	// returned by one if MaxUncompressedSize is exceeded
+	n int64
 }
 
+// ErrLimit indicates that the decompressed data exceeds the limit set
+// by ReaderConfig.MaxUncompressedSize.
+var ErrLimit = errors.New("xz: uncompressed size exceeds limit")
+
 // streamReader decodes a single xz stream
 type streamReader struct {
 	ReaderConfig
 
-	xz      io.Reader
-	br      *blockReader
-	newHash func() hash.Hash
-	h       header
-	index   []record
+	xz io.Reader
+	br *blockReader
+	// hash computing the check; it is reset for each block
+	hash  hash.Hash
+	h     header
+	index []record
+	// offset of the current block in the file and of its data in
+	// the uncompressed data
+	offset  int64
+	uoffset int64
 }
 
 // NewReader creates a new xz reader using the default parameters.
@@ -81,55 +195,237 @@ func (c ReaderConfig) NewReader(xz io.Reader) (r *Reader, err error) {
 	}
 	r = &Reader{
 		ReaderConfig: c,
-		xz:           xz,
+		cxz:          countingReader{r: xz},
 	}
-	if r.sr, err = c.newStreamReader(xz); err != nil {
+	r.xz = &r.cxz
+	if r.sr, err = c.newStreamReader(r.xz); err != nil {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
 		}
 		return nil, err
 	}
+	r.sr.offset = r.cxz.n
 	return r, nil
 }
 
 var errUnexpectedData = errors.New("xz: unexpected data after stream")
 
-// Read reads uncompressed data from the stream.
+// Read reads uncompressed data from the stream. Read returns as soon as
+// data has been decompressed and doesn't wait for more input to fill p.
+// This allows the decompression of flushed data while the writer is
+// still active.
 func (r *Reader) Read(p []byte) (n int, err error) {
+	if max := r.MaxUncompressedSize; max > 0 {
+		// One byte more than allowed is requested to detect that
+		// the limit is exceeded.
+		if r.n > max {
+			return 0, ErrLimit
+		}
+		if m := max - r.n + 1; int64(len(p)) > m {
+			p = p[:m]
+		}
+		n, err = r.read(p)
+		r.n += int64(n)
+		if r.n > max {
+			return n - 1, ErrLimit
+		}
+		return n, err
+	}
+	return r.read(p)
+}
+
+// read reads uncompressed data ignoring the MaxUncompressedSize limit.
+func (r *Reader) read(p []byte) (n int, err error) {
 	for n < len(p) {
 		if r.sr == nil {
-			if r.SingleStream {
-				data := make([]byte, 1)
-				_, err = io.ReadFull(r.xz, data)
-				if err != io.EOF {
-					return n, errUnexpectedData
-				}
-				return n, io.EOF
+			if n > 0 {
+				return n, nil
 			}
-			for {
-				r.sr, err = r.ReaderConfig.newStreamReader(r.xz)
-				if err != errPadding {
-					break
-				}
-			}
-			if err != nil {
+			if err = r.nextStream(); err != nil {
 				return n, err
 			}
 		}
 		k, err := r.sr.Read(p[n:])
 		n += k
+		r.reportProgress()
 		if err != nil {
 			if err == io.EOF {
-				r.sr = nil
+				r.endStream()
 				continue
 			}
 			return n, err
 		}
+		if k > 0 {
+			return n, nil
+		}
 	}
 	return n, nil
 }
 
-var errPadding = errors.New("xz: padding (4 zero bytes) encountered")
+// WriteTo writes the uncompressed data to w. It implements io.WriterTo
+// and uses the WriteTo methods of the LZMA2 readers, so the data isn't
+// copied into an intermediate buffer.
+func (r *Reader) WriteTo(w io.Writer) (n int64, err error) {
+	if max := r.MaxUncompressedSize; max > 0 {
+		if r.n > max {
+			return 0, ErrLimit
+		}
+		lw := &limitWriter{w: w, n: max - r.n}
+		n, err = r.writeTo(lw)
+		r.n += n
+		if lw.exceeded {
+			r.n++
+			err = ErrLimit
+		}
+		return n, err
+	}
+	return r.writeTo(w)
+}
+
+// limitWriter writes at most n bytes to w and returns ErrLimit if more
+// bytes are written.
+type limitWriter struct {
+	w        io.Writer
+	n        int64
+	exceeded bool
+}
+
+func (lw *limitWriter) Write(p []byte) (n int, err error) {
+	if int64(len(p)) > lw.n {
+		n, err = lw.w.Write(p[:lw.n])
+		lw.n -= int64(n)
+		if err == nil {
+			lw.exceeded = true
+			err = ErrLimit
+		}
+		return n, err
+	}
+	n, err = lw.w.Write(p)
+	lw.n -= int64(n)
+	return n, err
+}
+
+// writeTo writes the uncompressed data to w ignoring the
+// MaxUncompressedSize limit.
+func (r *Reader) writeTo(w io.Writer) (n int64, err error) {
+	for {
+		if r.sr == nil {
+			if err = r.nextStream(); err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				return n, err
+			}
+		}
+		k, err := r.sr.writeBlock(w)
+		n += k
+		r.reportProgress()
+		if err != nil {
+			if err == io.EOF {
+				r.endStream()
+				continue
+			}
+			return n, err
+		}
+	}
+}
+
+// Verify decompresses the rest of the data without returning it. All
+// checks, sizes and indexes are verified, so nil is returned only for
+// intact data.
+func (r *Reader) Verify() error {
+	_, err := r.WriteTo(ioutil.Discard)
+	return err
+}
+
+// Validate checks the integrity of the xz data provided by xz using the
+// default reader parameters. It decompresses all streams, discards the
+// data and returns the first error detected.
+func Validate(xz io.Reader) error {
+	r, err := NewReader(xz)
+	if err != nil {
+		return err
+	}
+	return r.Verify()
+}
+
+// DecodeBuffer decompresses the xz data read from src, writes it to
+// dst and returns the number of bytes written. Like io.CopyBuffer it
+// works with the buffer provided by the caller: buf is used as the
+// dictionary buffer of the decoder, so the dictionary isn't allocated.
+// The capacity of buf must exceed the dictionary capacity of the
+// compressed data by one byte; otherwise lzma.ErrDictBuf is returned.
+// If buf is nil, the dictionary is allocated as usual.
+func DecodeBuffer(dst io.Writer, src io.Reader, buf []byte) (written int64,
+	err error) {
+
+	r, err := ReaderConfig{DictBuf: buf}.NewReader(src)
+	if err != nil {
+		return 0, err
+	}
+	return r.WriteTo(dst)
+}
+
+// nextStream starts the reading of the next stream. It returns io.EOF
+// if no stream follows.
+func (r *Reader) nextStream() (err error) {
+	if r.SingleStream {
+		data := make([]byte, 1)
+		_, err = io.ReadFull(r.xz, data)
+		if err != io.EOF {
+			return errUnexpectedData
+		}
+		return io.EOF
+	}
+	for {
+		r.sr, err = r.ReaderConfig.newStreamReader(r.xz)
+		if err != errStreamPadding {
+			break
+		}
+	}
+	if err != nil {
+		return err
+	}
+	r.sr.offset = r.cxz.n
+	r.sr.uoffset = r.uoffset
+	return nil
+}
+
+// endStream records the index of the stream that has been read
+// completely.
+func (r *Reader) endStream() {
+	r.indexes = append(r.indexes, &Index{records: r.sr.index})
+	r.uoffset = r.sr.uoffset
+	if r.Progress != nil {
+		r.Progress(r.cxz.n, r.uncompressed)
+	}
+	r.sr = nil
+	r.blocks = 0
+}
+
+// reportProgress calls the progress function for the blocks of the
+// current stream that have been completed since the last call.
+func (r *Reader) reportProgress() {
+	if r.Progress == nil {
+		return
+	}
+	for ; r.blocks < len(r.sr.index); r.blocks++ {
+		r.uncompressed += r.sr.index[r.blocks].uncompressedSize
+		r.Progress(r.cxz.n, r.uncompressed)
+	}
+}
+
+// Indexes returns the indexes of the streams that have been read
+// completely. The index of a stream is available after Read reached its
+// end, so the indexes of all streams are available after Read returned
+// io.EOF.
+func (r *Reader) Indexes() []*Index {
+	return append([]*Index(nil), r.indexes...)
+}
+
+// errStreamPadding indicates four zero bytes of stream padding where
+// a stream header was expected.
+var errStreamPadding = errors.New("xz: stream padding encountered")
 
 // newStreamReader creates a new xz stream reader using the given configuration
 // parameters. NewReader reads and checks the header of the xz stream.
@@ -141,8 +437,12 @@ func (c ReaderConfig) newStreamReader(xz io.Reader) (r *streamReader, err error)
 	if _, err := io.ReadFull(xz, data[:4]); err != nil {
 		return nil, err
 	}
-	if bytes.Equal(data[:4], []byte{0, 0, 0, 0}) {
-		return nil, errPadding
+	if data[0] == 0 {
+		// The magic bytes of the header don't start with zero.
+		if allZeros(data[:4]) {
+			return nil, errStreamPadding
+		}
+		return nil, ErrPadding
 	}
 	if _, err = io.ReadFull(xz, data[4:]); err != nil {
 		if err == io.EOF {
@@ -158,13 +458,40 @@ func (c ReaderConfig) newStreamReader(xz io.Reader) (r *streamReader, err error)
 	if err = r.h.UnmarshalBinary(data); err != nil {
 		return nil, err
 	}
-	xlog.Debugf("xz header %s", r.h)
-	if r.newHash, err = newHashFunc(r.h.flags); err != nil {
+	if r.Logger != nil {
+		r.Logger.Debugf("xz header %s", r.h)
+	}
+	newHash, err := c.newCheck(r.h.flags)
+	if err != nil {
 		return nil, err
 	}
+	r.hash = newHash()
 	return r, nil
 }
 
+// newCheck returns the function creating the hashes for the check
+// given by the stream flags. Checks that are ignored or not supported
+// are skipped.
+func (c *ReaderConfig) newCheck(flags byte) (newHash func() hash.Hash,
+	err error) {
+
+	newHash, err = newHashFunc(flags)
+	switch err {
+	case nil:
+		if !c.IgnoreChecks {
+			return newHash, nil
+		}
+	case errUnsupportedCheck:
+		if c.Logger != nil {
+			c.Logger.Warnf("check %s not verified", flagString(flags))
+		}
+	default:
+		return nil, err
+	}
+	n := checkSize(flags)
+	return func() hash.Hash { return skipCheck(n) }, nil
+}
+
 // errIndex indicates an error with the xz file index.
 var errIndex = errors.New("xz: error in xz file index")
 
@@ -199,7 +526,9 @@ func (r *streamReader) readTail() error {
 	if err = f.UnmarshalBinary(p); err != nil {
 		return err
 	}
-	xlog.Debugf("xz footer %s", f)
+	if r.Logger != nil {
+		r.Logger.Debugf("xz footer %s", f)
+	}
 	if f.flags != r.h.flags {
 		return errors.New("xz: footer flags incorrect")
 	}
@@ -209,24 +538,15 @@ func (r *streamReader) readTail() error {
 	return nil
 }
 
-// Read reads actual data from the xz stream.
+// Read reads actual data from the xz stream. It returns without reading
+// the next block header, if data is already available.
 func (r *streamReader) Read(p []byte) (n int, err error) {
 	for n < len(p) {
 		if r.br == nil {
-			bh, hlen, err := readBlockHeader(r.xz)
-			if err != nil {
-				if err == errIndexIndicator {
-					if err = r.readTail(); err != nil {
-						return n, err
-					}
-					return n, io.EOF
-				}
-				return n, err
+			if n > 0 {
+				return n, nil
 			}
-			xlog.Debugf("block %v", *bh)
-			r.br, err = r.ReaderConfig.newBlockReader(r.xz, bh,
-				hlen, r.newHash())
-			if err != nil {
+			if err = r.nextBlock(); err != nil {
 				return n, err
 			}
 		}
@@ -234,16 +554,83 @@ func (r *streamReader) Read(p []byte) (n int, err error) {
 		n += k
 		if err != nil {
 			if err == io.EOF {
-				r.index = append(r.index, r.br.record())
-				r.br = nil
-			} else {
-				return n, err
+				if err = r.endBlock(); err != nil {
+					return n, err
+				}
+				continue
 			}
+			return n, err
+		}
+		if k > 0 {
+			return n, nil
 		}
 	}
 	return n, nil
 }
 
+// writeBlock writes the rest of the current block or the next block to
+// w. It returns io.EOF at the end of the stream.
+func (r *streamReader) writeBlock(w io.Writer) (n int64, err error) {
+	if r.br == nil {
+		if err = r.nextBlock(); err != nil {
+			return 0, err
+		}
+	}
+	if n, err = r.br.WriteTo(w); err != nil {
+		return n, err
+	}
+	return n, r.endBlock()
+}
+
+// endBlock adds the record of the block that has been read completely
+// to the index and reports the block to the BlockHook.
+func (r *streamReader) endBlock() error {
+	rec := r.br.record()
+	if r.BlockHook != nil {
+		b, err := newBlockHeaderInfo(r.br.header, r.br.headerLen,
+			r.h.flags)
+		if err != nil {
+			return err
+		}
+		b.BlockInfo = BlockInfo{
+			BlockRecord: BlockRecord{
+				UnpaddedSize:     rec.unpaddedSize,
+				UncompressedSize: rec.uncompressedSize,
+			},
+			Offset:             r.offset,
+			UncompressedOffset: r.uoffset,
+		}
+		r.BlockHook(b)
+	}
+	r.index = append(r.index, rec)
+	r.offset += rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+	r.uoffset += rec.uncompressedSize
+	r.br = nil
+	return nil
+}
+
+// nextBlock reads the next block header and creates the block reader.
+// At the end of the stream the index and the footer are read and
+// io.EOF is returned.
+func (r *streamReader) nextBlock() error {
+	bh, hlen, err := readBlockHeader(r.xz)
+	if err != nil {
+		if err == errIndexIndicator {
+			if err = r.readTail(); err != nil {
+				return err
+			}
+			return io.EOF
+		}
+		return err
+	}
+	if r.Logger != nil {
+		r.Logger.Debugf("block %v", *bh)
+	}
+	r.hash.Reset()
+	r.br, err = r.ReaderConfig.newBlockReader(r.xz, bh, hlen, r.hash)
+	return err
+}
+
 // countingReader is a reader that counts the bytes read.
 type countingReader struct {
 	r io.Reader
@@ -264,6 +651,7 @@ type blockReader struct {
 	headerLen int
 	n         int64
 	hash      hash.Hash
+	fr        io.Reader
 	r         io.Reader
 	err       error
 }
@@ -279,11 +667,15 @@ func (c *ReaderConfig) newBlockReader(xz io.Reader, h *blockHeader,
 		hash:      hash,
 	}
 
-	fr, err := c.newFilterReader(&br.lxz, h.filters)
+	br.fr, err = c.newFilterReader(&br.lxz, h.filters)
 	if err != nil {
 		return nil, err
 	}
-	br.r = io.TeeReader(fr, br.hash)
+	if _, skip := hash.(skipCheck); skip {
+		br.r = br.fr
+	} else {
+		br.r = checks.NewReader(br.fr, br.hash)
+	}
 
 	return br, nil
 }
@@ -311,28 +703,56 @@ func (br *blockReader) record() record {
 	return record{br.unpaddedSize(), br.uncompressedSize()}
 }
 
-// errBlockSize indicates that the size of the block in the block header
-// is wrong.
-var errBlockSize = errors.New("xz: wrong uncompressed size for block")
-
 // Read reads data from the block.
 func (br *blockReader) Read(p []byte) (n int, err error) {
 	n, err = br.r.Read(p)
 	br.n += int64(n)
+	return n, br.check(err)
+}
 
+// WriteTo writes the rest of the block to w. The WriteTo method of the
+// filter reader is used if it is available.
+func (br *blockReader) WriteTo(w io.Writer) (n int64, err error) {
+	wt, ok := br.fr.(io.WriterTo)
+	if !ok {
+		return io.Copy(w, struct{ io.Reader }{br})
+	}
+	if _, skip := br.hash.(skipCheck); !skip {
+		w = checks.NewWriter(w, br.hash)
+	}
+	n, err = wt.WriteTo(w)
+	br.n += n
+	if err == nil {
+		err = io.EOF
+	}
+	if err = br.check(err); err == io.EOF {
+		err = nil
+	}
+	return n, err
+}
+
+// check verifies the sizes of the block. If err is io.EOF, the end of
+// the block has been reached and the padding and the check are read and
+// verified. The function returns err if no problem has been found.
+func (br *blockReader) check(err error) error {
 	u := br.header.uncompressedSize
 	if u >= 0 && br.uncompressedSize() > u {
-		return n, errors.New("xz: wrong uncompressed size for block")
+		return &lzma.ErrSizeMismatch{Want: u,
+			Got: br.uncompressedSize()}
 	}
 	c := br.header.compressedSize
 	if c >= 0 && br.compressedSize() > c {
-		return n, errors.New("xz: wrong compressed size for block")
+		return errors.New("xz: wrong compressed size for block")
 	}
 	if err != io.EOF {
-		return n, err
+		return err
 	}
-	if br.uncompressedSize() < u || br.compressedSize() < c {
-		return n, io.ErrUnexpectedEOF
+	if br.uncompressedSize() < u {
+		return &lzma.ErrSizeMismatch{Want: u,
+			Got: br.uncompressedSize()}
+	}
+	if br.compressedSize() < c {
+		return io.ErrUnexpectedEOF
 	}
 
 	s := br.hash.Size()
@@ -342,17 +762,20 @@ func (br *blockReader) Read(p []byte) (n int, err error) {
 		if err == io.EOF {
 			err = io.ErrUnexpectedEOF
 		}
-		return n, err
+		return err
 	}
 	if !allZeros(q[:k]) {
-		return n, errors.New("xz: non-zero block padding")
+		return ErrPadding
 	}
 	checkSum := q[k:]
+	if _, skip := br.hash.(skipCheck); skip {
+		return io.EOF
+	}
 	computedSum := br.hash.Sum(checkSum[s:])
 	if !bytes.Equal(checkSum, computedSum) {
-		return n, errors.New("xz: checksum error for block")
+		return &ErrChecksum{Part: "block"}
 	}
-	return n, io.EOF
+	return io.EOF
 }
 
 func (c *ReaderConfig) newFilterReader(r io.Reader, f []filter) (fr io.Reader,
@@ -6,10 +6,16 @@ package xz
 
 import (
 	"bytes"
+	"crypto/sha256"
 	"io"
 	"io/ioutil"
+	"math/rand"
 	"os"
 	"testing"
+	"testing/iotest"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 func TestReaderSimple(t *testing.T) {
@@ -79,3 +85,429 @@ func TestReaaderMultipleStreams(t *testing.T) {
 		t.Fatalf("io.Copy error %s", err)
 	}
 }
+
+func TestReaderTee(t *testing.T) {
+	data, err := ioutil.ReadFile("fox.xz")
+	if err != nil {
+		t.Fatalf("ReadFile error %s", err)
+	}
+	var tee bytes.Buffer
+	r, err := ReaderConfig{Tee: &tee}.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	var buf bytes.Buffer
+	if _, err = io.Copy(&buf, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if tee.String() != buf.String() {
+		t.Fatalf("tee received %q; want %q", tee.String(), buf.String())
+	}
+}
+
+// strictReader reports violations of the io.Reader contract by the
+// wrapped reader. Reads of an empty slice may return io.EOF only if no
+// more data is available.
+type strictReader struct {
+	t   *testing.T
+	r   io.Reader
+	eof bool
+}
+
+func (s *strictReader) Read(p []byte) (n int, err error) {
+	k, emptyErr := s.r.Read(p[:0])
+	if k != 0 || (emptyErr != nil && emptyErr != io.EOF) {
+		s.t.Errorf("Read of empty slice returned %d, %v", k, emptyErr)
+	}
+	n, err = s.r.Read(p)
+	switch {
+	case n < 0 || n > len(p):
+		s.t.Fatalf("Read returned %d for slice length %d", n, len(p))
+	case n == 0 && err == nil:
+		s.t.Errorf("Read returned (0, nil)")
+	case n > 0 && err == io.EOF:
+		s.t.Errorf("Read returned io.EOF with %d bytes", n)
+	case (s.eof || emptyErr == io.EOF) && (n > 0 || err != io.EOF):
+		s.t.Errorf("Read after io.EOF returned %d, %v", n, err)
+	}
+	if err == io.EOF {
+		s.eof = true
+	}
+	return n, err
+}
+
+func TestReaderContract(t *testing.T) {
+	var data bytes.Buffer
+	io.CopyN(&data, randtxt.NewReader(rand.NewSource(49)), 60000)
+	var stream bytes.Buffer
+	w, err := WriterConfig{BlockSize: 16000}.NewWriter(&stream)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data.Bytes()); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	// two streams separated by padding
+	xz := append([]byte{}, stream.Bytes()...)
+	xz = append(xz, 0, 0, 0, 0)
+	xz = append(xz, stream.Bytes()...)
+	want := append(append([]byte{}, data.Bytes()...), data.Bytes()...)
+	for _, size := range []int{1, 100, 20000, 200000} {
+		r, err := NewReader(iotest.HalfReader(bytes.NewReader(xz)))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		s := &strictReader{t: t, r: r}
+		var out bytes.Buffer
+		p := make([]byte, size)
+		for {
+			n, err := s.Read(p)
+			out.Write(p[:n])
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				t.Fatalf("Read error %s", err)
+			}
+		}
+		s.Read(p)
+		if !bytes.Equal(out.Bytes(), want) {
+			t.Fatalf("size %d: data differs", size)
+		}
+	}
+}
+
+func TestReaderMemoryLimit(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := WriterConfig{DictCap: 1 << 24}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	const text = "The quick brown fox jumps over the lazy dog."
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	data := buf.Bytes()
+
+	c := ReaderConfig{MemoryLimit: 1 << 22}
+	r, err := c.NewReader(bytes.NewReader(data))
+	if err == nil {
+		_, err = ioutil.ReadAll(r)
+	}
+	if err != lzma.ErrMemoryLimit {
+		t.Fatalf("got error %v; want %v", err, lzma.ErrMemoryLimit)
+	}
+
+	c.MemoryLimit = 1 << 25
+	if r, err = c.NewReader(bytes.NewReader(data)); err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(p) != text {
+		t.Fatalf("got %q; want %q", p, text)
+	}
+}
+
+func TestReaderMaxUncompressedSize(t *testing.T) {
+	xz, streams := multiStream(t, 1000)
+	data := bytes.Join(streams, nil)
+	size := int64(len(data))
+	for _, max := range []int64{size, size - 1, 1500} {
+		cfg := ReaderConfig{MaxUncompressedSize: max}
+		r, err := cfg.NewReader(bytes.NewReader(xz))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		got, err := ioutil.ReadAll(r)
+		want := data
+		if max < size {
+			if err != ErrLimit {
+				t.Fatalf("max %d: ReadAll returned %v; want %v",
+					max, err, ErrLimit)
+			}
+			want = data[:max]
+		} else if err != nil {
+			t.Fatalf("max %d: ReadAll error %s", max, err)
+		}
+		if !bytes.Equal(got, want) {
+			t.Fatalf("max %d: Read returned %d bytes; want %d",
+				max, len(got), len(want))
+		}
+		if _, err = r.Read(make([]byte, 1)); max < size &&
+			err != ErrLimit {
+			t.Fatalf("max %d: Read after limit returned %v",
+				max, err)
+		}
+
+		r, err = cfg.NewReader(bytes.NewReader(xz))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		var buf bytes.Buffer
+		n, err := r.WriteTo(&buf)
+		if max < size {
+			if err != ErrLimit {
+				t.Fatalf("max %d: WriteTo returned %v; want %v",
+					max, err, ErrLimit)
+			}
+		} else if err != nil {
+			t.Fatalf("max %d: WriteTo error %s", max, err)
+		}
+		if n != int64(len(want)) || !bytes.Equal(buf.Bytes(), want) {
+			t.Fatalf("max %d: WriteTo wrote %d bytes; want %d",
+				max, n, len(want))
+		}
+	}
+}
+
+func TestReaderPadding(t *testing.T) {
+	xz, _ := multiStream(t, 1000)
+	if len(xz)%4 != 0 {
+		t.Fatalf("stream length %d is not a multiple of 4", len(xz))
+	}
+	readAll := func(p []byte) error {
+		r, err := NewReader(bytes.NewReader(p))
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(ioutil.Discard, r)
+		return err
+	}
+	padded := append(append([]byte{}, xz...), make([]byte, 8)...)
+	padded = append(padded, xz...)
+	if err := readAll(padded); err != nil {
+		t.Fatalf("stream padding: error %s", err)
+	}
+	bad := append(append([]byte{}, xz...), 0, 0, 1, 0)
+	bad = append(bad, xz...)
+	if err := readAll(bad); err != ErrPadding {
+		t.Fatalf("non-zero stream padding: got error %v; want %v",
+			err, ErrPadding)
+	}
+
+	info, err := Stat(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	for _, b := range info.BlockMap() {
+		k := padLen(b.UnpaddedSize)
+		if k == 0 {
+			continue
+		}
+		// The padding precedes the 8-byte CRC64 check.
+		i := b.Offset + b.UnpaddedSize - 8
+		bad = append([]byte{}, xz...)
+		bad[i] = 1
+		if err = readAll(bad); err != ErrPadding {
+			t.Fatalf("non-zero block padding: got error %v; "+
+				"want %v", err, ErrPadding)
+		}
+		return
+	}
+	t.Fatalf("no block with padding found")
+}
+
+func TestValidate(t *testing.T) {
+	xz, streams := multiStream(t, 1000)
+	if err := Validate(bytes.NewReader(xz)); err != nil {
+		t.Fatalf("Validate error %s", err)
+	}
+	info, err := Stat(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	b := info.BlockMap()[1]
+	end := info.Streams[0].CompressedSize
+	for _, off := range []int64{
+		// last byte of the check of the second block
+		b.Offset + b.UnpaddedSize - 1,
+		// record of the index of the first stream
+		end - footerLen - 4 - 2,
+	} {
+		p := append([]byte(nil), xz...)
+		p[off] ^= 0x55
+		if err = Validate(bytes.NewReader(p)); err == nil {
+			t.Fatalf("Validate didn't detect corruption at %d", off)
+		}
+	}
+
+	// Verify continues after Read.
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.ReadFull(r, make([]byte, 1500)); err != nil {
+		t.Fatalf("ReadFull error %s", err)
+	}
+	if err = r.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	indexes := r.Indexes()
+	if len(indexes) != len(streams) {
+		t.Fatalf("got %d indexes; want %d", len(indexes), len(streams))
+	}
+	for i, ix := range indexes {
+		if u := ix.UncompressedSize(); u != int64(len(streams[i])) {
+			t.Fatalf("stream %d: uncompressed size %d; want %d", i,
+				u, len(streams[i]))
+		}
+	}
+}
+
+func TestReaderBlockHook(t *testing.T) {
+	xz, _ := multiStream(t, 1000)
+	info, err := Stat(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	want := info.BlockMap()
+	// The first pass uses Read, the second WriteTo.
+	for pass := 0; pass < 2; pass++ {
+		var blocks []BlockHeaderInfo
+		c := ReaderConfig{BlockHook: func(b BlockHeaderInfo) {
+			blocks = append(blocks, b)
+		}}
+		r, err := c.NewReader(bytes.NewReader(xz))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		if pass == 0 {
+			_, err = ioutil.ReadAll(r)
+		} else {
+			_, err = io.Copy(ioutil.Discard, r)
+		}
+		if err != nil {
+			t.Fatalf("pass %d: read error %s", pass, err)
+		}
+		if len(blocks) != len(want) {
+			t.Fatalf("pass %d: got %d blocks; want %d", pass,
+				len(blocks), len(want))
+		}
+		for i, b := range blocks {
+			if b.BlockInfo != want[i] {
+				t.Fatalf("pass %d: block %d is %+v; want %+v",
+					pass, i, b.BlockInfo, want[i])
+			}
+			if b.Check != CRC64 {
+				t.Fatalf("block %d: check %d; want %d", i,
+					b.Check, CRC64)
+			}
+			if len(b.Filters) != 1 ||
+				b.Filters[0].ID != lzmaFilterID ||
+				len(b.Filters[0].Props) != 1 {
+				t.Fatalf("block %d: filters %v", i, b.Filters)
+			}
+			if b.DictCap != lzma.MinDictCap {
+				t.Fatalf("block %d: DictCap %d; want %d", i,
+					b.DictCap, lzma.MinDictCap)
+			}
+			if b.HeaderSize%4 != 0 || b.Flags&filterCountMask != 0 {
+				t.Fatalf("block %d: header size %d flags %#x",
+					i, b.HeaderSize, b.Flags)
+			}
+		}
+	}
+}
+
+func TestReaderIgnoreChecks(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 10000)
+	data := txt.Bytes()
+	var buf bytes.Buffer
+	w, err := WriterConfig{CheckSum: SHA256}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	// corrupt the check of the block
+	xz := buf.Bytes()
+	sum := sha256.Sum256(data)
+	i := bytes.Index(xz, sum[:])
+	if i < 0 {
+		t.Fatalf("block check not found")
+	}
+	xz[i] ^= 1
+
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err == nil {
+		t.Fatalf("ReadAll: no error for corrupted check")
+	}
+	for pass := 0; pass < 2; pass++ {
+		r, err = ReaderConfig{IgnoreChecks: true}.NewReader(
+			bytes.NewReader(xz))
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		var got []byte
+		if pass == 0 {
+			got, err = ioutil.ReadAll(r)
+		} else {
+			var out bytes.Buffer
+			_, err = io.Copy(&out, r)
+			got = out.Bytes()
+		}
+		if err != nil {
+			t.Fatalf("pass %d: read error %s", pass, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("pass %d: data differs", pass)
+		}
+	}
+}
+
+func benchmarkReaderChecks(b *testing.B, check byte) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<20)
+	var buf bytes.Buffer
+	w, err := WriterConfig{CheckSum: check}.NewWriter(&buf)
+	if err != nil {
+		b.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		b.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		b.Fatalf("Close error %s", err)
+	}
+	xz := buf.Bytes()
+	for _, ignore := range []bool{false, true} {
+		name := "verify"
+		if ignore {
+			name = "ignore"
+		}
+		b.Run(name, func(b *testing.B) {
+			b.SetBytes(int64(txt.Len()))
+			for i := 0; i < b.N; i++ {
+				r, err := ReaderConfig{IgnoreChecks: ignore}.NewReader(
+					bytes.NewReader(xz))
+				if err != nil {
+					b.Fatalf("NewReader error %s", err)
+				}
+				if _, err = io.Copy(ioutil.Discard, r); err != nil {
+					b.Fatalf("Copy error %s", err)
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkReaderCRC32(b *testing.B)  { benchmarkReaderChecks(b, CRC32) }
+func BenchmarkReaderCRC64(b *testing.B)  { benchmarkReaderChecks(b, CRC64) }
+func BenchmarkReaderSHA256(b *testing.B) { benchmarkReaderChecks(b, SHA256) }
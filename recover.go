@@ -0,0 +1,346 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"hash"
+	"io"
+)
+
+// SkippedRange describes a damaged region of an xz file that has been
+// skipped by the RecoveryReader.
+type SkippedRange struct {
+	// offset of the first skipped byte in the file
+	Offset int64
+	// number of skipped bytes
+	Size int64
+	// offset in the decompressed data at which the data of the
+	// skipped region is missing
+	UncompressedOffset int64
+	// error that caused the region to be skipped
+	Err error
+}
+
+// maxBlockHeaderLen is the maximum length of a block header.
+const maxBlockHeaderLen = 256 * 4
+
+// RecoveryReader decompresses the intact blocks of a damaged xz file.
+// If a block cannot be decoded, the reader scans the following data for
+// the next valid block or stream header and continues the
+// decompression there. Block headers have no magic bytes; candidates
+// are identified by their size byte and their CRC-32 at the four-byte
+// aligned offsets, at which blocks must start.
+//
+// The data of a damaged block that has been decompressed before the
+// damage has been detected has already been returned by Read and cannot
+// be verified. The damaged regions are reported by the Skipped method.
+type RecoveryReader struct {
+	ReaderConfig
+
+	xz   *io.SectionReader
+	size int64
+	// offset of the next header to read
+	pos int64
+	// hash for the check of the current stream
+	hash hash.Hash
+	br   *blockReader
+	// offset of the header of the current block
+	blockPos int64
+	// number of decompressed bytes returned
+	n int64
+	// start of the current damaged region or -1
+	skip    int64
+	skipErr error
+	skipped []SkippedRange
+	err     error
+}
+
+// NewRecoveryReader creates a recovery reader for the xz file of the
+// given size provided by the io.ReaderAt using the default parameters.
+func NewRecoveryReader(xz io.ReaderAt, size int64) (r *RecoveryReader,
+	err error) {
+
+	return ReaderConfig{}.NewRecoveryReader(xz, size)
+}
+
+// NewRecoveryReader creates a recovery reader for the xz file of the
+// given size provided by the io.ReaderAt. The check method is taken
+// from the stream headers. If the header of the first stream is
+// damaged, the check method of the last stream footer is used and CRC64
+// if that footer is damaged too.
+func (c ReaderConfig) NewRecoveryReader(xz io.ReaderAt, size int64) (
+	r *RecoveryReader, err error) {
+
+	if err = c.Verify(); err != nil {
+		return nil, err
+	}
+	r = &RecoveryReader{
+		ReaderConfig: c,
+		xz:           io.NewSectionReader(xz, 0, size),
+		size:         size,
+		skip:         -1,
+	}
+	flags, err := r.footerFlags()
+	if err != nil {
+		return nil, err
+	}
+	r.setFlags(flags)
+	return r, nil
+}
+
+// footerFlags returns the flags of the last stream footer of the file
+// or CRC64 if no valid footer can be found.
+func (r *RecoveryReader) footerFlags() (flags byte, err error) {
+	p := make([]byte, footerLen)
+	end := r.size - r.size%4
+	for ; end >= footerLen; end -= 4 {
+		if err = readAt(r.xz, end-4, p[:4]); err != nil {
+			return 0, err
+		}
+		if !allZeros(p[:4]) {
+			break
+		}
+	}
+	if end < footerLen {
+		return CRC64, nil
+	}
+	if err = readAt(r.xz, end-footerLen, p); err != nil {
+		return 0, err
+	}
+	var f footer
+	if f.UnmarshalBinary(p) != nil {
+		return CRC64, nil
+	}
+	return f.flags, nil
+}
+
+// setFlags sets the hash for the check method given by the stream
+// flags.
+func (r *RecoveryReader) setFlags(flags byte) {
+	newHash, err := newHashFunc(flags)
+	if err != nil {
+		r.hash = skipCheck(checkSize(flags))
+		return
+	}
+	r.hash = newHash()
+}
+
+// Skipped returns the damaged regions of the file that have been
+// skipped so far. All regions are known after Read returned io.EOF.
+func (r *RecoveryReader) Skipped() []SkippedRange {
+	return append([]SkippedRange(nil), r.skipped...)
+}
+
+// Read reads decompressed data from the intact blocks of the file.
+// Errors are only returned if the underlying reader fails while
+// searching for headers or if the data exceeds MaxUncompressedSize;
+// damaged data is skipped.
+func (r *RecoveryReader) Read(p []byte) (n int, err error) {
+	if max := r.MaxUncompressedSize; max > 0 {
+		// One byte more than allowed is requested to detect that
+		// the limit is exceeded.
+		if r.n > max {
+			return 0, ErrLimit
+		}
+		if m := max - r.n + 1; int64(len(p)) > m {
+			p = p[:m]
+		}
+		n, err = r.read(p)
+		if r.n > max {
+			return n - 1, ErrLimit
+		}
+		return n, err
+	}
+	return r.read(p)
+}
+
+// read reads decompressed data ignoring the MaxUncompressedSize limit.
+func (r *RecoveryReader) read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	for n < len(p) {
+		if r.br == nil {
+			if n > 0 {
+				return n, nil
+			}
+			if err = r.nextBlock(); err != nil {
+				r.err = err
+				return n, err
+			}
+		}
+		k, err := r.br.Read(p[n:])
+		n += k
+		r.n += int64(k)
+		if err != nil {
+			if err == io.EOF {
+				u := r.br.unpaddedSize()
+				r.pos = r.blockPos + u + int64(padLen(u))
+			} else {
+				// Resume the search after the block
+				// header.
+				r.skip = r.blockPos
+				r.skipErr = err
+				r.pos = r.blockPos + int64(r.br.headerLen)
+			}
+			r.br = nil
+			continue
+		}
+		if k > 0 {
+			return n, nil
+		}
+	}
+	return n, nil
+}
+
+// endSkip records the current damaged region, which ends at end.
+func (r *RecoveryReader) endSkip(end int64) {
+	if r.skip < 0 {
+		return
+	}
+	r.skipped = append(r.skipped, SkippedRange{
+		Offset:             r.skip,
+		Size:               end - r.skip,
+		UncompressedOffset: r.n,
+		Err:                r.skipErr,
+	})
+	r.skip = -1
+	r.skipErr = nil
+}
+
+// nextBlock searches the next intact block header starting at r.pos
+// and creates the block reader for it. Stream headers, indexes, footers
+// and stream padding are skipped. Data that cannot be parsed is
+// recorded as damaged.
+func (r *RecoveryReader) nextBlock() error {
+	p := make([]byte, maxBlockHeaderLen)
+	for r.pos < r.size {
+		k, err := r.xz.ReadAt(p, r.pos)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		q := p[:k]
+		if len(q) >= HeaderLen && bytes.HasPrefix(q, headerMagic) {
+			var h header
+			if h.UnmarshalBinary(q[:HeaderLen]) == nil {
+				r.endSkip(r.pos)
+				r.setFlags(h.flags)
+				r.pos += HeaderLen
+				continue
+			}
+		}
+		if r.skip >= 0 {
+			ok, err := r.skipToIndex(q)
+			if err != nil {
+				return err
+			}
+			if ok {
+				continue
+			}
+		} else if q[0] == 0 {
+			if r.readIndex() {
+				continue
+			}
+			if len(q) >= 4 && allZeros(q[:4]) {
+				// stream padding
+				r.pos += 4
+				continue
+			}
+		}
+		if ok := r.openBlock(q); ok {
+			r.endSkip(r.pos)
+			r.blockPos = r.pos
+			return nil
+		}
+		if r.skip < 0 {
+			r.skip = r.pos
+			r.skipErr = errStat
+		}
+		r.pos += 4
+	}
+	r.endSkip(r.size)
+	return io.EOF
+}
+
+// openBlock creates the block reader if q starts with a valid block
+// header.
+func (r *RecoveryReader) openBlock(q []byte) bool {
+	if q[0] == 0 {
+		return false
+	}
+	hlen := (int(q[0]) + 1) * 4
+	if hlen > len(q) {
+		return false
+	}
+	h := new(blockHeader)
+	if h.UnmarshalBinary(q[:hlen]) != nil {
+		return false
+	}
+	start := r.pos + int64(hlen)
+	r.hash.Reset()
+	br, err := r.ReaderConfig.newBlockReader(
+		io.NewSectionReader(r.xz, start, r.size-start), h, hlen,
+		r.hash)
+	if err != nil {
+		return false
+	}
+	r.br = br
+	return true
+}
+
+// readIndex reads the index and the footer at r.pos. It returns false if
+// they are not valid.
+func (r *RecoveryReader) readIndex() bool {
+	start := r.pos + 1
+	_, n, err := readIndexBody(
+		io.NewSectionReader(r.xz, start, r.size-start))
+	if err != nil {
+		return false
+	}
+	p := make([]byte, footerLen)
+	if _, err = r.xz.ReadAt(p, start+n); err != nil {
+		return false
+	}
+	var f footer
+	if f.UnmarshalBinary(p) != nil || f.indexSize != n+1 {
+		return false
+	}
+	r.pos = start + n + footerLen
+	return true
+}
+
+// skipToIndex checks whether q starts with a valid stream footer whose
+// index starts inside the damaged region. In that case the damaged
+// region ends at the index, or after the footer if the index itself is
+// damaged, and the search resumes after the footer.
+func (r *RecoveryReader) skipToIndex(q []byte) (ok bool, err error) {
+	if len(q) < footerLen || !bytes.Equal(q[10:footerLen], footerMagic) {
+		return false, nil
+	}
+	var f footer
+	if f.UnmarshalBinary(q[:footerLen]) != nil {
+		return false, nil
+	}
+	start := r.pos - f.indexSize
+	if start < r.skip {
+		return false, nil
+	}
+	p := make([]byte, 1)
+	if err = readAt(r.xz, start, p); err != nil {
+		return false, err
+	}
+	if p[0] != 0 {
+		return false, nil
+	}
+	r.pos += footerLen
+	if start == r.skip {
+		// The index itself is damaged.
+		r.endSkip(r.pos)
+	} else {
+		r.endSkip(start)
+	}
+	return true, nil
+}
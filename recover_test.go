@@ -0,0 +1,122 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRecoveryReader(t *testing.T) {
+	const blockSize = 1000
+	xz, streams := multiStream(t, blockSize)
+	data := bytes.Join(streams, nil)
+	info, err := Stat(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	// the second block of the first stream
+	blocks := info.BlockMap()
+	b, next := blocks[1], blocks[2]
+	// the index of the first stream
+	end := info.Streams[0].CompressedSize
+	var f footer
+	if err = f.UnmarshalBinary(xz[end-footerLen : end]); err != nil {
+		t.Fatalf("footer error %s", err)
+	}
+	index := end - footerLen - f.indexSize
+
+	tests := []struct {
+		name string
+		// offset of the byte to corrupt or -1
+		off     int64
+		skipped []SkippedRange
+		want    []byte
+	}{
+		{"intact", -1, nil, data},
+		{"stream header", 6,
+			[]SkippedRange{{Offset: 0, Size: HeaderLen}}, data},
+		{"block header", b.Offset + 1,
+			[]SkippedRange{{
+				Offset:             b.Offset,
+				Size:               next.Offset - b.Offset,
+				UncompressedOffset: b.UncompressedOffset,
+			}},
+			bytes.Join([][]byte{data[:b.UncompressedOffset],
+				data[next.UncompressedOffset:]}, nil)},
+		// The block data has been returned before the check
+		// fails.
+		{"block check", next.Offset - 1,
+			[]SkippedRange{{
+				Offset:             b.Offset,
+				Size:               next.Offset - b.Offset,
+				UncompressedOffset: next.UncompressedOffset,
+			}},
+			data},
+		{"index", index + 1,
+			[]SkippedRange{{
+				Offset:             index,
+				Size:               f.indexSize + footerLen,
+				UncompressedOffset: int64(len(streams[0])),
+			}},
+			data},
+	}
+	for _, tc := range tests {
+		p := append([]byte(nil), xz...)
+		if tc.off >= 0 {
+			p[tc.off] ^= 0x55
+		}
+		r, err := NewRecoveryReader(bytes.NewReader(p), int64(len(p)))
+		if err != nil {
+			t.Fatalf("%s: NewRecoveryReader error %s", tc.name, err)
+		}
+		got, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("%s: ReadAll error %s", tc.name, err)
+		}
+		if !bytes.Equal(got, tc.want) {
+			t.Fatalf("%s: decompressed data differs", tc.name)
+		}
+		skipped := r.Skipped()
+		if len(skipped) != len(tc.skipped) {
+			t.Fatalf("%s: got skipped ranges %v; want %v", tc.name,
+				skipped, tc.skipped)
+		}
+		for i, s := range skipped {
+			if s.Err == nil {
+				t.Fatalf("%s: skipped range %d has no error",
+					tc.name, i)
+			}
+			s.Err = nil
+			if s != tc.skipped[i] {
+				t.Fatalf("%s: skipped range %+v; want %+v",
+					tc.name, s, tc.skipped[i])
+			}
+		}
+	}
+}
+
+func TestRecoveryReaderLimit(t *testing.T) {
+	xz, streams := multiStream(t, 1000)
+	data := bytes.Join(streams, nil)
+	max := int64(len(data)) / 2
+	r, err := ReaderConfig{MaxUncompressedSize: max}.NewRecoveryReader(
+		bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("NewRecoveryReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != ErrLimit {
+		t.Fatalf("ReadAll returned error %v; want %v", err, ErrLimit)
+	}
+	if !bytes.Equal(p, data[:max]) {
+		t.Fatalf("ReadAll returned %d bytes; want %d", len(p), max)
+	}
+	if _, err = r.Read(make([]byte, 1)); err != ErrLimit {
+		t.Fatalf("Read after limit returned %v; want %v", err,
+			ErrLimit)
+	}
+}
@@ -0,0 +1,101 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"io"
+	"io/ioutil"
+)
+
+// WriteIndex writes the index and the stream footer for the blocks
+// given by their records to w. The check method must be the one of the
+// stream header. It allows to terminate a stream whose blocks have been
+// written or copied without a Writer. The function returns the number
+// of bytes written.
+func WriteIndex(w io.Writer, check byte, blocks []BlockRecord) (n int64,
+	err error) {
+
+	if err = verifyFlags(check); err != nil {
+		return 0, err
+	}
+	index := make([]record, len(blocks))
+	for i, b := range blocks {
+		index[i] = record{b.UnpaddedSize, b.UncompressedSize}
+	}
+	f := footer{flags: check}
+	if f.indexSize, err = writeIndex(w, index); err != nil {
+		return f.indexSize, err
+	}
+	data, err := f.MarshalBinary()
+	if err != nil {
+		return f.indexSize, err
+	}
+	k, err := w.Write(data)
+	return f.indexSize + int64(k), err
+}
+
+// RepairIndex writes a valid xz stream to w, which consists of the
+// stream header and the intact blocks at the start of the first stream
+// of the file xz with the given size followed by a rebuilt index and
+// footer. It repairs streams whose index or footer has been damaged or
+// removed by truncation. The blocks are decompressed to verify their
+// checks and are copied unchanged. The copy ends at the first block
+// that cannot be read. The records of the blocks copied are returned.
+func RepairIndex(w io.Writer, xz io.ReaderAt, size int64) (
+	blocks []BlockRecord, err error) {
+
+	p := make([]byte, HeaderLen)
+	if _, err = xz.ReadAt(p, 0); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	var h header
+	if err = h.UnmarshalBinary(p); err != nil {
+		return nil, err
+	}
+	newHash, err := newHashFunc(h.flags)
+	if err != nil {
+		return nil, err
+	}
+	hash := newHash()
+	var c ReaderConfig
+	c.fill()
+	pos := int64(HeaderLen)
+	for pos < size {
+		sr := io.NewSectionReader(xz, pos, size-pos)
+		bh, hlen, err := readBlockHeader(sr)
+		if err != nil {
+			break
+		}
+		hash.Reset()
+		br, err := c.newBlockReader(sr, bh, hlen, hash)
+		if err != nil {
+			break
+		}
+		if _, err = io.Copy(ioutil.Discard, br); err != nil {
+			break
+		}
+		rec := br.record()
+		blocks = append(blocks, BlockRecord{
+			UnpaddedSize:     rec.unpaddedSize,
+			UncompressedSize: rec.uncompressedSize,
+		})
+		pos += rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+	}
+
+	if _, err = w.Write(p); err != nil {
+		return nil, err
+	}
+	if _, err = io.Copy(w, io.NewSectionReader(xz, HeaderLen,
+		pos-HeaderLen)); err != nil {
+		return nil, err
+	}
+	if _, err = WriteIndex(w, h.flags, blocks); err != nil {
+		return nil, err
+	}
+	return blocks, nil
+}
@@ -0,0 +1,62 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+)
+
+func TestRepairIndex(t *testing.T) {
+	xz, streams := multiStream(t, 1000)
+	info, err := Stat(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	end := info.Streams[0].CompressedSize
+	var f footer
+	if err = f.UnmarshalBinary(xz[end-footerLen : end]); err != nil {
+		t.Fatalf("footer error %s", err)
+	}
+	index := end - footerLen - f.indexSize
+	third := info.BlockMap()[2]
+
+	// The rebuilt index is identical to the original one.
+	var buf bytes.Buffer
+	p := xz[:index]
+	blocks, err := RepairIndex(&buf, bytes.NewReader(p), int64(len(p)))
+	if err != nil {
+		t.Fatalf("RepairIndex error %s", err)
+	}
+	if len(blocks) != 3 {
+		t.Fatalf("RepairIndex returned %d blocks; want 3", len(blocks))
+	}
+	if !bytes.Equal(buf.Bytes(), xz[:end]) {
+		t.Fatalf("repaired stream differs from original")
+	}
+
+	// The truncated third block is dropped.
+	buf.Reset()
+	p = xz[:third.Offset+20]
+	if blocks, err = RepairIndex(&buf, bytes.NewReader(p),
+		int64(len(p))); err != nil {
+		t.Fatalf("RepairIndex error %s", err)
+	}
+	if len(blocks) != 2 {
+		t.Fatalf("RepairIndex returned %d blocks; want 2", len(blocks))
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(data, streams[0][:third.UncompressedOffset]) {
+		t.Fatalf("decompressed data differs")
+	}
+}
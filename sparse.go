@@ -0,0 +1,338 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"os"
+)
+
+// Sparse files and disk images contain long runs of zeros. Running the
+// match finder over them costs as much time as compressing any other
+// data, while the LZMA2 writer can encode a zero run directly. The
+// helpers below detect the zero runs in blocks of sparseBlockSize
+// bytes, which is the page and file system block size on most
+// platforms.
+
+// sparseBlockSize is the granularity of the detection of zero runs.
+const sparseBlockSize = 4096
+
+// minZeroRun is the minimum length of a zero run encoded by CopySparse
+// with WriteZeros. Shorter runs are compressed like any other data,
+// because WriteZeros resets the dictionary.
+const minZeroRun = 1 << 16
+
+// zeroBuf provides zeros for the checks and for writers not supporting
+// WriteZeros.
+var zeroBuf [1 << 15]byte
+
+// zeroWriter is implemented by filter writers that encode zero runs
+// without compressing them byte by byte.
+type zeroWriter interface {
+	WriteZeros(n int64) error
+}
+
+// WriteZeros writes n zero bytes without running the match finder; see
+// lzma.Writer2.WriteZeros. The dictionary is reset before and after
+// the run, so it should only be used for long runs.
+func (w *Writer) WriteZeros(n int64) error {
+	if err := w.lock(); err != nil {
+		w.unlock()
+		return err
+	}
+	defer w.unlock()
+	if w.closed {
+		return errClosed
+	}
+	if n < 0 {
+		return errors.New("xz: negative number of zeros")
+	}
+	for n > 0 {
+		k, err := w.bw.writeZeros(n)
+		n -= k
+		if k > 0 {
+			w.pending = true
+		}
+		if err != errNoSpace {
+			return err
+		}
+		if err = w.closeBlockWriter(); err != nil {
+			return err
+		}
+		if err = w.newBlockWriter(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeZeros writes n zeros into the block. The zeros not fitting into
+// the block are reported by errNoSpace.
+func (bw *blockWriter) writeZeros(n int64) (k int64, err error) {
+	if bw.closed {
+		return 0, errClosed
+	}
+	if t := bw.blockSize - bw.n; n > t {
+		n = t
+		err = errNoSpace
+	}
+	zw, ok := bw.w.(zeroWriter)
+	if !ok {
+		for k < n {
+			p := zeroBuf[:]
+			if r := n - k; r < int64(len(p)) {
+				p = p[:r]
+			}
+			m, werr := bw.mw.Write(p)
+			k += int64(m)
+			bw.n += int64(m)
+			if werr != nil {
+				return k, werr
+			}
+		}
+		return k, err
+	}
+	if werr := zw.WriteZeros(n); werr != nil {
+		return 0, werr
+	}
+	for k < n {
+		p := zeroBuf[:]
+		if r := n - k; r < int64(len(p)) {
+			p = p[:r]
+		}
+		bw.hash.Write(p)
+		k += int64(len(p))
+	}
+	bw.n += n
+	return n, err
+}
+
+// dataLen returns the length of the prefix of p consisting of blocks
+// with non-zero bytes.
+func dataLen(p []byte) int {
+	n := 0
+	for n < len(p) {
+		b := p[n:]
+		if len(b) > sparseBlockSize {
+			b = b[:sparseBlockSize]
+		}
+		if bytes.Equal(b, zeroBuf[:len(b)]) {
+			break
+		}
+		n += len(b)
+	}
+	return n
+}
+
+// zeroLen returns the length of the prefix of p consisting of blocks of
+// zeros.
+func zeroLen(p []byte) int {
+	n := 0
+	for n < len(p) {
+		b := p[n:]
+		if len(b) > sparseBlockSize {
+			b = b[:sparseBlockSize]
+		}
+		if !bytes.Equal(b, zeroBuf[:len(b)]) {
+			break
+		}
+		n += len(b)
+	}
+	return n
+}
+
+// sparseCopier copies data to a Writer and collects the zero runs.
+type sparseCopier struct {
+	w   *Writer
+	buf []byte
+	// number of zeros not written yet
+	zeros int64
+	// number of bytes written
+	n int64
+}
+
+// copy reads the data from r until io.EOF.
+func (c *sparseCopier) copy(r io.Reader) error {
+	for {
+		k, rerr := io.ReadFull(r, c.buf)
+		p := c.buf[:k]
+		for len(p) > 0 {
+			if i := dataLen(p); i > 0 {
+				if err := c.flush(); err != nil {
+					return err
+				}
+				m, err := c.w.Write(p[:i])
+				c.n += int64(m)
+				if err != nil {
+					return err
+				}
+				p = p[i:]
+			}
+			i := zeroLen(p)
+			c.zeros += int64(i)
+			p = p[i:]
+		}
+		switch rerr {
+		case nil:
+		case io.EOF, io.ErrUnexpectedEOF:
+			return nil
+		default:
+			return rerr
+		}
+	}
+}
+
+// flush writes the zero run collected. Short runs are compressed like
+// other data.
+func (c *sparseCopier) flush() error {
+	if c.zeros >= minZeroRun {
+		if err := c.w.WriteZeros(c.zeros); err != nil {
+			return err
+		}
+		c.n += c.zeros
+		c.zeros = 0
+		return nil
+	}
+	for c.zeros > 0 {
+		p := zeroBuf[:]
+		if c.zeros < int64(len(p)) {
+			p = p[:c.zeros]
+		}
+		k, err := c.w.Write(p)
+		c.n += int64(k)
+		c.zeros -= int64(k)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// errNoHoles indicates that the holes of a file cannot be located.
+var errNoHoles = errors.New("xz: holes not supported")
+
+// copyHoles copies the regular file f. The holes are located with
+// dataRegion and don't need to be read.
+func (c *sparseCopier) copyHoles(f *os.File) error {
+	fi, err := f.Stat()
+	if err != nil || !fi.Mode().IsRegular() {
+		return errNoHoles
+	}
+	off, err := f.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return errNoHoles
+	}
+	start, size := off, fi.Size()
+	for off < size {
+		data, hole, err := dataRegion(f, off, size)
+		if err != nil {
+			if err == errNoHoles && off == start {
+				// nothing has been read yet
+				if _, err = f.Seek(start,
+					io.SeekStart); err != nil {
+					return err
+				}
+				return errNoHoles
+			}
+			return err
+		}
+		c.zeros += data - off
+		if data < hole {
+			if err = c.copy(io.LimitReader(f, hole-data)); err != nil {
+				return err
+			}
+		}
+		off = hole
+	}
+	// data appended to the file since Stat
+	if _, err = f.Seek(size, io.SeekStart); err != nil {
+		return err
+	}
+	return c.copy(f)
+}
+
+// CopySparse compresses the data read from src until io.EOF and
+// returns the number of bytes written to w. Zero runs of at least 64
+// KiB, consisting of zero blocks of 4 KiB, are written with WriteZeros,
+// which speeds up the compression of sparse files and disk images.
+// If src is a regular file, the holes are located with SEEK_DATA and
+// SEEK_HOLE on platforms supporting them and are not read at all. The
+// writer is not closed.
+func CopySparse(w *Writer, src io.Reader) (written int64, err error) {
+	c := &sparseCopier{w: w, buf: make([]byte, 1<<16)}
+	err = errNoHoles
+	if f, ok := src.(*os.File); ok {
+		err = c.copyHoles(f)
+	}
+	if err == errNoHoles {
+		err = c.copy(src)
+	}
+	if err == nil {
+		err = c.flush()
+	}
+	return c.n, err
+}
+
+// WriteSparse copies the data read from src until io.EOF to dst and
+// returns the number of bytes copied. Blocks of 4 KiB zeros are not
+// written; dst is moved over them by Seek, which creates holes in files
+// of file systems supporting sparse files. If the data ends with zeros,
+// dst is truncated to its size, if dst has a Truncate method like
+// os.File; otherwise the last zero is written. Regions skipped keep
+// their content, so dst should be a new or truncated file.
+func WriteSparse(dst io.WriteSeeker, src io.Reader) (written int64,
+	err error) {
+
+	buf := make([]byte, 1<<16)
+	var skip int64
+	for {
+		k, rerr := io.ReadFull(src, buf)
+		p := buf[:k]
+		for len(p) > 0 {
+			if i := dataLen(p); i > 0 {
+				if skip > 0 {
+					if _, err = dst.Seek(skip,
+						io.SeekCurrent); err != nil {
+						return written, err
+					}
+					skip = 0
+				}
+				m, err := dst.Write(p[:i])
+				written += int64(m)
+				if err != nil {
+					return written, err
+				}
+				p = p[i:]
+			}
+			i := zeroLen(p)
+			skip += int64(i)
+			written += int64(i)
+			p = p[i:]
+		}
+		if rerr == io.EOF || rerr == io.ErrUnexpectedEOF {
+			break
+		}
+		if rerr != nil {
+			return written, rerr
+		}
+	}
+	if skip == 0 {
+		return written, nil
+	}
+	if t, ok := dst.(truncater); ok {
+		off, err := dst.Seek(skip, io.SeekCurrent)
+		if err != nil {
+			return written, err
+		}
+		return written, t.Truncate(off)
+	}
+	if _, err = dst.Seek(skip-1, io.SeekCurrent); err != nil {
+		return written, err
+	}
+	_, err = dst.Write(zeroBuf[:1])
+	return written, err
+}
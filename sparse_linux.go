@@ -0,0 +1,46 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"io"
+	"os"
+	"syscall"
+)
+
+// whence values of lseek(2) locating data and holes
+const (
+	seekData = 3
+	seekHole = 4
+)
+
+// dataRegion returns the next region of data at or after off in the
+// file of the given size. The file is positioned at the start of the
+// data. If no data follows off, data and hole are both the size.
+func dataRegion(f *os.File, off, size int64) (data, hole int64, err error) {
+	if data, err = f.Seek(off, seekData); err != nil {
+		pe, ok := err.(*os.PathError)
+		if !ok {
+			return 0, 0, err
+		}
+		switch pe.Err {
+		case syscall.ENXIO:
+			return size, size, nil
+		case syscall.EINVAL:
+			return 0, 0, errNoHoles
+		}
+		return 0, 0, err
+	}
+	if hole, err = f.Seek(data, seekHole); err != nil {
+		return 0, 0, err
+	}
+	if hole > size {
+		hole = size
+	}
+	if _, err = f.Seek(data, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	return data, hole, nil
+}
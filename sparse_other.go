@@ -0,0 +1,16 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !linux
+// +build !linux
+
+package xz
+
+import "os"
+
+// dataRegion is not supported on this platform; the holes are detected
+// by reading the zeros.
+func dataRegion(f *os.File, off, size int64) (data, hole int64, err error) {
+	return 0, 0, errNoHoles
+}
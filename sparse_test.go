@@ -0,0 +1,123 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"testing"
+
+	"github.com/ulikunitz/xz/internal/randtxt"
+)
+
+// sparseData returns text with a zero run of n bytes in the middle and
+// at the end.
+func sparseData(n int) []byte {
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(42)), 100000)
+	buf.Write(make([]byte, n))
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(43)), 10000)
+	buf.Write(make([]byte, n))
+	return buf.Bytes()
+}
+
+// decompress decompresses the xz stream.
+func decompress(t *testing.T, xz []byte) []byte {
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	return p
+}
+
+func TestWriterWriteZeros(t *testing.T) {
+	text := []byte("The quick brown fox jumps over the lazy dog.\n")
+	var buf bytes.Buffer
+	w, err := WriterConfig{BlockSize: 1 << 20}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	var want []byte
+	for _, n := range []int64{3 << 20, 1000} {
+		if _, err = w.Write(text); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.WriteZeros(n); err != nil {
+			t.Fatalf("WriteZeros(%d) error %s", n, err)
+		}
+		want = append(want, text...)
+		want = append(want, make([]byte, n)...)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if buf.Len() > 1000 {
+		t.Fatalf("compressed size %d; want at most 1000", buf.Len())
+	}
+	if !bytes.Equal(decompress(t, buf.Bytes()), want) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestCopySparse(t *testing.T) {
+	data := sparseData(1 << 20)
+	f, err := ioutil.TempFile("", "sparse")
+	if err != nil {
+		t.Fatalf("TempFile error %s", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+	if _, err = WriteSparse(f, bytes.NewReader(data)); err != nil {
+		t.Fatalf("WriteSparse error %s", err)
+	}
+	if _, err = f.Seek(0, io.SeekStart); err != nil {
+		t.Fatalf("Seek error %s", err)
+	}
+	p, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(p, data) {
+		t.Fatalf("WriteSparse: file content differs")
+	}
+	sources := []struct {
+		name string
+		r    io.Reader
+	}{
+		{"file", f},
+		{"reader", bytes.NewReader(data)},
+	}
+	for _, src := range sources {
+		if _, err = f.Seek(0, io.SeekStart); err != nil {
+			t.Fatalf("Seek error %s", err)
+		}
+		var buf bytes.Buffer
+		w, err := NewWriter(&buf)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		n, err := CopySparse(w, src.r)
+		if err != nil {
+			t.Fatalf("%s: CopySparse error %s", src.name, err)
+		}
+		if n != int64(len(data)) {
+			t.Fatalf("%s: CopySparse returned %d; want %d",
+				src.name, n, len(data))
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("%s: Close error %s", src.name, err)
+		}
+		if !bytes.Equal(decompress(t, buf.Bytes()), data) {
+			t.Fatalf("%s: decompressed data differs", src.name)
+		}
+	}
+}
@@ -0,0 +1,328 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"errors"
+	"io"
+)
+
+// StreamInfo describes a single stream of an xz file.
+type StreamInfo struct {
+	// offset of the stream header in the file
+	Offset int64
+	// size of the stream including header, index and footer
+	CompressedSize int64
+	// size of the stream padding following the stream
+	Padding int64
+	// check method: CRC32, CRC64 or SHA256
+	Check byte
+	// largest dictionary capacity used by a block of the stream
+	DictCap int64
+	// index of the stream
+	Index *Index
+}
+
+// CheckName returns the name of the check method of the stream.
+func (s *StreamInfo) CheckName() string {
+	return flagString(s.Check)
+}
+
+// Info provides the information about an xz file that can be gathered
+// without decompressing it.
+type Info struct {
+	Streams []StreamInfo
+	// size of the file
+	CompressedSize int64
+	// size of the decompressed data
+	UncompressedSize int64
+}
+
+// Blocks returns the number of blocks in all streams.
+func (info *Info) Blocks() int {
+	n := 0
+	for _, s := range info.Streams {
+		n += s.Index.Len()
+	}
+	return n
+}
+
+// DictCap returns the largest dictionary capacity of all streams.
+func (info *Info) DictCap() int64 {
+	var c int64
+	for _, s := range info.Streams {
+		if s.DictCap > c {
+			c = s.DictCap
+		}
+	}
+	return c
+}
+
+// Ratio returns the compression ratio computed by dividing the
+// compressed size by the uncompressed size. Zero is returned if the
+// uncompressed size is zero.
+func (info *Info) Ratio() float64 {
+	if info.UncompressedSize == 0 {
+		return 0
+	}
+	return float64(info.CompressedSize) / float64(info.UncompressedSize)
+}
+
+// BlockInfo describes the position of a block in an xz file.
+type BlockInfo struct {
+	BlockRecord
+	// offset of the block header in the file
+	Offset int64
+	// offset of the first byte of the block in the uncompressed data
+	// of the file
+	UncompressedOffset int64
+}
+
+// FilterInfo describes a filter of a block.
+type FilterInfo struct {
+	// filter ID; the LZMA2 filter has the ID 0x21
+	ID uint64
+	// encoded properties of the filter
+	Props []byte
+}
+
+// BlockHeaderInfo describes a block that has been read by the Reader
+// including the information of its header. It is provided to the
+// BlockHook function of the reader configuration.
+type BlockHeaderInfo struct {
+	BlockInfo
+	// size of the block header
+	HeaderSize int
+	// block flags of the header
+	Flags byte
+	// compressed and uncompressed sizes stored in the header; -1 if
+	// not present
+	HeaderCompressedSize   int64
+	HeaderUncompressedSize int64
+	// filters of the block in the order of the header
+	Filters []FilterInfo
+	// check method of the stream: CRC32, CRC64 or SHA256
+	Check byte
+	// dictionary capacity of the LZMA2 filter
+	DictCap int64
+}
+
+// newBlockHeaderInfo creates the information for the given block
+// header. The fields of the embedded BlockInfo are not set.
+func newBlockHeaderInfo(h *blockHeader, hlen int, check byte) (
+	b BlockHeaderInfo, err error) {
+
+	b = BlockHeaderInfo{
+		HeaderSize:             hlen,
+		Flags:                  h.flags(),
+		HeaderCompressedSize:   h.compressedSize,
+		HeaderUncompressedSize: h.uncompressedSize,
+		Check:                  check,
+	}
+	for _, f := range h.filters {
+		p, err := f.MarshalBinary()
+		if err != nil {
+			return b, err
+		}
+		id, k, err := Uvarint(p)
+		if err != nil {
+			return b, err
+		}
+		_, l, err := Uvarint(p[k:])
+		if err != nil {
+			return b, err
+		}
+		b.Filters = append(b.Filters,
+			FilterInfo{ID: id, Props: p[k+l:]})
+		if lf, ok := f.(*lzmaFilter); ok {
+			b.DictCap = lf.dictCap
+		}
+	}
+	return b, nil
+}
+
+// BlockMap returns the positions of all blocks of all streams in the
+// order of the file. It allows random access to the uncompressed data.
+func (info *Info) BlockMap() []BlockInfo {
+	blocks := make([]BlockInfo, 0, info.Blocks())
+	var u int64
+	for _, s := range info.Streams {
+		pos := s.Offset + HeaderLen
+		for _, rec := range s.Index.records {
+			blocks = append(blocks, BlockInfo{
+				BlockRecord: BlockRecord{
+					UnpaddedSize:     rec.unpaddedSize,
+					UncompressedSize: rec.uncompressedSize,
+				},
+				Offset:             pos,
+				UncompressedOffset: u,
+			})
+			pos += rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+			u += rec.uncompressedSize
+		}
+	}
+	return blocks
+}
+
+// errStat indicates that the structure of the xz file is corrupt.
+var errStat = errors.New("xz: invalid file structure")
+
+// Stat reads the structure of the xz file r and returns information
+// about it. The streams are located starting from the end of the file
+// using the stream footers and indexes. Only the headers of the streams
+// and blocks are read; no data is decompressed.
+func Stat(r io.ReadSeeker) (info *Info, err error) {
+	end, err := r.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, err
+	}
+	if end%4 != 0 || end == 0 {
+		return nil, errStat
+	}
+	info = &Info{CompressedSize: end}
+	p := make([]byte, HeaderLen)
+	for pos := end; pos > 0; {
+		// stream padding
+		var padding int64
+		for pos >= 4 {
+			if err = readAt(r, pos-4, p[:4]); err != nil {
+				return nil, err
+			}
+			if !allZeros(p[:4]) {
+				break
+			}
+			pos -= 4
+			padding += 4
+		}
+		if pos == 0 {
+			if len(info.Streams) == 0 {
+				return nil, errStat
+			}
+			info.Streams[len(info.Streams)-1].Padding += padding
+			break
+		}
+		s, err := statStream(r, pos, p)
+		if err != nil {
+			return nil, err
+		}
+		s.Padding = padding
+		info.Streams = append(info.Streams, s)
+		info.UncompressedSize += s.Index.UncompressedSize()
+		pos = s.Offset
+	}
+	// The streams have been found in reverse order.
+	for i, j := 0, len(info.Streams)-1; i < j; i, j = i+1, j-1 {
+		info.Streams[i], info.Streams[j] =
+			info.Streams[j], info.Streams[i]
+	}
+	return info, nil
+}
+
+// StatAt works like Stat for an xz file of the given size provided by
+// an io.ReaderAt. Only the footers, indexes and headers are read, so
+// large files can be inspected quickly.
+func StatAt(r io.ReaderAt, size int64) (info *Info, err error) {
+	return Stat(io.NewSectionReader(r, 0, size))
+}
+
+// statStream reads the stream ending at position end. The slice p must
+// have a length of at least HeaderLen.
+func statStream(r io.ReadSeeker, end int64, p []byte) (s StreamInfo,
+	err error) {
+
+	// footer
+	if end < HeaderLen+footerLen {
+		return s, errStat
+	}
+	if err = readAt(r, end-footerLen, p[:footerLen]); err != nil {
+		return s, err
+	}
+	var f footer
+	if err = f.UnmarshalBinary(p[:footerLen]); err != nil {
+		return s, err
+	}
+
+	// index
+	indexStart := end - footerLen - f.indexSize
+	if indexStart < HeaderLen {
+		return s, errStat
+	}
+	if err = readAt(r, indexStart, p[:1]); err != nil {
+		return s, err
+	}
+	if p[0] != 0 {
+		return s, errIndex
+	}
+	records, n, err := readIndexBody(r)
+	if err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return s, err
+	}
+	if n+1 != f.indexSize {
+		return s, errors.New("xz: index size in footer wrong")
+	}
+
+	// header
+	var blocksSize int64
+	for _, rec := range records {
+		blocksSize += rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+		if blocksSize < 0 || blocksSize > indexStart {
+			return s, errStat
+		}
+	}
+	s.Offset = indexStart - blocksSize - HeaderLen
+	if s.Offset < 0 {
+		return s, errStat
+	}
+	if err = readAt(r, s.Offset, p[:HeaderLen]); err != nil {
+		return s, err
+	}
+	var h header
+	if err = h.UnmarshalBinary(p[:HeaderLen]); err != nil {
+		return s, err
+	}
+	if h.flags != f.flags {
+		return s, errors.New("xz: footer flags incorrect")
+	}
+	s.Check = h.flags
+	s.CompressedSize = end - s.Offset
+	s.Index = &Index{records: records}
+
+	// block headers
+	pos := s.Offset + HeaderLen
+	for _, rec := range records {
+		if _, err = r.Seek(pos, io.SeekStart); err != nil {
+			return s, err
+		}
+		bh, _, err := readBlockHeader(r)
+		if err != nil {
+			if err == io.EOF || err == errIndexIndicator {
+				err = errStat
+			}
+			return s, err
+		}
+		for _, f := range bh.filters {
+			if lf, ok := f.(*lzmaFilter); ok && lf.dictCap > s.DictCap {
+				s.DictCap = lf.dictCap
+			}
+		}
+		pos += rec.unpaddedSize + int64(padLen(rec.unpaddedSize))
+	}
+	return s, nil
+}
+
+// readAt reads len(p) bytes at offset off.
+func readAt(r io.ReadSeeker, off int64, p []byte) error {
+	if _, err := r.Seek(off, io.SeekStart); err != nil {
+		return err
+	}
+	_, err := io.ReadFull(r, p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return err
+}
@@ -0,0 +1,145 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ulikunitz/xz/lzma"
+)
+
+func TestStat(t *testing.T) {
+	const blockSize = 1000
+	xz, data := multiStream(t, blockSize)
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	indexes := r.Indexes()
+	xz = append(xz, make([]byte, 8)...)
+
+	info, err := Stat(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	if len(info.Streams) != len(data) {
+		t.Fatalf("got %d streams; want %d", len(info.Streams),
+			len(data))
+	}
+	if info.CompressedSize != int64(len(xz)) {
+		t.Fatalf("CompressedSize %d; want %d", info.CompressedSize,
+			len(xz))
+	}
+	var n int64
+	blocks := 0
+	for i, s := range info.Streams {
+		n += int64(len(data[i]))
+		blocks += indexes[i].Len()
+		if got, want := s.Index.BlockRecords(), indexes[i].BlockRecords(); len(got) != len(want) {
+			t.Fatalf("stream %d: got %d blocks; want %d", i,
+				len(got), len(want))
+		}
+		if s.CheckName() != "CRC-64" {
+			t.Fatalf("stream %d: check %s; want CRC-64", i,
+				s.CheckName())
+		}
+		// the dictionary capacity is reduced to the block size
+		if s.DictCap != lzma.MinDictCap {
+			t.Fatalf("stream %d: DictCap %d; want %d", i, s.DictCap,
+				lzma.MinDictCap)
+		}
+	}
+	if info.Streams[0].Offset != 0 {
+		t.Fatalf("first stream at offset %d", info.Streams[0].Offset)
+	}
+	s0 := info.Streams[0]
+	if s0.Offset+s0.CompressedSize != info.Streams[1].Offset {
+		t.Fatalf("second stream at offset %d; want %d",
+			info.Streams[1].Offset, s0.Offset+s0.CompressedSize)
+	}
+	if p := info.Streams[1].Padding; p != 8 {
+		t.Fatalf("padding %d; want %d", p, 8)
+	}
+	if info.UncompressedSize != n {
+		t.Fatalf("UncompressedSize %d; want %d", info.UncompressedSize,
+			n)
+	}
+	if info.Blocks() != blocks {
+		t.Fatalf("Blocks returned %d; want %d", info.Blocks(), blocks)
+	}
+	if r := info.Ratio(); r <= 0 || r >= 1 {
+		t.Fatalf("Ratio %g out of range", r)
+	}
+}
+
+func TestStatErrors(t *testing.T) {
+	xz, _ := multiStream(t, 1000)
+	tests := [][]byte{
+		nil,
+		make([]byte, 16),
+		xz[:len(xz)-4],
+		xz[4:],
+		append([]byte{1, 2, 3, 4}, xz...),
+	}
+	for i, p := range tests {
+		if _, err := Stat(bytes.NewReader(p)); err == nil {
+			t.Errorf("test %d: Stat returned no error", i)
+		}
+	}
+}
+
+func TestStatAtBlockMap(t *testing.T) {
+	xz, data := multiStream(t, 1000)
+	info, err := StatAt(bytes.NewReader(xz), int64(len(xz)))
+	if err != nil {
+		t.Fatalf("StatAt error %s", err)
+	}
+	blocks := info.BlockMap()
+	if len(blocks) != info.Blocks() {
+		t.Fatalf("BlockMap returned %d blocks; want %d", len(blocks),
+			info.Blocks())
+	}
+	all := bytes.Join(data, nil)
+	var c ReaderConfig
+	if err = c.Verify(); err != nil {
+		t.Fatalf("Verify error %s", err)
+	}
+	var u int64
+	for i, b := range blocks {
+		if b.UncompressedOffset != u {
+			t.Fatalf("block %d: UncompressedOffset %d; want %d",
+				i, b.UncompressedOffset, u)
+		}
+		u += b.UncompressedSize
+		r := bytes.NewReader(xz[b.Offset:])
+		h, hlen, err := readBlockHeader(r)
+		if err != nil {
+			t.Fatalf("block %d: readBlockHeader error %s", i, err)
+		}
+		br, err := c.newBlockReader(r, h, hlen, newCRC64())
+		if err != nil {
+			t.Fatalf("block %d: newBlockReader error %s", i, err)
+		}
+		p, err := ioutil.ReadAll(br)
+		if err != nil {
+			t.Fatalf("block %d: ReadAll error %s", i, err)
+		}
+		q := all[b.UncompressedOffset : b.UncompressedOffset+
+			b.UncompressedSize]
+		if !bytes.Equal(p, q) {
+			t.Fatalf("block %d: data differs", i)
+		}
+	}
+	if u != info.UncompressedSize {
+		t.Fatalf("blocks contain %d bytes; want %d", u,
+			info.UncompressedSize)
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "sync"
+
+// SyncWriter serializes the calls to a Writer, so it can be shared by
+// multiple goroutines. The data of a single Write call is never
+// interleaved with the data of other calls, but the order of
+// concurrent calls is not defined.
+type SyncWriter struct {
+	mu sync.Mutex
+	w  *Writer
+}
+
+// NewSyncWriter returns a SyncWriter for w. The writer must not be used
+// directly afterwards.
+func NewSyncWriter(w *Writer) *SyncWriter {
+	return &SyncWriter{w: w}
+}
+
+// Write compresses the data provided.
+func (s *SyncWriter) Write(p []byte) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Write(p)
+}
+
+// Flush writes all buffered data to the underlying writer.
+func (s *SyncWriter) Flush() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Flush()
+}
+
+// Close closes the writer. Calls of Write after Close return an error.
+func (s *SyncWriter) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.w.Close()
+}
+
+// SyncReader serializes the calls to a Reader, so it can be shared by
+// multiple goroutines. Each Read call returns a contiguous piece of
+// the decompressed data.
+type SyncReader struct {
+	mu sync.Mutex
+	r  *Reader
+}
+
+// NewSyncReader returns a SyncReader for r. The reader must not be used
+// directly afterwards.
+func NewSyncReader(r *Reader) *SyncReader {
+	return &SyncReader{r: r}
+}
+
+// Read reads decompressed data.
+func (s *SyncReader) Read(p []byte) (n int, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Read(p)
+}
+
+// Indexes returns the indexes of the streams that have been read
+// completely.
+func (s *SyncReader) Indexes() []*Index {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.r.Indexes()
+}
@@ -0,0 +1,73 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"sync"
+	"testing"
+)
+
+func TestSyncWriter(t *testing.T) {
+	const (
+		producers = 8
+		records   = 500
+	)
+	var buf bytes.Buffer
+	w, err := NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	sw := NewSyncWriter(w)
+	var wg sync.WaitGroup
+	for i := 0; i < producers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < records; j++ {
+				rec := fmt.Sprintf("producer %d record %04d\n", i, j)
+				if _, err := sw.Write([]byte(rec)); err != nil {
+					t.Errorf("Write error %s", err)
+					return
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	if err = sw.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	data, err := ioutil.ReadAll(NewSyncReader(r))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	lines := bytes.Split(bytes.TrimSuffix(data, []byte{'\n'}),
+		[]byte{'\n'})
+	if len(lines) != producers*records {
+		t.Fatalf("got %d records; want %d", len(lines),
+			producers*records)
+	}
+	next := make([]int, producers)
+	for _, line := range lines {
+		var i, j int
+		_, err := fmt.Sscanf(string(line), "producer %d record %d",
+			&i, &j)
+		if err != nil {
+			t.Fatalf("corrupted record %q", line)
+		}
+		if j != next[i] {
+			t.Fatalf("producer %d: got record %d; want %d",
+				i, j, next[i])
+		}
+		next[i]++
+	}
+}
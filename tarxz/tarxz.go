@@ -0,0 +1,117 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package tarxz supports the creation and the reading of tar archives
+// compressed with xz. It provides the glue between archive/tar and the
+// xz package required by backup and packaging tools.
+//
+// CreateTarXz writes files and directory trees into a .tar.xz archive
+// and Walk calls a function for every entry of an archive.
+package tarxz
+
+import (
+	"archive/tar"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/ulikunitz/xz"
+)
+
+// CreateTarXz writes a tar archive compressed with xz to dst. The
+// archive contains the given files. Directories are added with their
+// complete content. The names in the archive are the paths as given
+// with slashes as separator. Symbolic links are stored as links and
+// not followed.
+func CreateTarXz(dst io.Writer, files ...string) error {
+	xw, err := xz.NewWriter(dst)
+	if err != nil {
+		return err
+	}
+	tw := tar.NewWriter(xw)
+	for _, name := range files {
+		err = filepath.Walk(name, func(path string, fi os.FileInfo,
+			err error) error {
+			if err != nil {
+				return err
+			}
+			return addFile(tw, path, fi)
+		})
+		if err != nil {
+			return err
+		}
+	}
+	if err = tw.Close(); err != nil {
+		return err
+	}
+	return xw.Close()
+}
+
+// addFile writes the header and the content of the file to the tar
+// writer.
+func addFile(tw *tar.Writer, path string, fi os.FileInfo) error {
+	var link string
+	if fi.Mode()&os.ModeSymlink != 0 {
+		var err error
+		if link, err = os.Readlink(path); err != nil {
+			return err
+		}
+	}
+	hdr, err := tar.FileInfoHeader(fi, link)
+	if err != nil {
+		return err
+	}
+	hdr.Name = filepath.ToSlash(path)
+	if fi.IsDir() {
+		hdr.Name += "/"
+	}
+	if err = tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	if !fi.Mode().IsRegular() {
+		return nil
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(tw, f)
+	return err
+}
+
+// WalkFunc is called by Walk for every entry of the archive. The reader
+// provides the content of the entry and is only valid during the call.
+// An error returned stops the walk; SkipAll stops it without error.
+type WalkFunc func(hdr *tar.Header, r io.Reader) error
+
+// SkipAll can be returned by a WalkFunc to stop the walk without
+// returning an error from Walk.
+var SkipAll = errors.New("tarxz: skip all remaining entries")
+
+// Walk reads the tar archive compressed with xz from src and calls fn
+// for every entry in the order of the archive.
+func Walk(src io.Reader, fn WalkFunc) error {
+	xr, err := xz.NewReader(src)
+	if err != nil {
+		return err
+	}
+	tr := tar.NewReader(xr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		if err = fn(hdr, tr); err != nil {
+			if err == SkipAll {
+				return nil
+			}
+			return err
+		}
+	}
+}
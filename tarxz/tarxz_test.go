@@ -0,0 +1,95 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tarxz
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCreateWalk(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tarxz")
+	if err != nil {
+		t.Fatalf("TempDir error %s", err)
+	}
+	defer os.RemoveAll(dir)
+	files := map[string]string{
+		"a.txt":     "The quick brown fox jumps over the lazy dog.\n",
+		"sub/b.txt": "Hello, World!\n",
+		"sub/c":     "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatalf("MkdirAll error %s", err)
+		}
+		if err = ioutil.WriteFile(path, []byte(content),
+			0644); err != nil {
+			t.Fatalf("WriteFile error %s", err)
+		}
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatalf("Getwd error %s", err)
+	}
+	if err = os.Chdir(dir); err != nil {
+		t.Fatalf("Chdir error %s", err)
+	}
+	defer os.Chdir(wd)
+
+	var buf bytes.Buffer
+	if err = CreateTarXz(&buf, "a.txt", "sub"); err != nil {
+		t.Fatalf("CreateTarXz error %s", err)
+	}
+	got := make(map[string]string)
+	var dirs []string
+	err = Walk(bytes.NewReader(buf.Bytes()), func(hdr *tar.Header,
+		r io.Reader) error {
+		if hdr.Typeflag == tar.TypeDir {
+			dirs = append(dirs, hdr.Name)
+			return nil
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			return err
+		}
+		got[hdr.Name] = string(p)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("Walk error %s", err)
+	}
+	if len(dirs) != 1 || dirs[0] != "sub/" {
+		t.Fatalf("got directories %q; want %q", dirs, []string{"sub/"})
+	}
+	if len(got) != len(files) {
+		t.Fatalf("got %d files; want %d", len(got), len(files))
+	}
+	for name, content := range files {
+		if got[name] != content {
+			t.Fatalf("file %s has content %q; want %q", name,
+				got[name], content)
+		}
+	}
+
+	n := 0
+	err = Walk(bytes.NewReader(buf.Bytes()), func(hdr *tar.Header,
+		r io.Reader) error {
+		n++
+		return SkipAll
+	})
+	if err != nil || n != 1 {
+		t.Fatalf("Walk with SkipAll returned %v after %d entries; "+
+			"want nil after 1", err, n)
+	}
+	if err = CreateTarXz(ioutil.Discard, "missing"); err == nil {
+		t.Fatalf("CreateTarXz accepted missing file")
+	}
+}
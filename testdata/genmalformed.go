@@ -0,0 +1,157 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build ignore
+// +build ignore
+
+// Genmalformed creates the crafted streams in testdata/malformed. Run
+// it from the root directory of the repository.
+//
+//	$ go run testdata/genmalformed.go
+//
+// The streams are derived from valid streams compressing the text
+// below. The tests in malformed_test.go depend on it.
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"log"
+	"path/filepath"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+var text = bytes.Repeat([]byte("The quick brown fox jumps over the lazy dog.\n"),
+	20)
+
+func lzmaStream(p lzma.Properties, eos bool) []byte {
+	var buf bytes.Buffer
+	w, err := lzma.WriterConfig{
+		Properties: &p,
+		DictCap:    lzma.MinDictCap,
+		Size:       int64(len(text)),
+		EOSMarker:  eos,
+	}.NewWriter(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = w.Write(text); err != nil {
+		log.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func lzma2Stream() []byte {
+	var buf bytes.Buffer
+	w, err := lzma.NewWriter2(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = w.Write(text); err != nil {
+		log.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func xzStream() []byte {
+	var buf bytes.Buffer
+	w, err := xz.NewWriter(&buf)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if _, err = w.Write(text); err != nil {
+		log.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		log.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// setSize sets the uncompressed size in the header of the classic
+// format.
+func setSize(p []byte, size int64) []byte {
+	binary.LittleEndian.PutUint64(p[5:], uint64(size))
+	return p
+}
+
+// flip inverts the bits of the byte at offset i.
+func flip(p []byte, i int) []byte {
+	p[i] ^= 0xff
+	return p
+}
+
+// xzOffsets returns the offsets of the first chunk of the first block
+// and of the index of an xz stream.
+func xzOffsets(p []byte) (chunk, index int) {
+	chunk = 12 + (int(p[12])+1)*4
+	backward := (int(binary.LittleEndian.Uint32(p[len(p)-8:])) + 1) * 4
+	index = len(p) - 12 - backward
+	return chunk, index
+}
+
+func main() {
+	std := lzma.Properties{LC: 3, LP: 0, PB: 2}
+	files := map[string][]byte{}
+	add := func(name string, p []byte) { files[name] = p }
+
+	s := lzmaStream(std, true)
+	add("empty.lzma", []byte{})
+	add("truncated-header.lzma", s[:7])
+	add("truncated-data.lzma", s[:len(s)/2])
+	add("properties.lzma", append([]byte{225}, s[1:]...))
+	p := append([]byte{}, s...)
+	binary.LittleEndian.PutUint32(p[1:], 1024)
+	add("small-dict.lzma", p)
+	p = append([]byte{}, s...)
+	binary.LittleEndian.PutUint32(p[1:], 1<<32-1)
+	add("huge-dict.lzma", p)
+	add("size-too-long.lzma",
+		setSize(append([]byte{}, s...), int64(len(text))+100))
+	add("size-too-short.lzma",
+		setSize(append([]byte{}, s...), int64(len(text))-10))
+	add("missing-eos.lzma", setSize(lzmaStream(std, false), -1))
+	add("lc0-lp4-pb4.lzma", lzmaStream(lzma.Properties{LC: 0, LP: 4,
+		PB: 4}, false))
+	add("lc8-lp0-pb0.lzma", lzmaStream(lzma.Properties{LC: 8, LP: 0,
+		PB: 0}, true))
+	add("lc4-lp4-pb1.lzma", lzmaStream(lzma.Properties{LC: 4, LP: 4,
+		PB: 1}, true))
+
+	// The first chunk of the LZMA2 stream sets the properties.
+	props := lzma.Properties{LC: 4, LP: 1, PB: 2}.Code()
+	s = lzma2Stream()
+	p = append([]byte{}, s...)
+	p[5] = props
+	add("lc-lp.lzma2", p)
+	add("truncated.lzma2", s[:len(s)-1])
+
+	s = xzStream()
+	chunk, index := xzOffsets(s)
+	add("truncated-header.xz", s[:6])
+	add("header-crc.xz", flip(append([]byte{}, s...), 8))
+	add("block-header-crc.xz", flip(append([]byte{}, s...), chunk-1))
+	add("block-check.xz", flip(append([]byte{}, s...), index-1))
+	add("index-crc.xz", flip(append([]byte{}, s...), len(s)-13))
+	add("footer-crc.xz", flip(append([]byte{}, s...), len(s)-12))
+	p = append([]byte{}, s...)
+	p[chunk+5] = props
+	add("lc-lp.xz", p)
+
+	for name, data := range files {
+		path := filepath.Join("testdata", "malformed", name)
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			log.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "sort"
+
+// version is the version of the package.
+const version = "0.6.0-dev"
+
+// Version returns the version of the package. It follows semantic
+// versioning; development versions have the suffix -dev.
+func Version() string {
+	return version
+}
+
+// FilterCapability describes a filter of the xz format.
+type FilterCapability struct {
+	ID   uint64
+	Name string
+	// the filter is supported by Reader and Writer
+	Decode bool
+	Encode bool
+}
+
+// CheckCapability describes a check method of the xz format.
+type CheckCapability struct {
+	ID   byte
+	Name string
+	// size of the check value in bytes
+	Size int
+	// the check has been registered with RegisterCheck
+	Registered bool
+}
+
+// Capabilities describes the formats, filters and checks supported by
+// the package. Applications can report them or enable features
+// depending on them.
+type Capabilities struct {
+	// Version is the version of the package.
+	Version string
+	// Formats lists the names of the supported file formats: xz
+	// and the classic LZMA format supported by NewAutoReader.
+	Formats []string
+	// Filters lists the filters ordered by ID.
+	Filters []FilterCapability
+	// Checks lists the check methods that can be computed, including
+	// the registered ones, ordered by ID. Readers skip other checks
+	// without verifying them.
+	Checks []CheckCapability
+}
+
+// SupportedCapabilities returns the capabilities of the package. The
+// checks registered at the time of the call are included.
+func SupportedCapabilities() Capabilities {
+	c := Capabilities{
+		Version: version,
+		Formats: []string{"xz", "lzma"},
+		Filters: []FilterCapability{
+			{ID: lzmaFilterID, Name: "LZMA2", Decode: true,
+				Encode: true},
+		},
+		Checks: []CheckCapability{{ID: 0, Name: flagString(0)}},
+	}
+	for id := range flagstrings {
+		c.Checks = append(c.Checks, CheckCapability{
+			ID:   id,
+			Name: flagString(id),
+			Size: checkSize(id),
+		})
+	}
+	var registered []byte
+	checkRegistry.RLock()
+	for id := range checkRegistry.byID {
+		registered = append(registered, id)
+	}
+	checkRegistry.RUnlock()
+	for _, id := range registered {
+		c.Checks = append(c.Checks, CheckCapability{
+			ID:         id,
+			Name:       flagString(id),
+			Size:       checkSize(id),
+			Registered: true,
+		})
+	}
+	sort.Slice(c.Checks, func(i, j int) bool {
+		return c.Checks[i].ID < c.Checks[j].ID
+	})
+	return c
+}
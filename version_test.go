@@ -0,0 +1,38 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xz
+
+import "testing"
+
+func TestSupportedCapabilities(t *testing.T) {
+	c := SupportedCapabilities()
+	if c.Version == "" || c.Version != Version() {
+		t.Fatalf("Version %q; want %q", c.Version, Version())
+	}
+	if len(c.Filters) != 1 || c.Filters[0].ID != lzmaFilterID ||
+		!c.Filters[0].Decode || !c.Filters[0].Encode {
+		t.Fatalf("unexpected filters %+v", c.Filters)
+	}
+	want := map[byte]int{0: 0, CRC32: 4, CRC64: 8, SHA256: 32}
+	for i, check := range c.Checks {
+		if i > 0 && c.Checks[i-1].ID >= check.ID {
+			t.Fatalf("checks not ordered: %+v", c.Checks)
+		}
+		if check.Size != checkSize(check.ID) {
+			t.Fatalf("check %+v has wrong size", check)
+		}
+		if _, ok := flagstrings[check.ID]; ok && check.Registered {
+			t.Fatalf("predefined check %+v registered", check)
+		}
+		if _, err := newHashFunc(check.ID); err != nil &&
+			check.ID != 0 {
+			t.Fatalf("check %+v not supported: %s", check, err)
+		}
+		delete(want, check.ID)
+	}
+	if len(want) > 0 {
+		t.Fatalf("checks %v missing", want)
+	}
+}
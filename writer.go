@@ -5,10 +5,15 @@
 package xz
 
 import (
+	"bytes"
 	"errors"
 	"hash"
 	"io"
+	"sync"
+	"time"
 
+	"github.com/ulikunitz/xz/checks"
+	"github.com/ulikunitz/xz/internal/xio"
 	"github.com/ulikunitz/xz/lzma"
 )
 
@@ -16,12 +21,103 @@ import (
 type WriterConfig struct {
 	Properties *lzma.Properties
 	DictCap    int
-	BufSize    int
-	BlockSize  int64
-	// checksum method: CRC32, CRC64 or SHA256
+	// BufSize is the size of the lookahead buffer of the encoder. A
+	// larger buffer costs memory but reduces the overhead of many
+	// small writes; see lzma.Writer2Config. The value 0 selects the
+	// default of the LZMA2 writer.
+	BufSize int
+	// BlockSize is the number of uncompressed bytes after which the
+	// current block is closed and a new block is started. Blocks
+	// don't share the dictionary or the coder state, so each block
+	// can be decoded separately. A dictionary capacity larger than
+	// the block size is reduced accordingly, which bounds the memory
+	// required by the decoder. The value 0 selects blocks of
+	// unlimited size.
+	BlockSize int64
+	// HeaderSizes requests that the compressed and the uncompressed
+	// size of each block are stored in its block header, so that
+	// decoders can report the sizes without reading the index. The
+	// writer buffers each block in memory until it is complete, so
+	// BlockSize should be set to limit the memory required. Flush is
+	// not supported.
+	HeaderSizes bool
+	// Size is the number of bytes that will be written, if it is
+	// known. A positive value reduces a larger dictionary capacity
+	// to the size, so small payloads don't require large
+	// dictionaries in the writer and the reader. Close returns
+	// *lzma.ErrSizeMismatch if a different number of bytes has been
+	// written.
+	Size int64
+	// checksum method: CRC32, CRC64, SHA256 or a check registered
+	// with RegisterCheck
 	CheckSum byte
-	// match algorithm
+	// match algorithm and its search depth
 	Matcher lzma.MatchAlgorithm
+	Depth   int
+	// NiceLen stops the search for longer matches and Lazy enables
+	// lazy matching; see lzma.Writer2Config.
+	NiceLen int
+	Lazy    bool
+	// word length and hash function of the lzma.HashTable4 matcher;
+	// see lzma.Writer2Config
+	WordLen   int
+	HashMixer lzma.HashMixer
+	// LongRangeLen enables the long-range matcher for very large
+	// dictionaries; see lzma.Writer2Config.
+	LongRangeLen int
+	// Pipelined runs the match finder and the range encoder in
+	// separate goroutines; see lzma.Writer2Config.
+	Pipelined bool
+	// maximum uncompressed and compressed sizes of the LZMA2 chunks;
+	// zero values select the maximum sizes
+	ChunkSize           int
+	CompressedChunkSize int
+	// AdaptiveChunks adapts the chunk sizes and state resets to the
+	// compressibility of the input; see lzma.Writer2Config.
+	AdaptiveChunks bool
+	// DictResetInterval resets the dictionary every
+	// DictResetInterval uncompressed bytes of a block; see
+	// lzma.Writer2Config. Each block starts with a dictionary reset
+	// anyway, so the interval should divide BlockSize.
+	DictResetInterval int64
+	// StoreThreshold is the size of a compressed chunk in percent of
+	// its uncompressed size, above which the data is stored
+	// uncompressed; see lzma.Writer2Config. Zero selects 100.
+	StoreThreshold int
+	// Store writes the data in uncompressed LZMA2 chunks. The xz
+	// container with its checks is kept, but no CPU time is spent on
+	// compression, which suits already compressed data. If DictCap is
+	// zero, the minimum dictionary capacity is used, so the decoder
+	// needs little memory.
+	Store bool
+	// DedupHints receives hints for long repeated regions of the
+	// uncompressed data. The offsets are relative to the start of
+	// the stream. DedupMinLen is the minimum length of a reported
+	// region; zero selects the default. Pipelined is ignored if
+	// DedupHints is set; see lzma.Writer2Config.
+	DedupHints  func(h lzma.DedupHint)
+	DedupMinLen int
+	// DictBuf provides the memory for the dictionary buffer of the
+	// LZMA2 encoder, which is shared by the blocks and not
+	// allocated. Its capacity must be at least DictCap+BufSize+1
+	// bytes.
+	DictBuf []byte
+	// MaxDelay bounds the time written data lingers in the writer.
+	// If it is positive, a timer flushes the writer MaxDelay after
+	// the first byte written since the last flush; see
+	// lzma.Writer2Config. It cannot be combined with HeaderSizes.
+	MaxDelay time.Duration
+	// Progress is called after each block and at the end of the
+	// stream with the total numbers of compressed and uncompressed
+	// bytes written so far.
+	Progress func(compressed, uncompressed int64)
+	// Logger receives debug messages for the chunks written. If it
+	// is nil, nothing is logged.
+	Logger lzma.Logger
+	// Trace receives the decisions of the LZMA2 encoder; see
+	// lzma.Writer2Config. The positions are offsets in the
+	// uncompressed data of the stream.
+	Trace lzma.EncoderTrace
 }
 
 // fill replaces zero values with default values.
@@ -30,10 +126,22 @@ func (c *WriterConfig) fill() {
 		c.Properties = &lzma.Properties{LC: 3, LP: 0, PB: 2}
 	}
 	if c.DictCap == 0 {
-		c.DictCap = 8 * 1024 * 1024
+		if c.Store {
+			c.DictCap = lzma.MinDictCap
+		} else {
+			c.DictCap = 8 * 1024 * 1024
+		}
+	}
+	// The dictionary never holds more than the data of a block.
+	n := c.Size
+	if 0 < c.BlockSize && (n <= 0 || c.BlockSize < n) {
+		n = c.BlockSize
 	}
-	if c.BufSize == 0 {
-		c.BufSize = 4096
+	if 0 < n && n < int64(c.DictCap) {
+		c.DictCap = int(n)
+		if c.DictCap < lzma.MinDictCap {
+			c.DictCap = lzma.MinDictCap
+		}
 	}
 	if c.BlockSize == 0 {
 		c.BlockSize = maxInt64
@@ -50,24 +158,97 @@ func (c *WriterConfig) Verify() error {
 		return errors.New("xz: writer configuration is nil")
 	}
 	c.fill()
-	lc := lzma.Writer2Config{
-		Properties: c.Properties,
-		DictCap:    c.DictCap,
-		BufSize:    c.BufSize,
-		Matcher:    c.Matcher,
-	}
+	lc := c.writer2Config()
 	if err := lc.Verify(); err != nil {
 		return err
 	}
 	if c.BlockSize <= 0 {
 		return errors.New("xz: block size out of range")
 	}
-	if err := verifyFlags(c.CheckSum); err != nil {
+	if _, err := newHashFunc(c.CheckSum); err != nil {
 		return err
 	}
+	if c.MaxDelay < 0 {
+		return errors.New("xz: MaxDelay must not be negative")
+	}
+	if c.MaxDelay > 0 && c.HeaderSizes {
+		return errors.New("xz: MaxDelay not supported with HeaderSizes")
+	}
 	return nil
 }
 
+// writer2Config returns the configuration of the LZMA2 writer.
+func (c *WriterConfig) writer2Config() lzma.Writer2Config {
+	return lzma.Writer2Config{
+		Properties: c.Properties,
+		DictCap:    c.DictCap,
+		BufSize:    c.BufSize,
+		Matcher:    c.Matcher,
+		Depth:      c.Depth,
+		NiceLen:    c.NiceLen,
+		Lazy:       c.Lazy,
+		WordLen:    c.WordLen,
+		HashMixer:  c.HashMixer,
+
+		LongRangeLen:        c.LongRangeLen,
+		Pipelined:           c.Pipelined,
+		ChunkSize:           c.ChunkSize,
+		CompressedChunkSize: c.CompressedChunkSize,
+		AdaptiveChunks:      c.AdaptiveChunks,
+		DictResetInterval:   c.DictResetInterval,
+		StoreThreshold:      c.StoreThreshold,
+		Store:               c.Store,
+
+		DedupHints:  c.DedupHints,
+		DedupMinLen: c.DedupMinLen,
+
+		DictBuf: c.DictBuf,
+		Logger:  c.Logger,
+		Trace:   c.Trace,
+	}
+}
+
+// MemoryUsage returns the number of bytes a writer created with the
+// configuration allocates; see lzma.Writer2Config.MemoryUsage. If
+// HeaderSizes is set, the buffer for a block of BlockSize bytes is
+// added, so the result is huge for blocks of unlimited size.
+func (c WriterConfig) MemoryUsage() (n int64, err error) {
+	if err = c.Verify(); err != nil {
+		return 0, err
+	}
+	lc := c.writer2Config()
+	if n, err = lc.MemoryUsage(); err != nil {
+		return 0, err
+	}
+	if c.HeaderSizes {
+		if c.BlockSize > maxInt64-n {
+			return maxInt64, nil
+		}
+		n += c.BlockSize
+	}
+	return n, nil
+}
+
+// Preset returns the writer configuration for the compression preset
+// level in the range [lzma.MinPreset,lzma.MaxPreset]. The extreme flag
+// requests the slower but stronger match finder for all levels. See
+// lzma.Preset for details. Level 0 still compresses the data; set the
+// Store field for a configuration without compression.
+func Preset(level int, extreme bool) (c WriterConfig, err error) {
+	lc, err := lzma.Preset(level, extreme)
+	if err != nil {
+		return c, err
+	}
+	c = WriterConfig{
+		Properties: lc.Properties,
+		DictCap:    lc.DictCap,
+		Matcher:    lc.Matcher,
+		Depth:      lc.Depth,
+		NiceLen:    lc.NiceLen,
+	}
+	return c, nil
+}
+
 // filters creates the filter list for the given parameters.
 func (c *WriterConfig) filters() []filter {
 	return []filter{&lzmaFilter{int64(c.DictCap)}}
@@ -76,6 +257,9 @@ func (c *WriterConfig) filters() []filter {
 // maxInt64 defines the maximum 64-bit signed integer.
 const maxInt64 = 1<<63 - 1
 
+// maxInt defines the maximum value of the int type of the platform.
+const maxInt = int(^uint(0) >> 1)
+
 // verifyFilters checks the filter list for the length and the right
 // sequence of filters.
 func verifyFilters(f []filter) error {
@@ -129,80 +313,250 @@ func nopWriteCloser(w io.Writer) io.WriteCloser {
 	return nopWCloser{w}
 }
 
-// Writer compresses data written to it. It is an io.WriteCloser.
+// Writer compresses data written to it. It is an io.WriteCloser. A
+// Writer must not be used by multiple goroutines at the same time; use
+// SyncWriter to share it.
+//
+// Writes of the underlying writer that return fewer bytes than
+// requested without an error are retried, as non-blocking sinks may
+// do that. If 100 consecutive writes don't make progress, the Writer
+// returns io.ErrShortWrite.
 type Writer struct {
 	WriterConfig
 
-	xz      io.Writer
-	bw      *blockWriter
-	newHash func() hash.Hash
-	h       header
-	index   []record
-	closed  bool
+	xz  io.Writer
+	cxz countingWriter
+	bw  *blockWriter
+	// hash computing the check; it is reset for each block
+	hash   hash.Hash
+	h      header
+	index  []record
+	closed bool
+	// offset of the stream header in the output, which is known if
+	// the output is seekable
+	streamOffset int64
+	// uncompressed bytes of the completed blocks
+	uncompressed int64
+	// buffers the current block if HeaderSizes is set
+	buf bytes.Buffer
+	// LZMA2 writer reused by all blocks
+	lw *lzma.Writer2
+
+	// flushing by timer if MaxDelay is positive; mu protects the
+	// writer against the timer and pending reports data written
+	// since the last flush
+	mu       sync.Mutex
+	timer    *time.Timer
+	timerErr error
+	pending  bool
 }
 
 // newBlockWriter creates a new block writer writes the header out.
 func (w *Writer) newBlockWriter() error {
 	var err error
-	w.bw, err = w.WriterConfig.newBlockWriter(w.xz, w.newHash())
+	c := w.WriterConfig
+	if f := c.DedupHints; f != nil {
+		// hints use offsets relative to the start of the stream;
+		// the LZMA2 writer reports them before the block is
+		// added to w.uncompressed
+		c.DedupHints = func(h lzma.DedupHint) {
+			h.Offset += w.uncompressed
+			f(h)
+		}
+	}
+	if c.Trace != nil {
+		c.Trace = blockTrace{c.Trace, w}
+	}
+	w.hash.Reset()
+	xz := w.xz
+	if c.HeaderSizes {
+		// The header is written by closeBlockWriter.
+		w.buf.Reset()
+		xz = &w.buf
+	}
+	w.bw, err = c.newBlockWriter(xz, w.hash, w.lw)
 	if err != nil {
 		return err
 	}
+	w.lw, _ = w.bw.w.(*lzma.Writer2)
+	if c.HeaderSizes {
+		return nil
+	}
 	if err = w.bw.writeHeader(w.xz); err != nil {
 		return err
 	}
 	return nil
 }
 
+// blockTrace converts the positions of the events reported for a block
+// into offsets in the stream.
+type blockTrace struct {
+	lzma.EncoderTrace
+	w *Writer
+}
+
+func (t blockTrace) Literal(pos int64, b byte) {
+	t.EncoderTrace.Literal(t.w.uncompressed+pos, b)
+}
+
+func (t blockTrace) Match(pos int64, m lzma.TraceMatch, rep int,
+	candidates []lzma.TraceMatch) {
+
+	t.EncoderTrace.Match(t.w.uncompressed+pos, m, rep, candidates)
+}
+
 // closeBlockWriter closes a block writer and records the sizes in the
-// index.
+// index. A buffered block is written with the sizes in its header.
 func (w *Writer) closeBlockWriter() error {
 	var err error
 	if err = w.bw.Close(); err != nil {
 		return err
 	}
-	w.index = append(w.index, w.bw.record())
+	if w.HeaderSizes {
+		if err = w.bw.writeHeader(w.xz); err != nil {
+			return err
+		}
+		if _, err = w.buf.WriteTo(w.xz); err != nil {
+			return err
+		}
+	}
+	rec := w.bw.record()
+	w.index = append(w.index, rec)
+	w.uncompressed += rec.uncompressedSize
+	w.reportProgress()
 	return nil
 }
 
+// reportProgress calls the progress function if it has been set.
+func (w *Writer) reportProgress() {
+	if w.Progress != nil {
+		w.Progress(w.cxz.n, w.uncompressed)
+	}
+}
+
 // NewWriter creates a new xz writer using default parameters.
 func NewWriter(xz io.Writer) (w *Writer, err error) {
 	return WriterConfig{}.NewWriter(xz)
 }
 
+// encodeBufSize is the size of the lookahead buffer used by
+// EncodeBuffer.
+const encodeBufSize = 4096
+
+// EncodeBuffer compresses the data read from src into an xz stream
+// written to dst and returns the number of bytes read from src. Like
+// io.CopyBuffer it works with the buffer provided by the caller: buf
+// is used as the dictionary buffer of the encoder, so the dictionary
+// isn't allocated. The dictionary capacity is the capacity of buf
+// reduced by a lookahead buffer of 4096 bytes and one byte; it must not
+// be smaller than lzma.MinDictCap. If buf is nil, the default
+// dictionary is allocated.
+func EncodeBuffer(dst io.Writer, src io.Reader, buf []byte) (written int64,
+	err error) {
+
+	var c WriterConfig
+	if buf != nil {
+		c.DictCap = cap(buf) - encodeBufSize - 1
+		if c.DictCap < lzma.MinDictCap {
+			return 0, lzma.ErrDictBuf
+		}
+		c.BufSize = encodeBufSize
+		c.DictBuf = buf
+	}
+	w, err := c.NewWriter(dst)
+	if err != nil {
+		return 0, err
+	}
+	if written, err = io.Copy(w, src); err != nil {
+		return written, err
+	}
+	return written, w.Close()
+}
+
 // NewWriter creates a new Writer using the given configuration parameters.
 func (c WriterConfig) NewWriter(xz io.Writer) (w *Writer, err error) {
 	if err = c.Verify(); err != nil {
 		return nil, err
 	}
+	if w, err = c.newWriter(xz); err != nil {
+		return nil, err
+	}
+	if err = w.start(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// newWriter creates the writer for the verified configuration without
+// writing anything.
+func (c WriterConfig) newWriter(xz io.Writer) (w *Writer, err error) {
 	w = &Writer{
 		WriterConfig: c,
-		xz:           xz,
+		cxz:          countingWriter{w: xz},
 		h:            header{c.CheckSum},
 		index:        make([]record, 0, 4),
 	}
-	if w.newHash, err = newHashFunc(c.CheckSum); err != nil {
+	w.xz = &w.cxz
+	newHash, err := newHashFunc(c.CheckSum)
+	if err != nil {
 		return nil, err
 	}
+	w.hash = newHash()
+	return w, nil
+}
+
+// start writes the stream header and creates the writer for the first
+// block.
+func (w *Writer) start() error {
+	w.streamOffset = 0
+	if s, ok := w.cxz.w.(io.Seeker); ok {
+		if off, err := s.Seek(0, io.SeekCurrent); err == nil {
+			w.streamOffset = off
+		}
+	}
 	data, err := w.h.MarshalBinary()
-	if _, err = xz.Write(data); err != nil {
-		return nil, err
+	if err != nil {
+		return err
 	}
-	if err = w.newBlockWriter(); err != nil {
-		return nil, err
+	if _, err = w.xz.Write(data); err != nil {
+		return err
 	}
-	return w, nil
+	return w.newBlockWriter()
+}
 
+// Reset discards the state of the writer and starts a new xz stream
+// written to xz using the original configuration. The LZMA2 writer and
+// its dictionary are reused, so writers can be kept in a sync.Pool.
+// Data not written by Close before is discarded.
+func (w *Writer) Reset(xz io.Writer) error {
+	w.lock()
+	defer w.unlock()
+	w.stopTimer()
+	w.timerErr = nil
+	w.pending = false
+	w.cxz = countingWriter{w: xz}
+	w.index = w.index[:0]
+	w.closed = false
+	w.uncompressed = 0
+	return w.start()
 }
 
 // Write compresses the uncompressed data provided.
 func (w *Writer) Write(p []byte) (n int, err error) {
+	if err = w.lock(); err != nil {
+		w.unlock()
+		return 0, err
+	}
+	defer w.unlock()
 	if w.closed {
 		return 0, errClosed
 	}
 	for {
 		k, err := w.bw.Write(p[n:])
 		n += k
+		if k > 0 {
+			w.pending = true
+		}
 		if err != errNoSpace {
 			return n, err
 		}
@@ -215,14 +569,93 @@ func (w *Writer) Write(p []byte) (n int, err error) {
 	}
 }
 
+// Flush writes all buffered data to the underlying writer, so that a
+// reader of the stream can decompress all data written so far. The
+// current block is not terminated. Note that frequent flushing degrades
+// the compression ratio. Flush is not supported if HeaderSizes is set.
+func (w *Writer) Flush() error {
+	err := w.lock()
+	defer w.unlock()
+	if err != nil {
+		return err
+	}
+	return w.flush()
+}
+
+// flush writes the buffered data of the current block.
+func (w *Writer) flush() error {
+	if w.closed {
+		return errClosed
+	}
+	if w.HeaderSizes {
+		return errors.New("xz: Flush not supported with HeaderSizes")
+	}
+	w.pending = false
+	return w.bw.Flush()
+}
+
+// lock locks the writer against the flush timer if MaxDelay is set. It
+// returns the error of a flush by the timer.
+func (w *Writer) lock() error {
+	if w.MaxDelay <= 0 {
+		return nil
+	}
+	w.mu.Lock()
+	return w.timerErr
+}
+
+// unlock starts the flush timer if data has been written since the
+// last flush and unlocks the writer.
+func (w *Writer) unlock() {
+	if w.MaxDelay <= 0 {
+		return
+	}
+	if w.timer == nil && !w.closed && w.pending {
+		w.timer = time.AfterFunc(w.MaxDelay, w.timedFlush)
+	}
+	w.mu.Unlock()
+}
+
+// stopTimer stops the flush timer. The writer must be locked.
+func (w *Writer) stopTimer() {
+	if w.timer != nil {
+		w.timer.Stop()
+		w.timer = nil
+	}
+}
+
+// timedFlush is called by the flush timer.
+func (w *Writer) timedFlush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.timer = nil
+	if w.closed || w.timerErr != nil {
+		return
+	}
+	w.timerErr = w.flush()
+}
+
 // Close closes the writer and adds the footer to the Writer. Close
-// doesn't close the underlying writer.
+// doesn't close the underlying writer. If Size has been given and a
+// different number of bytes has been written, *lzma.ErrSizeMismatch
+// is returned and the stream is not completed.
 func (w *Writer) Close() error {
+	err := w.lock()
+	defer w.unlock()
+	if err != nil {
+		return err
+	}
 	if w.closed {
 		return errClosed
 	}
+	if w.Size > 0 {
+		n := w.uncompressed + w.bw.uncompressedSize()
+		if n != w.Size {
+			return &lzma.ErrSizeMismatch{Want: w.Size, Got: n}
+		}
+	}
 	w.closed = true
-	var err error
+	w.stopTimer()
 	if err = w.closeBlockWriter(); err != nil {
 		return err
 	}
@@ -238,6 +671,7 @@ func (w *Writer) Close() error {
 	if _, err = w.xz.Write(data); err != nil {
 		return err
 	}
+	w.reportProgress()
 	return nil
 }
 
@@ -247,14 +681,18 @@ type countingWriter struct {
 	n int64
 }
 
-// Write writes data to the countingWriter.
+// Write writes data to the countingWriter. Short writes are retried
+// by xio.WriteFull.
 func (cw *countingWriter) Write(p []byte) (n int, err error) {
-	n, err = cw.w.Write(p)
+	n, err = xio.WriteFull(cw.w, p)
 	cw.n += int64(n)
-	if err == nil && cw.n < 0 {
+	if err != nil {
+		return n, err
+	}
+	if cw.n < 0 {
 		return n, errors.New("xz: counter overflow")
 	}
-	return
+	return n, nil
 }
 
 // blockWriter is writes a single block.
@@ -272,19 +710,28 @@ type blockWriter struct {
 	hash    hash.Hash
 }
 
-// newBlockWriter creates a new block writer.
-func (c *WriterConfig) newBlockWriter(xz io.Writer, hash hash.Hash) (bw *blockWriter, err error) {
+// newBlockWriter creates a new block writer. If lw is not nil, the
+// LZMA2 writer is reset and used instead of creating a new one.
+func (c *WriterConfig) newBlockWriter(xz io.Writer, hash hash.Hash,
+	lw *lzma.Writer2) (bw *blockWriter, err error) {
 	bw = &blockWriter{
 		cxz:       countingWriter{w: xz},
 		blockSize: c.BlockSize,
 		filters:   c.filters(),
 		hash:      hash,
 	}
-	bw.w, err = c.newFilterWriteCloser(&bw.cxz, bw.filters)
-	if err != nil {
-		return nil, err
+	if lw != nil {
+		if err = lw.Reset(&bw.cxz); err != nil {
+			return nil, err
+		}
+		bw.w = lw
+	} else {
+		bw.w, err = c.newFilterWriteCloser(&bw.cxz, bw.filters)
+		if err != nil {
+			return nil, err
+		}
 	}
-	bw.mw = io.MultiWriter(bw.w, bw.hash)
+	bw.mw = checks.NewWriter(bw.w, bw.hash)
 	return bw, nil
 }
 
@@ -366,6 +813,23 @@ func (bw *blockWriter) Write(p []byte) (n int, err error) {
 	return n, err
 }
 
+// flusher is implemented by filter writers supporting Flush.
+type flusher interface {
+	Flush() error
+}
+
+// Flush writes the data buffered by the filter writers to the
+// underlying writer.
+func (bw *blockWriter) Flush() error {
+	if bw.closed {
+		return errClosed
+	}
+	if f, ok := bw.w.(flusher); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
 // Close closes the writer.
 func (bw *blockWriter) Close() error {
 	if bw.closed {
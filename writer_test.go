@@ -7,12 +7,16 @@ package xz
 import (
 	"bytes"
 	"io"
+	"io/ioutil"
 	"log"
 	"math/rand"
 	"os"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/ulikunitz/xz/internal/randtxt"
+	"github.com/ulikunitz/xz/lzma"
 )
 
 func TestWriter(t *testing.T) {
@@ -136,3 +140,649 @@ func TestWriter2(t *testing.T) {
 		t.Fatal("decompressed data differs from original")
 	}
 }
+
+func TestWriterFlush(t *testing.T) {
+	parts := []string{
+		"The quick brown fox jumps over the lazy dog.\n",
+		"The quick brown fox jumps over the lazy cat.\n",
+	}
+	buf := new(bytes.Buffer)
+	w, err := NewWriter(buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	var r *Reader
+	for _, s := range parts {
+		if _, err = io.WriteString(w, s); err != nil {
+			t.Fatalf("WriteString error %s", err)
+		}
+		if err = w.Flush(); err != nil {
+			t.Fatalf("w.Flush error %s", err)
+		}
+		if r == nil {
+			if r, err = NewReader(buf); err != nil {
+				t.Fatalf("NewReader error %s", err)
+			}
+		}
+		// The buffer contains only the flushed data; reading
+		// more would result in an error.
+		p := make([]byte, len(s))
+		if _, err = io.ReadFull(r, p); err != nil {
+			t.Fatalf("io.ReadFull error %s", err)
+		}
+		if string(p) != s {
+			t.Fatalf("read %q; want %q", p, s)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if err = w.Flush(); err != errClosed {
+		t.Fatalf("w.Flush after Close returned %v; want %v", err,
+			errClosed)
+	}
+	rest, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ioutil.ReadAll error %s", err)
+	}
+	if len(rest) != 0 {
+		t.Fatalf("read %d unexpected bytes", len(rest))
+	}
+}
+
+func TestWriterDedupHints(t *testing.T) {
+	r := rand.New(rand.NewSource(3))
+	block := make([]byte, 8192)
+	r.Read(block)
+	var data []byte
+	for i := 0; i < 4; i++ {
+		data = append(data, block...)
+	}
+	var hints []lzma.DedupHint
+	var buf bytes.Buffer
+	w, err := WriterConfig{
+		BlockSize:  10000,
+		DedupHints: func(h lzma.DedupHint) { hints = append(hints, h) },
+	}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	if len(hints) == 0 {
+		t.Fatalf("no dedup hints")
+	}
+	var maxOffset int64
+	for _, h := range hints {
+		p := data[h.Offset : h.Offset+h.Length]
+		q := data[h.Offset-h.Distance : h.Offset+h.Length-h.Distance]
+		if !bytes.Equal(p, q) {
+			t.Fatalf("hint %+v doesn't describe a repetition", h)
+		}
+		if h.Offset > maxOffset {
+			maxOffset = h.Offset
+		}
+	}
+	if maxOffset < 20000 {
+		t.Fatalf("no hints in the later blocks")
+	}
+}
+
+func TestProgress(t *testing.T) {
+	txt, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(54)), 100000))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	type call struct{ c, u int64 }
+	var wcalls, rcalls []call
+	var buf bytes.Buffer
+	w, err := WriterConfig{
+		BlockSize: 30000,
+		Progress: func(c, u int64) {
+			wcalls = append(wcalls, call{c, u})
+		},
+	}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	// four blocks and the end of the stream
+	want := call{int64(buf.Len()), int64(len(txt))}
+	if len(wcalls) != 5 || wcalls[4] != want {
+		t.Fatalf("writer progress %v; want 5 calls ending with %v",
+			wcalls, want)
+	}
+
+	r, err := ReaderConfig{
+		Progress: func(c, u int64) {
+			rcalls = append(rcalls, call{c, u})
+		},
+	}.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = io.Copy(ioutil.Discard, r); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if len(rcalls) != 5 || rcalls[4] != want {
+		t.Fatalf("reader progress %v; want 5 calls ending with %v",
+			rcalls, want)
+	}
+	for i := 0; i < 4; i++ {
+		if rcalls[i].u != wcalls[i].u {
+			t.Fatalf("reader progress %v doesn't match writer "+
+				"progress %v", rcalls, wcalls)
+		}
+	}
+}
+
+func TestWriterStore(t *testing.T) {
+	const size = 200000
+	data, err := ioutil.ReadAll(io.LimitReader(
+		randtxt.NewReader(rand.NewSource(5)), size))
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	var buf bytes.Buffer
+	w, err := WriterConfig{Store: true}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data); err != nil {
+		t.Fatalf("w.Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	// The data is copied unchanged into the stream.
+	if !bytes.Contains(buf.Bytes(), data[:1<<15]) {
+		t.Fatalf("stream doesn't contain the stored data")
+	}
+	if buf.Len() < size || buf.Len() > size+1024 {
+		t.Fatalf("stream has %d bytes for %d bytes of data",
+			buf.Len(), size)
+	}
+	r, err := ReaderConfig{DictCap: lzma.MinDictCap}.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data) {
+		t.Fatalf("decompressed data differs")
+	}
+}
+
+func TestWriterSizeDictCap(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf bytes.Buffer
+	cfg := WriterConfig{DictCap: 64 << 20, Size: int64(len(text))}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	// The memory limit rejects the stream, if the dictionary
+	// capacity of the filter hasn't been reduced.
+	r, err := ReaderConfig{MemoryLimit: 1 << 20}.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != text {
+		t.Fatalf("got %q; want %q", out, text)
+	}
+}
+
+func TestWriterSizeMismatch(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf bytes.Buffer
+	cfg := WriterConfig{Size: int64(len(text)) + 1}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	err = w.Close()
+	e, ok := err.(*lzma.ErrSizeMismatch)
+	if !ok {
+		t.Fatalf("w.Close returned %v; want *lzma.ErrSizeMismatch",
+			err)
+	}
+	if e.Want != cfg.Size || e.Got != int64(len(text)) {
+		t.Fatalf("got %+v; want {Want:%d Got:%d}", *e, cfg.Size,
+			len(text))
+	}
+}
+
+func TestWriterBlockSizeDictCap(t *testing.T) {
+	const (
+		blockSize = 1 << 16
+		size      = 300000
+	)
+	var data bytes.Buffer
+	io.CopyN(&data, randtxt.NewReader(rand.NewSource(43)), size)
+	var buf bytes.Buffer
+	cfg := WriterConfig{DictCap: 64 << 20, BlockSize: blockSize}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(data.Bytes()); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	// The memory limit rejects the stream, if the dictionary
+	// capacity hasn't been reduced to the block size.
+	r, err := ReaderConfig{MemoryLimit: 1 << 20}.NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+	ix := r.Indexes()[0]
+	if n := ix.Len(); n != (size+blockSize-1)/blockSize {
+		t.Fatalf("got %d blocks; want %d", n,
+			(size+blockSize-1)/blockSize)
+	}
+	for i := 0; i < ix.Len()-1; i++ {
+		if u := ix.Block(i).UncompressedSize; u != blockSize {
+			t.Fatalf("block %d has %d bytes; want %d", i, u,
+				blockSize)
+		}
+	}
+}
+
+func TestWriterSHA256(t *testing.T) {
+	var data bytes.Buffer
+	io.CopyN(&data, randtxt.NewReader(rand.NewSource(47)), 100000)
+	var buf bytes.Buffer
+	cfg := WriterConfig{CheckSum: SHA256, BlockSize: 30000}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.Copy(w, bytes.NewReader(data.Bytes())); err != nil {
+		t.Fatalf("io.Copy error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("w.Close error %s", err)
+	}
+	xz := buf.Bytes()
+	r, err := NewReader(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if !bytes.Equal(out, data.Bytes()) {
+		t.Fatalf("decompressed data differs from original")
+	}
+
+	// corrupt the check of the last block
+	info, err := Stat(bytes.NewReader(xz))
+	if err != nil {
+		t.Fatalf("Stat error %s", err)
+	}
+	blocks := info.BlockMap()
+	if len(blocks) != 4 {
+		t.Fatalf("got %d blocks; want %d", len(blocks), 4)
+	}
+	b := blocks[len(blocks)-1]
+	bad := append([]byte{}, xz...)
+	bad[b.Offset+b.UnpaddedSize-1] ^= 1
+	if r, err = NewReader(bytes.NewReader(bad)); err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	if _, err = ioutil.ReadAll(r); err == nil {
+		t.Fatalf("ReadAll didn't detect corrupted SHA-256 check")
+	}
+}
+
+func TestWriterHeaderSizes(t *testing.T) {
+	var buf bytes.Buffer
+	io.CopyN(&buf, randtxt.NewReader(rand.NewSource(42)), 2500)
+	txt := buf.String()
+
+	buf.Reset()
+	cfg := WriterConfig{BlockSize: 1000, HeaderSizes: true}
+	w, err := cfg.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = io.WriteString(w, txt); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	if err = w.Flush(); err == nil {
+		t.Fatalf("Flush returned no error")
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	data := buf.Bytes()
+	h, _, err := readBlockHeader(bytes.NewReader(data[HeaderLen:]))
+	if err != nil {
+		t.Fatalf("readBlockHeader error %s", err)
+	}
+	if h.uncompressedSize != 1000 {
+		t.Fatalf("uncompressed size in block header %d; want %d",
+			h.uncompressedSize, 1000)
+	}
+	if h.compressedSize <= 0 {
+		t.Fatalf("compressed size in block header %d",
+			h.compressedSize)
+	}
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	out, err := ioutil.ReadAll(r)
+	if err != nil {
+		t.Fatalf("ReadAll error %s", err)
+	}
+	if string(out) != txt {
+		t.Fatal("decompressed data differs from original")
+	}
+}
+
+func TestWriterReset(t *testing.T) {
+	var texts [][]byte
+	for i := 0; i < 3; i++ {
+		var buf bytes.Buffer
+		io.CopyN(&buf, randtxt.NewReader(rand.NewSource(int64(i))),
+			int64(1000+i*1500))
+		texts = append(texts, buf.Bytes())
+	}
+	cfg := WriterConfig{DictCap: 1 << 16, BlockSize: 1000}
+	var w *Writer
+	for i, txt := range texts {
+		var want bytes.Buffer
+		fw, err := cfg.NewWriter(&want)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = fw.Write(txt); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = fw.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+
+		var buf bytes.Buffer
+		if w == nil {
+			w, err = cfg.NewWriter(&buf)
+		} else {
+			err = w.Reset(&buf)
+		}
+		if err != nil {
+			t.Fatalf("NewWriter or Reset error %s", err)
+		}
+		if _, err = w.Write(txt); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		if !bytes.Equal(buf.Bytes(), want.Bytes()) {
+			t.Fatalf("stream %d after Reset differs", i)
+		}
+		r, err := NewReader(&buf)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		out, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(out, txt) {
+			t.Fatalf("stream %d decompressed after Reset differs", i)
+		}
+	}
+}
+
+func TestMemoryUsage(t *testing.T) {
+	c := WriterConfig{DictCap: 1 << 20, BlockSize: 1 << 18}
+	n, err := c.MemoryUsage()
+	if err != nil {
+		t.Fatalf("MemoryUsage error %s", err)
+	}
+	// the dictionary is reduced to the block size
+	lc := lzma.Writer2Config{DictCap: 1 << 18}
+	want, err := lc.MemoryUsage()
+	if err != nil {
+		t.Fatalf("lzma MemoryUsage error %s", err)
+	}
+	if n != want {
+		t.Fatalf("MemoryUsage %d; want %d", n, want)
+	}
+	c.HeaderSizes = true
+	if n, err = c.MemoryUsage(); err != nil {
+		t.Fatalf("MemoryUsage error %s", err)
+	}
+	if n != want+1<<18 {
+		t.Fatalf("MemoryUsage with HeaderSizes %d; want %d", n,
+			want+1<<18)
+	}
+	r := ReaderConfig{DictCap: 1 << 20}
+	if n, err = r.MemoryUsage(); err != nil {
+		t.Fatalf("ReaderConfig.MemoryUsage error %s", err)
+	}
+	lr := lzma.Reader2Config{DictCap: 1 << 20}
+	if want, err = lr.MemoryUsage(); err != nil {
+		t.Fatalf("lzma MemoryUsage error %s", err)
+	}
+	if n != want {
+		t.Fatalf("ReaderConfig.MemoryUsage %d; want %d", n, want)
+	}
+}
+
+// shortWriter writes at most 13 bytes per call without reporting an
+// error for the rest.
+type shortWriter struct {
+	buf bytes.Buffer
+}
+
+func (w *shortWriter) Write(p []byte) (n int, err error) {
+	if len(p) > 13 {
+		p = p[:13]
+	}
+	return w.buf.Write(p)
+}
+
+func TestWriterShortWrites(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<16)
+	for _, c := range []WriterConfig{
+		{BlockSize: 1 << 14},
+		{BlockSize: 1 << 14, HeaderSizes: true},
+	} {
+		var sw shortWriter
+		w, err := c.NewWriter(&sw)
+		if err != nil {
+			t.Fatalf("NewWriter error %s", err)
+		}
+		if _, err = w.Write(txt.Bytes()); err != nil {
+			t.Fatalf("Write error %s", err)
+		}
+		if err = w.Close(); err != nil {
+			t.Fatalf("Close error %s", err)
+		}
+		r, err := NewReader(&sw.buf)
+		if err != nil {
+			t.Fatalf("NewReader error %s", err)
+		}
+		p, err := ioutil.ReadAll(r)
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if !bytes.Equal(p, txt.Bytes()) {
+			t.Fatalf("decompressed data differs")
+		}
+	}
+}
+
+func TestEncodeDecodeBuffer(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 1<<17)
+	buf := make([]byte, 1<<16+4096+1)
+	for i := 0; i < 2; i++ {
+		var xz, out bytes.Buffer
+		n, err := EncodeBuffer(&xz, bytes.NewReader(txt.Bytes()), buf)
+		if err != nil {
+			t.Fatalf("EncodeBuffer error %s", err)
+		}
+		if n != int64(txt.Len()) {
+			t.Fatalf("EncodeBuffer read %d bytes; want %d", n,
+				txt.Len())
+		}
+		if n, err = DecodeBuffer(&out, &xz, buf); err != nil {
+			t.Fatalf("DecodeBuffer error %s", err)
+		}
+		if n != int64(txt.Len()) || !bytes.Equal(out.Bytes(), txt.Bytes()) {
+			t.Fatalf("decompressed data differs")
+		}
+	}
+	_, err := EncodeBuffer(ioutil.Discard, bytes.NewReader(txt.Bytes()),
+		make([]byte, 4096))
+	if err != lzma.ErrDictBuf {
+		t.Fatalf("EncodeBuffer returned error %v; want %s", err,
+			lzma.ErrDictBuf)
+	}
+}
+
+// lockedBuffer is a buffer that can be written by the flush timer of a
+// writer while it is read by the test.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+// Bytes returns a copy of the buffered data.
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func TestWriterMaxDelay(t *testing.T) {
+	const text = "The quick brown fox jumps over the lazy dog."
+	var buf lockedBuffer
+	w, err := WriterConfig{MaxDelay: 10 * time.Millisecond}.NewWriter(&buf)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	n := len(buf.Bytes())
+	if _, err = io.WriteString(w, text); err != nil {
+		t.Fatalf("WriteString error %s", err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(buf.Bytes()) == n {
+		if time.Now().After(deadline) {
+			t.Fatalf("data not flushed by the timer")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader error %s", err)
+	}
+	p := make([]byte, len(text))
+	if _, err = io.ReadFull(r, p); err != nil {
+		t.Fatalf("ReadFull error %s", err)
+	}
+	if string(p) != text {
+		t.Fatalf("read %q; want %q", p, text)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if err = Validate(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("Validate error %s", err)
+	}
+	_, err = WriterConfig{MaxDelay: time.Second,
+		HeaderSizes: true}.NewWriter(&buf)
+	if err == nil {
+		t.Fatalf("NewWriter accepted MaxDelay with HeaderSizes")
+	}
+}
+
+// offsetTrace checks that the positions of the events are contiguous.
+type offsetTrace struct {
+	t   *testing.T
+	pos int64
+}
+
+func (c *offsetTrace) Literal(pos int64, b byte) {
+	if pos != c.pos {
+		c.t.Fatalf("literal at %d; want %d", pos, c.pos)
+	}
+	c.pos++
+}
+
+func (c *offsetTrace) Match(pos int64, m lzma.TraceMatch, rep int,
+	candidates []lzma.TraceMatch) {
+
+	if pos != c.pos {
+		c.t.Fatalf("match at %d; want %d", pos, c.pos)
+	}
+	c.pos += int64(m.Len)
+}
+
+func (c *offsetTrace) ChunkClosed(ch lzma.TraceChunk) {}
+
+func TestWriterTrace(t *testing.T) {
+	var txt bytes.Buffer
+	io.CopyN(&txt, randtxt.NewReader(rand.NewSource(42)), 50000)
+	tr := &offsetTrace{t: t}
+	w, err := WriterConfig{BlockSize: 8000, Trace: tr}.NewWriter(
+		ioutil.Discard)
+	if err != nil {
+		t.Fatalf("NewWriter error %s", err)
+	}
+	if _, err = w.Write(txt.Bytes()); err != nil {
+		t.Fatalf("Write error %s", err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatalf("Close error %s", err)
+	}
+	if tr.pos != 50000 {
+		t.Fatalf("operations cover %d bytes; want %d", tr.pos, 50000)
+	}
+}
@@ -0,0 +1,284 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package xzhttp supports the content encodings xz and lzma for HTTP.
+// Handler compresses the responses of a handler for clients that
+// accept one of the encodings and Transport decompresses responses
+// transparently for HTTP clients.
+//
+// The compressing writers are kept in pools and reused with their
+// Reset methods, which avoids allocating a new dictionary for every
+// response.
+package xzhttp
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+)
+
+// Content encodings supported by the package.
+const (
+	EncodingXZ   = "xz"
+	EncodingLZMA = "lzma"
+)
+
+// resetWriter is implemented by the pooled writers of both encodings.
+type resetWriter interface {
+	io.WriteCloser
+	Reset(w io.Writer) error
+}
+
+// pools contain the writers for the encodings.
+var pools = map[string]*sync.Pool{
+	EncodingXZ: {New: func() interface{} {
+		w, err := xz.NewWriter(ioutil.Discard)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	}},
+	EncodingLZMA: {New: func() interface{} {
+		w, err := lzma.NewWriter(ioutil.Discard)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	}},
+}
+
+// getWriter returns a writer for the encoding from its pool that
+// writes the compressed data to w.
+func getWriter(encoding string, w io.Writer) (resetWriter, error) {
+	zw := pools[encoding].Get().(resetWriter)
+	if err := zw.Reset(w); err != nil {
+		return nil, err
+	}
+	return zw, nil
+}
+
+// putWriter returns the writer into the pool for the encoding.
+func putWriter(encoding string, zw resetWriter) {
+	zw.Reset(ioutil.Discard)
+	pools[encoding].Put(zw)
+}
+
+// acceptedEncoding selects the encoding for the Accept-Encoding header
+// of a request. The encoding with the higher quality value is preferred
+// and xz wins a tie. The empty string is returned, if the client
+// accepts neither encoding.
+func acceptedEncoding(header string) string {
+	var encoding string
+	var best float64
+	for _, s := range strings.Split(header, ",") {
+		name, params := s, ""
+		if i := strings.Index(s, ";"); i >= 0 {
+			name, params = s[:i], s[i+1:]
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name != EncodingXZ && name != EncodingLZMA {
+			continue
+		}
+		q := 1.0
+		params = strings.TrimSpace(params)
+		if strings.HasPrefix(params, "q=") {
+			var err error
+			q, err = strconv.ParseFloat(params[2:], 64)
+			if err != nil {
+				continue
+			}
+		}
+		if q > best || (q == best && name == EncodingXZ) {
+			encoding, best = name, q
+		}
+	}
+	if best <= 0 {
+		return ""
+	}
+	return encoding
+}
+
+// Handler returns a handler that compresses the responses of h with xz
+// or lzma if the client accepts one of the encodings. Responses for
+// which h sets the Content-Encoding header themselves are not
+// compressed.
+func Handler(h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+		rw := &responseWriter{ResponseWriter: w, encoding: encoding}
+		defer rw.close()
+		h.ServeHTTP(rw, r)
+	})
+}
+
+// responseWriter compresses the body of a response. The compression is
+// started when the header is written.
+type responseWriter struct {
+	http.ResponseWriter
+	encoding    string
+	zw          resetWriter
+	wroteHeader bool
+	err         error
+}
+
+// WriteHeader writes the header and decides whether the body is
+// compressed.
+func (rw *responseWriter) WriteHeader(code int) {
+	if rw.wroteHeader {
+		return
+	}
+	if code < 200 {
+		// informational responses don't complete the header
+		rw.ResponseWriter.WriteHeader(code)
+		return
+	}
+	rw.wroteHeader = true
+	h := rw.Header()
+	if h.Get("Content-Encoding") == "" && code != http.StatusNoContent &&
+		code != http.StatusNotModified {
+		h.Set("Content-Encoding", rw.encoding)
+		h.Del("Content-Length")
+		rw.zw, rw.err = getWriter(rw.encoding, rw.ResponseWriter)
+	}
+	rw.ResponseWriter.WriteHeader(code)
+}
+
+// Write compresses the data and writes it to the underlying response
+// writer. The content type is detected from the uncompressed data, if
+// it hasn't been set.
+func (rw *responseWriter) Write(p []byte) (n int, err error) {
+	if !rw.wroteHeader {
+		h := rw.Header()
+		if h.Get("Content-Type") == "" {
+			h.Set("Content-Type", http.DetectContentType(p))
+		}
+		rw.WriteHeader(http.StatusOK)
+	}
+	if rw.err != nil {
+		return 0, rw.err
+	}
+	if rw.zw == nil {
+		return rw.ResponseWriter.Write(p)
+	}
+	return rw.zw.Write(p)
+}
+
+// flusher is implemented by writers supporting Flush.
+type flusher interface {
+	Flush() error
+}
+
+// Flush writes the compressed data buffered so far to the client, if
+// the compressing writer and the underlying response writer support
+// it.
+func (rw *responseWriter) Flush() {
+	if f, ok := rw.zw.(flusher); ok && rw.err == nil {
+		rw.err = f.Flush()
+	}
+	if f, ok := rw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// close completes the compressed body and returns the writer into its
+// pool.
+func (rw *responseWriter) close() {
+	if rw.zw == nil {
+		return
+	}
+	if rw.err == nil {
+		rw.zw.Close()
+	}
+	putWriter(rw.encoding, rw.zw)
+	rw.zw = nil
+}
+
+// Transport is an http.RoundTripper requesting responses encoded with
+// xz or lzma and decompressing them transparently. Requests that set
+// the Accept-Encoding header themselves are passed through unchanged.
+type Transport struct {
+	// Base is the transport used for the requests. If it is nil,
+	// http.DefaultTransport is used.
+	Base http.RoundTripper
+}
+
+// RoundTrip executes a single HTTP transaction.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	if req.Header.Get("Accept-Encoding") != "" ||
+		req.Header.Get("Range") != "" {
+		return base.RoundTrip(req)
+	}
+	// A RoundTripper must not modify the request.
+	r := new(http.Request)
+	*r = *req
+	r.Header = make(http.Header, len(req.Header)+1)
+	for k, v := range req.Header {
+		r.Header[k] = v
+	}
+	r.Header.Set("Accept-Encoding", EncodingXZ+", "+EncodingLZMA)
+	resp, err := base.RoundTrip(r)
+	if err != nil {
+		return nil, err
+	}
+	encoding := strings.ToLower(resp.Header.Get("Content-Encoding"))
+	if encoding != EncodingXZ && encoding != EncodingLZMA {
+		return resp, nil
+	}
+	if req.Method == http.MethodHead ||
+		resp.StatusCode == http.StatusNoContent ||
+		resp.StatusCode == http.StatusNotModified {
+		return resp, nil
+	}
+	resp.Body = &body{rc: resp.Body, encoding: encoding}
+	resp.Header.Del("Content-Encoding")
+	resp.Header.Del("Content-Length")
+	resp.ContentLength = -1
+	resp.Uncompressed = true
+	return resp, nil
+}
+
+// body decompresses the body of a response. The reader is created on
+// the first call of Read, because it reads the header of the
+// compressed stream.
+type body struct {
+	rc       io.ReadCloser
+	encoding string
+	r        io.Reader
+	err      error
+}
+
+// Read reads decompressed data from the body.
+func (b *body) Read(p []byte) (n int, err error) {
+	if b.r == nil && b.err == nil {
+		if b.encoding == EncodingXZ {
+			b.r, b.err = xz.NewReader(b.rc)
+		} else {
+			b.r, b.err = lzma.NewReader(b.rc)
+		}
+	}
+	if b.err != nil {
+		return 0, b.err
+	}
+	return b.r.Read(p)
+}
+
+// Close closes the underlying body.
+func (b *body) Close() error {
+	return b.rc.Close()
+}
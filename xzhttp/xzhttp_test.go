@@ -0,0 +1,122 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package xzhttp
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAcceptedEncoding(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"", ""},
+		{"gzip, deflate", ""},
+		{"gzip, xz", "xz"},
+		{"lzma", "lzma"},
+		{"lzma, xz", "xz"},
+		{"xz;q=0.5, lzma", "lzma"},
+		{"XZ", "xz"},
+		{"xz;q=0", ""},
+	}
+	for _, c := range tests {
+		if got := acceptedEncoding(c.header); got != c.want {
+			t.Errorf("acceptedEncoding(%q) returned %q; want %q",
+				c.header, got, c.want)
+		}
+	}
+}
+
+const text = "The quick brown fox jumps over the lazy dog.\n"
+
+func TestHandlerTransport(t *testing.T) {
+	ts := httptest.NewServer(Handler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, strings.Repeat(text, 100))
+		})))
+	defer ts.Close()
+
+	client := &http.Client{Transport: &Transport{}}
+	for _, encoding := range []string{EncodingXZ, EncodingLZMA} {
+		// The Transport passes requests with Accept-Encoding
+		// through.
+		req, err := http.NewRequest("GET", ts.URL, nil)
+		if err != nil {
+			t.Fatalf("NewRequest error %s", err)
+		}
+		req.Header.Set("Accept-Encoding", encoding)
+		resp, err := client.Do(req)
+		if err != nil {
+			t.Fatalf("Do error %s", err)
+		}
+		p, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if g := resp.Header.Get("Content-Encoding"); g != encoding {
+			t.Fatalf("Content-Encoding %q; want %q", g, encoding)
+		}
+		if len(p) >= 100*len(text) {
+			t.Fatalf("%s response of %d bytes not compressed",
+				encoding, len(p))
+		}
+	}
+	for i := 0; i < 3; i++ {
+		resp, err := client.Get(ts.URL)
+		if err != nil {
+			t.Fatalf("Get error %s", err)
+		}
+		p, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatalf("ReadAll error %s", err)
+		}
+		if string(p) != strings.Repeat(text, 100) {
+			t.Fatalf("decompressed body differs")
+		}
+		if !resp.Uncompressed {
+			t.Fatalf("response not marked as uncompressed")
+		}
+		if g := resp.Header.Get("Content-Type"); !strings.HasPrefix(g,
+			"text/plain") {
+			t.Fatalf("Content-Type %q; want text/plain", g)
+		}
+	}
+}
+
+func TestHandlerNoEncoding(t *testing.T) {
+	h := Handler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			io.WriteString(w, text)
+		}))
+	rec := httptest.NewRecorder()
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("Accept-Encoding", "gzip")
+	h.ServeHTTP(rec, req)
+	if rec.Body.String() != text {
+		t.Fatalf("body %q; want %q", rec.Body.String(), text)
+	}
+	if g := rec.Header().Get("Vary"); g != "Accept-Encoding" {
+		t.Fatalf("Vary %q; want %q", g, "Accept-Encoding")
+	}
+
+	h = Handler(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}))
+	rec = httptest.NewRecorder()
+	req.Header.Set("Accept-Encoding", "xz")
+	h.ServeHTTP(rec, req)
+	if g := rec.Header().Get("Content-Encoding"); g != "" {
+		t.Fatalf("Content-Encoding %q for status 204", g)
+	}
+}
@@ -0,0 +1,56 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+// Package xztestdata provides malformed inputs that the decoders of the
+// xz and lzma packages must reject. The inputs have been constructed by
+// hand to reproduce bugs fixed in the decoders. Forks and wrappers of the packages can use the inputs to
+// verify that they reject them with an error instead of panicking or
+// allocating excessive memory.
+package xztestdata
+
+import (
+	"embed"
+	"path"
+	"strings"
+)
+
+//go:embed regressions
+var regressions embed.FS
+
+// Regression is a malformed input that a decoder must reject with an
+// error.
+type Regression struct {
+	// Name of the regression
+	Name string
+	// Format of the data: "xz", "lzma" or "lzma2"
+	Format string
+	// input for the decoder
+	Data []byte
+}
+
+// Regressions returns all regression inputs sorted by name.
+func Regressions() []Regression {
+	entries, err := regressions.ReadDir("regressions")
+	if err != nil {
+		panic(err)
+	}
+	r := make([]Regression, 0, len(entries))
+	for _, e := range entries {
+		data, err := regressions.ReadFile(path.Join("regressions",
+			e.Name()))
+		if err != nil {
+			panic(err)
+		}
+		ext := path.Ext(e.Name())
+		r = append(r, Regression{
+			Name:   strings.TrimSuffix(e.Name(), ext),
+			Format: ext[1:],
+			Data:   data,
+		})
+	}
+	return r
+}
@@ -0,0 +1,55 @@
+// Copyright 2014-2017 Ulrich Kunitz. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build go1.16
+// +build go1.16
+
+package xztestdata_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/ulikunitz/xz"
+	"github.com/ulikunitz/xz/lzma"
+	"github.com/ulikunitz/xz/xztestdata"
+)
+
+// decode decompresses the data in the given format.
+func decode(format string, data []byte) error {
+	var (
+		r   io.Reader
+		err error
+	)
+	br := bytes.NewReader(data)
+	switch format {
+	case "xz":
+		r, err = xz.NewReader(br)
+	case "lzma":
+		r, err = lzma.NewReader(br)
+	case "lzma2":
+		r, err = lzma.NewReader2(br)
+	default:
+		panic("unsupported format " + format)
+	}
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(ioutil.Discard, r)
+	return err
+}
+
+func TestRegressions(t *testing.T) {
+	regs := xztestdata.Regressions()
+	if len(regs) == 0 {
+		t.Fatalf("no regressions")
+	}
+	for _, reg := range regs {
+		if err := decode(reg.Format, reg.Data); err == nil {
+			t.Errorf("%s.%s: no error", reg.Name, reg.Format)
+		}
+	}
+}